@@ -0,0 +1,448 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gtfierro/pundat/dots"
+	"github.com/immesys/bw2/objects"
+	"github.com/immesys/bw2/util"
+	bw2 "github.com/immesys/bw2bind"
+	"github.com/mgutz/ansi"
+	"github.com/pkg/errors"
+	"github.com/urfave/cli"
+)
+
+// the permissions a key needs on each of the URIs returned by archiverAccessURIs
+var archiverAccessPermissions = []string{"C*", "P", "C"}
+
+// what each of the permissions lets the key do
+var archiverAccessPurposes = []string{"find archivers", "publish to the archiver", "consume from the archiver"}
+
+// Returns the URIs a key needs DOTs on to use the archiver at uri: the scan URI (C*),
+// the query URI (P) and the key's response URI (C)
+func archiverAccessURIs(uri, key_vk string) []string {
+	return []string{
+		uri + "/*/s.giles/!meta/lastalive",
+		uri + "/s.giles/_/i.archiver/slot/query",
+		uri + "/s.giles/_/i.archiver/signal/" + key_vk[:len(key_vk)-1] + ",queries",
+	}
+}
+
+// the comment on the DOTs 'pundat grant' creates, which 'pundat revoke' looks for
+func grantComment(uri string) string {
+	return fmt.Sprintf("Access to archiver on URI %s", uri)
+}
+
+// builds DOT chains; satisfied by *bw2.BW2Client
+type chainBuilder interface {
+	BuildAnyChain(uri, permissions, to string) (*bw2.SimpleChain, error)
+}
+
+// Builds a chain granting the key each of the permissions it needs to use the archiver at
+// uri (see archiverAccessURIs). A chain is nil if the key does not have that permission
+func buildAccessChains(bwclient chainBuilder, key_vk, uri string) (uris []string, chains []*bw2.SimpleChain, err error) {
+	uris = archiverAccessURIs(uri, key_vk)
+	chains = make([]*bw2.SimpleChain, len(uris))
+	for idx, accessURI := range uris {
+		chains[idx], err = bwclient.BuildAnyChain(accessURI, archiverAccessPermissions[idx], key_vk)
+		if err != nil {
+			err = errors.Wrapf(err, "Could not build chain on %s to %s", accessURI, key_vk)
+			return
+		}
+	}
+	return
+}
+
+// Returns the earliest expiry of the DOTs in the chain, or nil if none of them expire
+func chainExpiry(bwclient *bw2.BW2Client, chain *bw2.SimpleChain) (*time.Time, error) {
+	obj, err := objects.NewDChain(objects.ROAccessDChain, chain.Content)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Could not decode chain %s", chain.Hash)
+	}
+	dchain, ok := obj.(*objects.DChain)
+	if !ok {
+		return nil, errors.New(fmt.Sprintf("Not a chain: %s", chain.Hash))
+	}
+	var expiry *time.Time
+	for i := 0; i < dchain.NumHashes(); i++ {
+		hash := base64.URLEncoding.EncodeToString(dchain.GetDotHash(i))
+		ro, _, err := bwclient.ResolveRegistry(hash)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Could not resolve DOT %s", hash)
+		}
+		dot, ok := ro.(*objects.DOT)
+		if !ok {
+			return nil, errors.New(fmt.Sprintf("Not a DOT: %s", hash))
+		}
+		if e := dot.GetExpiry(); e != nil && (expiry == nil || e.Before(*expiry)) {
+			expiry = e
+		}
+	}
+	return expiry, nil
+}
+
+// the parts of a DOT the access commands look at
+type grant struct {
+	hash     string
+	receiver string
+	// the VK of the namespace the DOT grants access in
+	mvk     string
+	suffix  string
+	perms   string
+	comment string
+	ttl     int
+	access  bool
+}
+
+func grantOf(dot *objects.DOT) grant {
+	return grant{
+		hash:     base64.URLEncoding.EncodeToString(dot.GetHash()),
+		receiver: objects.FmtKey(dot.GetReceiverVK()),
+		mvk:      objects.FmtKey(dot.GetAccessURIMVK()),
+		suffix:   dot.GetAccessURISuffix(),
+		perms:    dot.GetPermString(),
+		comment:  dot.GetComment(),
+		ttl:      dot.GetTTL(),
+		access:   dot.IsAccess(),
+	}
+}
+
+// finds the valid DOTs granted by a VK
+type grantFinder interface {
+	grantsFrom(vk string) ([]grant, error)
+}
+
+// finds DOTs in the BOSSWAVE registry
+type registryGrants struct {
+	client *bw2.BW2Client
+}
+
+func (r registryGrants) grantsFrom(vk string) ([]grant, error) {
+	granted, validity, err := r.client.FindDOTsFromVK(vk)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Could not find DOTs granted by %s", vk)
+	}
+	var grants []grant
+	for idx, dot := range granted {
+		if validity[idx] == bw2.StateValid {
+			grants = append(grants, grantOf(dot))
+		}
+	}
+	return grants, nil
+}
+
+// returns true if the DOT is one of those 'pundat grant' creates for the archiver at uri,
+// whose namespace has the VK nsvk
+func isArchiverGrant(dot grant, uri, nsvk string, uris []string) bool {
+	if dot.comment == grantComment(uri) {
+		return true
+	}
+	// older grants may have a different comment, so also match on the URI. The
+	// namespace is in the DOT's MVK, so compare that and the rest of the URI
+	if dot.mvk != nsvk {
+		return false
+	}
+	for idx, accessURI := range uris {
+		parts := strings.SplitN(accessURI, "/", 2)
+		if len(parts) == 2 && dot.suffix == parts[1] && dot.perms == archiverAccessPermissions[idx] {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the valid DOTs giver granted to key_vk for the archiver at uri (see isArchiverGrant)
+func archiverGrants(finder grantFinder, giver, key_vk, uri, nsvk string) ([]grant, error) {
+	granted, err := finder.grantsFrom(giver)
+	if err != nil {
+		return nil, err
+	}
+	uris := archiverAccessURIs(uri, key_vk)
+	var grants []grant
+	for _, dot := range granted {
+		if dot.receiver == key_vk && isArchiverGrant(dot, uri, nsvk, uris) {
+			grants = append(grants, dot)
+		}
+	}
+	return grants, nil
+}
+
+func doRevoke(c *cli.Context) error {
+	bw2.SilenceLog()
+	key := c.String("key")
+	if key == "" {
+		log.Fatal(errors.New("Need to specify key"))
+	}
+	uri := c.String("uri")
+	if uri == "" {
+		log.Fatal(errors.New("Need to specify uri"))
+	}
+	// connect
+	bwclient := bw2.ConnectOrExit(c.String("agent"))
+	our_vk := bwclient.SetEntityFileOrExit(c.String("entity"))
+	bwclient.OverrideAutoChainTo(true)
+
+	key_vk, err := resolveKey(bwclient, key)
+	if err != nil {
+		log.Fatal(err)
+	}
+	namespace := strings.Split(uri, "/")[0]
+	nsvk, err := resolveKey(bwclient, namespace)
+	if err != nil {
+		log.Fatal(errors.Wrapf(err, "Could not resolve namespace %s", namespace))
+	}
+
+	datmoney := bw2.ConnectOrExit(c.String("agent"))
+	datmoney.SetEntityFileOrExit(c.String("bankroll"))
+	datmoney.OverrideAutoChainTo(true)
+
+	// the DOTs granted by our entity
+	granted, err := archiverGrants(registryGrants{bwclient}, our_vk, key_vk, uri, nsvk)
+	if err != nil {
+		log.Fatal(err)
+	}
+	successcolor := ansi.ColorFunc("green")
+	revoked := 0
+	for _, dot := range granted {
+		hash := dot.hash
+		_, blob, err := bwclient.RevokeDOT(hash, fmt.Sprintf("Revoking access to archiver on URI %s", uri))
+		if err != nil {
+			log.Fatal(errors.Wrapf(err, "Could not revoke DOT %s", hash))
+		}
+		log.Info("Publishing revocation of DOT", hash)
+		if _, err := datmoney.PublishRevocation(0, blob); err != nil {
+			log.Error(errors.Wrapf(err, "Could not publish revocation of DOT %s", hash))
+			continue
+		}
+		log.Info(successcolor(fmt.Sprintf("Revoked DOT %s (%s %s)", hash, dot.perms, dot.suffix)))
+		revoked++
+	}
+	if revoked == 0 {
+		fmt.Printf("Found no DOTs granted by %s to %s for the archiver at %s\n", our_vk, key_vk, uri)
+		return nil
+	}
+	fmt.Printf("Revoked %d DOTs. Key %s may still have access through DOTs granted by others; check with 'pundat check'\n", revoked, key_vk)
+	return nil
+}
+
+// Lists the VKs that can query the archiver. BOSSWAVE can't list the holders of DOTs on a
+// URI, so the candidates are the receivers of the DOTs reachable from the entity and the
+// archiver's namespace; each is then checked by building chains the same way 'pundat check' does
+func doAccess(c *cli.Context) error {
+	bw2.SilenceLog()
+	uri := strings.TrimSuffix(c.String("uri"), "/")
+	if uri == "" {
+		log.Fatal(errors.New("Need to specify uri"))
+	}
+	bwclient := bw2.ConnectOrExit(c.String("agent"))
+	our_vk := bwclient.SetEntityFileOrExit(c.String("entity"))
+	bwclient.OverrideAutoChainTo(true)
+
+	roots := []string{our_vk}
+	if ns_vk, err := resolveKey(bwclient, strings.Split(uri, "/")[0]); err == nil && ns_vk != our_vk {
+		roots = append(roots, ns_vk)
+	}
+	candidates, err := findGrantees(registryGrants{bwclient}, roots)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	foundcolor := ansi.ColorFunc("blue+h")
+	badcolor := ansi.ColorFunc("yellow+b")
+	users := archiverUsers(bwclient, candidates, uri)
+	for _, user := range users {
+		var expiry *time.Time
+		for _, chain := range user.chains {
+			if chain == nil {
+				continue
+			}
+			e, err := chainExpiry(bwclient, chain)
+			if err != nil {
+				log.Error(err)
+				continue
+			}
+			if e != nil && (expiry == nil || e.Before(*expiry)) {
+				expiry = e
+			}
+		}
+		expires := "never expires"
+		if expiry != nil {
+			expires = fmt.Sprintf("expires %s (in %s)", expiry.Format(time.RFC3339), time.Until(*expiry).Truncate(time.Minute))
+		}
+		fmt.Println(foundcolor(user.vk), expires)
+		if user.chains[0] == nil {
+			fmt.Println(badcolor(fmt.Sprintf("    cannot %s (%s)", archiverAccessPurposes[0], user.uris[0])))
+		}
+	}
+	fmt.Printf("%d of %d keys found have access to the archiver at %s\n", len(users), len(candidates), uri)
+	return nil
+}
+
+// a key that can use an archiver, with the chains it has on archiverAccessURIs
+type archiverUser struct {
+	vk     string
+	uris   []string
+	chains []*bw2.SimpleChain
+}
+
+// Returns the candidates that can send queries to the archiver at uri and receive the
+// responses, in order
+func archiverUsers(bwclient chainBuilder, candidates []string, uri string) []archiverUser {
+	var users []archiverUser
+	for _, vk := range candidates {
+		uris, chains, err := buildAccessChains(bwclient, vk, uri)
+		if err != nil {
+			log.Error(err)
+			continue
+		}
+		if chains[1] != nil && chains[2] != nil {
+			users = append(users, archiverUser{vk: vk, uris: uris, chains: chains})
+		}
+	}
+	return users
+}
+
+// Returns the roots and the receivers of the valid access DOTs granted by the roots, and of
+// the DOTs they can in turn grant (those with a TTL above 0), in the order they are found
+func findGrantees(finder grantFinder, roots []string) ([]string, error) {
+	var (
+		grantees = append([]string{}, roots...)
+		seen     = make(map[string]bool)
+		queued   = make(map[string]bool)
+		queue    = roots
+	)
+	for _, root := range roots {
+		seen[root] = true
+		queued[root] = true
+	}
+	for len(queue) > 0 {
+		giver := queue[0]
+		queue = queue[1:]
+		granted, err := finder.grantsFrom(giver)
+		if err != nil {
+			return grantees, err
+		}
+		for _, dot := range granted {
+			if !dot.access {
+				continue
+			}
+			if !seen[dot.receiver] {
+				seen[dot.receiver] = true
+				grantees = append(grantees, dot.receiver)
+			}
+			if dot.ttl > 0 && !queued[dot.receiver] {
+				queued[dot.receiver] = true
+				queue = append(queue, dot.receiver)
+			}
+		}
+	}
+	return grantees, nil
+}
+
+// Replaces the namespace (alias) at the start of the URI with the namespace's VK. The
+// archiver looks for archival DOT chains starting from the VK in the stream's URI
+func resolveNamespace(bwclient *bw2.BW2Client, uri string) (string, error) {
+	parts := strings.SplitN(strings.Trim(uri, "/"), "/", 2)
+	nsvk, err := resolveKey(bwclient, parts[0])
+	if err != nil {
+		return "", errors.Wrapf(err, "Could not resolve namespace %s", parts[0])
+	}
+	parts[0] = nsvk
+	return strings.Join(parts, "/"), nil
+}
+
+// Grants a DOT on archive/start/<from>/end/<to>/<uri>, which lets the key read the data
+// of uri archived in that range of time (see dots/README.md)
+func doGrantRange(c *cli.Context) error {
+	bw2.SilenceLog()
+	key := c.String("key")
+	if key == "" {
+		log.Fatal(errors.New("Need to specify key"))
+	}
+	uri := c.String("uri")
+	if uri == "" {
+		log.Fatal(errors.New("Need to specify uri"))
+	}
+	var (
+		from, to time.Time
+		err      error
+	)
+	if c.String("from") != "" {
+		if from, err = parseTimeExpression(c.String("from")); err != nil {
+			log.Fatal(errors.Wrap(err, "Could not parse --from"))
+		}
+	}
+	if c.String("to") != "" {
+		if to, err = parseTimeExpression(c.String("to")); err != nil {
+			log.Fatal(errors.Wrap(err, "Could not parse --to"))
+		}
+	}
+	if !from.IsZero() && !to.IsZero() && to.Before(from) {
+		log.Fatal(errors.New("--to is before --from"))
+	}
+	var expiry *time.Duration
+	if c.String("expiry") != "" {
+		if expiry, err = util.ParseDuration(c.String("expiry")); err != nil {
+			log.Fatal(errors.Wrap(err, "Could not parse expiry"))
+		}
+	}
+	// connect
+	bwclient := bw2.ConnectOrExit(c.String("agent"))
+	our_vk := bwclient.SetEntityFileOrExit(c.String("entity"))
+	bwclient.OverrideAutoChainTo(true)
+
+	key_vk, err := resolveKey(bwclient, key)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if uri, err = resolveNamespace(bwclient, uri); err != nil {
+		log.Fatal(err)
+	}
+	// chains must start at the namespace authority, so anyone else needs archival access
+	// of their own to pass on
+	if nsvk := strings.Split(uri, "/")[0]; nsvk != our_vk {
+		badcolor := ansi.ColorFunc("yellow+b")
+		master := dots.NewDotMaster(bwclient, commandPermissionExpiry, dots.DefaultMaxStaleness)
+		if ranges, err := master.GetArchivalRanges(uri, our_vk); err != nil || len(ranges) == 0 {
+			fmt.Println(badcolor(fmt.Sprintf("Warning: %s is not the namespace authority and has no archival access to %s, so the grant will have no effect", our_vk, uri)))
+		}
+	}
+
+	datmoney := bw2.ConnectOrExit(c.String("agent"))
+	datmoney.SetEntityFileOrExit(c.String("bankroll"))
+	datmoney.OverrideAutoChainTo(true)
+
+	archiveURI := dots.ArchiveURI(from, to, uri)
+	params := &bw2.CreateDOTParams{
+		To:                key_vk,
+		TTL:               uint8(c.Int("ttl")),
+		Comment:           fmt.Sprintf("Archival access to %s", uri),
+		URI:               archiveURI,
+		ExpiryDelta:       expiry,
+		AccessPermissions: "C",
+	}
+	hash, blob, err := bwclient.CreateDOT(params)
+	if err != nil {
+		log.Fatal(errors.Wrapf(err, "Could not grant DOT to %s on %s", key_vk, archiveURI))
+	}
+	log.Info("Publishing DOT", hash)
+	if _, err := datmoney.PublishDOT(blob); err != nil {
+		log.Fatal(errors.Wrapf(err, "Could not publish DOT with hash %s (%s)", hash, archiveURI))
+	}
+	successcolor := ansi.ColorFunc("green")
+	window := "all data"
+	switch {
+	case !from.IsZero() && !to.IsZero():
+		window = fmt.Sprintf("data from %s to %s", from.Format(time.RFC3339), to.Format(time.RFC3339))
+	case !from.IsZero():
+		window = fmt.Sprintf("data from %s", from.Format(time.RFC3339))
+	case !to.IsZero():
+		window = fmt.Sprintf("data up to %s", to.Format(time.RFC3339))
+	}
+	fmt.Println(successcolor(fmt.Sprintf("Granted %s access to %s on %s (DOT %s)", key_vk, window, uri, hash)))
+	fmt.Println("Check the granted ranges with 'pundat range'")
+	return nil
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	bw2 "github.com/immesys/bw2bind"
+)
+
+// DOTs by giver; the VKs are short names
+type fakeRegistry map[string][]grant
+
+func (r fakeRegistry) grantsFrom(vk string) ([]grant, error) {
+	if vk == "broken" {
+		return nil, errors.New("registry unavailable")
+	}
+	return r[vk], nil
+}
+
+// the chains each VK can build, by URI and permissions
+type fakeChains map[string]map[string]bool
+
+func (c fakeChains) BuildAnyChain(uri, permissions, to string) (*bw2.SimpleChain, error) {
+	if c[to][permissions+" "+uri] {
+		return &bw2.SimpleChain{Hash: to + " " + uri}, nil
+	}
+	return nil, nil
+}
+
+func TestFindGrantees(t *testing.T) {
+	registry := fakeRegistry{
+		"ns": {
+			{receiver: "alice", access: true, ttl: 1},
+			{receiver: "bob", access: true},
+			{receiver: "perm", access: false},
+		},
+		// alice can pass on access; bob can't, so his DOTs aren't followed
+		"alice": {{receiver: "carol", access: true}, {receiver: "bob", access: true}},
+		"bob":   {{receiver: "dave", access: true}},
+	}
+	grantees, err := findGrantees(registry, []string{"ns"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(grantees, []string{"ns", "alice", "bob", "carol"}) {
+		t.Errorf("Expected [ns alice bob carol], got %v", grantees)
+	}
+
+	registry["alice"] = append(registry["alice"], grant{receiver: "broken", access: true, ttl: 1})
+	if _, err := findGrantees(registry, []string{"ns"}); err == nil {
+		t.Error("Expected an error when the registry can't be read")
+	}
+}
+
+func TestArchiverGrants(t *testing.T) {
+	const (
+		uri    = "ns/archiver"
+		nsvk   = "nsvk"
+		key_vk = "alice="
+	)
+	uris := archiverAccessURIs(uri, key_vk)
+	suffix := func(accessURI string) string { return accessURI[len("ns/"):] }
+	registry := fakeRegistry{"giver": {
+		{hash: "comment", receiver: key_vk, comment: grantComment(uri)},
+		{hash: "query", receiver: key_vk, mvk: nsvk, suffix: suffix(uris[1]), perms: "P"},
+		// the same suffix in another namespace
+		{hash: "otherns", receiver: key_vk, mvk: "othervk", suffix: suffix(uris[1]), perms: "P"},
+		{hash: "perms", receiver: key_vk, mvk: nsvk, suffix: suffix(uris[1]), perms: "C"},
+		{hash: "bob", receiver: "bob=", comment: grantComment(uri)},
+	}}
+	grants, err := archiverGrants(registry, "giver", key_vk, uri, nsvk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var hashes []string
+	for _, g := range grants {
+		hashes = append(hashes, g.hash)
+	}
+	if !reflect.DeepEqual(hashes, []string{"comment", "query"}) {
+		t.Errorf("Expected the grants [comment query], got %v", hashes)
+	}
+	if _, err := archiverGrants(registry, "broken", key_vk, uri, nsvk); err == nil {
+		t.Error("Expected an error when the registry can't be read")
+	}
+}
+
+func TestArchiverUsers(t *testing.T) {
+	const uri = "ns/archiver"
+	can := func(vk string, which ...int) map[string]bool {
+		chains := make(map[string]bool)
+		for _, idx := range which {
+			chains[archiverAccessPermissions[idx]+" "+archiverAccessURIs(uri, vk)[idx]] = true
+		}
+		return chains
+	}
+	builder := fakeChains{
+		"all=":     can("all=", 0, 1, 2),
+		"noscan=":  can("noscan=", 1, 2),
+		"noreply=": can("noreply=", 0, 1),
+	}
+	users := archiverUsers(builder, []string{"all=", "noscan=", "noreply=", "none="}, uri)
+	if len(users) != 2 || users[0].vk != "all=" || users[1].vk != "noscan=" {
+		t.Fatalf("Expected all= and noscan= to have access, got %+v", users)
+	}
+	if users[1].chains[0] != nil {
+		t.Errorf("Expected noscan= to have no scan chain, got %+v", users[1].chains[0])
+	}
+}
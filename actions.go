@@ -1,12 +1,14 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"os"
 	"os/user"
 	"regexp"
-	"runtime"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -29,6 +31,10 @@ func init() {
 	fmt.Println(version.LOGO)
 }
 
+// how long the commands that check permissions cache them. Each command only runs for a
+// moment, so this just has to outlast it
+const commandPermissionExpiry = time.Minute
+
 func resolveKey(client *bw2.BW2Client, key string) (string, error) {
 	if _, err := os.Stat(key); err != nil && !os.IsNotExist(err) {
 		return "", errors.Wrap(err, "Could not check key file")
@@ -118,24 +124,43 @@ func scanWithClient(client *bw2.BW2Client, uri string) ([]string, []time.Time, e
 	return found, times, nil
 }
 
-func scan(uri, entity, agent string) ([]string, []time.Time, error) {
-	client := bw2.ConnectOrExit(agent)
-	client.SetEntityFileOrExit(entity)
-	client.OverrideAutoChainTo(true)
+// returns the status the archiver at the URI last published, or nil if it hasn't published one
+func getArchiverStatus(client *bw2.BW2Client, uri string) (*archiver.Status, error) {
+	res, err := client.Query(&bw2.QueryParams{
+		URI: uri + "/s.giles/!meta/status",
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "Could not query status of archiver at %s", uri)
+	}
+	var status *archiver.Status
+	for msg := range res {
+		po := msg.GetOnePODF(bw2.PODFMaskSMetadata)
+		if po == nil {
+			continue
+		}
+		if status, err = decodeArchiverStatus(po); err != nil {
+			return nil, err
+		}
+	}
+	return status, nil
+}
 
-	return scanWithClient(client, uri)
+// decodes the status from the metadata the archiver publishes it as
+func decodeArchiverStatus(po bw2.PayloadObject) (*archiver.Status, error) {
+	var md map[string]interface{}
+	if err := po.(bw2.MsgPackPayloadObject).ValueInto(&md); err != nil {
+		return nil, errors.Wrap(err, "Could not decode status")
+	}
+	doc, _ := md["val"].(string)
+	status := new(archiver.Status)
+	if err := json.Unmarshal([]byte(doc), status); err != nil {
+		return nil, errors.Wrap(err, "Could not decode status")
+	}
+	return status, nil
 }
 
 func startArchiver(c *cli.Context) error {
 	config := archiver.LoadConfig(c.String("config"))
-	if config.Archiver.PeriodicReport {
-		go func() {
-			for {
-				time.Sleep(30 * time.Second)
-				log.Infof("Number of active goroutines %v", runtime.NumGoroutine())
-			}
-		}()
-	}
 	a := archiver.NewArchiver(config)
 	a.Serve()
 	return nil
@@ -153,6 +178,15 @@ func makeConfig(c *cli.Context) error {
 	fmt.Fprintln(f, "[Archiver]")
 	fmt.Fprintln(f, "PeriodicReport = true")
 	fmt.Fprintln(f, "BlockExpiry = 10s")
+	fmt.Fprintln(f, "MaxPermissionStaleness = 10m")
+	fmt.Fprintln(f, "; read permissions from a YAML ACL instead of DOTs")
+	fmt.Fprintln(f, "; ACLFile = acl.yaml")
+	fmt.Fprintln(f, "; hide sensitive tags from some VKs")
+	fmt.Fprintln(f, "; RedactionFile = redaction.yaml")
+	fmt.Fprintln(f, "MaxReplyReadings = 100000")
+	fmt.Fprintln(f, "; record every query in an audit log in this directory (see 'pundat audit')")
+	fmt.Fprintln(f, "; AuditLog = audit")
+	fmt.Fprintln(f, "; AuditLogMaxSize = 100")
 	fmt.Fprintln(f, "")
 	fmt.Fprintln(f, "[BOSSWAVE]")
 	fmt.Fprintln(f, "Address = 0.0.0.0:28589")
@@ -172,6 +206,37 @@ func makeConfig(c *cli.Context) error {
 	fmt.Fprintln(f, "EnableCPUProfile = false")
 	fmt.Fprintln(f, "EnableMEMProfile = false")
 	fmt.Fprintln(f, "EnableBlockProfile = false")
+	fmt.Fprintln(f, "")
+	fmt.Fprintln(f, "; limits for every VK's queries (0 means no limit). Override them")
+	fmt.Fprintln(f, "; for one VK with a [Limits \"<vk>\"] section")
+	fmt.Fprintln(f, "[Limits]")
+	fmt.Fprintln(f, "MaxConcurrentQueries = 10")
+	fmt.Fprintln(f, "MaxStreams = 1000")
+	fmt.Fprintln(f, "MaxPoints = 10000000")
+	fmt.Fprintln(f, "MaxQueryTime = 60s")
+	fmt.Fprintln(f, "")
+	fmt.Fprintln(f, "; uncomment to serve the query API over HTTP/JSON")
+	fmt.Fprintln(f, ";[HTTP]")
+	fmt.Fprintln(f, ";Address = 0.0.0.0:8080")
+	fmt.Fprintln(f, ";Credentials = http-credentials.yaml")
+	fmt.Fprintln(f, ";TLSCert = cert.pem")
+	fmt.Fprintln(f, ";TLSKey = key.pem")
+	fmt.Fprintln(f, "")
+	fmt.Fprintln(f, "; uncomment to serve the query and ingestion API over gRPC")
+	fmt.Fprintln(f, ";[GRPC]")
+	fmt.Fprintln(f, ";Address = 0.0.0.0:4420")
+	fmt.Fprintln(f, ";Credentials = http-credentials.yaml")
+	fmt.Fprintln(f, ";WriteACL = grpc-write-acl.yaml")
+	fmt.Fprintln(f, "")
+	fmt.Fprintln(f, "; uncomment to accept InfluxDB line protocol on the HTTP API's /write endpoint and over UDP")
+	fmt.Fprintln(f, ";[Influx]")
+	fmt.Fprintln(f, ";WriteACL = influx-acl.yaml")
+	fmt.Fprintln(f, ";UDPAddress = 0.0.0.0:8089")
+	fmt.Fprintln(f, ";UDPVK = <VK whose grants apply to lines received over UDP>")
+	fmt.Fprintln(f, "")
+	fmt.Fprintln(f, "; serves Prometheus metrics on /metrics")
+	fmt.Fprintln(f, "[Metrics]")
+	fmt.Fprintln(f, "Address = localhost:6065")
 	return f.Sync()
 }
 
@@ -222,6 +287,52 @@ func doIQuery(c *cli.Context) error {
 	// check if we have a query specified to run
 	user_query := c.String("query")
 	if user_query != "" {
+		if isSubscription(user_query) {
+			// print readings until the process is killed, renewing the lease as it runs
+			// out. If it can't be renewed, sub.C is closed and we exit
+			sub, err := pc.Subscribe(user_query, c.Int("timeout"))
+			if err != nil {
+				fmt.Println(err)
+				return nil
+			}
+			fmt.Printf("Subscription %s to %d streams (expires %s)\n", sub.ID, sub.Streams, sub.Expires.Format(time.RFC3339))
+			go func() {
+				if err := sub.KeepAlive(c.Int("timeout")); err != nil {
+					fmt.Printf("Subscription %s expired: could not renew it (%v)\n", sub.ID, err)
+				}
+			}()
+			for readings := range sub.C {
+				printSubscriptionReadings(sub.ID, readings, formattime)
+			}
+			return nil
+		}
+		if isSubscriptionControl(user_query) {
+			res, err := pc.SubscriptionQuery(user_query, c.Int("timeout"))
+			if err != nil {
+				fmt.Println(err)
+			} else {
+				fmt.Println(res.Dump())
+			}
+			return nil
+		}
+		if isDistribution(user_query) {
+			dist, err := pc.Distribution(user_query, c.Int("timeout"))
+			if err != nil {
+				fmt.Println(err)
+			} else {
+				fmt.Println(dist.Dump())
+			}
+			return nil
+		}
+		if isExplain(user_query) {
+			plan, err := pc.Explain(user_query, c.Int("timeout"))
+			if err != nil {
+				fmt.Println(err)
+			} else {
+				fmt.Println(plan.Dump())
+			}
+			return nil
+		}
 		md, ts, ch, err := pc.Query(user_query, c.Int("timeout"))
 		if err != nil {
 			fmt.Println(err)
@@ -249,7 +360,15 @@ func doIQuery(c *cli.Context) error {
 	}
 
 	completer := readline.NewPrefixCompleter(
+		readline.PcItem("explain"),
+		readline.PcItem("subscribe",
+			readline.PcItem("data"),
+		),
+		readline.PcItem("renew"),
+		readline.PcItem("unsubscribe"),
 		readline.PcItem("select",
+			readline.PcItem("percentile"),
+			readline.PcItem("histogram"),
 			readline.PcItem("data",
 				readline.PcItem("in"),
 				readline.PcItem("before"),
@@ -274,12 +393,60 @@ func doIQuery(c *cli.Context) error {
 	}
 	defer rl.Close()
 
+	// subscriptions made in this session, so they stop printing when unsubscribed
+	subscriptions := make(map[string]*client.Subscription)
 	for {
 		line, err := rl.Readline()
 		if err != nil {
 			fmt.Println(err)
 			break
 		}
+		if isSubscription(line) {
+			sub, err := pc.Subscribe(line, c.Int("timeout"))
+			if err != nil {
+				fmt.Println(err)
+				continue
+			}
+			subscriptions[sub.ID] = sub
+			fmt.Printf("Subscription %s to %d streams (expires %s)\n", sub.ID, sub.Streams, sub.Expires.Format(time.RFC3339))
+			go func() {
+				for readings := range sub.C {
+					printSubscriptionReadings(sub.ID, readings, formattime)
+				}
+			}()
+			continue
+		}
+		if isSubscriptionControl(line) {
+			res, err := pc.SubscriptionQuery(line, c.Int("timeout"))
+			if err != nil {
+				fmt.Println(err)
+				continue
+			}
+			if sub, found := subscriptions[res.ID]; found && res.Expires == 0 {
+				sub.Close()
+				delete(subscriptions, res.ID)
+			}
+			fmt.Println(res.Dump())
+			continue
+		}
+		if isDistribution(line) {
+			dist, err := pc.Distribution(line, c.Int("timeout"))
+			if err != nil {
+				fmt.Println(err)
+			} else {
+				fmt.Println(dist.Dump())
+			}
+			continue
+		}
+		if isExplain(line) {
+			plan, err := pc.Explain(line, c.Int("timeout"))
+			if err != nil {
+				fmt.Println(err)
+			} else {
+				fmt.Println(plan.Dump())
+			}
+			continue
+		}
 		md, ts, ch, err := pc.Query(line, c.Int("timeout"))
 		if err != nil {
 			fmt.Println(err)
@@ -302,13 +469,51 @@ func doIQuery(c *cli.Context) error {
 	return nil
 }
 
+// returns true if the query asks for the query plan rather than the results
+func isExplain(query string) bool {
+	return strings.HasPrefix(strings.ToLower(strings.TrimSpace(query)), "explain")
+}
+
+// returns true if the query registers a subscription (SUBSCRIBE DATA WHERE ...)
+func isSubscription(query string) bool {
+	return strings.HasPrefix(strings.ToLower(strings.TrimSpace(query)), "subscribe")
+}
+
+// returns true if the query renews or ends a subscription
+func isSubscriptionControl(query string) bool {
+	query = strings.ToLower(strings.TrimSpace(query))
+	return strings.HasPrefix(query, "renew") || strings.HasPrefix(query, "unsubscribe")
+}
+
+func printSubscriptionReadings(id string, readings archiver.QueryTimeseriesResult, formattime bool) {
+	fmt.Printf("Subscription %s:\n", id)
+	if formattime {
+		fmt.Println(readings.DumpWithFormattedTime())
+	} else {
+		fmt.Println(readings.Dump())
+	}
+}
+
+// returns true if the query is a PERCENTILE or HISTOGRAM query, which have their own reply
+func isDistribution(query string) bool {
+	fields := strings.Fields(strings.ToLower(query))
+	if len(fields) < 2 || fields[0] != "select" {
+		return false
+	}
+	return strings.HasPrefix(fields[1], "percentile") || strings.HasPrefix(fields[1], "histogram")
+}
+
 func doScan(c *cli.Context) error {
 	bw2.SilenceLog()
 	if c.NArg() == 0 {
 		return errors.New("Need to specify a namespace or URI prefix to scan")
 	}
 
-	archivers, times, err := scan(c.Args().Get(0), c.String("entity"), c.String("agent"))
+	client := bw2.ConnectOrExit(c.String("agent"))
+	client.SetEntityFileOrExit(c.String("entity"))
+	client.OverrideAutoChainTo(true)
+
+	archivers, times, err := scanWithClient(client, c.Args().Get(0))
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -321,23 +526,44 @@ func doScan(c *cli.Context) error {
 		alive := times[i]
 		ago := time.Since(alive)
 		uri := archivers[i]
-		oldColor := ansi.ColorFunc("red")
-		newColor := ansi.ColorFunc("green+b")
+		color := ansi.ColorFunc("green+b")
 		if ago.Minutes() > time.Duration(5*time.Minute).Minutes() {
-			fmt.Println(oldColor("Found Archiver at:"))
-			fmt.Println(oldColor(fmt.Sprintf("     URI        -> %s", uri)))
-			fmt.Println(oldColor(fmt.Sprintf("     Last Alive -> %v (%v ago)", alive, ago)))
-			fmt.Println()
-		} else {
-			fmt.Println(newColor("Found Archiver at:"))
-			fmt.Println(newColor(fmt.Sprintf("     URI        -> %s", uri)))
-			fmt.Println(newColor(fmt.Sprintf("     Last Alive -> %v (%v ago)", alive, ago)))
-			fmt.Println()
+			color = ansi.ColorFunc("red")
+		}
+		fmt.Println(color("Found Archiver at:"))
+		fmt.Println(color(fmt.Sprintf("     URI        -> %s", uri)))
+		fmt.Println(color(fmt.Sprintf("     Last Alive -> %v (%v ago)", alive, ago)))
+		status, err := getArchiverStatus(client, uri)
+		if err != nil {
+			log.Error(err)
+		} else if status != nil {
+			printArchiverStatus(color, status)
 		}
+		fmt.Println()
 	}
 	return nil
 }
 
+func printArchiverStatus(color func(string) string, status *archiver.Status) {
+	fmt.Println(color(fmt.Sprintf("     Version    -> %s", status.Version)))
+	fmt.Println(color(fmt.Sprintf("     Uptime     -> %v (since %v)", time.Duration(status.Uptime)*time.Second, status.Started)))
+	fmt.Println(color(fmt.Sprintf("     Namespaces -> %s", strings.Join(status.Namespaces, ", "))))
+	fmt.Println(color(fmt.Sprintf("     Requests   -> %d", status.ArchiveRequests)))
+	fmt.Println(color(fmt.Sprintf("     Streams    -> %d", status.Streams)))
+	var backends []string
+	for name := range status.Backends {
+		backends = append(backends, name)
+	}
+	sort.Strings(backends)
+	for _, name := range backends {
+		health := status.Backends[name]
+		if health != "ok" {
+			health = ansi.Color(health, "red+b")
+		}
+		fmt.Println(color(fmt.Sprintf("     %-10s -> ", name)) + health)
+	}
+}
+
 func doCheck(c *cli.Context) error {
 	bw2.SilenceLog()
 	key := c.String("key")
@@ -414,7 +640,7 @@ func doGrant(c *cli.Context) error {
 		params := &bw2.CreateDOTParams{
 			To:                key_vk,
 			TTL:               0,
-			Comment:           fmt.Sprintf("Access to archiver on URI %s", uri),
+			Comment:           grantComment(uri),
 			URI:               scanURI,
 			ExpiryDelta:       expiry,
 			AccessPermissions: "C*",
@@ -437,7 +663,7 @@ func doGrant(c *cli.Context) error {
 		params := &bw2.CreateDOTParams{
 			To:                key_vk,
 			TTL:               0,
-			Comment:           fmt.Sprintf("Access to archiver on URI %s", uri),
+			Comment:           grantComment(uri),
 			URI:               queryURI,
 			ExpiryDelta:       expiry,
 			AccessPermissions: "P",
@@ -460,7 +686,7 @@ func doGrant(c *cli.Context) error {
 		params := &bw2.CreateDOTParams{
 			To:                key_vk,
 			TTL:               0,
-			Comment:           fmt.Sprintf("Access to archiver on URI %s", uri),
+			Comment:           grantComment(uri),
 			URI:               responseURI,
 			ExpiryDelta:       expiry,
 			AccessPermissions: "C",
@@ -549,50 +775,24 @@ func checkAccess(bwclient *bw2.BW2Client, key, uri string) (uris []string, hasPe
 		return
 	}
 
-	scanURI := uri + "/*/s.giles/!meta/lastalive"                                              // (C*)
-	queryURI := uri + "/s.giles/_/i.archiver/slot/query"                                       // (P)
-	responseURI := uri + "/s.giles/_/i.archiver/signal/" + key_vk[:len(key_vk)-1] + ",queries" // (C)
-	uris = []string{scanURI, queryURI, responseURI}
-	hasPermission = []bool{false, false, false}
-
 	// now check access
-	chain, err := bwclient.BuildAnyChain(scanURI, "C*", key_vk)
+	uris, chains, err := buildAccessChains(bwclient, key_vk, uri)
+	hasPermission = make([]bool, len(uris))
 	if err != nil {
-		err = errors.Wrapf(err, "Could not build chain on %s to %s", scanURI, key_vk)
 		return
 	}
-	if chain == nil {
-		err = errors.New(fmt.Sprintf("Key %s does not have a chain to find archivers (%s)", key_vk, scanURI))
-		return
-	} else {
-		hasPermission[0] = true
-		fmt.Printf("Hash: %s  Permissions: %s%s URI: %s\n", chain.Hash, chain.Permissions, strings.Repeat(" ", 5-len(chain.Permissions)), chain.URI)
-	}
-
-	chain, err = bwclient.BuildAnyChain(queryURI, "P", key_vk)
-	if err != nil {
-		err = errors.Wrapf(err, "Could not build chain on %s to %s", queryURI, key_vk)
-		return
-	}
-	if chain == nil {
-		err = errors.New(fmt.Sprintf("Key %s does not have a chain to publish to the archiver (%s)", key_vk, queryURI))
-		return
-	} else {
-		hasPermission[1] = true
+	for idx, chain := range chains {
+		if chain == nil {
+			continue
+		}
+		hasPermission[idx] = true
 		fmt.Printf("Hash: %s  Permissions: %s%s URI: %s\n", chain.Hash, chain.Permissions, strings.Repeat(" ", 5-len(chain.Permissions)), chain.URI)
 	}
-
-	chain, err = bwclient.BuildAnyChain(responseURI, "C", key_vk)
-	if err != nil {
-		err = errors.Wrapf(err, "Could not build chain on %s to %s", responseURI, key_vk)
-		return
-	}
-	if chain == nil {
-		err = errors.New(fmt.Sprintf("Key %s does not have a chain to consume from the archiver (%s)", key_vk, responseURI))
-		return
-	} else {
-		hasPermission[2] = true
-		fmt.Printf("Hash: %s  Permissions: %s%s URI: %s\n", chain.Hash, chain.Permissions, strings.Repeat(" ", 5-len(chain.Permissions)), chain.URI)
+	for idx, chain := range chains {
+		if chain == nil {
+			err = errors.New(fmt.Sprintf("Key %s does not have a chain to %s (%s)", key_vk, archiverAccessPurposes[idx], uris[idx]))
+			return
+		}
 	}
 
 	fmt.Println(successcolor(fmt.Sprintf("Key %s has access to archiver at %s\n", key_vk, uri)))
@@ -605,7 +805,7 @@ func doRange(c *cli.Context) error {
 	client := bw2.ConnectOrExit(c.String("agent"))
 	client.SetEntityFileOrExit(c.String("entity"))
 	client.OverrideAutoChainTo(true)
-	master := dots.NewDotMaster(client, 10)
+	master := dots.NewDotMaster(client, commandPermissionExpiry, dots.DefaultMaxStaleness)
 
 	uri := c.String("uri")
 	if uri == "" {
@@ -617,8 +817,12 @@ func doRange(c *cli.Context) error {
 	if err != nil {
 		log.Fatal(errors.Wrapf(err, "Could not resolve key %s", key))
 	}
+	// archival DOT chains are found from the namespace VK
+	if resolved, err := resolveNamespace(client, uri); err == nil {
+		uri = resolved
+	}
 
-	rangeset, err := master.GetValidRanges(c.String("uri"), key_vk)
+	rangeset, err := master.GetValidRanges(uri, key_vk)
 	if err != nil {
 		log.Fatal(errors.Wrapf(err, "Could not check ranges for uri %s against key %s", uri, key_vk))
 	}
@@ -626,50 +830,173 @@ func doRange(c *cli.Context) error {
 	for _, r := range rangeset.Ranges {
 		fmt.Println("  ", r.String())
 	}
+	windows, err := master.GetArchivalRanges(uri, key_vk)
+	if err != nil {
+		log.Fatal(errors.Wrapf(err, "Could not find archival DOTs for uri %s to key %s", uri, key_vk))
+	}
+	if len(windows) > 0 {
+		fmt.Printf("of which %d were granted by archival DOTs (pundat grant-range):\n", len(windows))
+		for _, r := range windows {
+			fmt.Println("  ", r.String())
+		}
+	}
 	return nil
 }
 
-func doTime(c *cli.Context) error {
-	var supported_formats = []string{"1/2/2006",
-		"1-2-2006",
-		"1/2/2006 03:04:05 PM MST",
-		"1-2-2006 03:04:05 PM MST",
+var supportedTimeFormats = []string{"1/2/2006",
+	"1-2-2006",
+	"1/2/2006 03:04:05 PM MST",
+	"1-2-2006 03:04:05 PM MST",
+
+	"1/2/2006 15:04:05 MST",
+	"1-2-2006 15:04:05 MST",
+	"2006-1-2 15:04:05 MST",
+
+	"1/2/2006 03:04:05 PM",
+	"1-2-2006 03:04:05 PM",
+	"2006-1-2 03:04:05 PM",
+
+	"1/2/2006 15:04:05",
+	"1-2-2006 15:04:05",
+	"2006-1-2 15:04:05",
 
-		"1/2/2006 15:04:05 MST",
-		"1-2-2006 15:04:05 MST",
-		"2006-1-2 15:04:05 MST",
+	"1/2/2006 15:04",
+	"1-2-2006 15:04",
+	"2006-1-2 15:04",
+}
 
-		"1/2/2006 03:04:05 PM",
-		"1-2-2006 03:04:05 PM",
-		"2006-1-2 03:04:05 PM",
+// units of relative time expressions like -2d
+var relativeTimeUnits = map[string]time.Duration{
+	"ns": time.Nanosecond,
+	"us": time.Microsecond,
+	"ms": time.Millisecond,
+	"s":  time.Second,
+	"m":  time.Minute,
+	"h":  time.Hour,
+	"d":  24 * time.Hour,
+	"w":  7 * 24 * time.Hour,
+}
 
-		"1/2/2006 15:04:05",
-		"1-2-2006 15:04:05",
-		"2006-1-2 15:04:05",
+var relativeTimeRegex = regexp.MustCompile("^([-+]?)([0-9]+)([a-zA-Z]*)$")
 
-		"1/2/2006 15:04",
-		"1-2-2006 15:04",
-		"2006-1-2 15:04",
+// Parses a time expression: one of the supported formats, a Unix nanosecond timestamp,
+// or a time relative to now such as -2d or +1h
+func parseTimeExpression(s string) (time.Time, error) {
+	if results := relativeTimeRegex.FindStringSubmatch(s); results != nil {
+		amount, err := strconv.ParseInt(results[2], 10, 64)
+		if err != nil {
+			return time.Time{}, errors.Wrapf(err, "Could not parse time %s", s)
+		}
+		if results[3] == "" {
+			if results[1] == "-" {
+				amount = -amount
+			}
+			return time.Unix(0, amount), nil
+		}
+		unit, found := relativeTimeUnits[results[3]]
+		if !found {
+			return time.Time{}, errors.Errorf("Unknown unit %s in time %s", results[3], s)
+		}
+		offset := time.Duration(amount) * unit
+		if results[1] == "-" {
+			offset = -offset
+		}
+		return time.Now().Add(offset), nil
 	}
+	for _, format := range supportedTimeFormats {
+		if t, err := time.Parse(format, s); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, errors.Errorf("Could not parse time %s", s)
+}
+
+func doTime(c *cli.Context) error {
 	if c.NArg() == 0 {
 		// use current time
 		fmt.Println(time.Now().UnixNano())
 		return nil
 	}
-	re := regexp.MustCompile("^([-+]?)([0-9]*)([a-zA-Z]*)$")
 	for i := 0; i < c.NArg(); i++ {
-		s := c.Args().Get(i)
-		results := re.FindAllStringSubmatch(s, -1)
-		if len(results) == 0 {
-			for _, format := range supported_formats {
-				t, err := time.Parse(format, s)
-				if err != nil {
-					continue
-				}
-				fmt.Println(t.UnixNano())
-				break
+		t, err := parseTimeExpression(c.Args().Get(i))
+		if err != nil {
+			return err
+		}
+		fmt.Println(t.UnixNano())
+	}
+	return nil
+}
+
+func doAudit(c *cli.Context) error {
+	var (
+		vk     = c.String("vk")
+		prefix = c.String("uri")
+		from   time.Time
+		to     time.Time
+		err    error
+	)
+	if c.String("from") != "" {
+		if from, err = parseTimeExpression(c.String("from")); err != nil {
+			return err
+		}
+	}
+	if c.String("to") != "" {
+		if to, err = parseTimeExpression(c.String("to")); err != nil {
+			return err
+		}
+	}
+	entries, err := archiver.ReadAuditLog(c.String("log"), func(entry *archiver.AuditEntry) bool {
+		if vk != "" && entry.VK != vk {
+			return false
+		}
+		if !from.IsZero() && entry.Time.Before(from) {
+			return false
+		}
+		if !to.IsZero() && entry.Time.After(to) {
+			return false
+		}
+		if prefix == "" {
+			return true
+		}
+		for _, stream := range entry.Streams {
+			if strings.HasPrefix(stream.URI, prefix) {
+				return true
 			}
 		}
+		return false
+	})
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if c.Bool("json") {
+			line, err := json.Marshal(entry)
+			if err != nil {
+				return err
+			}
+			fmt.Println(string(line))
+			continue
+		}
+		fmt.Printf("%s %s (%dms)\n    %s\n", entry.Time.Format(time.RFC3339), entry.VK, entry.Millis, entry.Query)
+		if entry.Error != "" {
+			fmt.Printf("    error: %s\n", entry.Error)
+		}
+		if entry.Documents > 0 {
+			fmt.Printf("    %d documents\n", entry.Documents)
+		}
+		for _, stream := range entry.Streams {
+			if prefix != "" && !strings.HasPrefix(stream.URI, prefix) {
+				continue
+			}
+			fmt.Printf("    %s %s", stream.UUID, stream.URI)
+			if stream.Start != 0 || stream.End != 0 {
+				fmt.Printf(" [%s, %s]", time.Unix(0, stream.Start).Format(time.RFC3339), time.Unix(0, stream.End).Format(time.RFC3339))
+			}
+			if stream.Readings > 0 {
+				fmt.Printf(" %d readings", stream.Readings)
+			}
+			fmt.Println()
+		}
 	}
 	return nil
 }
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/gtfierro/pundat/archiver"
+	bw2 "github.com/immesys/bw2bind"
+)
+
+func TestDecodeArchiverStatus(t *testing.T) {
+	status := archiver.Status{
+		Version:         "0.3.4",
+		Started:         time.Date(2017, 9, 1, 12, 0, 0, 0, time.UTC),
+		Uptime:          3600,
+		Namespaces:      []string{"ns1", "ns2"},
+		ArchiveRequests: 4,
+		Streams:         12,
+		Goroutines:      80,
+		Backends:        map[string]string{"Metadata": "ok", "Timeseries": "Could not get BtrDB info"},
+	}
+	// as the archiver publishes it with SetMetadata
+	doc, err := json.Marshal(status)
+	if err != nil {
+		t.Fatal(err)
+	}
+	po := bw2.CreateMetadataPayloadObject(&bw2.MetadataTuple{Value: string(doc), Timestamp: time.Now().UnixNano()})
+
+	decoded, err := decodeArchiverStatus(po)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !decoded.Started.Equal(status.Started) {
+		t.Errorf("Expected the start time %s, got %s", status.Started, decoded.Started)
+	}
+	decoded.Started = status.Started
+	if !reflect.DeepEqual(*decoded, status) {
+		t.Errorf("Expected %+v, got %+v", status, *decoded)
+	}
+
+	garbled := bw2.CreateMetadataPayloadObject(&bw2.MetadataTuple{Value: "{", Timestamp: time.Now().UnixNano()})
+	if _, err := decodeArchiverStatus(garbled); err == nil {
+		t.Error("Expected an error for a status that isn't JSON")
+	}
+}
@@ -3,172 +3,366 @@ package archiver
 import (
 	"github.com/gtfierro/pundat/common"
 	"github.com/gtfierro/pundat/dots"
+	"github.com/pkg/errors"
 	"sort"
+	"time"
 )
 
 // how to do metadata DOT protection? run the query; if there is a uuid or path, we
 // then see if we can build a chain to the path (or translate uuid into a uri); if that is
 // the case, then we return, else we don't.
 
-func (a *Archiver) SelectTags(vk string, params *common.TagParams) ([]common.MetadataGroup, error) {
-	groups, err := a.MD.GetMetadata(vk, params.Tags, params.Where)
+// Returns the metadata documents matching params.Where that the VK is allowed to read, along with
+// a cursor for fetching the next page of results (if params.Page.Limit cut off the results)
+func (a *Archiver) SelectTags(vk string, params *common.TagParams) ([]common.MetadataGroup, string, error) {
+	var next string
+	if params.Page.Cursor != "" {
+		cursor, err := decodeCursor(params.Page.Cursor)
+		if err != nil {
+			return nil, next, err
+		}
+		params.Page.Offset = cursor.Offset
+	}
+	if !params.AsOf.IsZero() {
+		return a.selectTagsAsOf(vk, params)
+	}
+	groups, err := a.MD.GetMetadata(vk, params.Tags, params.Where, params.Page)
 	if err != nil {
-		return nil, err
+		return nil, next, err
+	}
+	// a full page means there are probably more results. Note that the cursor counts
+	// documents *before* they are masked by permission, so that subsequent pages
+	// line up with what the metadata store returns
+	if params.Page.Limit > 0 && len(groups) == params.Page.Limit {
+		next = (&queryCursor{Offset: params.Page.Offset + len(groups)}).encode()
 	}
-	return a.maskMetadataGroupsByPermission(vk, groups)
+	groups, err = a.maskMetadataGroupsByPermission(vk, params.Where, params.Page.OrderBy, groups)
+	return groups, next, err
 }
 
-func (a *Archiver) DistinctTag(vk string, params *common.DistinctParams) ([]string, error) {
-	return a.MD.GetDistinct(vk, params.Tag, params.Where)
+func (a *Archiver) DistinctTag(vk string, params *common.DistinctParams) ([]string, string, error) {
+	var next string
+	if params.Page.Cursor != "" {
+		cursor, err := decodeCursor(params.Page.Cursor)
+		if err != nil {
+			return nil, next, err
+		}
+		params.Page.Offset = cursor.Offset
+	}
+	if !params.AsOf.IsZero() {
+		return a.distinctTagAsOf(vk, params)
+	}
+	groups, err := a.MD.GetMetadata(vk, []string{params.Tag}, params.Where, common.Pagination{})
+	if err != nil {
+		return nil, next, err
+	}
+	values, err := a.readableDistinct(vk, params.Tag, params.Where, groups, params.Page)
+	if err != nil {
+		return nil, next, err
+	}
+	if params.Page.Limit > 0 && len(values) == params.Page.Limit {
+		next = (&queryCursor{Offset: params.Page.Offset + len(values)}).encode()
+	}
+	return values, next, nil
 }
 
-func (a *Archiver) SelectDataRange(vk string, params *common.DataParams) ([]common.Timeseries, error) {
+// Returns the data in [params.Begin, params.End] for all streams matching the query that the VK is allowed to read.
+// If any stream was truncated by params.DataLimit, also returns a cursor that resumes those streams
+func (a *queryContext) SelectDataRange(vk string, params *common.DataParams) ([]common.Timeseries, string, error) {
 	var (
 		err    error
 		result []common.Timeseries
+		resume map[string]int64
+		next   = &queryCursor{Resume: make(map[string]int64)}
 	)
-	if err = a.prepareDataParams(params); err != nil {
-		return result, err
+	if err = a.prepareDataParams(vk, params); err != nil {
+		return result, "", err
+	}
+	if params.Cursor != "" {
+		cursor, err := decodeCursor(params.Cursor)
+		if err != nil {
+			return result, "", err
+		}
+		resume = cursor.Resume
 	}
 	result = make([]common.Timeseries, len(params.UUIDs))
 
-	// TODO: this should be a time.Time consistently throughout (params. begin, end)
-	requestedRange := dots.NewTimeRangeNano(params.Begin, params.End)
-
 	// for each of the UUIDs in the params, get the intersection of that with the
 	// valid ranges of access this VK has to that UUID.
 	for idx, uuid := range params.UUIDs {
+		begin := params.Begin
+		if resume != nil {
+			from, found := resume[uuid.String()]
+			if !found {
+				// this stream was finished by a previous page
+				continue
+			}
+			begin = from
+		}
+		// TODO: this should be a time.Time consistently throughout (params. begin, end)
+		requestedRange := dots.NewTimeRangeNano(begin, params.End)
+
 		uri, err := a.MD.URIFromUUID(uuid)
 		if err != nil {
-			return result, err
+			return result, "", err
 		}
-		validRanges, err := a.dotmaster.GetValidRanges(uri, vk)
+		validRanges, err := a.authority.GetValidRanges(uri, vk)
 		if err != nil {
-			return result, err
+			return result, "", err
 		}
-		validRequestedRanges := validRanges.GetOverlap(requestedRange)
+		// walk the ranges in time order so that a truncated stream can be resumed
+		validRequestedRanges := matchedRanges(params, uuid, validRanges.GetOverlap(requestedRange))
 		for _, rng := range validRequestedRanges.Ranges {
 			tsresult, err := a.TS.GetDataUUID(uuid, rng.Start.UnixNano(), rng.End.UnixNano(), params.ConvertToUnit)
 			if err != nil {
-				return result, err
+				return result, "", err
 			}
+			a.cache.observe(uuid, tsresult.Generation)
 			result[idx].Extend(tsresult)
 
 			// check limit
 			if params.DataLimit > 0 && len(result[idx].Records) > params.DataLimit {
 				result[idx].Records = result[idx].Records[:params.DataLimit]
-				continue
+				next.Resume[uuid.String()] = result[idx].Records[params.DataLimit-1].Time.UnixNano() + 1
+				break
 			}
 		}
 	}
 
-	return result, err
+	if len(next.Resume) > 0 {
+		return result, next.encode(), err
+	}
+	return result, "", err
 }
 
 // selects the data point most immediately before the Start parameter for all matching streams
-func (a *Archiver) SelectDataBefore(vk string, params *common.DataParams) (result []common.Timeseries, err error) {
-	if err = a.prepareDataParams(params); err != nil {
+func (a *queryContext) SelectDataBefore(vk string, params *common.DataParams) (result []common.Timeseries, err error) {
+	if params.AsOfData && params.Where != nil {
+		if params.Begin, err = toNanoseconds(params.Begin); err != nil {
+			return
+		}
+		if err = a.matchHistory(params, 0, params.Begin); err != nil {
+			return
+		}
+	}
+	if err = a.prepareDataParams(vk, params); err != nil {
 		return
 	}
-	result, err = a.TS.Prev(params.UUIDs, params.Begin)
+	if params.Matched != nil {
+		result, err = a.prevMatching(params)
+	} else {
+		result, err = a.TS.Prev(params.UUIDs, params.Begin)
+	}
 	result = a.packResults(params, result)
 	return a.maskTimeseriesByPermission(vk, result)
 }
 
 // selects the data point most immediately after the Start parameter for all matching streams
-func (a *Archiver) SelectDataAfter(vk string, params *common.DataParams) (result []common.Timeseries, err error) {
-	if err = a.prepareDataParams(params); err != nil {
+func (a *queryContext) SelectDataAfter(vk string, params *common.DataParams) (result []common.Timeseries, err error) {
+	if params.AsOfData && params.Where != nil {
+		if params.Begin, err = toNanoseconds(params.Begin); err != nil {
+			return
+		}
+		if err = a.matchHistory(params, params.Begin, time.Now().UnixNano()); err != nil {
+			return
+		}
+	}
+	if err = a.prepareDataParams(vk, params); err != nil {
 		return
 	}
-	result, err = a.TS.Next(params.UUIDs, params.Begin)
+	if params.Matched != nil {
+		result, err = a.nextMatching(params)
+	} else {
+		result, err = a.TS.Next(params.UUIDs, params.Begin)
+	}
 	result = a.packResults(params, result)
 	return a.maskTimeseriesByPermission(vk, result)
 }
 
-func (a *Archiver) SelectStatisticalData(vk string, params *common.DataParams) (result []common.StatisticTimeseries, err error) {
-	if err = a.prepareDataParams(params); err != nil {
+// Returns statistical or window summaries of the data for all streams matching the query that the VK is allowed to read.
+// If any stream was truncated by params.DataLimit, also returns a cursor that resumes those streams
+func (a *queryContext) SelectStatisticalData(vk string, params *common.DataParams) (result []common.StatisticTimeseries, next string, err error) {
+	var (
+		resume map[string]int64
+		cursor = &queryCursor{Resume: make(map[string]int64)}
+	)
+	if err = a.prepareDataParams(vk, params); err != nil {
 		return
 	}
+	if params.Cursor != "" {
+		prev, err := decodeCursor(params.Cursor)
+		if err != nil {
+			return result, next, err
+		}
+		resume = prev.Resume
+	}
 	result = make([]common.StatisticTimeseries, len(params.UUIDs))
-	requestedRange := dots.NewTimeRangeNano(params.Begin, params.End)
 
 	for idx, uuid := range params.UUIDs {
+		begin := params.Begin
+		if resume != nil {
+			from, found := resume[uuid.String()]
+			if !found {
+				continue
+			}
+			begin = from
+		}
+		requestedRange := dots.NewTimeRangeNano(begin, params.End)
+
 		uri, err := a.MD.URIFromUUID(uuid)
 		if err != nil {
-			return result, err
+			return result, next, err
 		}
-		validRanges, err := a.dotmaster.GetValidRanges(uri, vk)
+		validRanges, err := a.authority.GetValidRanges(uri, vk)
 		if err != nil {
-			return result, err
+			return result, next, err
 		}
-		validRequestedRanges := validRanges.GetOverlap(requestedRange)
+		validRequestedRanges := matchedRanges(params, uuid, validRanges.GetOverlap(requestedRange))
 		for _, rng := range validRequestedRanges.Ranges {
-
-			var tsresult common.StatisticTimeseries
+			start, end := rng.Start.UnixNano(), rng.End.UnixNano()
 			if params.IsStatistical {
-				tsresult, err = a.TS.StatisticalDataUUID(uuid, params.PointWidth, rng.Start.UnixNano(), rng.End.UnixNano(), params.ConvertToUnit)
-			} else if params.IsWindow {
-				tsresult, err = a.TS.WindowDataUUID(uuid, params.Width, rng.Start.UnixNano(), rng.End.UnixNano(), params.ConvertToUnit)
+				// statistical windows are aligned to the pointwidth, so aligning the range
+				// doesn't change the result but does make repeated 'now' queries hit the cache
+				mask := int64(1)<<uint(params.PointWidth) - 1
+				start, end = start&^mask, end&^mask
 			}
-			log.Debug(len(tsresult.Records))
+			key := statisticsKey(params, start, end)
 
-			if err != nil {
-				return result, err
+			tsresult, found := a.cache.getStatistics(uuid, key)
+			if !found {
+				version := a.cache.version(uuid)
+				if params.IsStatistical {
+					tsresult, err = a.TS.StatisticalDataUUID(uuid, params.PointWidth, start, end, params.ConvertToUnit)
+				} else if params.Calendar != nil {
+					tsresult, err = a.calendarWindowData(uuid, params, start, end)
+				} else if params.IsWindow {
+					tsresult, err = a.TS.WindowDataUUID(uuid, params.Width, start, end, params.ConvertToUnit)
+				}
+				if err != nil {
+					return result, next, err
+				}
+				a.cache.putStatistics(uuid, key, version, &tsresult)
 			}
+			log.Debug(len(tsresult.Records))
+
 			result[idx].Extend(tsresult)
 
 			// check limit
 			if params.DataLimit > 0 && len(result[idx].Records) > params.DataLimit {
 				result[idx].Records = result[idx].Records[:params.DataLimit]
-				continue
+				// resume at the start of the next window
+				last := result[idx].Records[params.DataLimit-1].Time.UnixNano()
+				if params.IsStatistical {
+					cursor.Resume[uuid.String()] = last + (1 << uint(params.PointWidth))
+				} else if params.Calendar != nil {
+					cursor.Resume[uuid.String()] = params.Calendar.Next(time.Unix(0, last).In(params.Format.Location())).UnixNano()
+				} else {
+					cursor.Resume[uuid.String()] = last + int64(params.Width)
+				}
+				break
 			}
 		}
 	}
 
-	return result, err
+	if len(cursor.Resume) > 0 {
+		next = cursor.encode()
+	}
+	return result, next, err
 }
 
-func (a *Archiver) GetChangedRanges(params *common.DataParams) (result []common.ChangedRange, err error) {
-	if err = a.prepareDataParams(params); err != nil {
+// Returns the ranges of time that changed between two generations of the streams matching
+// the query, clipped to the ranges of time the VK may read
+func (a *queryContext) GetChangedRanges(vk string, params *common.DataParams) (result []common.ChangedRange, err error) {
+	if err = a.prepareDataParams(vk, params); err != nil {
 		return
 	}
 	result, err = a.TS.ChangedRanges(params.UUIDs, params.FromGen, params.ToGen, params.Resolution)
-	return
+	if err != nil {
+		return
+	}
+	return a.maskChangedRangesByPermission(vk, result)
+}
+
+// Resolves the streams the query reads (dropping those the VK has no access to),
+// normalizes the time range and applies the stream limits
+func (a *queryContext) prepareDataParams(vk string, params *common.DataParams) error {
+	if err := a.resolveStreams(vk, params); err != nil {
+		return err
+	}
+	err := a.applyStreamLimit(params)
+	a.resolved = append(a.resolved, params.UUIDs...)
+	return err
 }
 
-func (a *Archiver) prepareDataParams(params *common.DataParams) (err error) {
-	// parse and evaluate the where clause if we need to
-	if len(params.Where) > 0 {
-		params.UUIDs, err = a.MD.GetUUIDs("", params.Where)
+// Normalizes the time range and resolves the where clause to the streams the VK may
+// read, dropping those that only match because of tags hidden from the VK
+func (a *Archiver) resolveStreams(vk string, params *common.DataParams) (err error) {
+	// make sure that Begin/End are both in nanoseconds
+	if params.Begin, err = toNanoseconds(params.Begin); err != nil {
+		return err
+	}
+	if params.End, err = toNanoseconds(params.End); err != nil {
+		return err
+	}
+
+	// switch order so its consistent
+	if params.End < params.Begin {
+		params.Begin, params.End = params.End, params.Begin
+	}
+
+	// parse and evaluate the where clause if we need to. The selection cache only
+	// holds the results of evaluating it against the current metadata
+	if params.Where != nil && params.AsOfData {
+		// BEFORE and AFTER match the history over their own ranges first
+		if params.Matched == nil {
+			if err = a.matchHistory(params, params.Begin, params.End); err != nil {
+				return err
+			}
+		}
+	} else if params.Where != nil && !params.AsOf.IsZero() {
+		docs, err := a.MD.GetDocumentsAsOf(params.Where, params.AsOf)
 		if err != nil {
 			return err
 		}
+		params.UUIDs = params.UUIDs[:0]
+		for _, doc := range docs {
+			params.UUIDs = append(params.UUIDs, common.ParseUUID(doc["uuid"].(string)))
+		}
+	} else if params.Where != nil {
+		var found bool
+		if params.UUIDs, found = a.cache.getSelection(params.Where); !found {
+			params.UUIDs, err = a.MD.GetUUIDs(params.Where)
+			if err != nil {
+				return err
+			}
+			a.cache.putSelection(params.Where, params.UUIDs)
+		}
 	}
 
-	// apply the streamlimit if it exists
+	if params.UUIDs, err = readableStreams(a.authority, a.MD, vk, params.UUIDs); err != nil {
+		return err
+	}
+	params.UUIDs, err = a.redaction.unprobed(a.MD, vk, params.Where, params.UUIDs)
+	return err
+}
+
+// Truncates the streams to the query's STREAMLIMIT, then checks them against the VK's limits
+func (a *queryContext) applyStreamLimit(params *common.DataParams) error {
 	if params.StreamLimit > 0 && len(params.UUIDs) > params.StreamLimit {
 		params.UUIDs = params.UUIDs[:params.StreamLimit]
 	}
+	return a.checkStreamLimit(params)
+}
 
-	// make sure that Begin/End are both in nanoseconds
-	if begin_uot := common.GuessTimeUnit(params.Begin); begin_uot != common.UOT_NS {
-		params.Begin, err = common.ConvertTime(params.Begin, begin_uot, common.UOT_NS)
+func toNanoseconds(t int64) (int64, error) {
+	if uot := common.GuessTimeUnit(t); uot != common.UOT_NS {
+		converted, err := common.ConvertTime(t, uot, common.UOT_NS)
 		if err != nil {
-			return err
+			return t, badQuery(errors.Wrapf(err, "Invalid time %d", t))
 		}
+		return converted, nil
 	}
-	if end_uot := common.GuessTimeUnit(params.End); end_uot != common.UOT_NS {
-		params.End, err = common.ConvertTime(params.End, end_uot, common.UOT_NS)
-		if err != nil {
-			return err
-		}
-	}
-
-	// switch order so its consistent
-	if params.End < params.Begin {
-		params.Begin, params.End = params.End, params.Begin
-	}
-	return nil
+	return t, nil
 }
 
 func (a *Archiver) packResults(params *common.DataParams, readings []common.Timeseries) []common.Timeseries {
@@ -201,7 +395,7 @@ func (a *Archiver) maskTimeseriesByPermission(vk string, readings []common.Times
 			return common.EmptyTimeseries, err
 		}
 		// fetch the valid ranges for the URI that published these
-		validRanges, err := a.dotmaster.GetValidRanges(uri, vk)
+		validRanges, err := a.authority.GetValidRanges(uri, vk)
 		if err != nil {
 			return common.EmptyTimeseries, err
 		}
@@ -232,7 +426,9 @@ func (a *Archiver) maskTimeseriesByPermission(vk string, readings []common.Times
 	return ret, nil
 }
 
-func (a *Archiver) maskMetadataGroupsByPermission(vk string, metadata []common.MetadataGroup) ([]common.MetadataGroup, error) {
+// Drops the groups the VK may not read, and redacts the tags hidden from the VK on the rest
+// (see redactor). where and orderBy are those the groups were selected with
+func (a *Archiver) maskMetadataGroupsByPermission(vk string, where *common.Predicate, orderBy string, metadata []common.MetadataGroup) ([]common.MetadataGroup, error) {
 	var (
 		ret []common.MetadataGroup
 	)
@@ -246,10 +442,10 @@ func (a *Archiver) maskMetadataGroupsByPermission(vk string, metadata []common.M
 			}
 			group.URI = uri
 		}
-		if err := a.dotmaster.CanRead(group.URI, vk); err != nil {
+		if err := a.authority.CanRead(group.URI, vk); err != nil {
 			continue
 		}
 		ret = append(ret, group)
 	}
-	return ret, nil
+	return a.redaction.redactGroups(vk, where, orderBy, ret), nil
 }
@@ -8,7 +8,6 @@ import (
 	_ "net/http/pprof"
 	"os"
 	"os/signal"
-	"sync/atomic"
 	"syscall"
 	"time"
 
@@ -16,6 +15,7 @@ import (
 	"github.com/op/go-logging"
 	"github.com/pkg/errors"
 	"github.com/pkg/profile"
+	"github.com/prometheus/client_golang/prometheus"
 
 	"github.com/gtfierro/pundat/common"
 	"github.com/gtfierro/pundat/dots"
@@ -26,6 +26,9 @@ import (
 // logger
 var log *logging.Logger
 
+// the default for the MaxReplyReadings config option
+const defaultMaxReplyReadings = 100000
+
 // set up logging facilities
 func init() {
 	log = logging.MustGetLogger("archiver")
@@ -40,15 +43,26 @@ type Archiver struct {
 	bw        *bw2.BW2Client
 	vk        string
 	MD        MetadataStore
-	dotmaster *dots.DotMaster
+	authority Authorizer
+	redaction *redactor
 	TS        TimeseriesStore
 	svc       *bw2.Service
 	iface     *bw2.Interface
 	vm        *viewManager
 	qp        *querylang.QueryProcessor
+	cache     *resultCache
+	subs      *subscriptionManager
+	archived  *streamSet
+	limiter   *queryLimiter
+	influx    *influxIngester
+	auditlog  *auditLog
 	config    *Config
 	stop      chan bool
 
+	started time.Time
+	// the stores whose health is reported in the status, by name
+	backends map[string]pinger
+
 	bw2address string
 	bw2entity  string
 }
@@ -56,7 +70,10 @@ type Archiver struct {
 func NewArchiver(c *Config) (a *Archiver) {
 	scraper.Init()
 	a = &Archiver{
+		cache:      newResultCache(),
+		archived:   newStreamSet(),
 		config:     c,
+		started:    time.Now(),
 		stop:       make(chan bool),
 		bw2address: c.BOSSWAVE.Address,
 		bw2entity:  c.BOSSWAVE.Entityfile,
@@ -73,26 +90,33 @@ func NewArchiver(c *Config) (a *Archiver) {
 	go func() {
 		log.Fatal(http.ListenAndServe("localhost:6064", nil))
 	}()
-	go func() {
-		for _ = range time.Tick(10 * time.Second) {
-			_active_streams := atomic.LoadInt64(&currentStreams)
-			_completed := atomic.SwapInt64(&completedWrites, 0)
-			_pending := atomic.LoadInt64(&currentWrites)
-			log.Infof("active=%d completed=%d pending=%d", _active_streams, _completed, _pending)
-		}
-	}()
+	if c.Metrics.Address != "" {
+		startMetrics(c.Metrics)
+		log.Noticef("Serving metrics on %s", c.Metrics.Address)
+	}
 
 	// setup metadata
 	mongoaddr, err := net.ResolveTCPAddr("tcp4", c.Metadata.Address)
 	if err != nil {
 		log.Fatal(errors.Wrapf(err, "Could not resolve Metadata address %s", c.Metadata.Address))
 	}
-	a.MD = newMongoStore(&mongoConfig{address: mongoaddr, collectionPrefix: c.Metadata.CollectionPrefix})
+	mongo := newMongoStore(&mongoConfig{address: mongoaddr, collectionPrefix: c.Metadata.CollectionPrefix})
+	a.MD = &timedMetadataStore{MetadataStore: mongo}
+	// changes to metadata can change which streams a query matches
+	scraper.DB.OnUpdate(func(uri string) {
+		prefixDBUpdates.Inc()
+		a.cache.invalidateSelections()
+		a.cache.markStale(uri)
+	})
+	go a.cache.invalidateStale(a.MD)
 
-	a.TS = newBTrDBv4(&btrdbv4Config{addresses: []string{c.BtrDB.Address}})
-	if a.TS == nil {
+	btrdb := newBTrDBv4(&btrdbv4Config{addresses: []string{c.BtrDB.Address}})
+	if btrdb == nil {
 		log.Fatal("could not connect to btrdb")
 	}
+	a.TS = &timedStore{TimeseriesStore: btrdb}
+	a.backends = map[string]pinger{"Metadata": mongo, "Timeseries": btrdb}
+	a.TS = &invalidatingStore{TimeseriesStore: a.TS, cache: a.cache}
 	//	a.TS = NewCSVDB()
 
 	// setup bosswave
@@ -100,23 +124,57 @@ func NewArchiver(c *Config) (a *Archiver) {
 	a.bw.OverrideAutoChainTo(true)
 	a.vk = a.bw.SetEntityFileOrExit(c.BOSSWAVE.Entityfile)
 
-	// setup dot master
-	// parse duration
-	expiry, err := time.ParseDuration(c.Archiver.BlockExpiry)
-	if err != nil {
-		log.Fatal(errors.Wrapf(err, "Could not parse expiry duration %s", c.Archiver.BlockExpiry))
+	// setup permissions: either DOTs or a static ACL
+	if c.Archiver.ACLFile != "" {
+		acl, err := dots.ReadACL(c.Archiver.ACLFile)
+		if err != nil {
+			log.Fatal(errors.Wrapf(err, "Could not load ACL %s", c.Archiver.ACLFile))
+		}
+		log.Noticef("Using permissions from ACL %s instead of DOTs", c.Archiver.ACLFile)
+		a.authority = acl
+	} else {
+		a.authority = newDotMaster(c, a.bw)
+	}
+	if c.Archiver.RedactionFile != "" {
+		a.redaction, err = readRedactionPolicies(c.Archiver.RedactionFile, a.authority)
+		if err != nil {
+			log.Fatal(errors.Wrapf(err, "Could not load redaction policies %s", c.Archiver.RedactionFile))
+		}
+		log.Noticef("Redacting tags according to %s", c.Archiver.RedactionFile)
 	}
-	a.dotmaster = dots.NewDotMaster(a.bw, expiry)
+
+	// setup subscriptions. New streams and metadata changes can change which
+	// streams a subscription matches
+	a.subs = newSubscriptionManager(a.MD, a.authority, a.redaction)
+	a.TS = &notifyingStore{TimeseriesStore: a.TS, subs: a.subs}
+	scraper.DB.OnUpdate(func(uri string) {
+		a.subs.markStale()
+	})
 
 	// setup view manager
-	a.vm = newViewManager(a.bw, a.vk, c.BOSSWAVE, a.MD, a.TS, a.bw2address, a.bw2entity)
+	a.vm = newViewManager(a.bw, a.vk, c.BOSSWAVE, a.MD, a.TS, a.subs, a.archived, a.bw2address, a.bw2entity)
+	prometheus.MustRegister(muxCollector{vm: a.vm})
 
 	a.qp = querylang.NewQueryProcessor()
 
+	a.limiter, err = newQueryLimiter(c.Limits)
+	if err != nil {
+		log.Fatal(errors.Wrap(err, "Could not load query limits"))
+	}
+
+	if c.Archiver.AuditLog != "" {
+		a.auditlog, err = newAuditLog(c.Archiver.AuditLog, c.Archiver.AuditLogMaxSize)
+		if err != nil {
+			log.Fatal(errors.Wrap(err, "Could not open audit log"))
+		}
+		log.Noticef("Auditing queries to %s", c.Archiver.AuditLog)
+	}
+
 	queryClient := bw2.ConnectOrExit(c.BOSSWAVE.Address)
 	queryClient.OverrideAutoChainTo(true)
 	queryClient.SetEntityFileOrExit(c.BOSSWAVE.Entityfile)
-	a.svc = queryClient.RegisterService(c.BOSSWAVE.DeployNS, "s.giles")
+	// the archiver publishes its own heartbeat (see publishStatus)
+	a.svc = queryClient.RegisterServiceNoHb(c.BOSSWAVE.DeployNS, "s.giles")
 	a.iface = a.svc.RegisterInterface("_", "i.archiver")
 	queryChan, err := queryClient.Subscribe(&bw2.SubscribeParams{
 		URI: a.iface.SlotURI("query"),
@@ -127,9 +185,58 @@ func NewArchiver(c *Config) (a *Archiver) {
 	log.Noticef("Listening on %s", a.iface.SlotURI("query"))
 	common.NewWorkerPool(queryChan, a.listenQueries, 1000).Start()
 
+	if c.Influx.WriteACL != "" {
+		if a.influx, err = newInfluxIngester(a, c.Influx); err != nil {
+			log.Fatal(errors.Wrap(err, "Could not start line protocol ingestion"))
+		}
+		if c.Influx.UDPAddress != "" {
+			if err := a.influx.listenUDP(c.Influx.UDPAddress, c.Influx.UDPVK); err != nil {
+				log.Fatal(errors.Wrap(err, "Could not start line protocol ingestion"))
+			}
+			log.Noticef("Accepting line protocol over UDP on %s", c.Influx.UDPAddress)
+		}
+	}
+	if c.HTTP.Address != "" {
+		if err := a.startHTTP(c.HTTP); err != nil {
+			log.Fatal(errors.Wrap(err, "Could not start HTTP API"))
+		}
+		log.Noticef("Serving HTTP API on %s", c.HTTP.Address)
+	}
+	if c.GRPC.Address != "" {
+		if err := a.startGRPC(c.GRPC); err != nil {
+			log.Fatal(errors.Wrap(err, "Could not start gRPC API"))
+		}
+		log.Noticef("Serving gRPC API on %s", c.GRPC.Address)
+	}
+
 	return a
 }
 
+// sets up the dot master, which checks permissions by building DOT chains
+func newDotMaster(c *Config, client *bw2.BW2Client) *dots.DotMaster {
+	// parse duration
+	expiry, err := time.ParseDuration(c.Archiver.BlockExpiry)
+	if err != nil {
+		log.Fatal(errors.Wrapf(err, "Could not parse expiry duration %s", c.Archiver.BlockExpiry))
+	}
+	maxStaleness := dots.DefaultMaxStaleness
+	if c.Archiver.MaxPermissionStaleness != "" {
+		maxStaleness, err = time.ParseDuration(c.Archiver.MaxPermissionStaleness)
+		if err != nil {
+			log.Fatal(errors.Wrapf(err, "Could not parse MaxPermissionStaleness %s", c.Archiver.MaxPermissionStaleness))
+		}
+	}
+	dotmaster := dots.NewDotMaster(client, expiry, maxStaleness)
+	registerDotMasterMetrics(dotmaster)
+	go func() {
+		for _ = range time.Tick(time.Minute) {
+			stats := dotmaster.Stats()
+			log.Infof("permission cache hits=%d misses=%d hitrate=%.2f invalidations=%d revoked=%d", stats.Hits, stats.Misses, stats.HitRate(), stats.Invalidations, stats.Revoked)
+		}
+	}()
+	return dotmaster
+}
+
 func (a *Archiver) Serve() {
 	ctx, cancel := context.WithCancel(context.Background())
 	sigs := make(chan os.Signal, 1)
@@ -139,6 +246,7 @@ func (a *Archiver) Serve() {
 		log.Info("GOT SIGNAL-->", sig)
 		a.stop <- true
 	}()
+	go a.publishStatus(ctx)
 	for _, namespace := range a.config.BOSSWAVE.ListenNS {
 		go a.vm.subscribeNamespace(ctx, namespace)
 		time.Sleep(2 * time.Second)
@@ -180,7 +288,7 @@ func (a *Archiver) listenQueries(msg *bw2.SimpleMessage) {
 	signalURI = fmt.Sprintf("%s,queries", fromVK[:len(fromVK)-1])
 
 	log.Infof("Got query %+v", query)
-	mdRes, tsRes, statsRes, changedRes, err := a.HandleQuery(fromVK, query.Query)
+	res, err := a.HandleQuery(fromVK, query.Query)
 	if err != nil {
 		msg := QueryError{
 			Query: query.Query,
@@ -198,38 +306,157 @@ func (a *Archiver) listenQueries(msg *bw2.SimpleMessage) {
 	// assemble replies
 	var reply []bw2.PayloadObject
 
-	if len(mdRes) > 0 {
-		metadataPayload := POsFromMetadataGroup(query.Nonce, mdRes)
+	if res.Plan != nil {
+		res.Plan.Nonce = query.Nonce
+		reply = append(reply, res.Plan.ToMsgPackBW())
+	}
+
+	if len(res.Metadata) > 0 {
+		metadataPayload := POsFromMetadataGroup(query.Nonce, res.Metadata, res.Cursor)
 		reply = append(reply, metadataPayload)
 	}
 
-	if len(tsRes)+len(statsRes) > 0 {
-		timeseriesPayload := POsFromTimeseriesGroup(query.Nonce, tsRes, statsRes)
-		reply = append(reply, timeseriesPayload)
+	// large timeseries results are split into chunks. The first is sent with the rest
+	// of the reply and the others follow in their own messages
+	var chunks []bw2.PayloadObject
+	if len(res.Timeseries)+len(res.Statistics) > 0 {
+		chunks = POsFromTimeseriesGroup(query.Nonce, res.Timeseries, res.Statistics, res.Cursor, res.Format, a.maxReplyReadings())
+		reply = append(reply, chunks[0])
+		chunks = chunks[1:]
+	}
+
+	if len(res.Distributions) > 0 {
+		reply = append(reply, POsFromDistributions(query.Nonce, res.Distributions))
 	}
 
-	if len(changedRes) > 0 {
-		changedPayload := POsFromChangedGroup(query.Nonce, changedRes)
+	if res.Subscription != nil {
+		res.Subscription.Nonce = query.Nonce
+		reply = append(reply, res.Subscription.ToMsgPackBW())
+		// new readings are published on the subscriber's signal URI with the nonce of the SUBSCRIBE query
+		nonce := query.Nonce
+		a.subs.attach(res.Subscription.ID, false, func(readings QueryTimeseriesResult) error {
+			readings.Nonce = nonce
+			return a.iface.PublishSignal(signalURI, readings.ToMsgPackBW())
+		})
+	}
+
+	if len(res.Changed) > 0 {
+		changedPayload := POsFromChangedGroup(query.Nonce, res.Changed)
 		reply = append(reply, changedPayload)
 	}
 
 	// if we do not have any results, send back an empty metadata payload
 	if len(reply) == 0 {
-		metadataPayload := POsFromMetadataGroup(query.Nonce, mdRes)
+		metadataPayload := POsFromMetadataGroup(query.Nonce, res.Metadata, res.Cursor)
 		reply = append(reply, metadataPayload)
 	}
 
-	log.Infof("Reply to %s: %d POs MD/TS/Stat/Chng (%d/%d/%d/%d) (took %s)", fromVK, len(reply), len(mdRes), len(tsRes), len(statsRes), len(changedRes), time.Since(start))
+	log.Infof("Reply to %s: %d POs (+%d chunks) MD/TS/Stat/Dist/Chng (%d/%d/%d/%d/%d) (took %s)", fromVK, len(reply), len(chunks), len(res.Metadata), len(res.Timeseries), len(res.Statistics), len(res.Distributions), len(res.Changed), time.Since(start))
 
 	if err := a.iface.PublishSignal(signalURI, reply...); err != nil {
 		log.Error(errors.Wrap(err, "Error sending response"))
+		return
+	}
+	for _, chunk := range chunks {
+		if err := a.iface.PublishSignal(signalURI, chunk); err != nil {
+			log.Error(errors.Wrap(err, "Error sending response"))
+			return
+		}
+	}
+}
+
+func (a *Archiver) maxReplyReadings() int {
+	if a.config.Archiver.MaxReplyReadings > 0 {
+		return a.config.Archiver.MaxReplyReadings
 	}
+	return defaultMaxReplyReadings
+}
+
+// The results of evaluating a query. Only the fields relevant to the type of query will be populated
+type QueryResult struct {
+	Metadata   []common.MetadataGroup
+	Timeseries []common.Timeseries
+	Statistics []common.StatisticTimeseries
+	Changed    []common.ChangedRange
+	// for PERCENTILE and HISTOGRAM queries
+	Distributions []common.Distribution
+	// token for fetching the next page of results; empty if there are none
+	Cursor string
+	// how timestamps in Timeseries and Statistics should be rendered
+	Format common.TimeFormat
+	// populated instead of the results for EXPLAIN queries
+	Plan *QueryPlan
+	// for SUBSCRIBE, RENEW and UNSUBSCRIBE queries
+	Subscription *QuerySubscriptionResult
+}
+
+// An error caused by the query itself, or by how much it asks for, rather than by the
+// archiver or its databases
+type queryFault struct {
+	error
+	// the query is fine, but the VK should wait for its other queries to finish first
+	retry bool
 }
 
-func (a *Archiver) HandleQuery(vk, query string) (mdResult []common.MetadataGroup, tsResult []common.Timeseries, statsResult []common.StatisticTimeseries, changedResult []common.ChangedRange, err error) {
+func badQuery(err error) error {
+	return queryFault{error: err}
+}
+
+func busy(err error) error {
+	return queryFault{error: err, retry: true}
+}
+
+// Evaluates the query within the limits configured for the VK
+func (a *Archiver) HandleQuery(vk, query string) (result QueryResult, err error) {
 	parsed := a.qp.Parse(query)
+	return a.runLimited(vk, query, queryType(parsed), func(q *queryContext) (QueryResult, error) {
+		return q.handleQuery(vk, query, parsed)
+	})
+}
+
+// Runs a query within the limits configured for the VK (see limitedTo), and records it in
+// the audit log as the given query text and in the metrics as the given kind (see queryType)
+func (a *Archiver) runLimited(vk, query, kind string, run func(q *queryContext) (QueryResult, error)) (result QueryResult, err error) {
+	var (
+		started  = time.Now()
+		resolved []common.UUID
+	)
+	defer func() {
+		observeQuery(kind, started, err)
+		a.audit(vk, query, started, &result, resolved, err)
+	}()
+	limits, done, err := a.limiter.start(vk)
+	if err != nil {
+		return
+	}
+	defer done()
+	var (
+		ctx    context.Context
+		cancel context.CancelFunc
+	)
+	if limits.maxTime > 0 {
+		ctx, cancel = context.WithTimeout(context.Background(), limits.maxTime)
+	} else {
+		ctx, cancel = context.WithCancel(context.Background())
+	}
+	defer cancel()
+	q := a.limitedTo(ctx, limits)
+	result, err = run(q)
+	resolved = q.resolved
+	if err != nil && ctx.Err() == context.DeadlineExceeded {
+		err = badQuery(errors.Errorf("Query took longer than %s, the limit for this VK", limits.maxTime))
+	}
+	return
+}
+
+func (a *queryContext) handleQuery(vk, query string, parsed *querylang.ParsedQuery) (result QueryResult, err error) {
 	if parsed.Err != nil {
-		err = fmt.Errorf("Error (%v) in query \"%v\" (error at %v)\n", parsed.Err, query, parsed.ErrPos)
+		err = badQuery(fmt.Errorf("Error (%v) in query \"%v\" (error at %v)\n", parsed.Err, query, parsed.ErrPos))
+		return
+	}
+
+	if parsed.Explain {
+		result.Plan, err = a.ExplainQuery(vk, parsed)
 		return
 	}
 
@@ -238,41 +465,56 @@ func (a *Archiver) HandleQuery(vk, query string) (mdResult []common.MetadataGrou
 		if parsed.Distinct {
 			var results []string
 			params := parsed.GetParams().(*common.DistinctParams)
-			results, err = a.DistinctTag(vk, params)
+			results, result.Cursor, err = a.DistinctTag(vk, params)
 			// sandwidth the results into a metadata record
 			record := &common.MetadataRecord{
 				Key:   params.Tag,
 				Value: results,
 			}
-			mdResult = []common.MetadataGroup{
+			result.Metadata = []common.MetadataGroup{
 				{Records: map[string]*common.MetadataRecord{params.Tag: record}},
 			}
 			return
 		}
 		params := parsed.GetParams().(*common.TagParams)
-		mdResult, err = a.SelectTags(vk, params)
+		result.Metadata, result.Cursor, err = a.SelectTags(vk, params)
 		return
 	case querylang.DATA_TYPE:
 		params := parsed.GetParams().(*common.DataParams)
+		result.Format = params.Format
 		if params.IsStatistical || params.IsWindow {
-			statsResult, err = a.SelectStatisticalData(vk, params)
+			result.Statistics, result.Cursor, err = a.SelectStatisticalData(vk, params)
 			return
 		}
 		if params.IsChangedRanges {
-			changedResult, err = a.GetChangedRanges(params)
+			result.Changed, err = a.GetChangedRanges(vk, params)
+			return
+		}
+		if params.IsDistribution {
+			result.Distributions, err = a.SelectDistribution(vk, params)
 			return
 		}
 		switch parsed.Data.Dtype {
 		case querylang.IN_TYPE:
-			tsResult, err = a.SelectDataRange(vk, params)
+			result.Timeseries, result.Cursor, err = a.SelectDataRange(vk, params)
 			return
 		case querylang.BEFORE_TYPE:
-			tsResult, err = a.SelectDataBefore(vk, params)
+			result.Timeseries, err = a.SelectDataBefore(vk, params)
 			return
 		case querylang.AFTER_TYPE:
-			tsResult, err = a.SelectDataAfter(vk, params)
+			result.Timeseries, err = a.SelectDataAfter(vk, params)
 			return
 		}
+	case querylang.SUBSCRIBE_TYPE:
+		var sub QuerySubscriptionResult
+		sub, err = a.subs.subscribe(vk, parsed.GetParams().(*common.SubscribeParams))
+		result.Subscription = &sub
+		return
+	case querylang.UNSUBSCRIBE_TYPE:
+		var sub QuerySubscriptionResult
+		sub, err = a.subs.unsubscribe(vk, parsed.GetParams().(*common.UnsubscribeParams))
+		result.Subscription = &sub
+		return
 	}
 
 	return
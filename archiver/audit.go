@@ -0,0 +1,239 @@
+package archiver
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gtfierro/pundat/common"
+	"github.com/pkg/errors"
+)
+
+// the audit log is rotated once it grows past this many megabytes, unless configured otherwise
+const defaultAuditLogMaxSize = 100
+
+// the file the audit log is currently appended to. Rotated files are renamed to
+// audit-<time of rotation>.log, so sorting the names puts the files in order
+const currentAuditFile = "audit.log"
+
+// A record of a single query: who asked, what they asked for and what they were given
+type AuditEntry struct {
+	Time  time.Time
+	VK    string
+	Query string
+	// the streams in the results, and for data queries the streams the query read (even
+	// those without readings in the results). For data queries, the range of time the
+	// readings returned for each stream span (after masking by permission)
+	Streams []AuditStream `json:",omitempty"`
+	// the number of metadata documents returned
+	Documents int `json:",omitempty"`
+	// the number of readings (or statistical windows) returned
+	Readings int    `json:",omitempty"`
+	Error    string `json:",omitempty"`
+	// how long the query took, in milliseconds
+	Millis int64
+}
+
+type AuditStream struct {
+	UUID string
+	URI  string `json:",omitempty"`
+	// nanoseconds
+	Start    int64 `json:",omitempty"`
+	End      int64 `json:",omitempty"`
+	Readings int   `json:",omitempty"`
+}
+
+// An append-only log of queries, one JSON AuditEntry per line, in a directory of files
+type auditLog struct {
+	dir     string
+	maxSize int64
+	file    *os.File
+	size    int64
+	sync.Mutex
+}
+
+// maxSize is in megabytes
+func newAuditLog(dir string, maxSize int) (*auditLog, error) {
+	if maxSize <= 0 {
+		maxSize = defaultAuditLogMaxSize
+	}
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return nil, errors.Wrapf(err, "Could not create audit log directory %s", dir)
+	}
+	al := &auditLog{dir: dir, maxSize: int64(maxSize) * 1024 * 1024}
+	return al, al.open()
+}
+
+func (al *auditLog) open() error {
+	file, err := os.OpenFile(filepath.Join(al.dir, currentAuditFile), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0640)
+	if err != nil {
+		return errors.Wrap(err, "Could not open audit log")
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return errors.Wrap(err, "Could not open audit log")
+	}
+	al.file = file
+	al.size = info.Size()
+	return nil
+}
+
+// renames the current file out of the way and starts a new one
+func (al *auditLog) rotate() error {
+	if err := al.file.Close(); err != nil {
+		return errors.Wrap(err, "Could not close audit log")
+	}
+	rotated := filepath.Join(al.dir, "audit-"+time.Now().UTC().Format("20060102T150405.000000000")+".log")
+	if err := os.Rename(filepath.Join(al.dir, currentAuditFile), rotated); err != nil {
+		return errors.Wrap(err, "Could not rotate audit log")
+	}
+	return al.open()
+}
+
+func (al *auditLog) record(entry *AuditEntry) error {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return errors.Wrap(err, "Could not serialize audit entry")
+	}
+	line = append(line, '\n')
+	al.Lock()
+	defer al.Unlock()
+	if al.size > 0 && al.size+int64(len(line)) > al.maxSize {
+		if err := al.rotate(); err != nil {
+			return err
+		}
+	}
+	n, err := al.file.Write(line)
+	al.size += int64(n)
+	if err != nil {
+		return errors.Wrap(err, "Could not write audit entry")
+	}
+	return nil
+}
+
+// Records the query and its results in the audit log, if there is one. resolved are the
+// streams a data query read
+func (a *Archiver) audit(vk, query string, started time.Time, result *QueryResult, resolved []common.UUID, queryErr error) {
+	if a.auditlog == nil {
+		return
+	}
+	entry := &AuditEntry{
+		Time:   started,
+		VK:     vk,
+		Query:  query,
+		Millis: int64(time.Since(started) / time.Millisecond),
+	}
+	if queryErr != nil {
+		entry.Error = queryErr.Error()
+	}
+	addStream := func(uuid common.UUID, uri string) *AuditStream {
+		if uri == "" {
+			uri, _ = a.MD.URIFromUUID(uuid)
+		}
+		entry.Streams = append(entry.Streams, AuditStream{UUID: uuid.String(), URI: uri})
+		return &entry.Streams[len(entry.Streams)-1]
+	}
+	for i := range result.Metadata {
+		group := &result.Metadata[i]
+		entry.Documents++
+		if group.UUID.String() != "" {
+			addStream(group.UUID, group.URI)
+		}
+	}
+	for i := range result.Timeseries {
+		ts := &result.Timeseries[i]
+		stream := addStream(ts.UUID, ts.SrcURI)
+		stream.Readings = len(ts.Records)
+		for _, rdg := range ts.Records {
+			stream.extend(rdg.Time.UnixNano())
+		}
+		entry.Readings += stream.Readings
+	}
+	for i := range result.Statistics {
+		ts := &result.Statistics[i]
+		stream := addStream(ts.UUID, ts.SrcURI)
+		stream.Readings = len(ts.Records)
+		for _, rdg := range ts.Records {
+			stream.extend(rdg.Time.UnixNano())
+		}
+		entry.Readings += stream.Readings
+	}
+	for _, changed := range result.Changed {
+		stream := addStream(changed.UUID, "")
+		for _, rng := range changed.Ranges {
+			stream.extend(rng.StartTime)
+			stream.extend(rng.EndTime)
+		}
+	}
+	for _, dist := range result.Distributions {
+		stream := addStream(dist.UUID, "")
+		stream.Readings = int(dist.Count)
+		entry.Readings += stream.Readings
+	}
+	// streams that were read but had nothing in the results
+	seen := make(map[string]bool)
+	for _, stream := range entry.Streams {
+		seen[stream.UUID] = true
+	}
+	for _, uuid := range resolved {
+		if !seen[uuid.String()] {
+			seen[uuid.String()] = true
+			addStream(uuid, "")
+		}
+	}
+	if err := a.auditlog.record(entry); err != nil {
+		log.Error(errors.Wrap(err, "Could not audit query"))
+	}
+}
+
+// widens the stream's range to include the time
+func (stream *AuditStream) extend(t int64) {
+	if stream.Start == 0 || t < stream.Start {
+		stream.Start = t
+	}
+	if t > stream.End {
+		stream.End = t
+	}
+}
+
+// Returns the entries in the audit log in the directory for which keep returns true,
+// oldest first
+func ReadAuditLog(dir string, keep func(entry *AuditEntry) bool) ([]AuditEntry, error) {
+	var entries []AuditEntry
+	files, err := filepath.Glob(filepath.Join(dir, "audit-*.log"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(files)
+	files = append(files, filepath.Join(dir, currentAuditFile))
+	for _, filename := range files {
+		file, err := os.Open(filename)
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return entries, errors.Wrapf(err, "Could not open audit log %s", filename)
+		}
+		scanner := bufio.NewScanner(file)
+		scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+		for scanner.Scan() {
+			var entry AuditEntry
+			if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+				file.Close()
+				return entries, errors.Wrapf(err, "Corrupt entry in audit log %s", filename)
+			}
+			if keep(&entry) {
+				entries = append(entries, entry)
+			}
+		}
+		file.Close()
+		if err := scanner.Err(); err != nil {
+			return entries, errors.Wrapf(err, "Could not read audit log %s", filename)
+		}
+	}
+	return entries, nil
+}
@@ -0,0 +1,108 @@
+package archiver
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gtfierro/pundat/common"
+)
+
+func tempAuditLog(t *testing.T) (*auditLog, func()) {
+	dir, err := ioutil.TempDir("", "audit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	al, err := newAuditLog(dir, 1)
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	return al, func() {
+		al.file.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func TestAuditLogRotation(t *testing.T) {
+	al, cleanup := tempAuditLog(t)
+	defer cleanup()
+	// room for about two entries per file
+	al.maxSize = 150
+	for _, vk := range []string{"a", "b", "c", "d", "e"} {
+		if err := al.record(&AuditEntry{Time: time.Unix(0, 0), VK: vk, Query: "select Room where has Room;"}); err != nil {
+			t.Fatal(err)
+		}
+		// rotated files are named by the time of rotation
+		time.Sleep(time.Millisecond)
+	}
+	rotated, err := filepath.Glob(filepath.Join(al.dir, "audit-*.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rotated) < 2 {
+		t.Errorf("Expected the log to have been rotated at least twice, got %v", rotated)
+	}
+
+	var vks string
+	entries, err := ReadAuditLog(al.dir, func(entry *AuditEntry) bool { return entry.VK != "c" })
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		vks += entry.VK
+	}
+	if vks != "abde" {
+		t.Errorf("Expected the entries other than c in order, got %q", vks)
+	}
+
+	// reopening the log appends to the current file
+	size := al.size
+	al.file.Close()
+	if err := al.open(); err != nil {
+		t.Fatal(err)
+	}
+	if al.size != size {
+		t.Errorf("Expected the reopened log to be %d bytes, got %d", size, al.size)
+	}
+}
+
+func TestReadAuditLogMissing(t *testing.T) {
+	entries, err := ReadAuditLog(filepath.Join(os.TempDir(), "no-such-audit-log"), func(*AuditEntry) bool { return true })
+	if err != nil || len(entries) != 0 {
+		t.Errorf("Expected no entries and no error for a missing log, got %v, %v", entries, err)
+	}
+}
+
+func TestAuditResolvedStreams(t *testing.T) {
+	al, cleanup := tempAuditLog(t)
+	defer cleanup()
+	a := testArchiver()
+	a.auditlog = al
+
+	// the VK can't read uuidB, so only uuidA is read
+	q := unlimited(a)
+	if _, err := q.SelectDataBefore("vk", &common.DataParams{UUIDs: []common.UUID{uuidA, uuidB}, Begin: 15}); err != nil {
+		t.Fatal(err)
+	}
+	// a query that fails after reading has no results, but still read the stream
+	a.audit("vk", "select data before 15ns where has Room;", time.Now(), &QueryResult{}, q.resolved, errors.New("failed"))
+
+	entries, err := ReadAuditLog(al.dir, func(*AuditEntry) bool { return true })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("Expected one entry, got %v", entries)
+	}
+	streams := entries[0].Streams
+	if len(streams) != 1 || streams[0].UUID != uuidA.String() || streams[0].URI != "ns/public/temp" {
+		t.Errorf("Expected only the readable stream to be recorded, got %+v", streams)
+	}
+	if entries[0].Error != "failed" {
+		t.Errorf("Expected the error to be recorded, got %q", entries[0].Error)
+	}
+}
@@ -55,7 +55,7 @@ func (bdb *btrIface) getClient() *btrdb.BTrDBConnection {
 	return bdb.clients[rand.Intn(10)]
 }
 
-func (bdb *btrIface) AddReadings(ts common.Timeseries) error {
+func (bdb *btrIface) AddReadings(ts *common.Timeseries) error {
 	var (
 		parsed_uuid uuidlib.UUID
 		err         error
@@ -29,13 +29,17 @@ type btrdbv4Iface struct {
 	addresses       []string
 	conn            *btrdb.BTrDB
 	streamCache     map[string]*btrdb.Stream
-	streamCacheLock sync.RWMutex
+	streamCacheLock *sync.RWMutex
+	// calls are cancelled when this is done. Nil means they are only
+	// bounded by the timeout
+	ctx context.Context
 }
 
 func newBTrDBv4(c *btrdbv4Config) *btrdbv4Iface {
 	b := &btrdbv4Iface{
-		addresses:   c.addresses,
-		streamCache: make(map[string]*btrdb.Stream),
+		addresses:       c.addresses,
+		streamCache:     make(map[string]*btrdb.Stream),
+		streamCacheLock: new(sync.RWMutex),
 	}
 	log.Noticef("Connecting to BtrDBv4 at addresses %v...", b.addresses)
 	conn, err := btrdb.Connect(context.Background(), b.addresses...)
@@ -49,6 +53,22 @@ func newBTrDBv4(c *btrdbv4Config) *btrdbv4Iface {
 	return b
 }
 
+// Returns a view of the database whose calls are cancelled when ctx is done. The view
+// shares the connection and stream cache
+func (bdb *btrdbv4Iface) WithContext(ctx context.Context) TimeseriesStore {
+	view := *bdb
+	view.ctx = ctx
+	return &view
+}
+
+// the parent context for calls to BtrDB
+func (bdb *btrdbv4Iface) context() context.Context {
+	if bdb.ctx == nil {
+		return context.Background()
+	}
+	return bdb.ctx
+}
+
 // Fetch the stream object so we can read/write. This will first check the internal in-memory
 // cache of stream objects, then it will check the BtrDB client cache. If the stream
 // is not found there, then this method will return errStreamNotExist and a nil stream
@@ -61,7 +81,7 @@ func (bdb *btrdbv4Iface) getStream(streamuuid common.UUID) (stream *btrdb.Stream
 		return // from cache
 	}
 	// then check BtrDB for existing stream
-	ctx := context.Background()
+	ctx := bdb.context()
 	ctx, cancel := context.WithTimeout(ctx, timeout)
 	defer cancel()
 	stream = bdb.conn.StreamFromUUID(uuid.Parse(streamuuid.String()))
@@ -90,7 +110,7 @@ func (bdb *btrdbv4Iface) getStream(streamuuid common.UUID) (stream *btrdb.Stream
 // - a collection (which is the URI a message was published on)
 // - a set of tags (There will be one tag: name=request.Name)
 func (bdb *btrdbv4Iface) createStream(streamuuid common.UUID, uri, name, unit string) (stream *btrdb.Stream, err error) {
-	ctx := context.Background()
+	ctx := bdb.context()
 	ctx, cancel := context.WithTimeout(ctx, timeout)
 	defer cancel()
 
@@ -146,7 +166,7 @@ func (bdb *btrdbv4Iface) uuidsToStreams(uuids ...common.UUID) []*btrdb.Stream {
 	return streams
 }
 
-func (bdb *btrdbv4Iface) AddReadings(readings common.Timeseries) error {
+func (bdb *btrdbv4Iface) AddReadings(readings *common.Timeseries) error {
 	// get the stream object from the cache
 	stream, err := bdb.getStream(readings.UUID)
 	if err != nil {
@@ -154,7 +174,7 @@ func (bdb *btrdbv4Iface) AddReadings(readings common.Timeseries) error {
 	}
 
 	atomic.AddInt64(&currentWrites, 1)
-	ctx := context.Background()
+	ctx := bdb.context()
 	defer func() {
 		atomic.AddInt64(&currentWrites, -1)
 		atomic.AddInt64(&completedWrites, 1)
@@ -174,7 +194,7 @@ func (bdb *btrdbv4Iface) nearest(uuids []common.UUID, start int64, backwards boo
 	var results []common.Timeseries
 	streams := bdb.uuidsToStreams(uuids...)
 	for _, stream := range streams {
-		ctx := context.Background()
+		ctx := bdb.context()
 		ctx, cancel := context.WithTimeout(ctx, timeout)
 		defer cancel()
 		point, generation, err := stream.Nearest(ctx, start, 0, backwards)
@@ -207,8 +227,10 @@ func (bdb *btrdbv4Iface) GetData(uuids []common.UUID, start, end int64) ([]commo
 	var results []common.Timeseries
 	streams := bdb.uuidsToStreams(uuids...)
 	log.Debug(start, end)
+	budget := readBudgetFrom(bdb.context())
+	read := 0
 	for _, stream := range streams {
-		ctx := context.Background()
+		ctx := bdb.context()
 		ctx, cancel := context.WithTimeout(ctx, timeout)
 		defer cancel()
 
@@ -216,12 +238,26 @@ func (bdb *btrdbv4Iface) GetData(uuids []common.UUID, start, end int64) ([]commo
 			UUID: common.ParseUUID(stream.UUID().String()),
 		}
 		rawpoints, generations, errchan := stream.RawValues(ctx, start, end, 0)
-		// remember: must consume all points
+		// remember: must consume all points. If the query goes over its limit, stop
+		// reading and let the cancelled call drain
+		var overLimit error
 		for point := range rawpoints {
+			if overLimit != nil {
+				continue
+			}
+			read++
+			if overLimit = budget.exceededBy(read); overLimit != nil {
+				cancel()
+				continue
+			}
 			ts.Records = append(ts.Records, rawpointToTimeseriesReading(point, common.UOT_NS))
 		}
 		ts.Generation = <-generations
-		if err := <-errchan; err != nil {
+		err := <-errchan
+		if overLimit != nil {
+			return results, overLimit
+		}
+		if err != nil {
 			return results, errors.Wrapf(err, "Could not fetch rawdata for stream %s", stream.UUID())
 		}
 
@@ -233,26 +269,37 @@ func (bdb *btrdbv4Iface) GetData(uuids []common.UUID, start, end int64) ([]commo
 //func (s *Stream) RawValues(ctx context.Context, start int64, end int64, version int64) (chan RawPoint, chan int64, chan error)
 //RawValues reads raw values from BTrDB. The returned RawPoint channel must be fully consumed.
 // uot is the intended unit of time to interpret this as
-func (bdb *btrdbv4Iface) GetDataUUID(uuid common.UUID, start, end int64, uot common.UnitOfTime) (common.Timeseries, error) {
+func (bdb *btrdbv4Iface) GetDataUUID(uuid common.UUID, start, end int64, uot common.UnitOfTime) (ts common.Timeseries, err error) {
 	stream := bdb.uuidsToStreams(uuid)[0]
 	log.Debug(start, end)
-	ctx := context.Background()
+	ctx := bdb.context()
 	ctx, cancel := context.WithTimeout(ctx, timeout)
 	defer cancel()
 
-	ts := common.Timeseries{
-		UUID: common.ParseUUID(stream.UUID().String()),
-	}
+	ts.UUID = common.ParseUUID(stream.UUID().String())
 	rawpoints, generations, errchan := stream.RawValues(ctx, start, end, 0)
-	// remember: must consume all points
+	// remember: must consume all points. If the query goes over its limit, stop
+	// reading and let the cancelled call drain
+	budget := readBudgetFrom(ctx)
+	var overLimit error
 	for point := range rawpoints {
+		if overLimit != nil {
+			continue
+		}
+		if overLimit = budget.exceededBy(len(ts.Records) + 1); overLimit != nil {
+			cancel()
+			continue
+		}
 		ts.Records = append(ts.Records, rawpointToTimeseriesReading(point, uot))
 	}
 	ts.Generation = <-generations
-	if err := <-errchan; err != nil {
-		return ts, errors.Wrapf(err, "Could not fetch rawdata for stream %s", stream.UUID())
+	err = <-errchan
+	if overLimit != nil {
+		err = overLimit
+	} else if err != nil {
+		err = errors.Wrapf(err, "Could not fetch rawdata for stream %s", stream.UUID())
 	}
-	return ts, nil
+	return
 }
 
 // AlignedWindows reads power-of-two aligned windows from BTrDB.
@@ -267,7 +314,7 @@ func (bdb *btrdbv4Iface) StatisticalData(uuids []common.UUID, pointWidth int, st
 	streams := bdb.uuidsToStreams(uuids...)
 	log.Debug(start, end)
 	for _, stream := range streams {
-		ctx := context.Background()
+		ctx := bdb.context()
 		ctx, cancel := context.WithTimeout(ctx, timeout)
 		defer cancel()
 		ts := common.StatisticTimeseries{
@@ -291,7 +338,7 @@ func (bdb *btrdbv4Iface) StatisticalData(uuids []common.UUID, pointWidth int, st
 func (bdb *btrdbv4Iface) StatisticalDataUUID(uuid common.UUID, pointWidth int, start, end int64, uot common.UnitOfTime) (common.StatisticTimeseries, error) {
 	stream := bdb.uuidsToStreams(uuid)[0]
 	log.Debug(start, end)
-	ctx := context.Background()
+	ctx := bdb.context()
 	ctx, cancel := context.WithTimeout(ctx, timeout)
 	defer cancel()
 	ts := common.StatisticTimeseries{
@@ -323,7 +370,7 @@ func (bdb *btrdbv4Iface) WindowData(uuids []common.UUID, width uint64, start, en
 	var results []common.StatisticTimeseries
 	streams := bdb.uuidsToStreams(uuids...)
 	for _, stream := range streams {
-		ctx := context.Background()
+		ctx := bdb.context()
 		ctx, cancel := context.WithTimeout(ctx, timeout)
 		defer cancel()
 		ts := common.StatisticTimeseries{
@@ -346,7 +393,7 @@ func (bdb *btrdbv4Iface) WindowData(uuids []common.UUID, width uint64, start, en
 
 func (bdb *btrdbv4Iface) WindowDataUUID(uuid common.UUID, width uint64, start, end int64, uot common.UnitOfTime) (common.StatisticTimeseries, error) {
 	stream := bdb.uuidsToStreams(uuid)[0]
-	ctx := context.Background()
+	ctx := bdb.context()
 	ctx, cancel := context.WithTimeout(ctx, timeout)
 	defer cancel()
 	ts := common.StatisticTimeseries{
@@ -370,7 +417,7 @@ func (bdb *btrdbv4Iface) ChangedRanges(uuids []common.UUID, from_gen, to_gen uin
 	var results []common.ChangedRange
 	streams := bdb.uuidsToStreams(uuids...)
 	for _, stream := range streams {
-		ctx := context.Background()
+		ctx := bdb.context()
 		ctx, cancel := context.WithTimeout(ctx, timeout)
 		defer cancel()
 
@@ -392,7 +439,7 @@ func (bdb *btrdbv4Iface) ChangedRanges(uuids []common.UUID, from_gen, to_gen uin
 func (bdb *btrdbv4Iface) DeleteData(uuids []common.UUID, start, end int64) error {
 	streams := bdb.uuidsToStreams(uuids...)
 	for _, stream := range streams {
-		ctx := context.Background()
+		ctx := bdb.context()
 		ctx, cancel := context.WithTimeout(ctx, timeout)
 		defer cancel()
 		if _, err := stream.DeleteRange(ctx, start, end); err != nil {
@@ -420,7 +467,7 @@ func statpointToStatisticsReading(point btrdb.StatPoint) *common.StatisticsReadi
 func (bdb *btrdbv4Iface) AddAnnotations(uuid common.UUID, updates map[string]interface{}) error {
 	streams := bdb.uuidsToStreams(uuid)
 	for _, stream := range streams {
-		ctx := context.Background()
+		ctx := bdb.context()
 		ctx, cancel := context.WithTimeout(ctx, timeout)
 		defer cancel()
 		var annotations = make(map[string]*string)
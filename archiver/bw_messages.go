@@ -3,6 +3,7 @@ package archiver
 import (
 	"encoding/json"
 	"fmt"
+	"github.com/gtfierro/pundat/common"
 	bw2 "github.com/immesys/bw2bind"
 	"strings"
 	"time"
@@ -12,6 +13,18 @@ const GilesQueryChangedRangesPIDString = "2.0.8.8"
 
 var GilesQueryChangedRangesPID = bw2.FromDotForm(GilesQueryChangedRangesPIDString)
 
+const GilesQueryExplainPIDString = "2.0.8.10"
+
+var GilesQueryExplainPID = bw2.FromDotForm(GilesQueryExplainPIDString)
+
+const GilesQueryDistributionPIDString = "2.0.8.11"
+
+var GilesQueryDistributionPID = bw2.FromDotForm(GilesQueryDistributionPIDString)
+
+const GilesQuerySubscriptionPIDString = "2.0.8.12"
+
+var GilesQuerySubscriptionPID = bw2.FromDotForm(GilesQuerySubscriptionPIDString)
+
 type KeyValueQuery struct {
 	Query string
 	Nonce uint32
@@ -40,6 +53,9 @@ func (msg QueryError) IsEmpty() bool {
 type QueryMetadataResult struct {
 	Nonce uint32
 	Data  []KeyValueMetadata
+	// if non-empty, there are more results. Pass this back in a CURSOR
+	// clause to fetch the next page
+	Cursor string
 }
 
 func (msg QueryMetadataResult) ToMsgPackBW() (po bw2.PayloadObject) {
@@ -63,6 +79,18 @@ type QueryTimeseriesResult struct {
 	Nonce uint32
 	Data  []Timeseries
 	Stats []Statistics
+	// if non-empty, some streams were truncated by LIMIT. Pass this back in
+	// a CURSOR clause to fetch the rest
+	Cursor string
+	// the timezone of the query (from its TZ clause)
+	Timezone string
+	// Large results are sent as several messages with the same nonce, numbered
+	// from 0. Final is set on the last one. See Split and Append
+	Seqno uint32
+	Final bool
+	// set on every message of a result sent in chunks. Older archivers send each
+	// result as a single message without it, which is therefore the whole result
+	Chunked bool
 }
 
 func (msg QueryTimeseriesResult) ToMsgPackBW() (po bw2.PayloadObject) {
@@ -81,13 +109,21 @@ func (msg QueryTimeseriesResult) Dump() string {
 	return "[\n" + strings.Join(res, ",\n") + "\n]"
 }
 
+// formats the timestamps in the timezone of the query, or the local timezone if
+// the query didn't give one
 func (msg QueryTimeseriesResult) DumpWithFormattedTime() string {
+	loc := time.Local
+	if msg.Timezone != "" {
+		if zone, err := time.LoadLocation(msg.Timezone); err == nil {
+			loc = zone
+		}
+	}
 	var res []string
 	for _, ts := range msg.Data {
-		res = append(res, ts.DumpWithFormattedTime())
+		res = append(res, ts.DumpInLocation(loc))
 	}
 	for _, ts := range msg.Stats {
-		res = append(res, ts.DumpWithFormattedTime())
+		res = append(res, ts.DumpInLocation(loc))
 	}
 	return "[\n" + strings.Join(res, ",\n") + "\n]"
 }
@@ -96,6 +132,111 @@ func (msg QueryTimeseriesResult) IsEmpty() bool {
 	return len(msg.Data) == 0 && len(msg.Stats) == 0
 }
 
+// Splits the result into chunks of at most maxReadings readings each; the readings of a
+// single stream may be spread over several consecutive chunks. The cursor is carried
+// on the final chunk. If maxReadings <= 0, the result is returned as a single chunk
+func (msg QueryTimeseriesResult) Split(maxReadings int) []QueryTimeseriesResult {
+	var (
+		chunks []QueryTimeseriesResult
+		chunk  QueryTimeseriesResult
+		size   int
+	)
+	next := func() {
+		chunk = QueryTimeseriesResult{
+			Nonce:    msg.Nonce,
+			Data:     []Timeseries{},
+			Stats:    []Statistics{},
+			Timezone: msg.Timezone,
+			Seqno:    uint32(len(chunks)),
+			Chunked:  true,
+		}
+		size = 0
+	}
+	// returns how many readings of a stream with n readings (from offset) fit in the current chunk
+	room := func(offset, n int) int {
+		if maxReadings <= 0 || n-offset <= maxReadings-size {
+			return n - offset
+		}
+		return maxReadings - size
+	}
+	next()
+	for _, ts := range msg.Data {
+		for offset := 0; ; {
+			end := offset + room(offset, len(ts.Times))
+			chunk.Data = append(chunk.Data, ts.slice(offset, end))
+			size += end - offset
+			offset = end
+			if maxReadings > 0 && size >= maxReadings {
+				chunks = append(chunks, chunk)
+				next()
+			}
+			if offset >= len(ts.Times) {
+				break
+			}
+		}
+	}
+	for _, ts := range msg.Stats {
+		for offset := 0; ; {
+			end := offset + room(offset, len(ts.Times))
+			chunk.Stats = append(chunk.Stats, ts.slice(offset, end))
+			size += end - offset
+			offset = end
+			if maxReadings > 0 && size >= maxReadings {
+				chunks = append(chunks, chunk)
+				next()
+			}
+			if offset >= len(ts.Times) {
+				break
+			}
+		}
+	}
+	if len(chunks) == 0 || !chunk.IsEmpty() {
+		chunks = append(chunks, chunk)
+	}
+	chunks[len(chunks)-1].Final = true
+	chunks[len(chunks)-1].Cursor = msg.Cursor
+	return chunks
+}
+
+// Appends the next chunk of a result split by Split. Readings for the stream that ended the
+// previous chunk are appended to that stream rather than added as a new stream
+func (msg *QueryTimeseriesResult) Append(chunk QueryTimeseriesResult) {
+	for _, ts := range chunk.Data {
+		if n := len(msg.Data); n > 0 && msg.Data[n-1].UUID == ts.UUID {
+			last := &msg.Data[n-1]
+			last.Times = append(last.Times, ts.Times...)
+			last.Values = append(last.Values, ts.Values...)
+			last.Timestamps = append(last.Timestamps, ts.Timestamps...)
+			if ts.Generation > last.Generation {
+				last.Generation = ts.Generation
+			}
+			continue
+		}
+		msg.Data = append(msg.Data, ts)
+	}
+	for _, ts := range chunk.Stats {
+		if n := len(msg.Stats); n > 0 && msg.Stats[n-1].UUID == ts.UUID {
+			last := &msg.Stats[n-1]
+			last.Times = append(last.Times, ts.Times...)
+			last.Count = append(last.Count, ts.Count...)
+			last.Min = append(last.Min, ts.Min...)
+			last.Mean = append(last.Mean, ts.Mean...)
+			last.Max = append(last.Max, ts.Max...)
+			last.Timestamps = append(last.Timestamps, ts.Timestamps...)
+			if ts.Generation > last.Generation {
+				last.Generation = ts.Generation
+			}
+			continue
+		}
+		msg.Stats = append(msg.Stats, ts)
+	}
+	msg.Nonce = chunk.Nonce
+	msg.Timezone = chunk.Timezone
+	msg.Cursor = chunk.Cursor
+	msg.Seqno = chunk.Seqno
+	msg.Final = chunk.Final
+}
+
 type QueryChangedResult struct {
 	Nonce   uint32
 	Changed []ChangedRange
@@ -118,10 +259,163 @@ func (msg QueryChangedResult) IsEmpty() bool {
 	return len(msg.Changed) == 0
 }
 
+// The response to PERCENTILE and HISTOGRAM queries
+type QueryDistributionResult struct {
+	Nonce uint32
+	Data  []Distribution
+}
+
+func (msg QueryDistributionResult) ToMsgPackBW() (po bw2.PayloadObject) {
+	po, _ = bw2.CreateMsgPackPayloadObject(GilesQueryDistributionPID, msg)
+	return
+}
+
+func (msg QueryDistributionResult) Dump() string {
+	var res []string
+	for _, dist := range msg.Data {
+		res = append(res, dist.Dump())
+	}
+	return "[\n" + strings.Join(res, ",\n") + "\n]"
+}
+
+func (msg QueryDistributionResult) IsEmpty() bool {
+	return len(msg.Data) == 0
+}
+
+// The response to SUBSCRIBE, RENEW and UNSUBSCRIBE queries. New readings for the
+// subscription are published as QueryTimeseriesResults with the nonce of the
+// SUBSCRIBE query
+type QuerySubscriptionResult struct {
+	Nonce uint32
+	ID    string
+	// when the lease runs out (unix nanoseconds). 0 once unsubscribed
+	Expires int64
+	// the number of streams currently matching the where clause
+	Streams int
+}
+
+func (msg QuerySubscriptionResult) ToMsgPackBW() (po bw2.PayloadObject) {
+	po, _ = bw2.CreateMsgPackPayloadObject(GilesQuerySubscriptionPID, msg)
+	return
+}
+
+func (msg QuerySubscriptionResult) Dump() string {
+	if msg.Expires == 0 {
+		return fmt.Sprintf("Unsubscribed %s", msg.ID)
+	}
+	return fmt.Sprintf("Subscription %s to %d streams (expires %s)", msg.ID, msg.Streams, time.Unix(0, msg.Expires).Format(time.RFC3339))
+}
+
+type Distribution struct {
+	UUID       string  `msgpack:"uuid" json:"uuid"`
+	Generation uint64  `msgpack:"generation" json:"generation"`
+	Count      uint64  `msgpack:"count" json:"count"`
+	Min        float64 `msgpack:"min" json:"min"`
+	Max        float64 `msgpack:"max" json:"max"`
+	// requested percentiles (0-100) and their estimated values
+	Percentiles []float64 `msgpack:"percentiles" json:"percentiles"`
+	Values      []float64 `msgpack:"values" json:"values"`
+	// Counts[i] is the estimated number of values in [Edges[i], Edges[i+1])
+	Edges  []float64 `msgpack:"edges" json:"edges"`
+	Counts []uint64  `msgpack:"counts" json:"counts"`
+}
+
+func (msg Distribution) Dump() string {
+	res := map[string]interface{}{"uuid": msg.UUID, "Generation": msg.Generation, "Count": msg.Count}
+	if msg.Count > 0 {
+		res["Min"], res["Max"] = msg.Min, msg.Max
+	}
+	if len(msg.Percentiles) > 0 {
+		percentiles := make(map[string]float64)
+		for i, p := range msg.Percentiles {
+			if i < len(msg.Values) {
+				percentiles[fmt.Sprintf("p%v", p)] = msg.Values[i]
+			}
+		}
+		res["Percentiles"] = percentiles
+	}
+	if len(msg.Counts) > 0 {
+		var bins [][]interface{}
+		for i, count := range msg.Counts {
+			bins = append(bins, []interface{}{msg.Edges[i], msg.Edges[i+1], count})
+		}
+		res["Histogram"] = bins
+	}
+	if bytes, err := json.MarshalIndent(res, "", "  "); err != nil {
+		return fmt.Sprintf("%+v", msg)
+	} else {
+		return string(bytes)
+	}
+}
+
+// The plan for evaluating a query, returned in response to EXPLAIN <query>
+type QueryPlan struct {
+	Nonce uint32
+	Query string
+	// select, data or delete
+	Type string
+	// the parsed WHERE clause. Nil if the query has none
+	Where *common.Predicate
+	// the calls made to the metadata store to evaluate the WHERE clause
+	MetadataCalls []string
+	// for metadata queries: the number of documents the WHERE clause matched that the VK
+	// is allowed to read. Documents the VK can't read, or that only match on tags hidden
+	// from it, aren't counted
+	Matched int
+	// for data and subscribe queries: the number of streams the WHERE clause matched that
+	// the VK is allowed to read, before STREAMLIMIT
+	MatchedStreams int
+	// for data queries: the plan for each stream that would be read
+	Streams []StreamPlan
+	// the limits applied to the results. -1 or 0 means no limit
+	StreamLimit int
+	DataLimit   int
+	Page        common.Pagination
+}
+
+// How a single stream is read for a data query
+type StreamPlan struct {
+	UUID string
+	URI  string
+	// the ranges of time (in nanoseconds) the VK is allowed to read this stream
+	ValidRanges []PlanRange
+	// the ranges of time that will actually be read: the overlap of the valid
+	// ranges with the range of time in the query
+	ReadRanges []PlanRange
+	// the calls that would be made to the timeseries store
+	TimeseriesCalls []string
+	// why this stream will not be read, if it won't be
+	Error string
+}
+
+type PlanRange struct {
+	Start int64
+	End   int64
+}
+
+func (msg QueryPlan) ToMsgPackBW() (po bw2.PayloadObject) {
+	po, _ = bw2.CreateMsgPackPayloadObject(GilesQueryExplainPID, msg)
+	return
+}
+
+func (msg QueryPlan) Dump() string {
+	// print the WHERE clause as it would be written in a query
+	type plan QueryPlan
+	dump := struct {
+		plan
+		Where string
+	}{plan(msg), msg.Where.String()}
+	if bytes, err := json.MarshalIndent(dump, "", "  "); err != nil {
+		return fmt.Sprintf("%+v", msg)
+	} else {
+		return string(bytes)
+	}
+}
+
 type KeyValueMetadata struct {
-	UUID     string                 `msgpack:"uuid"`
-	Path     string                 `msgpack:"path"`
-	Metadata map[string]interface{} `msgpack:"metadata"`
+	UUID     string                 `msgpack:"uuid" json:"uuid"`
+	Path     string                 `msgpack:"path" json:"path"`
+	Metadata map[string]interface{} `msgpack:"metadata" json:"metadata"`
 }
 
 func (msg KeyValueMetadata) ToMsgPackBW() (po bw2.PayloadObject) {
@@ -151,11 +445,23 @@ func (msg KeyValueMetadata) Dump() string {
 }
 
 type Timeseries struct {
-	UUID       string    `msgpack:"uuid"`
-	Path       string    `msgpack:"path"`
-	Generation uint64    `msgpack:"generation"`
-	Times      []int64   `msgpack:"times"`
-	Values     []float64 `msgpack:"values"`
+	UUID       string    `msgpack:"uuid" json:"uuid"`
+	Path       string    `msgpack:"path" json:"path"`
+	Generation uint64    `msgpack:"generation" json:"generation"`
+	Times      []int64   `msgpack:"times" json:"times"`
+	Values     []float64 `msgpack:"values" json:"values"`
+	// Times as RFC3339 strings. Only populated for queries with AS rfc3339
+	Timestamps []string `msgpack:"timestamps,omitempty" json:"timestamps,omitempty"`
+}
+
+// returns the readings in [start, end)
+func (msg Timeseries) slice(start, end int) Timeseries {
+	msg.Times = msg.Times[start:end]
+	msg.Values = msg.Values[start:end]
+	if len(msg.Timestamps) > 0 {
+		msg.Timestamps = msg.Timestamps[start:end]
+	}
+	return msg
 }
 
 func (msg Timeseries) ToMsgPackBW() (po bw2.PayloadObject) {
@@ -176,9 +482,13 @@ func (msg Timeseries) Dump() string {
 }
 
 func (msg Timeseries) DumpWithFormattedTime() string {
+	return msg.DumpInLocation(time.Local)
+}
+
+func (msg Timeseries) DumpInLocation(loc *time.Location) string {
 	var res [][]interface{}
 	for i, timestamp := range msg.Times {
-		formattime := time.Unix(0, int64(timestamp))
+		formattime := time.Unix(0, int64(timestamp)).In(loc)
 		res = append(res, []interface{}{formattime, msg.Values[i]})
 	}
 	if bytes, err := json.MarshalIndent(map[string]interface{}{"uuid": msg.UUID, "Timeseries": res}, "", "  "); err != nil {
@@ -189,13 +499,28 @@ func (msg Timeseries) DumpWithFormattedTime() string {
 }
 
 type Statistics struct {
-	UUID       string    `msgpack:"uuid"`
-	Generation uint64    `msgpack:"generation"`
-	Times      []uint64  `msgpack:"times"`
-	Count      []uint64  `msgpack:"count"`
-	Min        []float64 `msgpack:"min"`
-	Mean       []float64 `msgpack:"mean"`
-	Max        []float64 `msgpack:"max"`
+	UUID       string    `msgpack:"uuid" json:"uuid"`
+	Generation uint64    `msgpack:"generation" json:"generation"`
+	Times      []uint64  `msgpack:"times" json:"times"`
+	Count      []uint64  `msgpack:"count" json:"count"`
+	Min        []float64 `msgpack:"min" json:"min"`
+	Mean       []float64 `msgpack:"mean" json:"mean"`
+	Max        []float64 `msgpack:"max" json:"max"`
+	// Times as RFC3339 strings. Only populated for queries with AS rfc3339
+	Timestamps []string `msgpack:"timestamps,omitempty" json:"timestamps,omitempty"`
+}
+
+// returns the windows in [start, end)
+func (msg Statistics) slice(start, end int) Statistics {
+	msg.Times = msg.Times[start:end]
+	msg.Count = msg.Count[start:end]
+	msg.Min = msg.Min[start:end]
+	msg.Mean = msg.Mean[start:end]
+	msg.Max = msg.Max[start:end]
+	if len(msg.Timestamps) > 0 {
+		msg.Timestamps = msg.Timestamps[start:end]
+	}
+	return msg
 }
 
 func (msg Statistics) ToMsgPackBW() (po bw2.PayloadObject) {
@@ -216,9 +541,13 @@ func (msg Statistics) Dump() string {
 }
 
 func (msg Statistics) DumpWithFormattedTime() string {
+	return msg.DumpInLocation(time.Local)
+}
+
+func (msg Statistics) DumpInLocation(loc *time.Location) string {
 	var res [][]interface{}
 	for i, timestamp := range msg.Times {
-		formattime := time.Unix(0, int64(timestamp))
+		formattime := time.Unix(0, int64(timestamp)).In(loc)
 		res = append(res, []interface{}{formattime, msg.Count[i], msg.Min[i], msg.Mean[i], msg.Max[i]})
 	}
 	if bytes, err := json.MarshalIndent(map[string]interface{}{"uuid": msg.UUID, "Generation": msg.Generation, "Timeseries": res}, "", "  "); err != nil {
@@ -229,10 +558,10 @@ func (msg Statistics) DumpWithFormattedTime() string {
 }
 
 type ChangedRange struct {
-	UUID       string `msgpack:"uuid"`
-	Generation uint64 `msgpack:"generation"`
-	StartTime  int64  `msgpack:"start"`
-	EndTime    int64  `msgpack:"end"`
+	UUID       string `msgpack:"uuid" json:"uuid"`
+	Generation uint64 `msgpack:"generation" json:"generation"`
+	StartTime  int64  `msgpack:"start" json:"start"`
+	EndTime    int64  `msgpack:"end" json:"end"`
 }
 
 func (msg ChangedRange) Dump() string {
@@ -0,0 +1,54 @@
+package archiver
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSplitAndAppendRoundTrip(t *testing.T) {
+	series := func(uuid string, n int) Timeseries {
+		ts := Timeseries{UUID: uuid, Generation: 3}
+		for i := 0; i < n; i++ {
+			ts.Times = append(ts.Times, int64(i))
+			ts.Values = append(ts.Values, float64(i))
+		}
+		return ts
+	}
+	result := QueryTimeseriesResult{
+		Nonce:  7,
+		Data:   []Timeseries{series("a", 5), series("b", 1), series("c", 7)},
+		Stats:  []Statistics{{UUID: "d", Times: []uint64{1, 2, 3}, Count: []uint64{1, 1, 1}, Min: []float64{1, 2, 3}, Mean: []float64{1, 2, 3}, Max: []float64{1, 2, 3}}},
+		Cursor: "next",
+	}
+	for _, max := range []int{0, 1, 3, 4, 16, 100} {
+		chunks := result.Split(max)
+		var reassembled QueryTimeseriesResult
+		for i, chunk := range chunks {
+			if int(chunk.Seqno) != i || !chunk.Chunked || chunk.Final != (i == len(chunks)-1) {
+				t.Errorf("max %d: chunk %d has seqno %d, chunked %v and final %v", max, i, chunk.Seqno, chunk.Chunked, chunk.Final)
+			}
+			var size int
+			for _, ts := range chunk.Data {
+				size += len(ts.Times)
+			}
+			for _, ts := range chunk.Stats {
+				size += len(ts.Times)
+			}
+			if max > 0 && size > max {
+				t.Errorf("max %d: chunk %d has %d readings", max, i, size)
+			}
+			reassembled.Append(chunk)
+		}
+		if max == 3 && len(chunks) != 6 {
+			t.Errorf("Expected 16 readings to take 6 chunks of 3, got %d", len(chunks))
+		}
+		if !reflect.DeepEqual(reassembled.Data, result.Data) || !reflect.DeepEqual(reassembled.Stats, result.Stats) || reassembled.Cursor != result.Cursor {
+			t.Errorf("max %d: expected the chunks to reassemble to %+v but got %+v", max, result, reassembled)
+		}
+	}
+
+	empty := QueryTimeseriesResult{Nonce: 7}.Split(10)
+	if len(empty) != 1 || !empty[0].Final {
+		t.Errorf("Expected an empty result to be sent as one final chunk, got %+v", empty)
+	}
+}
@@ -0,0 +1,209 @@
+package archiver
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/gtfierro/pundat/common"
+	"github.com/karlseguin/ccache"
+)
+
+// how long a cached statistical/window result is kept. This bounds how stale a
+// result can get if another writer advances the stream without us noticing
+const resultCacheTTL = 10 * time.Minute
+
+// how long the set of UUIDs matching a where clause is kept
+const selectionCacheTTL = 30 * time.Second
+
+// Caches the results of statistical and window queries. Dashboards tend to send the
+// same STATISTICS/WINDOW queries every few seconds, so we keep the per-stream results
+// keyed by the stream UUID (primary key) and the parameters of the query (secondary key).
+// Each cached result records the generation of the stream it was computed at; when we
+// learn the stream has advanced past that generation, all results for the stream are dropped.
+type resultCache struct {
+	results *ccache.LayeredCache
+	// where clause -> []common.UUID
+	selections *ccache.Cache
+	// the most recent generation we have seen for each stream
+	generations map[string]uint64
+	// bumped whenever a stream's results are invalidated, so a query that read the
+	// stream before then can't cache what it read
+	versions map[string]uint64
+	// URIs whose metadata changed, waiting for invalidateStale to drop their results
+	stale      map[string]struct{}
+	staleReady chan struct{}
+	sync.Mutex
+}
+
+type cachedStatistics struct {
+	records    []*common.StatisticsReading
+	generation uint64
+}
+
+func newResultCache() *resultCache {
+	return &resultCache{
+		results:     ccache.Layered(ccache.Configure().MaxSize(100000)),
+		selections:  ccache.New(ccache.Configure().MaxSize(10000)),
+		generations: make(map[string]uint64),
+		versions:    make(map[string]uint64),
+		stale:       make(map[string]struct{}),
+		staleReady:  make(chan struct{}, 1),
+	}
+}
+
+// the secondary key for a statistical or window query over [start, end] for a stream
+func statisticsKey(params *common.DataParams, start, end int64) string {
+	if params.IsStatistical {
+		return fmt.Sprintf("stat:%d:%d:%d:%s", params.PointWidth, start, end, params.ConvertToUnit)
+	}
+	if params.Calendar != nil {
+		return fmt.Sprintf("calendar:%s:%s:%d:%d:%s", params.Calendar, params.Format.Timezone, start, end, params.ConvertToUnit)
+	}
+	return fmt.Sprintf("window:%d:%d:%d:%s", params.Width, start, end, params.ConvertToUnit)
+}
+
+// Returns the cached result for the stream, or false if there is no result or the
+// stream has moved to a newer generation since the result was cached
+func (rc *resultCache) getStatistics(uuid common.UUID, key string) (common.StatisticTimeseries, bool) {
+	item := rc.results.Get(uuid.String(), key)
+	if item == nil || item.Expired() {
+		return common.StatisticTimeseries{}, false
+	}
+	cached := item.Value().(*cachedStatistics)
+	rc.Lock()
+	latest := rc.generations[uuid.String()]
+	rc.Unlock()
+	if cached.generation < latest {
+		rc.results.Delete(uuid.String(), key)
+		return common.StatisticTimeseries{}, false
+	}
+	return common.StatisticTimeseries{
+		UUID:       uuid,
+		Records:    cached.records,
+		Generation: cached.generation,
+	}, true
+}
+
+// returns the stream's version, to be passed to putStatistics with the result of the
+// read made after calling this
+func (rc *resultCache) version(uuid common.UUID) uint64 {
+	rc.Lock()
+	defer rc.Unlock()
+	return rc.versions[uuid.String()]
+}
+
+// Caches the result for the stream, unless the stream was invalidated after version
+// was taken: the result may have been read before a write that the cache has already
+// forgotten about
+func (rc *resultCache) putStatistics(uuid common.UUID, key string, version uint64, ts *common.StatisticTimeseries) {
+	rc.observe(uuid, ts.Generation)
+	rc.Lock()
+	defer rc.Unlock()
+	if rc.versions[uuid.String()] != version {
+		return
+	}
+	rc.results.Set(uuid.String(), key, &cachedStatistics{
+		records:    ts.Records,
+		generation: ts.Generation,
+	}, resultCacheTTL)
+}
+
+// Records the generation of a stream as returned by the timeseries store. If the
+// generation has advanced, the cached results for that stream are dropped
+func (rc *resultCache) observe(uuid common.UUID, generation uint64) {
+	rc.Lock()
+	defer rc.Unlock()
+	if generation > rc.generations[uuid.String()] {
+		rc.generations[uuid.String()] = generation
+		rc.results.DeleteAll(uuid.String())
+	}
+}
+
+// drops all cached results for the given stream, and any result still being read
+func (rc *resultCache) invalidate(uuid common.UUID) {
+	rc.Lock()
+	rc.versions[uuid.String()]++
+	rc.Unlock()
+	rc.results.DeleteAll(uuid.String())
+}
+
+// Queues the cached results of the stream at the URI to be dropped by invalidateStale.
+// Never blocks
+func (rc *resultCache) markStale(uri string) {
+	rc.Lock()
+	rc.stale[uri] = struct{}{}
+	rc.Unlock()
+	select {
+	case rc.staleReady <- struct{}{}:
+	default:
+	}
+}
+
+// Drops the cached results of the streams marked stale. A bulk metadata change marks
+// every dependent URI at once, so they are coalesced and resolved to UUIDs here, one
+// at a time, rather than in a goroutine each. Runs forever
+func (rc *resultCache) invalidateStale(md MetadataStore) {
+	for range rc.staleReady {
+		rc.Lock()
+		stale := rc.stale
+		rc.stale = make(map[string]struct{})
+		rc.Unlock()
+		for uri := range stale {
+			if uuid, err := md.UUIDFromURI(uri); err == nil {
+				rc.invalidate(uuid)
+			}
+		}
+	}
+}
+
+// returns the cached set of UUIDs matching the where clause
+func (rc *resultCache) getSelection(where *common.Predicate) ([]common.UUID, bool) {
+	item := rc.selections.Get(where.String())
+	if item == nil || item.Expired() {
+		return nil, false
+	}
+	return item.Value().([]common.UUID), true
+}
+
+func (rc *resultCache) putSelection(where *common.Predicate, uuids []common.UUID) {
+	rc.selections.Set(where.String(), uuids, selectionCacheTTL)
+}
+
+// drops all cached selections. Called when metadata changes, because we cannot
+// tell which where clauses are affected
+func (rc *resultCache) invalidateSelections() {
+	rc.selections.Clear()
+}
+
+// Wraps a TimeseriesStore so that all writes made by the archiver invalidate the
+// cached results of the streams they touch
+type invalidatingStore struct {
+	TimeseriesStore
+	cache *resultCache
+}
+
+func (s *invalidatingStore) RegisterStream(uuid common.UUID, uri, name, unit string) error {
+	// a new stream may match cached where clauses
+	s.cache.invalidateSelections()
+	return s.TimeseriesStore.RegisterStream(uuid, uri, name, unit)
+}
+
+func (s *invalidatingStore) AddReadings(readings *common.Timeseries) error {
+	err := s.TimeseriesStore.AddReadings(readings)
+	s.cache.invalidate(readings.UUID)
+	return err
+}
+
+func (s *invalidatingStore) WithContext(ctx context.Context) TimeseriesStore {
+	return &invalidatingStore{TimeseriesStore: s.TimeseriesStore.WithContext(ctx), cache: s.cache}
+}
+
+func (s *invalidatingStore) DeleteData(uuids []common.UUID, start int64, end int64) error {
+	err := s.TimeseriesStore.DeleteData(uuids, start, end)
+	for _, uuid := range uuids {
+		s.cache.invalidate(uuid)
+	}
+	return err
+}
@@ -0,0 +1,81 @@
+package archiver
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gtfierro/pundat/common"
+)
+
+func TestResultCacheDropsOlderGenerations(t *testing.T) {
+	rc := newResultCache()
+	key := statisticsKey(&common.DataParams{IsStatistical: true, PointWidth: 30}, 0, 100)
+	rc.putStatistics(uuidA, key, 0, &common.StatisticTimeseries{Generation: 5, Records: []*common.StatisticsReading{{Count: 1}}})
+	if cached, found := rc.getStatistics(uuidA, key); !found || cached.Generation != 5 {
+		t.Fatalf("Expected the result to be cached at generation 5, got %v %v", cached.Generation, found)
+	}
+	// the same generation doesn't invalidate the result
+	rc.observe(uuidA, 5)
+	if _, found := rc.getStatistics(uuidA, key); !found {
+		t.Error("Expected the result to survive observing the generation it was computed at")
+	}
+	rc.observe(uuidA, 6)
+	if _, found := rc.getStatistics(uuidA, key); found {
+		t.Error("Expected the result to be dropped once the stream advanced")
+	}
+}
+
+func TestResultCacheRefusesResultsReadBeforeAWrite(t *testing.T) {
+	rc := newResultCache()
+	key := statisticsKey(&common.DataParams{IsStatistical: true, PointWidth: 30}, 0, 100)
+	// a query reads the stream, then a write lands before it caches what it read
+	version := rc.version(uuidA)
+	rc.invalidate(uuidA)
+	rc.putStatistics(uuidA, key, version, &common.StatisticTimeseries{Generation: 1})
+	if _, found := rc.getStatistics(uuidA, key); found {
+		t.Error("Expected a result read before the write not to be cached")
+	}
+	// a query that started after the write may cache its result
+	rc.putStatistics(uuidA, key, rc.version(uuidA), &common.StatisticTimeseries{Generation: 1})
+	if _, found := rc.getStatistics(uuidA, key); !found {
+		t.Error("Expected a result read after the write to be cached")
+	}
+}
+
+// counts how many URIs were resolved
+type countingMetadata struct {
+	fakeMetadata
+	lookups chan string
+}
+
+func (md *countingMetadata) UUIDFromURI(uri string) (common.UUID, error) {
+	md.lookups <- uri
+	return uuidA, nil
+}
+
+func TestResultCacheInvalidatesStaleURIs(t *testing.T) {
+	rc := newResultCache()
+	key := statisticsKey(&common.DataParams{IsStatistical: true, PointWidth: 30}, 0, 100)
+	rc.putStatistics(uuidA, key, 0, &common.StatisticTimeseries{Generation: 1})
+	md := &countingMetadata{lookups: make(chan string, 100)}
+	// the same URI marked many times before the worker runs is only resolved once
+	for i := 0; i < 50; i++ {
+		rc.markStale("ns/public/temp")
+	}
+	go rc.invalidateStale(md)
+	select {
+	case <-md.lookups:
+	case <-time.After(time.Second):
+		t.Fatal("Expected the stale URI to be resolved")
+	}
+	for deadline := time.Now().Add(time.Second); ; time.Sleep(time.Millisecond) {
+		if _, found := rc.getStatistics(uuidA, key); !found {
+			break
+		} else if time.Now().After(deadline) {
+			t.Fatal("Expected the stale stream's results to be dropped")
+		}
+	}
+	if len(md.lookups) != 0 {
+		t.Errorf("Expected the repeated URI to be resolved once, got %d more lookups", len(md.lookups))
+	}
+}
@@ -0,0 +1,71 @@
+package archiver
+
+import (
+	"time"
+
+	"github.com/gtfierro/pundat/common"
+)
+
+// Computes a calendar-aligned window query over [start, end) for a single stream. The
+// buckets have different widths (months, and days across daylight savings transitions),
+// so rather than asking the timeseries store for fixed-width windows we split the range
+// into buckets and ask for a single window covering each one. Buckets that overlap start
+// or end are clipped to the range, but are still reported at the start of the bucket.
+func (a *queryContext) calendarWindowData(uuid common.UUID, params *common.DataParams, start, end int64) (result common.StatisticTimeseries, err error) {
+	var (
+		loc    = params.Format.Location()
+		window = *params.Calendar
+	)
+	result.UUID = uuid
+	for bucket := window.Floor(time.Unix(0, start).In(loc)); bucket.UnixNano() < end; bucket = window.Next(bucket) {
+		from, to := bucket.UnixNano(), window.Next(bucket).UnixNano()
+		if from < start {
+			from = start
+		}
+		if to > end {
+			to = end
+		}
+		if to <= from {
+			continue
+		}
+		var tsresult common.StatisticTimeseries
+		tsresult, err = a.TS.WindowDataUUID(uuid, uint64(to-from), from, to, params.ConvertToUnit)
+		if err != nil {
+			return
+		}
+		if tsresult.Generation > result.Generation {
+			result.Generation = tsresult.Generation
+		}
+		if rdg := mergeStatistics(tsresult.Records); rdg != nil {
+			rdg.Time = bucket
+			rdg.Unit = params.ConvertToUnit
+			result.Records = append(result.Records, rdg)
+		}
+	}
+	return
+}
+
+// combines statistics over adjacent windows into one. Returns nil if there is no data
+func mergeStatistics(records []*common.StatisticsReading) *common.StatisticsReading {
+	var merged *common.StatisticsReading
+	for _, rdg := range records {
+		if rdg.Count == 0 {
+			continue
+		}
+		if merged == nil {
+			copied := *rdg
+			merged = &copied
+			continue
+		}
+		total := merged.Count + rdg.Count
+		merged.Mean = (merged.Mean*float64(merged.Count) + rdg.Mean*float64(rdg.Count)) / float64(total)
+		merged.Count = total
+		if rdg.Min < merged.Min {
+			merged.Min = rdg.Min
+		}
+		if rdg.Max > merged.Max {
+			merged.Max = rdg.Max
+		}
+	}
+	return merged
+}
@@ -0,0 +1,59 @@
+package archiver
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gtfierro/pundat/common"
+)
+
+// records the ranges of the windows it is asked for
+type windowRecorder struct {
+	TimeseriesStore
+	windows [][2]int64
+}
+
+func (ts *windowRecorder) WindowDataUUID(uuid common.UUID, width uint64, start, end int64, convert common.UnitOfTime) (common.StatisticTimeseries, error) {
+	ts.windows = append(ts.windows, [2]int64{start, end})
+	return common.StatisticTimeseries{UUID: uuid, Records: []*common.StatisticsReading{{Count: 1}}}, nil
+}
+
+// days are 23 or 25 hours long across daylight savings transitions, and every bucket
+// starts at local midnight
+func TestCalendarWindowsAcrossDST(t *testing.T) {
+	loc, err := time.LoadLocation("America/Los_Angeles")
+	if err != nil {
+		t.Skip("No timezone database")
+	}
+	for _, test := range []struct {
+		day    time.Time
+		widths []time.Duration
+	}{
+		// spring forward
+		{time.Date(2021, time.March, 13, 0, 0, 0, 0, loc), []time.Duration{24 * time.Hour, 23 * time.Hour, 24 * time.Hour}},
+		// fall back
+		{time.Date(2021, time.November, 6, 0, 0, 0, 0, loc), []time.Duration{24 * time.Hour, 25 * time.Hour, 24 * time.Hour}},
+	} {
+		ts := &windowRecorder{}
+		a := &Archiver{TS: ts}
+		params := &common.DataParams{
+			Calendar: &common.CalendarWindow{Count: 1, Unit: common.CalendarDay},
+			Format:   common.TimeFormat{Timezone: "America/Los_Angeles"},
+		}
+		result, err := unlimited(a).calendarWindowData(uuidA, params, test.day.UnixNano(), test.day.AddDate(0, 0, 3).UnixNano())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(ts.windows) != len(test.widths) || len(result.Records) != len(test.widths) {
+			t.Fatalf("Expected %d buckets from %v, got %v", len(test.widths), test.day, ts.windows)
+		}
+		for i, window := range ts.windows {
+			if width := time.Duration(window[1] - window[0]); width != test.widths[i] {
+				t.Errorf("Expected bucket %d from %v to be %v long, got %v", i, test.day, test.widths[i], width)
+			}
+			if start := result.Records[i].Time.In(loc); start.Hour() != 0 || !start.Equal(test.day.AddDate(0, 0, i)) {
+				t.Errorf("Expected bucket %d to start at local midnight on %v, got %v", i, test.day.AddDate(0, 0, i), start)
+			}
+		}
+	}
+}
@@ -13,8 +13,74 @@ type BWConfig struct {
 }
 
 type ARConfig struct {
+	// if set, the archiver logs its status (see Status) each time it publishes it
 	PeriodicReport bool
 	BlockExpiry    string
+	// the longest a VK's permissions are cached before its DOTs are checked again,
+	// even if revocations can't be detected. Defaults to 10m
+	MaxPermissionStaleness string
+	// if set, read permissions from this YAML ACL file (see dots.ACLConfig) instead of
+	// building DOT chains, so no BOSSWAVE registry is needed to check them
+	ACLFile string
+	// if set, hide tags from VKs according to the YAML redaction policies in this
+	// file (see RedactionConfig)
+	RedactionFile string
+	// the most readings sent in a single query reply message; larger
+	// results are sent in several messages. Defaults to 100000
+	MaxReplyReadings int
+	// if set, every query is recorded in an audit log in this directory
+	AuditLog string
+	// the size in megabytes at which the audit log is rotated. Defaults to 100
+	AuditLogMaxSize int
+}
+
+// The optional HTTP/JSON query API. It is off unless Address is set
+type HTTPConfig struct {
+	// e.g. 0.0.0.0:8080
+	Address string
+	// YAML file mapping bearer tokens and client certificates to VKs (see HTTPCredentials)
+	Credentials string
+	// if set, serve HTTPS with this certificate and key
+	TLSCert string
+	TLSKey  string
+	// if set, accept client certificates signed by this CA (needs TLSCert and TLSKey)
+	ClientCA string
+}
+
+// The optional gRPC API (see grpcinterface/pundat.proto). It is off unless Address is set
+type GRPCConfig struct {
+	// e.g. 0.0.0.0:4420
+	Address string
+	// YAML file mapping bearer tokens and client certificates to VKs (see HTTPCredentials)
+	Credentials string
+	// if set, serve over TLS with this certificate and key
+	TLSCert string
+	TLSKey  string
+	// if set, accept client certificates signed by this CA (needs TLSCert and TLSKey)
+	ClientCA string
+	// YAML ACL (see dots.ACLConfig) granting VKs the URIs, and ranges of time, they may
+	// insert readings on. Insert is refused unless it is set
+	WriteACL string
+}
+
+// InfluxDB line protocol ingestion, for gateways that can't speak BOSSWAVE. It is off
+// unless WriteACL is set. Lines are accepted on the HTTP API's /write endpoint from
+// credentials with Ingest set and, if UDPAddress is set, over UDP
+type InfluxConfig struct {
+	// YAML ACL (see dots.ACLConfig) granting VKs the pseudo-URIs they may write to
+	WriteACL string
+	// the first segment of the pseudo-URIs. Defaults to influx
+	Prefix string
+	// e.g. 0.0.0.0:8089
+	UDPAddress string
+	// the VK whose grants apply to lines received over UDP
+	UDPVK string
+}
+
+// The Prometheus /metrics endpoint. It is off unless Address is set
+type MetricsConfig struct {
+	// e.g. localhost:6065
+	Address string
 }
 
 type MDConfig struct {
@@ -32,12 +98,30 @@ type BenchmarkConfig struct {
 	EnableBlockProfile bool
 }
 
+// Limits on the queries a VK can run. The [Limits] section applies to every VK and a
+// [Limits "<vk>"] section overrides it for one VK. 0 or empty means no limit
+type LimitConfig struct {
+	// the number of queries a VK may have running at once
+	MaxConcurrentQueries int
+	// the number of streams a single data query may read
+	MaxStreams int
+	// the number of readings (raw or statistical) a single query may read
+	MaxPoints int
+	// how long a query may run, e.g. 30s
+	MaxQueryTime string
+}
+
 type Config struct {
 	Archiver  ARConfig
 	BOSSWAVE  BWConfig
 	Metadata  MDConfig
 	BtrDB     BTRDBConfig
 	Benchmark BenchmarkConfig
+	Limits    map[string]*LimitConfig
+	HTTP      HTTPConfig
+	GRPC      GRPCConfig
+	Metrics   MetricsConfig
+	Influx    InfluxConfig
 }
 
 func LoadConfig(filename string) *Config {
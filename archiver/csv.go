@@ -1,6 +1,7 @@
 package archiver
 
 import (
+	"context"
 	"encoding/csv"
 	"fmt"
 	"github.com/gtfierro/pundat/common"
@@ -93,7 +94,7 @@ func (cdb *CSVDB) RegisterStream(uuid common.UUID, uri, name, unit string) error
 }
 
 // writes a set of readings for a particular stream
-func (cdb *CSVDB) AddReadings(ts common.Timeseries) error {
+func (cdb *CSVDB) AddReadings(ts *common.Timeseries) error {
 	log.Infof("Writing %d records for %s", len(ts.Records), ts.UUID.String())
 	filename := fmt.Sprintf("data/%s.csv", ts.UUID.String())
 
@@ -166,6 +167,11 @@ func (cdb *CSVDB) AddAnnotations(uuid common.UUID, annotations map[string]interf
 	return nil
 }
 
+// the CSV files are local, so calls are never cancelled
+func (cdb *CSVDB) WithContext(ctx context.Context) TimeseriesStore {
+	return cdb
+}
+
 func (cdb *CSVDB) Disconnect() error {
 	cdb.Lock()
 	defer cdb.Unlock()
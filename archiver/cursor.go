@@ -0,0 +1,40 @@
+package archiver
+
+import (
+	"encoding/base64"
+	"encoding/json"
+
+	"github.com/pkg/errors"
+)
+
+// The state needed to continue a query from where the previous page of
+// results left off. This is handed to clients as an opaque token which they
+// send back in the CURSOR clause of their next query.
+type queryCursor struct {
+	// for metadata queries: the number of (unmasked) documents already returned
+	Offset int `json:"o,omitempty"`
+	// for data queries: maps stream UUID to the nanosecond timestamp from which
+	// to resume fetching. Streams that have been fully returned are omitted
+	Resume map[string]int64 `json:"r,omitempty"`
+}
+
+func (c *queryCursor) encode() string {
+	bytes, err := json.Marshal(c)
+	if err != nil {
+		log.Error(errors.Wrap(err, "Could not encode cursor"))
+		return ""
+	}
+	return base64.URLEncoding.EncodeToString(bytes)
+}
+
+func decodeCursor(token string) (*queryCursor, error) {
+	var c = new(queryCursor)
+	bytes, err := base64.URLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, badQuery(errors.Wrap(err, "Invalid cursor"))
+	}
+	if err := json.Unmarshal(bytes, c); err != nil {
+		return nil, badQuery(errors.Wrap(err, "Invalid cursor"))
+	}
+	return c, nil
+}
@@ -0,0 +1,58 @@
+package archiver
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/gtfierro/pundat/common"
+)
+
+func TestCursorRoundTrip(t *testing.T) {
+	cursor := &queryCursor{Offset: 20, Resume: map[string]int64{uuidA.String(): 1500000000000000001}}
+	decoded, err := decodeCursor(cursor.encode())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(cursor, decoded) {
+		t.Errorf("Cursor should decode to %+v but got %+v", cursor, decoded)
+	}
+	if _, err := decodeCursor("not a cursor"); err == nil {
+		t.Error("Expected an invalid cursor to be rejected")
+	}
+}
+
+// fetching a data query one page at a time must return every reading exactly once
+func TestDataCursorResumesStreams(t *testing.T) {
+	a := testArchiver()
+	a.cache = newResultCache()
+	var (
+		seen   = make(map[string][]int64)
+		cursor string
+		pages  int
+	)
+	for {
+		params := &common.DataParams{UUIDs: []common.UUID{uuidA, uuidB}, Begin: 0, End: 100, DataLimit: 4, Cursor: cursor}
+		result, next, err := unlimited(a).SelectDataRange("admin", params)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := range result {
+			for _, rdg := range result[i].Records {
+				seen[result[i].UUID.String()] = append(seen[result[i].UUID.String()], rdg.Time.UnixNano())
+			}
+		}
+		if pages++; next == "" || pages > 10 {
+			break
+		}
+		cursor = next
+	}
+	if pages != 3 {
+		t.Errorf("Expected 11 readings per stream to take 3 pages of 4, took %d", pages)
+	}
+	expected := []int64{0, 10, 20, 30, 40, 50, 60, 70, 80, 90, 100}
+	for _, uuid := range []common.UUID{uuidA, uuidB} {
+		if !reflect.DeepEqual(seen[uuid.String()], expected) {
+			t.Errorf("Expected stream %s to return %v across the pages but got %v", uuid, expected, seen[uuid.String()])
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package archiver
+
+import (
+	"github.com/gtfierro/pundat/common"
+	"github.com/gtfierro/pundat/dots"
+)
+
+// the compression of the quantile sketch used for PERCENTILE and HISTOGRAM queries.
+// Each stream's sketch holds at most a few hundred centroids
+const distributionCompression = 200
+
+// PERCENTILE and HISTOGRAM queries read the raw data in chunks of this many nanoseconds
+// so that we never hold more than a chunk of a stream's readings in memory
+const distributionChunk = int64(24 * 60 * 60 * 1e9)
+
+// Computes the percentiles and/or histogram of the raw values of each stream over the
+// parts of the requested range the VK is allowed to read. The raw data is fed through a
+// streaming quantile sketch, so the results are estimates but the memory used does not
+// grow with the length of the range
+func (a *queryContext) SelectDistribution(vk string, params *common.DataParams) (result []common.Distribution, err error) {
+	if err = a.prepareDataParams(vk, params); err != nil {
+		return
+	}
+	requestedRange := dots.NewTimeRangeNano(params.Begin, params.End)
+
+	for _, uuid := range params.UUIDs {
+		uri, err := a.MD.URIFromUUID(uuid)
+		if err != nil {
+			return result, err
+		}
+		validRanges, err := a.authority.GetValidRanges(uri, vk)
+		if err != nil {
+			return result, err
+		}
+		validRequestedRanges := matchedRanges(params, uuid, validRanges.GetOverlap(requestedRange))
+
+		var generation uint64
+		sketch := common.NewQuantileSketch(distributionCompression)
+		for _, rng := range validRequestedRanges.Ranges {
+			end := rng.End.UnixNano()
+			for start := rng.Start.UnixNano(); start < end; start += distributionChunk {
+				chunkEnd := start + distributionChunk
+				if chunkEnd > end {
+					chunkEnd = end
+				}
+				ts, err := a.TS.GetDataUUID(uuid, start, chunkEnd, params.ConvertToUnit)
+				if err != nil {
+					return result, err
+				}
+				for _, rdg := range ts.Records {
+					sketch.Add(rdg.Value)
+				}
+				if ts.Generation > generation {
+					generation = ts.Generation
+				}
+			}
+		}
+		a.cache.observe(uuid, generation)
+
+		dist := common.NewDistribution(uuid, sketch, params.Percentiles, params.HistogramBins)
+		dist.Generation = generation
+		result = append(result, dist)
+	}
+	return
+}
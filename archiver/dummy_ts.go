@@ -10,7 +10,7 @@ type dummyts struct {
 func (ts *dummyts) StreamExists(uuid common.UUID) (bool, error) {
 	return true, nil
 }
-func (ts *dummyts) AddReadings(common.Timeseries) error {
+func (ts *dummyts) AddReadings(*common.Timeseries) error {
 	return nil
 }
 func (ts *dummyts) Prev([]common.UUID, uint64) ([]common.Timeseries, error) {
@@ -0,0 +1,189 @@
+package archiver
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/gtfierro/pundat/common"
+	"github.com/gtfierro/pundat/dots"
+	"github.com/gtfierro/pundat/querylang"
+)
+
+// Builds the plan for evaluating the query on behalf of the VK. This evaluates the WHERE
+// clause and the VK's permissions, but does not read any data from the timeseries store,
+// so that users can tell why a query returned fewer results than they expected
+func (a *queryContext) ExplainQuery(vk string, parsed *querylang.ParsedQuery) (*QueryPlan, error) {
+	plan := &QueryPlan{
+		Query: parsed.Querystring,
+		Type:  parsed.QueryType.String(),
+		Where: parsed.Where,
+	}
+
+	switch params := parsed.GetParams().(type) {
+	case *common.TagParams:
+		if !params.AsOf.IsZero() {
+			return plan, a.explainMetadataAsOf(vk, plan, params)
+		}
+		return plan, a.explainMetadata(vk, plan, params.Tags, params.Where, params.Page)
+	case *common.DistinctParams:
+		if !params.AsOf.IsZero() {
+			plan.MetadataCalls = append(plan.MetadataCalls, fmt.Sprintf("GetDocumentsAsOf(where=%s, at=%s), then distinct %s", params.Where, params.AsOf.Format(time.RFC3339Nano), params.Tag))
+			plan.Page = params.Page
+			return plan, nil
+		}
+		plan.MetadataCalls = append(plan.MetadataCalls, fmt.Sprintf("GetMetadata(tags=[%s], where=%s), then distinct readable values, %s", params.Tag, params.Where, dumpPage(params.Page)))
+		plan.Page = params.Page
+		return plan, nil
+	case *common.DataParams:
+		return plan, a.explainData(vk, plan, parsed, params)
+	case *common.SubscribeParams:
+		if params.ID != "" {
+			return plan, nil
+		}
+		plan.MetadataCalls = append(plan.MetadataCalls, fmt.Sprintf("GetUUIDs(where=%s)", params.Where))
+		uuids, err := a.MD.GetUUIDs(params.Where)
+		if err != nil {
+			return plan, err
+		}
+		// count only what the subscription itself would be given
+		if uuids, err = readableStreams(a.authority, a.MD, vk, uuids); err != nil {
+			return plan, err
+		}
+		uuids, err = a.redaction.unprobed(a.MD, vk, params.Where, uuids)
+		plan.MatchedStreams = len(uuids)
+		return plan, err
+	}
+	return plan, nil
+}
+
+func (a *Archiver) explainMetadata(vk string, plan *QueryPlan, tags []string, where *common.Predicate, page common.Pagination) error {
+	plan.Page = page
+	if page.Cursor != "" {
+		cursor, err := decodeCursor(page.Cursor)
+		if err != nil {
+			return err
+		}
+		page.Offset = cursor.Offset
+	}
+	plan.MetadataCalls = append(plan.MetadataCalls, fmt.Sprintf("GetMetadata(tags=%v, where=%s, %s)", tags, where, dumpPage(page)))
+	groups, err := a.MD.GetMetadata(vk, tags, where, page)
+	if err != nil {
+		return err
+	}
+	visible, err := a.maskMetadataGroupsByPermission(vk, where, page.OrderBy, groups)
+	plan.Matched = len(visible)
+	return err
+}
+
+func (a *Archiver) explainMetadataAsOf(vk string, plan *QueryPlan, params *common.TagParams) error {
+	plan.Page = params.Page
+	plan.MetadataCalls = append(plan.MetadataCalls, fmt.Sprintf("GetDocumentsAsOf(where=%s, at=%s), then %s", params.Where, params.AsOf.Format(time.RFC3339Nano), dumpPage(params.Page)))
+	docs, err := a.MD.GetDocumentsAsOf(params.Where, params.AsOf)
+	if err != nil {
+		return err
+	}
+	var groups []common.MetadataGroup
+	for _, doc := range docs {
+		groups = append(groups, *common.GroupFromBson(doc))
+	}
+	visible, err := a.maskMetadataGroupsByPermission(vk, params.Where, params.Page.OrderBy, groups)
+	plan.Matched = len(visible)
+	return err
+}
+
+func (a *queryContext) explainData(vk string, plan *QueryPlan, parsed *querylang.ParsedQuery, params *common.DataParams) error {
+	plan.StreamLimit = params.StreamLimit
+	plan.DataLimit = params.DataLimit
+	plan.Page = parsed.Page
+
+	switch {
+	case params.Where != nil && params.AsOfData:
+		plan.MetadataCalls = append(plan.MetadataCalls, fmt.Sprintf("GetMatchingIntervals(where=%s, %d, %d)", params.Where, params.Begin, params.End))
+	case params.Where != nil && !params.AsOf.IsZero():
+		plan.MetadataCalls = append(plan.MetadataCalls, fmt.Sprintf("GetDocumentsAsOf(where=%s, at=%s)", params.Where, params.AsOf.Format(time.RFC3339Nano)))
+	case params.Where != nil:
+		plan.MetadataCalls = append(plan.MetadataCalls, fmt.Sprintf("GetUUIDs(where=%s)", params.Where))
+	}
+	// resolve the streams the same way the query would, so the count doesn't include
+	// streams the VK can't read, but before STREAMLIMIT so we can report what it cut off
+	if err := a.resolveStreams(vk, params); err != nil {
+		return err
+	}
+	plan.MatchedStreams = len(params.UUIDs)
+	if err := a.applyStreamLimit(params); err != nil {
+		return err
+	}
+
+	if params.IsChangedRanges {
+		plan.Streams = append(plan.Streams, StreamPlan{
+			TimeseriesCalls: []string{fmt.Sprintf("ChangedRanges(%d streams, from=%d, to=%d, resolution=%d)", len(params.UUIDs), params.FromGen, params.ToGen, params.Resolution)},
+		})
+		return nil
+	}
+
+	requestedRange := dots.NewTimeRangeNano(params.Begin, params.End)
+	for _, uuid := range params.UUIDs {
+		plan.Streams = append(plan.Streams, StreamPlan{UUID: uuid.String()})
+		sp := &plan.Streams[len(plan.Streams)-1]
+
+		uri, err := a.MD.URIFromUUID(uuid)
+		if err != nil {
+			sp.Error = fmt.Sprintf("Could not resolve URI (%v)", err)
+			continue
+		}
+		sp.URI = uri
+		validRanges, err := a.authority.GetValidRanges(uri, vk)
+		if err != nil {
+			sp.Error = fmt.Sprintf("Could not get valid ranges (%v)", err)
+			continue
+		}
+		sp.ValidRanges = planRanges(validRanges)
+
+		switch parsed.Data.Dtype {
+		case querylang.BEFORE_TYPE:
+			sp.TimeseriesCalls = append(sp.TimeseriesCalls, fmt.Sprintf("Prev(%s, %d)", uuid, params.Begin))
+			continue
+		case querylang.AFTER_TYPE:
+			sp.TimeseriesCalls = append(sp.TimeseriesCalls, fmt.Sprintf("Next(%s, %d)", uuid, params.Begin))
+			continue
+		}
+
+		readRanges := matchedRanges(params, uuid, validRanges.GetOverlap(requestedRange))
+		sp.ReadRanges = planRanges(readRanges)
+		if len(sp.ReadRanges) == 0 {
+			sp.Error = "VK has no access to this stream in the requested range of time"
+		}
+		for _, rng := range sp.ReadRanges {
+			switch {
+			case params.IsStatistical:
+				sp.TimeseriesCalls = append(sp.TimeseriesCalls, fmt.Sprintf("StatisticalDataUUID(%s, pw=%d, %d, %d)", uuid, params.PointWidth, rng.Start, rng.End))
+			case params.IsDistribution:
+				sp.TimeseriesCalls = append(sp.TimeseriesCalls, fmt.Sprintf("GetDataUUID(%s, %d, %d) in chunks of %s, summarized by a quantile sketch", uuid, rng.Start, rng.End, time.Duration(distributionChunk)))
+			case params.Calendar != nil:
+				sp.TimeseriesCalls = append(sp.TimeseriesCalls, fmt.Sprintf("WindowDataUUID(%s, one call per %s bucket in %s, %d, %d)", uuid, params.Calendar, params.Format.Location(), rng.Start, rng.End))
+			case params.IsWindow:
+				sp.TimeseriesCalls = append(sp.TimeseriesCalls, fmt.Sprintf("WindowDataUUID(%s, width=%s, %d, %d)", uuid, time.Duration(params.Width), rng.Start, rng.End))
+			default:
+				sp.TimeseriesCalls = append(sp.TimeseriesCalls, fmt.Sprintf("GetDataUUID(%s, %d, %d)", uuid, rng.Start, rng.End))
+			}
+		}
+	}
+	return nil
+}
+
+// returns the ranges in time order
+func planRanges(ranges *dots.DisjointRanges) []PlanRange {
+	var ret []PlanRange
+	for _, rng := range ranges.Ranges {
+		ret = append(ret, PlanRange{Start: rng.Start.UnixNano(), End: rng.End.UnixNano()})
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].Start < ret[j].Start
+	})
+	return ret
+}
+
+func dumpPage(page common.Pagination) string {
+	return fmt.Sprintf("order=%s desc=%v limit=%d offset=%d", page.OrderBy, page.Descending, page.Limit, page.Offset)
+}
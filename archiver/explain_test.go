@@ -0,0 +1,72 @@
+package archiver
+
+import (
+	"testing"
+
+	"github.com/gtfierro/pundat/querylang"
+)
+
+func explain(t *testing.T, a *Archiver, vk, query string) *QueryPlan {
+	parsed := querylang.Parse(query)
+	if parsed.Err != nil {
+		t.Fatalf("Could not parse %s (%v)", query, parsed.Err)
+	}
+	plan, err := unlimited(a).ExplainQuery(vk, parsed)
+	if err != nil {
+		t.Fatalf("Could not explain %s (%v)", query, err)
+	}
+	return plan
+}
+
+// the counts in a plan must not reveal streams the VK can't see in the results
+func TestExplainCountsOnlyVisibleStreams(t *testing.T) {
+	a := testArchiver()
+	a.cache = newResultCache()
+	for _, test := range []struct {
+		vk             string
+		query          string
+		matched        int
+		matchedStreams int
+		streams        int
+	}{
+		{"vk", "select * where has Room;", 1, 0, 0},
+		{"admin", "select * where has Room;", 2, 0, 0},
+		{"other", "select * where has Room;", 0, 0, 0},
+		{"vk", "select data in (1ns, 100ns) where has Room;", 0, 1, 1},
+		{"admin", "select data in (1ns, 100ns) where has Room;", 0, 2, 2},
+		{"admin", "select data in (1ns, 100ns) streamlimit 1 where has Room;", 0, 2, 1},
+		{"other", "select data in (1ns, 100ns) where has Room;", 0, 0, 0},
+		{"vk", "subscribe data where has Room;", 0, 1, 0},
+	} {
+		plan := explain(t, a, test.vk, test.query)
+		if plan.Matched != test.matched || plan.MatchedStreams != test.matchedStreams || len(plan.Streams) != test.streams {
+			t.Errorf("%s for %s: expected %d documents, %d streams and %d stream plans but got %d, %d and %d",
+				test.query, test.vk, test.matched, test.matchedStreams, test.streams, plan.Matched, plan.MatchedStreams, len(plan.Streams))
+		}
+	}
+
+	plan := explain(t, a, "vk", "select data in (1ns, 100ns) where has Room;")
+	if rng := plan.Streams[0].ValidRanges; len(rng) != 1 || rng[0].Start != 10 || rng[0].End != 20 {
+		t.Errorf("Expected the VK to be allowed to read [10, 20], got %+v", rng)
+	}
+}
+
+func TestExplainHidesRedactedMatches(t *testing.T) {
+	a := testArchiver()
+	a.cache = newResultCache()
+	var err error
+	if a.redaction, err = newRedactor(RedactionConfig{Policies: []RedactionPolicy{
+		{URI: "ns/public/*", Tags: []string{"Room"}},
+	}}, a.authority); err != nil {
+		t.Fatal(err)
+	}
+	for _, query := range []string{
+		`select * where Room = "410";`,
+		`select data in (1ns, 100ns) where Room = "410";`,
+		`subscribe data where Room = "410";`,
+	} {
+		if plan := explain(t, a, "vk", query); plan.Matched != 0 || plan.MatchedStreams != 0 {
+			t.Errorf("%s: a where clause on a hidden tag should match nothing, got %d documents and %d streams", query, plan.Matched, plan.MatchedStreams)
+		}
+	}
+}
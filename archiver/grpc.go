@@ -0,0 +1,464 @@
+package archiver
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/gtfierro/pundat/common"
+	"github.com/gtfierro/pundat/dots"
+	"github.com/gtfierro/pundat/grpcinterface"
+	"github.com/pkg/errors"
+	"github.com/satori/go.uuid"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// the most documents, values or readings of a stream sent in one gRPC response
+const grpcPageSize = 10000
+
+// Serves the query and ingestion API over gRPC (see grpcinterface/pundat.proto). Calls are
+// evaluated on behalf of the VK their credentials map to, and each page of results is run
+// through runLimited, so it is limited and audited the same way as a query over BOSSWAVE
+type grpcServer struct {
+	archiver *Archiver
+	creds    *apiCredentials
+	// grants VKs the URIs they may insert readings on. Nil if inserting is off
+	writers *dots.ACL
+}
+
+// Starts the gRPC API in the background, if it is configured
+func (a *Archiver) startGRPC(c GRPCConfig) error {
+	creds, err := readAPICredentials(c.Credentials)
+	if err != nil {
+		return err
+	}
+	srv := &grpcServer{archiver: a, creds: creds}
+	if c.WriteACL != "" {
+		if srv.writers, err = dots.ReadACL(c.WriteACL); err != nil {
+			return err
+		}
+	}
+	var opts []grpc.ServerOption
+	if c.TLSCert != "" {
+		cert, err := tls.LoadX509KeyPair(c.TLSCert, c.TLSKey)
+		if err != nil {
+			return errors.Wrapf(err, "Could not load TLS certificate %s", c.TLSCert)
+		}
+		config := &tls.Config{}
+		if c.ClientCA != "" {
+			if config, err = clientCATLSConfig(c.ClientCA); err != nil {
+				return err
+			}
+		}
+		config.Certificates = []tls.Certificate{cert}
+		opts = append(opts, grpc.Creds(credentials.NewTLS(config)))
+	} else if c.ClientCA != "" {
+		return errors.New("ClientCA needs TLSCert and TLSKey")
+	}
+	listener, err := net.Listen("tcp", c.Address)
+	if err != nil {
+		return errors.Wrapf(err, "Could not listen on %s", c.Address)
+	}
+	server := grpc.NewServer(opts...)
+	grpcinterface.RegisterPundatServer(server, srv)
+	go func() {
+		log.Fatal(server.Serve(listener))
+	}()
+	return nil
+}
+
+// Returns the identity for the call's bearer token or verified client certificate
+func (srv *grpcServer) authenticate(ctx context.Context) (apiIdentity, error) {
+	var authorization string
+	if md, ok := metadata.FromIncomingContext(ctx); ok && len(md["authorization"]) > 0 {
+		authorization = md["authorization"][0]
+	}
+	var state *tls.ConnectionState
+	if p, ok := peer.FromContext(ctx); ok {
+		if info, ok := p.AuthInfo.(credentials.TLSInfo); ok {
+			state = &info.State
+		}
+	}
+	id, err := srv.creds.identify(authorization, state)
+	if err != nil {
+		return id, status.Error(codes.Unauthenticated, err.Error())
+	}
+	return id, nil
+}
+
+// Parses a WHERE clause as it would appear in a query (without the WHERE)
+func (a *Archiver) parseWhere(where string) (*common.Predicate, error) {
+	if strings.TrimSpace(where) == "" {
+		return nil, status.Error(codes.InvalidArgument, "Need a WHERE clause")
+	}
+	parsed := a.qp.Parse(fmt.Sprintf("select * where %s;", where))
+	if parsed.Err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "Error (%v) in WHERE clause \"%s\" (error at %v)", parsed.Err, where, parsed.ErrPos)
+	}
+	return parsed.Where, nil
+}
+
+// the parameters shared by the data calls. Readings are returned in nanoseconds
+func (a *Archiver) grpcDataParams(where string, start, end int64) (*common.DataParams, error) {
+	predicate, err := a.parseWhere(where)
+	if err != nil {
+		return nil, err
+	}
+	return &common.DataParams{
+		Where:         predicate,
+		Begin:         start,
+		End:           end,
+		DataLimit:     grpcPageSize,
+		ConvertToUnit: common.UOT_NS,
+		Format:        common.TimeFormat{Timezone: time.UTC.String()},
+	}, nil
+}
+
+func (srv *grpcServer) SelectMetadata(params *grpcinterface.SelectMetadataParams, stream grpcinterface.Pundat_SelectMetadataServer) error {
+	id, err := srv.authenticate(stream.Context())
+	if err != nil {
+		return err
+	}
+	where, err := srv.archiver.parseWhere(params.Where)
+	if err != nil {
+		return err
+	}
+	selector := "*"
+	if len(params.Tags) > 0 {
+		selector = strings.Join(params.Tags, ", ")
+	}
+	query := fmt.Sprintf("select %s where %s;", selector, params.Where)
+	tagParams := &common.TagParams{Tags: params.Tags, Where: where, Page: common.Pagination{Limit: grpcPageSize}}
+	for {
+		res, err := srv.archiver.runLimited(id.vk, query, "metadata", func(a *queryContext) (result QueryResult, err error) {
+			result.Metadata, result.Cursor, err = a.SelectTags(id.vk, tagParams)
+			return
+		})
+		if err != nil {
+			return err
+		}
+		if len(res.Metadata) > 0 {
+			reply := &grpcinterface.SelectMetadataResponse{}
+			for _, doc := range metadataResult(0, res.Metadata, "").Data {
+				reply.Documents = append(reply.Documents, &grpcinterface.Document{
+					Uuid:     doc.UUID,
+					Path:     doc.Path,
+					Metadata: grpcMetadata(doc.Metadata),
+				})
+			}
+			if err := stream.Send(reply); err != nil {
+				return err
+			}
+		}
+		if res.Cursor == "" {
+			return nil
+		}
+		tagParams.Page.Cursor = res.Cursor
+	}
+}
+
+func (srv *grpcServer) DistinctValues(params *grpcinterface.DistinctValuesParams, stream grpcinterface.Pundat_DistinctValuesServer) error {
+	id, err := srv.authenticate(stream.Context())
+	if err != nil {
+		return err
+	}
+	where, err := srv.archiver.parseWhere(params.Where)
+	if err != nil {
+		return err
+	}
+	if params.Tag == "" {
+		return status.Error(codes.InvalidArgument, "Need a tag")
+	}
+	query := fmt.Sprintf("select distinct %s where %s;", params.Tag, params.Where)
+	distinctParams := &common.DistinctParams{Tag: params.Tag, Where: where, Page: common.Pagination{Limit: grpcPageSize}}
+	for {
+		var values []string
+		res, err := srv.archiver.runLimited(id.vk, query, "distinct", func(a *queryContext) (result QueryResult, err error) {
+			values, result.Cursor, err = a.DistinctTag(id.vk, distinctParams)
+			return
+		})
+		if err != nil {
+			return err
+		}
+		if len(values) > 0 {
+			if err := stream.Send(&grpcinterface.DistinctValuesResponse{Values: values}); err != nil {
+				return err
+			}
+		}
+		if res.Cursor == "" {
+			return nil
+		}
+		distinctParams.Page.Cursor = res.Cursor
+	}
+}
+
+func (srv *grpcServer) RawValues(params *grpcinterface.RawValuesParams, stream grpcinterface.Pundat_RawValuesServer) error {
+	id, err := srv.authenticate(stream.Context())
+	if err != nil {
+		return err
+	}
+	dataParams, err := srv.archiver.grpcDataParams(params.Where, params.Start, params.End)
+	if err != nil {
+		return err
+	}
+	query := fmt.Sprintf("select data in (%d ns, %d ns) where %s;", params.Start, params.End, params.Where)
+	for {
+		res, err := srv.archiver.runLimited(id.vk, query, "data", func(a *queryContext) (result QueryResult, err error) {
+			result.Timeseries, result.Cursor, err = a.SelectDataRange(id.vk, dataParams)
+			return
+		})
+		if err != nil {
+			return err
+		}
+		for i := range res.Timeseries {
+			ts := &res.Timeseries[i]
+			if len(ts.Records) == 0 {
+				continue
+			}
+			reply := &grpcinterface.RawValuesResponse{
+				Uuid:       ts.UUID.String(),
+				Path:       ts.SrcURI,
+				Generation: ts.Generation,
+			}
+			for _, rdg := range ts.Records {
+				reply.Times = append(reply.Times, rdg.Time.UnixNano())
+				reply.Values = append(reply.Values, rdg.Value)
+			}
+			if err := stream.Send(reply); err != nil {
+				return err
+			}
+		}
+		if res.Cursor == "" {
+			return nil
+		}
+		dataParams.Cursor = res.Cursor
+	}
+}
+
+func (srv *grpcServer) StatisticalValues(params *grpcinterface.StatisticalValuesParams, stream grpcinterface.Pundat_StatisticalValuesServer) error {
+	dataParams, err := srv.archiver.grpcDataParams(params.Where, params.Start, params.End)
+	if err != nil {
+		return err
+	}
+	dataParams.IsStatistical = true
+	dataParams.PointWidth = int(params.PointWidth)
+	query := fmt.Sprintf("select statistical(%d) data in (%d ns, %d ns) where %s;", params.PointWidth, params.Start, params.End, params.Where)
+	return srv.sendStatistics(query, dataParams, stream)
+}
+
+func (srv *grpcServer) WindowValues(params *grpcinterface.WindowValuesParams, stream grpcinterface.Pundat_WindowValuesServer) error {
+	if params.Width == 0 {
+		return status.Error(codes.InvalidArgument, "Need a window width")
+	}
+	dataParams, err := srv.archiver.grpcDataParams(params.Where, params.Start, params.End)
+	if err != nil {
+		return err
+	}
+	dataParams.IsWindow = true
+	dataParams.Width = params.Width
+	query := fmt.Sprintf("select window(%d ns) data in (%d ns, %d ns) where %s;", params.Width, params.Start, params.End, params.Where)
+	return srv.sendStatistics(query, dataParams, stream)
+}
+
+// the stream of StatisticalValues and WindowValues
+type statisticsStream interface {
+	Send(*grpcinterface.StatisticalValuesResponse) error
+	Context() context.Context
+}
+
+func (srv *grpcServer) sendStatistics(query string, dataParams *common.DataParams, stream statisticsStream) error {
+	id, err := srv.authenticate(stream.Context())
+	if err != nil {
+		return err
+	}
+	for {
+		res, err := srv.archiver.runLimited(id.vk, query, dataQueryType(dataParams), func(a *queryContext) (result QueryResult, err error) {
+			result.Statistics, result.Cursor, err = a.SelectStatisticalData(id.vk, dataParams)
+			return
+		})
+		if err != nil {
+			return err
+		}
+		for i := range res.Statistics {
+			ts := &res.Statistics[i]
+			if len(ts.Records) == 0 {
+				continue
+			}
+			reply := &grpcinterface.StatisticalValuesResponse{
+				Uuid:       ts.UUID.String(),
+				Path:       ts.SrcURI,
+				Generation: ts.Generation,
+			}
+			for _, rdg := range ts.Records {
+				reply.Times = append(reply.Times, rdg.Time.UnixNano())
+				reply.Count = append(reply.Count, rdg.Count)
+				reply.Min = append(reply.Min, rdg.Min)
+				reply.Mean = append(reply.Mean, rdg.Mean)
+				reply.Max = append(reply.Max, rdg.Max)
+			}
+			if err := stream.Send(reply); err != nil {
+				return err
+			}
+		}
+		if res.Cursor == "" {
+			return nil
+		}
+		dataParams.Cursor = res.Cursor
+	}
+}
+
+func (srv *grpcServer) Changes(params *grpcinterface.ChangesParams, stream grpcinterface.Pundat_ChangesServer) error {
+	id, err := srv.authenticate(stream.Context())
+	if err != nil {
+		return err
+	}
+	if params.Resolution > math.MaxUint8 {
+		return status.Errorf(codes.InvalidArgument, "Resolution %d is too large", params.Resolution)
+	}
+	dataParams, err := srv.archiver.grpcDataParams(params.Where, 0, 0)
+	if err != nil {
+		return err
+	}
+	dataParams.IsChangedRanges = true
+	dataParams.FromGen = params.FromGeneration
+	dataParams.ToGen = params.ToGeneration
+	dataParams.Resolution = uint8(params.Resolution)
+	query := fmt.Sprintf("select changed(%d, %d, %d) data where %s;", params.FromGeneration, params.ToGeneration, params.Resolution, params.Where)
+	res, err := srv.archiver.runLimited(id.vk, query, dataQueryType(dataParams), func(a *queryContext) (result QueryResult, err error) {
+		result.Changed, err = a.GetChangedRanges(id.vk, dataParams)
+		return
+	})
+	if err != nil {
+		return err
+	}
+	for _, changed := range res.Changed {
+		if len(changed.Ranges) == 0 {
+			continue
+		}
+		reply := &grpcinterface.ChangesResponse{Uuid: changed.UUID.String()}
+		for _, rng := range changed.Ranges {
+			reply.Ranges = append(reply.Ranges, &grpcinterface.ChangedRange{
+				Start:      rng.StartTime,
+				End:        rng.EndTime,
+				Generation: rng.Generation,
+			})
+		}
+		if err := stream.Send(reply); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (srv *grpcServer) Insert(stream grpcinterface.Pundat_InsertServer) error {
+	id, err := srv.authenticate(stream.Context())
+	if err != nil {
+		return err
+	}
+	if !id.ingest {
+		return status.Error(codes.PermissionDenied, "These credentials may not insert readings")
+	}
+	if srv.writers == nil {
+		return status.Error(codes.PermissionDenied, "Inserting readings needs a WriteACL")
+	}
+	// the streams this call has already initialized
+	initialized := make(map[string]bool)
+	for {
+		params, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		uuid, written, err := srv.archiver.insert(srv.writers, id.vk, params, initialized)
+		if err != nil {
+			return err
+		}
+		if err := stream.Send(&grpcinterface.InsertResponse{Uuid: uuid.String(), Count: uint64(written)}); err != nil {
+			return err
+		}
+	}
+}
+
+// Writes the readings to the stream for params.Uri and params.Name, creating the stream as
+// an archive request would unless it is in initialized. The writers must let the VK write to
+// the URI at the time of every reading, and the stream may not be one that an archive
+// request writes to. Like readings from BOSSWAVE, NaN and infinite values are skipped.
+// Returns the stream's UUID and the number of readings written
+func (a *Archiver) insert(writers Authorizer, vk string, params *grpcinterface.InsertParams, initialized map[string]bool) (common.UUID, int, error) {
+	if params.Uri == "" || params.Name == "" {
+		return nil, 0, status.Error(codes.InvalidArgument, "Need a URI and name")
+	}
+	if len(params.Times) != len(params.Values) {
+		return nil, 0, status.Errorf(codes.InvalidArgument, "Got %d times but %d values", len(params.Times), len(params.Values))
+	}
+	if err := canWrite(writers, params.Uri, vk, params.Times); err != nil {
+		return nil, 0, status.Error(codes.PermissionDenied, err.Error())
+	}
+	streamUUID := common.ParseUUID(uuid.NewV3(NAMESPACE_UUID, params.Uri+params.Name).String())
+	if !initialized[streamUUID.String()] {
+		// an archive request whose URIs are rewritten records the URI it subscribed to
+		if owner, err := a.MD.URIFromUUID(streamUUID); a.archived.has(streamUUID) || (err == nil && owner != params.Uri) {
+			return streamUUID, 0, status.Errorf(codes.PermissionDenied, "%s %s is written by an archive request", params.Uri, params.Name)
+		}
+	}
+	readings := &common.Timeseries{UUID: streamUUID, SrcURI: params.Uri}
+	for i, t := range params.Times {
+		if !a.TS.ValidTimestamp(t, common.UOT_NS) {
+			return streamUUID, 0, status.Errorf(codes.InvalidArgument, "Timestamp %d is out of range", t)
+		}
+		if math.IsInf(params.Values[i], 0) || math.IsNaN(params.Values[i]) {
+			continue
+		}
+		readings.Records = append(readings.Records, &common.TimeseriesReading{Time: time.Unix(0, t), Unit: common.UOT_NS, Value: params.Values[i]})
+	}
+	if !initialized[streamUUID.String()] {
+		if err := a.MD.InitializeURI(params.Uri, params.Uri, params.Name, params.Unit, streamUUID); err != nil {
+			return streamUUID, 0, errors.Wrapf(err, "Error initializing metadata store with URI %s", params.Uri)
+		}
+		if exists, err := a.TS.StreamExists(streamUUID); err != nil {
+			return streamUUID, 0, errors.Wrapf(err, "Could not check stream exists (%s)", streamUUID)
+		} else if !exists {
+			if err := a.TS.RegisterStream(streamUUID, params.Uri, params.Name, params.Unit); err != nil {
+				return streamUUID, 0, errors.Wrapf(err, "Could not create stream (%s %s %s %s)", streamUUID, params.Uri, params.Name, params.Unit)
+			}
+		}
+		initialized[streamUUID.String()] = true
+	}
+	if len(readings.Records) == 0 {
+		return streamUUID, 0, nil
+	}
+	if err := a.TS.AddReadings(readings); err != nil {
+		return streamUUID, 0, errors.Wrap(err, "Could not write readings")
+	}
+	return streamUUID, len(readings.Records), nil
+}
+
+// renders metadata values as strings, JSON-encoding those that aren't strings already
+func grpcMetadata(md map[string]interface{}) map[string]string {
+	rendered := make(map[string]string, len(md))
+	for key, value := range md {
+		if s, ok := value.(string); ok {
+			rendered[key] = s
+			continue
+		}
+		bytes, err := json.Marshal(jsonValue(value))
+		if err != nil {
+			log.Error(errors.Wrapf(err, "Could not encode metadata %s", key))
+			continue
+		}
+		rendered[key] = string(bytes)
+	}
+	return rendered
+}
@@ -0,0 +1,103 @@
+package archiver
+
+import (
+	"testing"
+
+	"github.com/gtfierro/pundat/common"
+	"github.com/gtfierro/pundat/dots"
+	"github.com/gtfierro/pundat/grpcinterface"
+	"github.com/satori/go.uuid"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// records the streams created and the readings written
+type insertMetadata struct {
+	*fakeMetadata
+	initialized []string
+}
+
+func (md *insertMetadata) InitializeURI(uri, rewrittenuri, name, unit string, uuid common.UUID) error {
+	md.initialized = append(md.initialized, uuid.String())
+	return nil
+}
+
+type insertTimeseries struct {
+	*fakeTimeseries
+	written map[string]int
+}
+
+func (ts *insertTimeseries) ValidTimestamp(t int64, uot common.UnitOfTime) bool {
+	return t >= 0
+}
+
+func (ts *insertTimeseries) StreamExists(uuid common.UUID) (bool, error) {
+	return true, nil
+}
+
+func (ts *insertTimeseries) AddReadings(readings *common.Timeseries) error {
+	ts.written[readings.UUID.String()] += len(readings.Records)
+	return nil
+}
+
+func TestInsert(t *testing.T) {
+	a := testArchiver()
+	md := &insertMetadata{fakeMetadata: a.MD.(*fakeMetadata)}
+	ts := &insertTimeseries{fakeTimeseries: a.TS.(*fakeTimeseries), written: make(map[string]int)}
+	a.MD, a.TS = md, ts
+	a.archived = newStreamSet()
+	writers, err := dots.NewACL(dots.ACLConfig{Grants: []dots.ACLGrant{
+		{VK: "gateway", URI: "ns/gateway/*", From: "10", To: "20"},
+		{VK: "admin", URI: "ns/*"},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a stream an archive request is writing to
+	archived := common.ParseUUID(uuid.NewV3(NAMESPACE_UUID, "ns/gateway/archived"+"temp").String())
+	a.archived.add(archived)
+
+	for _, test := range []struct {
+		vk      string
+		uri     string
+		times   []int64
+		code    codes.Code
+		written int
+	}{
+		{"gateway", "ns/gateway/meter", []int64{10, 15, 20}, codes.OK, 3},
+		// outside the VK's URIs and range of time
+		{"gateway", "ns/other/meter", []int64{15}, codes.PermissionDenied, 0},
+		{"gateway", "ns/gateway/meter", []int64{15, 25}, codes.PermissionDenied, 0},
+		{"stranger", "ns/gateway/meter", []int64{15}, codes.PermissionDenied, 0},
+		{"admin", "ns/gateway/archived", []int64{15}, codes.PermissionDenied, 0},
+		{"admin", "ns/gateway/meter", []int64{5, 25}, codes.OK, 2},
+	} {
+		ts.written = make(map[string]int)
+		params := &grpcinterface.InsertParams{Uri: test.uri, Name: "temp", Times: test.times, Values: make([]float64, len(test.times))}
+		id, written, err := a.insert(writers, test.vk, params, make(map[string]bool))
+		if code := status.Code(err); code != test.code || written != test.written {
+			t.Errorf("%s inserting on %s at %v: expected %s and %d readings, got %s and %d (%v)", test.vk, test.uri, test.times, test.code, test.written, code, written, err)
+		}
+		if err == nil && ts.written[id.String()] != test.written {
+			t.Errorf("%s inserting on %s: expected %d readings stored, got %d", test.vk, test.uri, test.written, ts.written[id.String()])
+		}
+	}
+}
+
+func TestInsertRewrittenStream(t *testing.T) {
+	a := testArchiver()
+	a.archived = newStreamSet()
+	writers, err := dots.NewACL(dots.ACLConfig{Grants: []dots.ACLGrant{{VK: "admin", URI: "ns/*"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// an archive request rewrote ns/raw/temp to ns/public/temp, so the stream named
+	// "temp" there records ns/raw/temp as its URI
+	rewritten := common.ParseUUID(uuid.NewV3(NAMESPACE_UUID, "ns/public/temp"+"temp").String())
+	md := a.MD.(*fakeMetadata)
+	md.docs = append(md.docs, common.MetadataGroup{UUID: rewritten, URI: "ns/raw/temp"})
+	params := &grpcinterface.InsertParams{Uri: "ns/public/temp", Name: "temp", Times: []int64{15}, Values: []float64{1}}
+	if _, _, err := a.insert(writers, "admin", params, make(map[string]bool)); status.Code(err) != codes.PermissionDenied {
+		t.Errorf("Expected inserting into a rewritten archive request's stream to be denied, got %v", err)
+	}
+}
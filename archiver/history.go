@@ -0,0 +1,196 @@
+package archiver
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/gtfierro/pundat/common"
+	"github.com/gtfierro/pundat/dots"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// SELECT tags WHERE ... AS OF <time>: the metadata store can't evaluate the where clause
+// against past metadata, so the matching documents are sorted, paged and projected here
+func (a *Archiver) selectTagsAsOf(vk string, params *common.TagParams) ([]common.MetadataGroup, string, error) {
+	var (
+		next   string
+		groups []common.MetadataGroup
+	)
+	docs, err := a.MD.GetDocumentsAsOf(params.Where, params.AsOf)
+	if err != nil {
+		return nil, next, err
+	}
+	docs = pageDocuments(docs, params.Page)
+	if params.Page.Limit > 0 && len(docs) == params.Page.Limit {
+		next = (&queryCursor{Offset: params.Page.Offset + len(docs)}).encode()
+	}
+	for _, doc := range docs {
+		if len(params.Tags) > 0 {
+			projected := bson.M{"uri": doc["uri"], "originaluri": doc["originaluri"], "uuid": doc["uuid"]}
+			for _, tag := range params.Tags {
+				if value, found := doc[tag]; found {
+					projected[tag] = value
+				}
+			}
+			doc = projected
+		}
+		if group := common.GroupFromBson(doc); !group.IsEmpty() {
+			groups = append(groups, common.MetadataGroup{Records: group.Records, UUID: group.UUID, URI: group.URI})
+		}
+	}
+	groups, err = a.maskMetadataGroupsByPermission(vk, params.Where, params.Page.OrderBy, groups)
+	return groups, next, err
+}
+
+// SELECT DISTINCT tag WHERE ... AS OF <time>
+func (a *Archiver) distinctTagAsOf(vk string, params *common.DistinctParams) ([]string, string, error) {
+	var (
+		next   string
+		groups []common.MetadataGroup
+	)
+	docs, err := a.MD.GetDocumentsAsOf(params.Where, params.AsOf)
+	if err != nil {
+		return nil, next, err
+	}
+	for _, doc := range docs {
+		groups = append(groups, *common.GroupFromBson(doc))
+	}
+	values, err := a.readableDistinct(vk, params.Tag, params.Where, groups, params.Page)
+	if err != nil {
+		return nil, next, err
+	}
+	if params.Page.Limit > 0 && len(values) == params.Page.Limit {
+		next = (&queryCursor{Offset: params.Page.Offset + len(values)}).encode()
+	}
+	return values, next, nil
+}
+
+// sorts the documents the same way GetMetadata does (by page.OrderBy, ties broken by
+// uuid) and returns the requested page
+func pageDocuments(docs []bson.M, page common.Pagination) []bson.M {
+	sortValue := func(doc bson.M) string {
+		if value, found := doc[page.OrderBy]; found {
+			return fmt.Sprintf("%v", value)
+		}
+		return ""
+	}
+	sort.SliceStable(docs, func(i, j int) bool {
+		if page.OrderBy != "" {
+			if vi, vj := sortValue(docs[i]), sortValue(docs[j]); vi != vj {
+				return (vi < vj) != page.Descending
+			}
+		}
+		return fmt.Sprintf("%v", docs[i]["uuid"]) < fmt.Sprintf("%v", docs[j]["uuid"])
+	})
+	if page.Offset >= len(docs) {
+		return nil
+	}
+	docs = docs[page.Offset:]
+	if page.Limit > 0 && len(docs) > page.Limit {
+		docs = docs[:page.Limit]
+	}
+	return docs
+}
+
+// AS OF DATA: finds the streams whose metadata matched the where clause at some point in
+// [start, end] and records when it did, so that only the readings from those intervals are
+// returned. Replaces the streams with the matching UUIDs; the where clause is kept, as the
+// redaction still has to check which hidden tags it probes
+func (a *Archiver) matchHistory(params *common.DataParams, start, end int64) error {
+	matched, err := a.MD.GetMatchingIntervals(params.Where, time.Unix(0, start), time.Unix(0, end))
+	if err != nil {
+		return err
+	}
+	params.UUIDs = params.UUIDs[:0]
+	for uuid := range matched {
+		params.UUIDs = append(params.UUIDs, common.ParseUUID(uuid))
+	}
+	sort.Slice(params.UUIDs, func(i, j int) bool {
+		return params.UUIDs[i].String() < params.UUIDs[j].String()
+	})
+	params.Matched = matched
+	return nil
+}
+
+// For AS OF DATA queries, restricts the ranges of time the VK may read from the stream to
+// the intervals in which its metadata matched the where clause. The ranges are returned in
+// time order
+func matchedRanges(params *common.DataParams, uuid common.UUID, ranges *dots.DisjointRanges) *dots.DisjointRanges {
+	sort.Slice(ranges.Ranges, func(i, j int) bool {
+		return ranges.Ranges[i].Start.Before(ranges.Ranges[j].Start)
+	})
+	if params.Matched == nil {
+		return ranges
+	}
+	restricted := new(dots.DisjointRanges)
+	for _, rng := range ranges.Ranges {
+		for _, interval := range params.Matched[uuid.String()] {
+			start, end := rng.Start.UnixNano(), rng.End.UnixNano()
+			if interval.Start > start {
+				start = interval.Start
+			}
+			if interval.End < end {
+				end = interval.End
+			}
+			if start <= end {
+				restricted.Ranges = append(restricted.Ranges, dots.NewTimeRangeNano(start, end))
+			}
+		}
+	}
+	return restricted
+}
+
+// BEFORE ... AS OF DATA: for each stream, the latest reading before params.Begin that was
+// taken while the stream's metadata matched the where clause
+func (a *queryContext) prevMatching(params *common.DataParams) ([]common.Timeseries, error) {
+	var result []common.Timeseries
+	for _, uuid := range params.UUIDs {
+		intervals := params.Matched[uuid.String()]
+		for i := len(intervals) - 1; i >= 0; i-- {
+			ref := params.Begin
+			if intervals[i].End < ref {
+				ref = intervals[i].End + 1
+			}
+			res, err := a.TS.Prev([]common.UUID{uuid}, ref)
+			if err != nil {
+				return nil, err
+			}
+			if len(res) == 0 || len(res[0].Records) == 0 {
+				// no earlier readings at all
+				break
+			}
+			if res[0].Records[0].Time.UnixNano() >= intervals[i].Start {
+				result = append(result, res[:1]...)
+				break
+			}
+		}
+	}
+	return result, nil
+}
+
+// AFTER ... AS OF DATA: for each stream, the earliest reading after params.Begin that was
+// taken while the stream's metadata matched the where clause
+func (a *queryContext) nextMatching(params *common.DataParams) ([]common.Timeseries, error) {
+	var result []common.Timeseries
+	for _, uuid := range params.UUIDs {
+		for _, interval := range params.Matched[uuid.String()] {
+			ref := params.Begin
+			if interval.Start > ref {
+				ref = interval.Start - 1
+			}
+			res, err := a.TS.Next([]common.UUID{uuid}, ref)
+			if err != nil {
+				return nil, err
+			}
+			if len(res) == 0 || len(res[0].Records) == 0 {
+				break
+			}
+			if res[0].Records[0].Time.UnixNano() <= interval.End {
+				result = append(result, res[:1]...)
+				break
+			}
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,159 @@
+package archiver
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/gtfierro/pundat/common"
+	"github.com/gtfierro/pundat/dots"
+)
+
+// the latest reading before ref
+func (ts *fakeTimeseries) Prev(uuids []common.UUID, ref int64) ([]common.Timeseries, error) {
+	var res []common.Timeseries
+	for _, uuid := range uuids {
+		readings, _ := ts.GetDataUUID(uuid, 0, ref-1, common.UOT_NS)
+		if n := len(readings.Records); n > 0 {
+			readings.Records = readings.Records[n-1:]
+		}
+		res = append(res, common.Timeseries{UUID: readings.UUID, Records: readings.Records})
+	}
+	return res, nil
+}
+
+// the earliest reading after ref
+func (ts *fakeTimeseries) Next(uuids []common.UUID, ref int64) ([]common.Timeseries, error) {
+	var res []common.Timeseries
+	for _, uuid := range uuids {
+		readings, _ := ts.GetDataUUID(uuid, ref+1, 1<<62, common.UOT_NS)
+		if len(readings.Records) > 0 {
+			readings.Records = readings.Records[:1]
+		}
+		res = append(res, common.Timeseries{UUID: readings.UUID, Records: readings.Records})
+	}
+	return res, nil
+}
+
+func TestMatchingIntervals(t *testing.T) {
+	at := func(nanos ...int64) []time.Time {
+		var times []time.Time
+		for _, n := range nanos {
+			times = append(times, time.Unix(0, n))
+		}
+		return times
+	}
+	// matches between the given times
+	matchingFrom := func(from, until int64) func(time.Time) bool {
+		return func(at time.Time) bool {
+			return at.UnixNano() >= from && at.UnixNano() < until
+		}
+	}
+	for _, test := range []struct {
+		times     []time.Time
+		matches   func(time.Time) bool
+		intervals []common.Interval
+	}{
+		{at(0), matchingFrom(0, 1000), []common.Interval{{Start: 0, End: 100}}},
+		{at(0), matchingFrom(50, 1000), nil},
+		// consecutive matching versions are merged
+		{at(0, 10, 20, 30), matchingFrom(10, 30), []common.Interval{{Start: 10, End: 29}}},
+		{at(0, 10, 20), func(at time.Time) bool { return at.UnixNano() != 10 }, []common.Interval{{Start: 0, End: 9}, {Start: 20, End: 100}}},
+	} {
+		if intervals := matchingIntervals(test.times, time.Unix(0, 100), test.matches); !reflect.DeepEqual(intervals, test.intervals) {
+			t.Errorf("Changes at %v: expected %v, got %v", test.times, test.intervals, intervals)
+		}
+	}
+}
+
+func TestRequiredKey(t *testing.T) {
+	room := common.NewTagPredicate(common.OpEq, "Room", "410")
+	for _, test := range []struct {
+		where *common.Predicate
+		key   string
+	}{
+		{nil, ""},
+		{room, "Room"},
+		{common.NewTagPredicate(common.OpHas, "Floor"), "Floor"},
+		{common.NewAnd(common.NewTagPredicate(common.OpNeq, "Floor", "4"), room), "Room"},
+		// documents without the key can match these
+		{common.NewTagPredicate(common.OpNeq, "Room", "410"), ""},
+		{common.NewNot(room), ""},
+		{common.NewOr(room, common.NewTagPredicate(common.OpHas, "Floor")), ""},
+	} {
+		if key := requiredKey(test.where); key != test.key {
+			t.Errorf("%s: expected %q, got %q", test.where, test.key, key)
+		}
+	}
+}
+
+func TestMatchedRanges(t *testing.T) {
+	ranges := func(bounds ...int64) *dots.DisjointRanges {
+		ret := new(dots.DisjointRanges)
+		for i := 0; i < len(bounds); i += 2 {
+			ret.Ranges = append(ret.Ranges, dots.NewTimeRangeNano(bounds[i], bounds[i+1]))
+		}
+		return ret
+	}
+	// not an AS OF DATA query, so the ranges are only sorted
+	params := &common.DataParams{}
+	if got := planRanges(matchedRanges(params, uuidA, ranges(50, 60, 0, 10))); !reflect.DeepEqual(got, []PlanRange{{0, 10}, {50, 60}}) {
+		t.Errorf("Expected the ranges in time order, got %v", got)
+	}
+
+	params.Matched = map[string][]common.Interval{uuidA.String(): {{Start: 5, End: 15}, {Start: 40, End: 55}}}
+	if got := planRanges(matchedRanges(params, uuidA, ranges(50, 60, 0, 10))); !reflect.DeepEqual(got, []PlanRange{{5, 10}, {50, 55}}) {
+		t.Errorf("Expected the readable ranges clipped to the matching intervals, got %v", got)
+	}
+	if got := matchedRanges(params, uuidB, ranges(0, 100)); len(got.Ranges) != 0 {
+		t.Errorf("Expected nothing from a stream that never matched, got %v", planRanges(got))
+	}
+}
+
+func TestPrevNextMatching(t *testing.T) {
+	q := unlimited(testArchiver())
+	// readings are every 10ns in [0, 100]
+	params := &common.DataParams{
+		UUIDs: []common.UUID{uuidA, uuidB},
+		Matched: map[string][]common.Interval{
+			uuidA.String(): {{Start: 12, End: 35}, {Start: 61, End: 65}},
+			uuidB.String(): {{Start: 41, End: 49}},
+		},
+	}
+	times := func(res []common.Timeseries) map[string]int64 {
+		ret := make(map[string]int64)
+		for i := range res {
+			ret[res[i].UUID.String()] = res[i].Records[0].Time.UnixNano()
+		}
+		return ret
+	}
+
+	for _, test := range []struct {
+		begin int64
+		prev  map[string]int64
+		next  map[string]int64
+	}{
+		// A matched at 20 and 30 and B never had a reading while it matched
+		{50, map[string]int64{uuidA.String(): 30}, map[string]int64{}},
+		{25, map[string]int64{uuidA.String(): 20}, map[string]int64{uuidA.String(): 30}},
+		{0, map[string]int64{}, map[string]int64{uuidA.String(): 20}},
+		// the reading at 70 is after A's last interval
+		{64, map[string]int64{uuidA.String(): 30}, map[string]int64{}},
+	} {
+		params.Begin = test.begin
+		prev, err := q.prevMatching(params)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := times(prev); !reflect.DeepEqual(got, test.prev) {
+			t.Errorf("Before %d: expected %v, got %v", test.begin, test.prev, got)
+		}
+		next, err := q.nextMatching(params)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := times(next); !reflect.DeepEqual(got, test.next) {
+			t.Errorf("After %d: expected %v, got %v", test.begin, test.next, got)
+		}
+	}
+}
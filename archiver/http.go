@@ -0,0 +1,423 @@
+package archiver
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gtfierro/pundat/common"
+	"github.com/gtfierro/pundat/querylang"
+	"github.com/pkg/errors"
+	"gopkg.in/mgo.v2/bson"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// the largest query body POST /query accepts
+const maxHTTPQuerySize = 1 << 20
+
+// There is no write timeout: queries run for as long as their VK's limits allow, and /live
+// connections stay open, setting their own deadline for each write
+const (
+	httpReadHeaderTimeout = 10 * time.Second
+	httpReadTimeout       = time.Minute
+	httpIdleTimeout       = 2 * time.Minute
+)
+
+// Struct representation of the credentials for the HTTP and gRPC APIs. Each bearer token
+// or client certificate (identified by its subject's common name) is mapped to the VK whose
+// permissions apply to the queries made with it. Follows the basic structure:
+//
+//    Tokens:
+//      - Token: 8c5e2b1f0d6a4e3c9b7a
+//        VK: Tj1RiNjKD8ZfYWvMvLVLaIaqDTV9LuNE7pPJoFTwoy8=
+//        Ingest: true
+//    Certificates:
+//      - CommonName: dashboard.example.com
+//        VK: Tj1RiNjKD8ZfYWvMvLVLaIaqDTV9LuNE7pPJoFTwoy8=
+type HTTPCredentials struct {
+	Tokens       []HTTPToken       `yaml:"Tokens"`
+	Certificates []HTTPCertificate `yaml:"Certificates"`
+}
+
+type HTTPToken struct {
+	Token string `yaml:"Token"`
+	VK    string `yaml:"VK"`
+	// if true, the token may also write readings with the gRPC Insert call and in line protocol,
+	// wherever the WriteACL grants its VK
+	Ingest bool `yaml:"Ingest"`
+}
+
+type HTTPCertificate struct {
+	CommonName string `yaml:"CommonName"`
+	VK         string `yaml:"VK"`
+	// if true, the certificate may also write readings with the gRPC Insert call and in line protocol,
+	// wherever the WriteACL grants its VK
+	Ingest bool `yaml:"Ingest"`
+}
+
+// The response to POST /query. Only the results for the type of query are set
+type HTTPQueryResult struct {
+	Metadata      *QueryMetadataResult     `json:",omitempty"`
+	Timeseries    *QueryTimeseriesResult   `json:",omitempty"`
+	Changed       *QueryChangedResult      `json:",omitempty"`
+	Distributions *QueryDistributionResult `json:",omitempty"`
+	Plan          *QueryPlan               `json:",omitempty"`
+	Subscription  *QuerySubscriptionResult `json:",omitempty"`
+}
+
+// who a bearer token or client certificate identifies
+type apiIdentity struct {
+	vk     string
+	ingest bool
+}
+
+// The bearer tokens and client certificates accepted by the HTTP and gRPC APIs
+type apiCredentials struct {
+	// sha256 of the token -> identity
+	tokens map[[sha256.Size]byte]apiIdentity
+	// certificate common name -> identity
+	certs map[string]apiIdentity
+}
+
+// Reads the credentials from the YAML file (see HTTPCredentials). An empty filename
+// means there are none
+func readAPICredentials(filename string) (*apiCredentials, error) {
+	creds := &apiCredentials{
+		tokens: make(map[[sha256.Size]byte]apiIdentity),
+		certs:  make(map[string]apiIdentity),
+	}
+	if filename == "" {
+		return creds, nil
+	}
+	var config HTTPCredentials
+	bytes, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Could not read credentials %s", filename)
+	}
+	if err := yaml.Unmarshal(bytes, &config); err != nil {
+		return nil, errors.Wrap(err, "Could not unmarshal credentials")
+	}
+	for i, token := range config.Tokens {
+		if token.Token == "" || token.VK == "" {
+			return nil, errors.Errorf("Token %d needs both a Token and a VK", i)
+		}
+		creds.tokens[sha256.Sum256([]byte(token.Token))] = apiIdentity{vk: token.VK, ingest: token.Ingest}
+	}
+	for i, cert := range config.Certificates {
+		if cert.CommonName == "" || cert.VK == "" {
+			return nil, errors.Errorf("Certificate %d needs both a CommonName and a VK", i)
+		}
+		creds.certs[cert.CommonName] = apiIdentity{vk: cert.VK, ingest: cert.Ingest}
+	}
+	return creds, nil
+}
+
+// Returns the identity for an authorization header ("Bearer <token>") or, if there is
+// none, for the client's verified certificate
+func (creds *apiCredentials) identify(authorization string, state *tls.ConnectionState) (apiIdentity, error) {
+	if authorization != "" {
+		if !strings.HasPrefix(authorization, "Bearer ") {
+			return apiIdentity{}, errors.New("Only Bearer authorization is supported")
+		}
+		if id, found := creds.tokens[sha256.Sum256([]byte(strings.TrimPrefix(authorization, "Bearer ")))]; found {
+			return id, nil
+		}
+		return apiIdentity{}, errors.New("Unknown token")
+	}
+	if state != nil && len(state.VerifiedChains) > 0 {
+		name := state.VerifiedChains[0][0].Subject.CommonName
+		if id, found := creds.certs[name]; found {
+			return id, nil
+		}
+		return apiIdentity{}, errors.Errorf("No VK for certificate %s", name)
+	}
+	return apiIdentity{}, errors.New("Need a bearer token or client certificate")
+}
+
+// Returns the TLS config for an API that accepts client certificates signed by the CA
+// in clientCA. Clients may still use bearer tokens instead of certificates
+func clientCATLSConfig(clientCA string) (*tls.Config, error) {
+	pem, err := ioutil.ReadFile(clientCA)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Could not read client CA %s", clientCA)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, errors.Errorf("No certificates found in client CA %s", clientCA)
+	}
+	return &tls.Config{ClientCAs: pool, ClientAuth: tls.VerifyClientCertIfGiven}, nil
+}
+
+// Serves the query API over HTTP. Queries are evaluated by HandleQuery on behalf of the VK
+// the request's credentials map to, so they are masked, limited and audited the same way
+// as queries over BOSSWAVE
+type httpServer struct {
+	archiver *Archiver
+	creds    *apiCredentials
+}
+
+// Starts the HTTP API in the background, if it is configured
+func (a *Archiver) startHTTP(c HTTPConfig) error {
+	creds, err := readAPICredentials(c.Credentials)
+	if err != nil {
+		return err
+	}
+	srv := &httpServer{archiver: a, creds: creds}
+	server := &http.Server{
+		Addr:              c.Address,
+		Handler:           srv.handler(),
+		ReadHeaderTimeout: httpReadHeaderTimeout,
+		ReadTimeout:       httpReadTimeout,
+		IdleTimeout:       httpIdleTimeout,
+	}
+	if c.TLSCert == "" {
+		if c.ClientCA != "" {
+			return errors.New("ClientCA needs TLSCert and TLSKey")
+		}
+		go func() {
+			log.Fatal(server.ListenAndServe())
+		}()
+		return nil
+	}
+	if c.ClientCA != "" {
+		if server.TLSConfig, err = clientCATLSConfig(c.ClientCA); err != nil {
+			return err
+		}
+	}
+	go func() {
+		log.Fatal(server.ListenAndServeTLS(c.TLSCert, c.TLSKey))
+	}()
+	return nil
+}
+
+func (srv *httpServer) handler() http.Handler {
+	// not the default mux, which serves the profiler
+	mux := http.NewServeMux()
+	mux.HandleFunc("/query", srv.handleQuery)
+	mux.HandleFunc("/streams/", srv.handleStream)
+	mux.HandleFunc("/metadata", srv.handleMetadata)
+	mux.HandleFunc("/live", srv.handleLive)
+	if srv.archiver.influx != nil {
+		mux.HandleFunc("/write", srv.handleInfluxWrite)
+	}
+	return mux
+}
+
+// Returns the VK for the request's bearer token or verified client certificate
+func (srv *httpServer) authenticate(r *http.Request) (string, error) {
+	id, err := srv.creds.identify(r.Header.Get("Authorization"), r.TLS)
+	return id.vk, err
+}
+
+// POST /query with the query as the body, or as {"Query": "..."} if the content type is JSON
+func (srv *httpServer) handleQuery(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		srv.writeError(w, http.StatusMethodNotAllowed, "", errors.New("Use POST"))
+		return
+	}
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxHTTPQuerySize))
+	if err != nil {
+		srv.writeError(w, http.StatusBadRequest, "", errors.Wrap(err, "Could not read query"))
+		return
+	}
+	query := string(body)
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+		var msg KeyValueQuery
+		if err := json.Unmarshal(body, &msg); err != nil {
+			srv.writeError(w, http.StatusBadRequest, "", errors.Wrap(err, "Could not unmarshal query"))
+			return
+		}
+		query = msg.Query
+	}
+	srv.query(w, r, query)
+}
+
+// GET /streams/{uuid} returns the stream's metadata. With ?start=<time>[&end=<time>] it
+// returns the stream's data in that range instead; times are nanoseconds or RFC3339, and
+// end defaults to now
+func (srv *httpServer) handleStream(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		srv.writeError(w, http.StatusMethodNotAllowed, "", errors.New("Use GET"))
+		return
+	}
+	uuid := strings.TrimPrefix(r.URL.Path, "/streams/")
+	if common.ParseUUID(uuid) == nil {
+		srv.writeError(w, http.StatusNotFound, "", errors.Errorf("Invalid UUID %s", uuid))
+		return
+	}
+	params := r.URL.Query()
+	if params.Get("start") == "" {
+		srv.query(w, r, fmt.Sprintf("select * where uuid = \"%s\";", uuid))
+		return
+	}
+	start, err := parseHTTPTime(params.Get("start"))
+	if err != nil {
+		srv.writeError(w, http.StatusBadRequest, "", err)
+		return
+	}
+	end := "now"
+	if params.Get("end") != "" {
+		t, err := parseHTTPTime(params.Get("end"))
+		if err != nil {
+			srv.writeError(w, http.StatusBadRequest, "", err)
+			return
+		}
+		end = fmt.Sprintf("%d ns", t.UnixNano())
+	}
+	srv.query(w, r, fmt.Sprintf("select data in (%d ns, %s) where uuid = \"%s\";", start.UnixNano(), end, uuid))
+}
+
+var httpTagRegex = regexp.MustCompile(`^[a-zA-Z0-9_./!-]+$`)
+
+// GET /metadata?where=<where clause>&tags=<tag>,<tag> returns the tags (all of them if
+// there are none) of the streams matching the where clause (all streams if there is none)
+func (srv *httpServer) handleMetadata(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		srv.writeError(w, http.StatusMethodNotAllowed, "", errors.New("Use GET"))
+		return
+	}
+	params := r.URL.Query()
+	selector := "*"
+	if tags := params.Get("tags"); tags != "" {
+		for _, tag := range strings.Split(tags, ",") {
+			if !httpTagRegex.MatchString(tag) {
+				srv.writeError(w, http.StatusBadRequest, "", errors.Errorf("Invalid tag %s", tag))
+				return
+			}
+		}
+		selector = tags
+	}
+	query := "select " + selector
+	if where := params.Get("where"); where != "" {
+		query += " where " + where
+	}
+	srv.query(w, r, query+";")
+}
+
+// evaluates the query on behalf of the request's VK and writes the results
+func (srv *httpServer) query(w http.ResponseWriter, r *http.Request, query string) {
+	vk, err := srv.authenticate(r)
+	if err != nil {
+		srv.writeError(w, http.StatusUnauthorized, query, err)
+		return
+	}
+	// new readings can't be pushed to HTTP clients, though existing subscriptions can be renewed
+	if parsed := srv.archiver.qp.Parse(query); parsed.Err == nil && parsed.QueryType == querylang.SUBSCRIBE_TYPE {
+		if params, ok := parsed.GetParams().(*common.SubscribeParams); ok && params.ID == "" {
+			srv.writeError(w, http.StatusBadRequest, query, errors.New("SUBSCRIBE is not supported over HTTP"))
+			return
+		}
+	}
+	start := time.Now()
+	res, err := srv.archiver.HandleQuery(vk, query)
+	if err != nil {
+		srv.writeError(w, queryErrorStatus(err), query, err)
+		return
+	}
+	var reply HTTPQueryResult
+	if res.Plan != nil {
+		reply.Plan = res.Plan
+	}
+	if len(res.Metadata) > 0 {
+		md := metadataResult(0, res.Metadata, res.Cursor)
+		for _, doc := range md.Data {
+			for key, value := range doc.Metadata {
+				doc.Metadata[key] = jsonValue(value)
+			}
+		}
+		reply.Metadata = &md
+	}
+	if len(res.Timeseries)+len(res.Statistics) > 0 {
+		ts := timeseriesResult(0, res.Timeseries, res.Statistics, res.Cursor, res.Format)
+		reply.Timeseries = &ts
+	}
+	if len(res.Distributions) > 0 {
+		dist := distributionResult(0, res.Distributions)
+		reply.Distributions = &dist
+	}
+	if len(res.Changed) > 0 {
+		changed := changedResult(0, res.Changed)
+		reply.Changed = &changed
+	}
+	reply.Subscription = res.Subscription
+	// if we do not have any results, send back an empty metadata result
+	if reply == (HTTPQueryResult{}) {
+		md := metadataResult(0, nil, res.Cursor)
+		reply.Metadata = &md
+	}
+	log.Infof("HTTP reply to %s: MD/TS/Stat/Dist/Chng (%d/%d/%d/%d/%d) (took %s)", vk, len(res.Metadata), len(res.Timeseries), len(res.Statistics), len(res.Distributions), len(res.Changed), time.Since(start))
+	srv.write(w, http.StatusOK, reply)
+}
+
+// the status for an error from HandleQuery: the client's fault if the query was bad or
+// asked for too much, otherwise the archiver's
+func queryErrorStatus(err error) int {
+	fault, ok := errors.Cause(err).(queryFault)
+	switch {
+	case ok && fault.retry:
+		return http.StatusTooManyRequests
+	case ok:
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
+
+func (srv *httpServer) writeError(w http.ResponseWriter, status int, query string, err error) {
+	srv.write(w, status, QueryError{Query: query, Error: err.Error()})
+}
+
+func (srv *httpServer) write(w http.ResponseWriter, status int, msg interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(msg); err != nil {
+		log.Error(errors.Wrap(err, "Could not write HTTP response"))
+	}
+}
+
+// accepts unix nanoseconds and RFC3339 times
+func parseHTTPTime(value string) (time.Time, error) {
+	if nanos, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Unix(0, nanos), nil
+	}
+	t, err := time.Parse(time.RFC3339Nano, value)
+	if err != nil {
+		return t, errors.Errorf("Could not parse time %s (need nanoseconds or RFC3339)", value)
+	}
+	return t, nil
+}
+
+// converts the maps decoded from msgpack, which have interface{} keys, into maps that
+// can be encoded as JSON
+func jsonValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for key, inner := range v {
+			m[fmt.Sprintf("%v", key)] = jsonValue(inner)
+		}
+		return m
+	case bson.M:
+		return jsonValue(map[string]interface{}(v))
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for key, inner := range v {
+			m[key] = jsonValue(inner)
+		}
+		return m
+	case []interface{}:
+		l := make([]interface{}, len(v))
+		for i, inner := range v {
+			l[i] = jsonValue(inner)
+		}
+		return l
+	}
+	return value
+}
@@ -0,0 +1,219 @@
+package archiver
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gtfierro/pundat/common"
+	"github.com/gtfierro/pundat/querylang"
+	"github.com/pkg/errors"
+)
+
+// a metadata store whose database is down
+type brokenMetadata struct {
+	*fakeMetadata
+}
+
+func (md brokenMetadata) GetMetadata(VK string, tags []string, where *common.Predicate, page common.Pagination) ([]common.MetadataGroup, error) {
+	return nil, errors.New("no reachable servers")
+}
+
+// serves the test archiver, with "secret" as the token for "vk" and "admin-secret" for "admin"
+func testHTTPServer(t *testing.T) *httpServer {
+	a := testArchiver()
+	a.cache = newResultCache()
+	a.qp = querylang.NewQueryProcessor()
+	var err error
+	if a.limiter, err = newQueryLimiter(nil); err != nil {
+		t.Fatal(err)
+	}
+	creds := &apiCredentials{
+		tokens: map[[sha256.Size]byte]apiIdentity{
+			sha256.Sum256([]byte("secret")):       {vk: "vk"},
+			sha256.Sum256([]byte("admin-secret")): {vk: "admin"},
+		},
+		certs: map[string]apiIdentity{},
+	}
+	return &httpServer{archiver: a, creds: creds}
+}
+
+// makes the request and decodes the reply into res, returning the status
+func serve(t *testing.T, srv *httpServer, method, target, token, body string, res interface{}) int {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	rec := httptest.NewRecorder()
+	srv.handler().ServeHTTP(rec, req)
+	if err := json.NewDecoder(rec.Body).Decode(res); err != nil {
+		t.Fatalf("%s %s: could not decode reply (%v)", method, target, err)
+	}
+	return rec.Code
+}
+
+func TestHTTPQuery(t *testing.T) {
+	srv := testHTTPServer(t)
+	var res HTTPQueryResult
+	if status := serve(t, srv, "POST", "/query", "secret", "select * where has Room;", &res); status != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", status)
+	}
+	if res.Metadata == nil || len(res.Metadata.Data) != 1 || res.Metadata.Data[0].UUID != uuidA.String() {
+		t.Errorf("Expected only the readable stream, got %+v", res.Metadata)
+	}
+
+	for _, test := range []struct {
+		method string
+		token  string
+		query  string
+		status int
+	}{
+		{"GET", "secret", "select * where has Room;", http.StatusMethodNotAllowed},
+		{"POST", "", "select * where has Room;", http.StatusUnauthorized},
+		{"POST", "wrong", "select * where has Room;", http.StatusUnauthorized},
+		{"POST", "secret", "select where;", http.StatusBadRequest},
+		// a JSON body is only unwrapped with the JSON content type
+		{"POST", "secret", `{"Query": "select * where has Room;"}`, http.StatusBadRequest},
+		{"POST", "secret", "subscribe data where has Room;", http.StatusBadRequest},
+	} {
+		var qerr QueryError
+		if status := serve(t, srv, test.method, "/query", test.token, test.query, &qerr); status != test.status {
+			t.Errorf("%s %q with token %q: expected %d, got %d (%s)", test.method, test.query, test.token, test.status, status, qerr.Error)
+		}
+	}
+
+	// the database failing is not the client's fault
+	srv.archiver.MD = brokenMetadata{srv.archiver.MD.(*fakeMetadata)}
+	var qerr QueryError
+	if status := serve(t, srv, "POST", "/query", "secret", "select * where has Room;", &qerr); status != http.StatusInternalServerError {
+		t.Errorf("Expected 500 when the metadata store fails, got %d (%s)", status, qerr.Error)
+	}
+}
+
+func TestHTTPQueryJSON(t *testing.T) {
+	srv := testHTTPServer(t)
+	req := httptest.NewRequest("POST", "/query", strings.NewReader(`{"Query": "select * where has Room;"}`))
+	req.Header.Set("Authorization", "Bearer admin-secret")
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	srv.handler().ServeHTTP(rec, req)
+	var res HTTPQueryResult
+	if err := json.NewDecoder(rec.Body).Decode(&res); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Code != http.StatusOK || res.Metadata == nil || len(res.Metadata.Data) != 2 {
+		t.Errorf("Expected both streams for admin, got %d %+v", rec.Code, res.Metadata)
+	}
+}
+
+func TestHTTPStream(t *testing.T) {
+	srv := testHTTPServer(t)
+	var res HTTPQueryResult
+	if status := serve(t, srv, "GET", "/streams/"+uuidA.String()+"?start=0&end=100", "secret", "", &res); status != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", status)
+	}
+	// the VK may only read [10, 20]
+	if res.Timeseries == nil || len(res.Timeseries.Data) != 1 {
+		t.Fatalf("Expected one stream of data, got %+v", res.Timeseries)
+	}
+	if values := res.Timeseries.Data[0].Values; len(values) != 2 || values[0] != 10 || values[1] != 20 {
+		t.Errorf("Expected the readings at 10 and 20, got %v", values)
+	}
+
+	res = HTTPQueryResult{}
+	if status := serve(t, srv, "GET", "/streams/"+uuidA.String(), "secret", "", &res); status != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", status)
+	}
+	if res.Metadata == nil || len(res.Metadata.Data) != 1 {
+		t.Errorf("Expected the stream's metadata, got %+v", res.Metadata)
+	}
+
+	for _, test := range []struct {
+		method string
+		target string
+		status int
+	}{
+		{"POST", "/streams/" + uuidA.String(), http.StatusMethodNotAllowed},
+		{"GET", "/streams/not-a-uuid", http.StatusNotFound},
+		{"GET", "/streams/" + uuidA.String() + "?start=yesterday", http.StatusBadRequest},
+		{"GET", "/streams/" + uuidA.String() + "?start=0&end=tomorrow", http.StatusBadRequest},
+	} {
+		var qerr QueryError
+		if status := serve(t, srv, test.method, test.target, "secret", "", &qerr); status != test.status {
+			t.Errorf("%s %s: expected %d, got %d (%s)", test.method, test.target, test.status, status, qerr.Error)
+		}
+	}
+}
+
+func TestHTTPMetadata(t *testing.T) {
+	srv := testHTTPServer(t)
+	var res HTTPQueryResult
+	if status := serve(t, srv, "GET", "/metadata?tags=Room&where=has+Room", "admin-secret", "", &res); status != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", status)
+	}
+	if res.Metadata == nil || len(res.Metadata.Data) != 2 {
+		t.Errorf("Expected both streams for admin, got %+v", res.Metadata)
+	}
+
+	var qerr QueryError
+	if status := serve(t, srv, "GET", "/metadata?tags=Room+drop", "admin-secret", "", &qerr); status != http.StatusBadRequest {
+		t.Errorf("Expected an invalid tag to be rejected, got %d", status)
+	}
+	if status := serve(t, srv, "GET", "/metadata?where=Room+%3D", "admin-secret", "", &qerr); status != http.StatusBadRequest {
+		t.Errorf("Expected an invalid where clause to be rejected, got %d", status)
+	}
+}
+
+func TestIdentify(t *testing.T) {
+	creds := &apiCredentials{
+		tokens: map[[sha256.Size]byte]apiIdentity{sha256.Sum256([]byte("secret")): {vk: "vk", ingest: true}},
+		certs:  map[string]apiIdentity{"dashboard": {vk: "dashvk"}},
+	}
+	verified := func(name string) *tls.ConnectionState {
+		return &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{{Subject: pkix.Name{CommonName: name}}}}}
+	}
+	for _, test := range []struct {
+		authorization string
+		state         *tls.ConnectionState
+		id            apiIdentity
+		ok            bool
+	}{
+		{"Bearer secret", nil, apiIdentity{vk: "vk", ingest: true}, true},
+		{"Bearer wrong", nil, apiIdentity{}, false},
+		{"Basic c2VjcmV0", nil, apiIdentity{}, false},
+		// a token takes precedence over a certificate
+		{"Bearer wrong", verified("dashboard"), apiIdentity{}, false},
+		{"", verified("dashboard"), apiIdentity{vk: "dashvk"}, true},
+		{"", verified("stranger"), apiIdentity{}, false},
+		// unverified certificates are ignored
+		{"", &tls.ConnectionState{}, apiIdentity{}, false},
+		{"", nil, apiIdentity{}, false},
+	} {
+		id, err := creds.identify(test.authorization, test.state)
+		if (err == nil) != test.ok || id != test.id {
+			t.Errorf("identify(%q, %v): expected %+v (ok=%v), got %+v (%v)", test.authorization, test.state, test.id, test.ok, id, err)
+		}
+	}
+}
+
+func TestQueryErrorStatus(t *testing.T) {
+	for _, test := range []struct {
+		err    error
+		status int
+	}{
+		{badQuery(errors.New("Error in query")), http.StatusBadRequest},
+		{errors.Wrap(badQuery(errors.New("Invalid cursor")), "Could not page"), http.StatusBadRequest},
+		{busy(errors.New("Too many concurrent queries")), http.StatusTooManyRequests},
+		{errors.New("no reachable servers"), http.StatusInternalServerError},
+	} {
+		if status := queryErrorStatus(test.err); status != test.status {
+			t.Errorf("%v: expected %d, got %d", test.err, test.status, status)
+		}
+	}
+}
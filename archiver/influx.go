@@ -0,0 +1,522 @@
+package archiver
+
+import (
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"math"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gtfierro/pundat/common"
+	"github.com/gtfierro/pundat/dots"
+	"github.com/gtfierro/pundat/scraper"
+	"github.com/pkg/errors"
+	"github.com/satori/go.uuid"
+)
+
+// the first segment of the pseudo-URIs of readings written in line protocol, unless configured otherwise
+const defaultInfluxPrefix = "influx"
+
+// the largest (uncompressed) body POST /write accepts
+const maxInfluxWriteSize = 16 << 20
+
+// the largest UDP datagram
+const maxInfluxDatagramSize = 64 * 1024
+
+// nanoseconds per unit of each timestamp precision line protocol can be written in
+var influxPrecisions = map[string]int64{
+	"":   1,
+	"n":  1,
+	"ns": 1,
+	"u":  int64(time.Microsecond),
+	"us": int64(time.Microsecond),
+	"ms": int64(time.Millisecond),
+	"s":  int64(time.Second),
+	"m":  int64(time.Minute),
+	"h":  int64(time.Hour),
+}
+
+// A line of InfluxDB line protocol:
+//
+//    <measurement>[,<tag>=<value>...] <field>=<value>[,<field>=<value>...] [<timestamp>]
+type influxPoint struct {
+	measurement string
+	// sorted by key
+	tags []influxTag
+	// string fields are dropped because they can't be archived
+	fields map[string]float64
+	// nanoseconds
+	time int64
+}
+
+type influxTag struct {
+	key   string
+	value string
+}
+
+// The pseudo-URI of the point's series: <prefix>/<measurement>/<tag>=<value>/... with the
+// tags in order. Each field is a stream named after the field on this URI, so the stream's
+// UUID is derived from the measurement, tags and field the same way as for archive requests
+func (point *influxPoint) uri(prefix string) string {
+	segments := []string{prefix, url.QueryEscape(point.measurement)}
+	for _, tag := range point.tags {
+		segments = append(segments, url.QueryEscape(tag.key)+"="+url.QueryEscape(tag.value))
+	}
+	return strings.Join(segments, "/")
+}
+
+// Parses a line of line protocol. The timestamp is in units of multiplier nanoseconds and
+// defaults to now
+func parseInfluxLine(line string, multiplier, now int64) (point influxPoint, err error) {
+	key, rest, found := cutInflux(line, ' ', false)
+	if !found {
+		return point, errors.New("Missing fields")
+	}
+	fields, timestamp, _ := cutInflux(rest, ' ', true)
+
+	tags := splitInflux(key, ',', false)
+	if point.measurement = unescapeInflux(tags[0], ", "); point.measurement == "" {
+		return point, errors.New("Missing measurement")
+	}
+	for _, tag := range tags[1:] {
+		k, v, found := cutInflux(tag, '=', false)
+		if !found || k == "" || v == "" {
+			return point, errors.Errorf("Invalid tag %s", tag)
+		}
+		point.tags = append(point.tags, influxTag{key: unescapeInflux(k, ",= "), value: unescapeInflux(v, ",= ")})
+	}
+	sort.Slice(point.tags, func(i, j int) bool {
+		return point.tags[i].key < point.tags[j].key
+	})
+
+	point.fields = make(map[string]float64)
+	for _, field := range splitInflux(fields, ',', true) {
+		k, v, found := cutInflux(field, '=', false)
+		if !found || k == "" {
+			return point, errors.Errorf("Invalid field %s", field)
+		}
+		value, numeric, err := parseInfluxValue(v)
+		if err != nil {
+			return point, errors.Wrapf(err, "Invalid value for field %s", k)
+		}
+		if numeric {
+			point.fields[unescapeInflux(k, ",= ")] = value
+		}
+	}
+
+	point.time = now
+	if timestamp = strings.TrimSpace(timestamp); timestamp != "" {
+		t, err := strconv.ParseInt(timestamp, 10, 64)
+		if err != nil {
+			return point, errors.Wrapf(err, "Invalid timestamp %s", timestamp)
+		}
+		if t > math.MaxInt64/multiplier || t < math.MinInt64/multiplier {
+			return point, errors.Errorf("Timestamp %s is out of range", timestamp)
+		}
+		point.time = t * multiplier
+	}
+	return point, nil
+}
+
+// Parses a field value. Booleans are archived as 1 and 0, like those from BOSSWAVE, and
+// strings are not numeric
+func parseInfluxValue(value string) (float64, bool, error) {
+	switch value {
+	case "":
+		return 0, false, errors.New("Missing value")
+	case "t", "T", "true", "True", "TRUE":
+		return 1, true, nil
+	case "f", "F", "false", "False", "FALSE":
+		return 0, true, nil
+	}
+	switch {
+	case value[0] == '"':
+		if len(value) < 2 || value[len(value)-1] != '"' {
+			return 0, false, errors.Errorf("Unterminated string %s", value)
+		}
+		return 0, false, nil
+	case strings.HasSuffix(value, "i"):
+		i, err := strconv.ParseInt(strings.TrimSuffix(value, "i"), 10, 64)
+		return float64(i), true, err
+	case strings.HasSuffix(value, "u"):
+		u, err := strconv.ParseUint(strings.TrimSuffix(value, "u"), 10, 64)
+		return float64(u), true, err
+	}
+	f, err := strconv.ParseFloat(value, 64)
+	return f, true, err
+}
+
+// splits s at the unescaped instances of sep. If quoted is true, instances inside
+// double-quoted strings don't count
+func splitInflux(s string, sep byte, quoted bool) []string {
+	var parts []string
+	for {
+		before, after, found := cutInflux(s, sep, quoted)
+		parts = append(parts, before)
+		if !found {
+			return parts
+		}
+		s = after
+	}
+}
+
+// splits s around the first unescaped instance of sep. If quoted is true, instances inside
+// double-quoted strings don't count
+func cutInflux(s string, sep byte, quoted bool) (before, after string, found bool) {
+	var inQuote bool
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '\\':
+			i++
+		case quoted && s[i] == '"':
+			inQuote = !inQuote
+		case !inQuote && s[i] == sep:
+			return s[:i], s[i+1:], true
+		}
+	}
+	return s, "", false
+}
+
+// removes the backslashes before the special characters in s. Other backslashes are literal
+func unescapeInflux(s, specials string) string {
+	if strings.IndexByte(s, '\\') < 0 {
+		return s
+	}
+	unescaped := make([]byte, 0, len(s))
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) && strings.IndexByte(specials, s[i+1]) >= 0 {
+			i++
+		}
+		unescaped = append(unescaped, s[i])
+	}
+	return string(unescaped)
+}
+
+// Accepts readings in InfluxDB line protocol for gateways that can't speak BOSSWAVE. The
+// readings are buffered and committed like those of archive requests, as though there were
+// an archive request for each field on every pseudo-URI (see influxPoint.uri), and the
+// point's tags are stored as the pseudo-URI's metadata
+type influxIngester struct {
+	archiver *Archiver
+	prefix   string
+	// grants VKs the pseudo-URIs they may write to
+	acl *dots.ACL
+	// field -> stream
+	streams map[string]*Stream
+	// the pseudo-URIs whose tags have been stored
+	tagged map[string]bool
+	// guards streams and tagged
+	sync.Mutex
+	// held while a new series is initialized, so that each is only initialized once
+	initializing sync.Mutex
+}
+
+func newInfluxIngester(a *Archiver, c InfluxConfig) (*influxIngester, error) {
+	acl, err := dots.ReadACL(c.WriteACL)
+	if err != nil {
+		return nil, err
+	}
+	prefix := strings.Trim(c.Prefix, "/")
+	if prefix == "" {
+		prefix = defaultInfluxPrefix
+	}
+	return &influxIngester{
+		archiver: a,
+		prefix:   prefix,
+		acl:      acl,
+		streams:  make(map[string]*Stream),
+		tagged:   make(map[string]bool),
+	}, nil
+}
+
+// Parses the lines, whose timestamps are in the given precision ("ns" if empty). Lines
+// without a timestamp are given the current time
+func (ing *influxIngester) parse(data []byte, precision string) ([]influxPoint, error) {
+	multiplier, found := influxPrecisions[precision]
+	if !found {
+		return nil, errors.Errorf("Unknown precision %s", precision)
+	}
+	now := time.Now().UnixNano()
+	var points []influxPoint
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		point, err := parseInfluxLine(line, multiplier, now)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Could not parse line %d", i+1)
+		}
+		if !ing.archiver.TS.ValidTimestamp(point.time, common.UOT_NS) {
+			return nil, errors.Errorf("Timestamp on line %d is out of range", i+1)
+		}
+		points = append(points, point)
+	}
+	return points, nil
+}
+
+// Returns an error unless the ACL lets the VK write every point at the point's time
+func (ing *influxIngester) authorize(vk string, points []influxPoint) error {
+	var (
+		uris  []string
+		times = make(map[string][]int64)
+	)
+	for i := range points {
+		uri := points[i].uri(ing.prefix)
+		if _, found := times[uri]; !found {
+			uris = append(uris, uri)
+		}
+		times[uri] = append(times[uri], points[i].time)
+	}
+	for _, uri := range uris {
+		if err := canWrite(ing.acl, uri, vk, times[uri]); err != nil {
+			return errors.Wrap(err, "Not allowed to write")
+		}
+	}
+	return nil
+}
+
+// Buffers the points' readings to be committed, creating their streams as needed. Like
+// readings from BOSSWAVE, NaN and infinite values are skipped. Returns the number of
+// readings buffered
+func (ing *influxIngester) write(points []influxPoint) (int, error) {
+	type series struct {
+		uri   string
+		field string
+	}
+	var (
+		order    []series
+		readings = make(map[series][]*common.TimeseriesReading)
+		first    = make(map[series]*influxPoint)
+	)
+	for i := range points {
+		point := &points[i]
+		uri := point.uri(ing.prefix)
+		for field, value := range point.fields {
+			if math.IsInf(value, 0) || math.IsNaN(value) {
+				continue
+			}
+			key := series{uri: uri, field: field}
+			if _, found := first[key]; !found {
+				order = append(order, key)
+				first[key] = point
+			}
+			readings[key] = append(readings[key], &common.TimeseriesReading{Time: time.Unix(0, point.time), Unit: common.UOT_NS, Value: value})
+		}
+	}
+
+	var written int
+	for _, key := range order {
+		stream, err := ing.stream(key.field, key.uri, first[key])
+		if err != nil {
+			return written, err
+		}
+		stream.addReadings(key.uri, readings[key])
+		written += len(readings[key])
+	}
+	return written, nil
+}
+
+// Returns the stream for the field, initialized for the pseudo-URI
+func (ing *influxIngester) stream(field, uri string, point *influxPoint) (*Stream, error) {
+	if stream := ing.seen(field, uri); stream != nil {
+		return stream, nil
+	}
+	// new series are rare, so they are initialized one at a time. Another write may have
+	// initialized this one while we waited
+	ing.initializing.Lock()
+	defer ing.initializing.Unlock()
+	if stream := ing.seen(field, uri); stream != nil {
+		return stream, nil
+	}
+	return ing.initialize(field, uri, point)
+}
+
+// Returns the stream for the field if it has been initialized for the pseudo-URI, or nil
+func (ing *influxIngester) seen(field, uri string) *Stream {
+	ing.Lock()
+	stream := ing.streams[field]
+	ing.Unlock()
+	if stream == nil {
+		return nil
+	}
+	stream.RLock()
+	_, seen := stream.seenURIs[uri]
+	stream.RUnlock()
+	if !seen {
+		return nil
+	}
+	return stream
+}
+
+// Initializes the stream for the field on the pseudo-URI, creating the stream if it is the
+// field's first. The first time a pseudo-URI is seen, the point's measurement and tags are
+// stored as its metadata. Stream UUIDs are derived from the URI and name run together, so a
+// series whose UUID belongs to a stream on another URI is refused. Called with initializing held
+func (ing *influxIngester) initialize(field, uri string, point *influxPoint) (*Stream, error) {
+	a := ing.archiver
+	id := common.ParseUUID(uuid.NewV3(NAMESPACE_UUID, uri+field).String())
+	if owner, err := a.MD.URIFromUUID(id); err == nil && owner != uri {
+		return nil, badQuery(errors.Errorf("Field %s on %s would be the same stream as one on %s", field, uri, owner))
+	}
+
+	ing.Lock()
+	stream, found := ing.streams[field]
+	if !found {
+		stream = &Stream{
+			subscribeURI: ing.prefix + "/*",
+			name:         field,
+			live:         a.subs,
+			archived:     a.archived,
+			seenURIs:     make(map[string]common.UUID),
+			timeseries:   make(map[string]*common.Timeseries),
+		}
+		ing.streams[field] = stream
+	}
+	tagged := ing.tagged[uri]
+	ing.Unlock()
+	if !found {
+		stream.startAnnotations(a.TS, a.MD)
+	}
+
+	if !tagged {
+		now := time.Now()
+		// stored as though they were published on <uri>/!meta/<tag>
+		record := func(key, value string) common.MetadataRecord {
+			return common.MetadataRecord{Key: key, Value: value, SrcURI: uri + "/!meta/" + key, TimeValid: now}
+		}
+		records := []common.MetadataRecord{record("measurement", point.measurement)}
+		for _, tag := range point.tags {
+			records = append(records, record(tag.key, tag.value))
+		}
+		if err := scraper.DB.InsertRecords(records...); err != nil {
+			return nil, errors.Wrapf(err, "Could not store tags for %s", uri)
+		}
+		ing.Lock()
+		ing.tagged[uri] = true
+		ing.Unlock()
+	}
+	if err := stream.initializeURI(a.TS, a.MD, uri); err != nil {
+		// so that the next write tries again
+		stream.forget(uri)
+		return nil, err
+	}
+	return stream, nil
+}
+
+// POST /write?precision=<ns|u|ms|s|m|h> accepts line protocol, optionally gzipped, from
+// credentials that may ingest readings (the db and rp parameters are ignored). Like
+// InfluxDB, it accepts "Token <token>", basic authentication with the token as the password
+// and ?p=<token> as well as bearer tokens
+func (srv *httpServer) handleInfluxWrite(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		srv.writeError(w, http.StatusMethodNotAllowed, "", errors.New("Use POST"))
+		return
+	}
+	id, err := srv.creds.identify(influxAuthorization(r), r.TLS)
+	if err != nil {
+		srv.writeError(w, http.StatusUnauthorized, "", err)
+		return
+	}
+	if !id.ingest {
+		srv.writeError(w, http.StatusForbidden, "", errors.New("These credentials may not write readings"))
+		return
+	}
+	var body io.Reader = http.MaxBytesReader(w, r.Body, maxInfluxWriteSize)
+	if r.Header.Get("Content-Encoding") == "gzip" {
+		gz, err := gzip.NewReader(body)
+		if err != nil {
+			srv.writeError(w, http.StatusBadRequest, "", errors.Wrap(err, "Could not decompress body"))
+			return
+		}
+		defer gz.Close()
+		body = gz
+	}
+	data, err := ioutil.ReadAll(io.LimitReader(body, maxInfluxWriteSize+1))
+	if err != nil {
+		srv.writeError(w, http.StatusBadRequest, "", errors.Wrap(err, "Could not read body"))
+		return
+	}
+	if len(data) > maxInfluxWriteSize {
+		srv.writeError(w, http.StatusRequestEntityTooLarge, "", errors.Errorf("Body is larger than %d bytes", maxInfluxWriteSize))
+		return
+	}
+
+	ing := srv.archiver.influx
+	points, err := ing.parse(data, r.URL.Query().Get("precision"))
+	if err != nil {
+		srv.writeError(w, http.StatusBadRequest, "", err)
+		return
+	}
+	if err := ing.authorize(id.vk, points); err != nil {
+		srv.writeError(w, http.StatusForbidden, "", err)
+		return
+	}
+	if _, err := ing.write(points); err != nil {
+		srv.writeError(w, queryErrorStatus(err), "", err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Returns the request's credentials as a bearer authorization header for identify
+func influxAuthorization(r *http.Request) string {
+	authorization := r.Header.Get("Authorization")
+	switch {
+	case strings.HasPrefix(authorization, "Token "):
+		return "Bearer " + strings.TrimPrefix(authorization, "Token ")
+	case strings.HasPrefix(authorization, "Basic "):
+		if _, password, ok := r.BasicAuth(); ok {
+			return "Bearer " + password
+		}
+	case authorization == "" && r.URL.Query().Get("p") != "":
+		return "Bearer " + r.URL.Query().Get("p")
+	}
+	return authorization
+}
+
+// Accepts line protocol over UDP in the background. UDP can't be authenticated, so the
+// points are written as the given VK
+func (ing *influxIngester) listenUDP(address, vk string) error {
+	if vk == "" {
+		return errors.New("Need a UDPVK to write as")
+	}
+	conn, err := net.ListenPacket("udp", address)
+	if err != nil {
+		return errors.Wrapf(err, "Could not listen on %s", address)
+	}
+	go ing.serveUDP(conn, vk)
+	return nil
+}
+
+// Writes the line protocol in each datagram received on the connection as the VK, until the
+// connection is closed
+func (ing *influxIngester) serveUDP(conn net.PacketConn, vk string) {
+	buf := make([]byte, maxInfluxDatagramSize)
+	for {
+		n, from, err := conn.ReadFrom(buf)
+		if opErr, ok := err.(*net.OpError); ok && opErr.Err == net.ErrClosed {
+			return
+		} else if err != nil {
+			log.Error(errors.Wrap(err, "Could not read line protocol over UDP"))
+			continue
+		}
+		points, err := ing.parse(buf[:n], "")
+		if err == nil {
+			err = ing.authorize(vk, points)
+		}
+		if err == nil {
+			_, err = ing.write(points)
+		}
+		if err != nil {
+			log.Warning(errors.Wrapf(err, "Dropping line protocol from %s", from))
+		}
+	}
+}
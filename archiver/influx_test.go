@@ -0,0 +1,167 @@
+package archiver
+
+import (
+	"net"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/gtfierro/pundat/common"
+	"github.com/gtfierro/pundat/dots"
+	"github.com/pkg/errors"
+	"github.com/satori/go.uuid"
+)
+
+func TestParseInfluxLine(t *testing.T) {
+	for _, test := range []struct {
+		line  string
+		point influxPoint
+		uri   string
+	}{
+		{
+			"cpu,host=a,core=0 usage=0.5,busy=t,count=3i 1500000000",
+			influxPoint{
+				measurement: "cpu",
+				tags:        []influxTag{{"core", "0"}, {"host", "a"}},
+				fields:      map[string]float64{"usage": 0.5, "busy": 1, "count": 3},
+				time:        1500000000 * 1000,
+			},
+			"influx/cpu/core=0/host=a",
+		},
+		{
+			`if\ load,name=eth\,0/1 in=10u,descr="a, b=c",out=-2e3`,
+			influxPoint{
+				measurement: "if load",
+				tags:        []influxTag{{"name", "eth,0/1"}},
+				fields:      map[string]float64{"in": 10, "out": -2000},
+				time:        42,
+			},
+			"influx/if+load/name=eth%2C0%2F1",
+		},
+	} {
+		point, err := parseInfluxLine(test.line, 1000, 42)
+		if err != nil {
+			t.Errorf("Could not parse %s: %v", test.line, err)
+			continue
+		}
+		if !reflect.DeepEqual(test.point, point) {
+			t.Errorf("Line %s should be %+v but got %+v", test.line, test.point, point)
+		}
+		if uri := point.uri("influx"); uri != test.uri {
+			t.Errorf("Line %s should have URI %s but got %s", test.line, test.uri, uri)
+		}
+	}
+
+	for _, line := range []string{
+		"cpu",
+		",host=a usage=1",
+		"cpu,host usage=1",
+		"cpu usage=",
+		"cpu usage=abc",
+		`cpu descr="unterminated`,
+		"cpu usage=1 later",
+	} {
+		if _, err := parseInfluxLine(line, 1, 0); err == nil {
+			t.Errorf("Line %s should not parse", line)
+		}
+	}
+}
+
+func testIngester(t *testing.T) *influxIngester {
+	acl, err := dots.NewACL(dots.ACLConfig{Grants: []dots.ACLGrant{
+		{VK: "gateway", URI: "influx/cpu/*", From: "10", To: "20"},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &influxIngester{
+		archiver: testArchiver(),
+		prefix:   "influx",
+		acl:      acl,
+		streams:  make(map[string]*Stream),
+		tagged:   make(map[string]bool),
+	}
+}
+
+func TestInfluxAuthorize(t *testing.T) {
+	ing := testIngester(t)
+	point := func(measurement string, time int64) influxPoint {
+		return influxPoint{measurement: measurement, tags: []influxTag{{"host", "a"}}, time: time}
+	}
+	for _, test := range []struct {
+		vk     string
+		points []influxPoint
+		ok     bool
+	}{
+		{"gateway", []influxPoint{point("cpu", 10), point("cpu", 20)}, true},
+		{"gateway", []influxPoint{point("cpu", 15), point("cpu", 25)}, false},
+		{"gateway", []influxPoint{point("mem", 15)}, false},
+		{"other", []influxPoint{point("cpu", 15)}, false},
+	} {
+		if err := ing.authorize(test.vk, test.points); (err == nil) != test.ok {
+			t.Errorf("%s writing %+v: expected ok=%v, got %v", test.vk, test.points, test.ok, err)
+		}
+	}
+}
+
+func TestInfluxStreamCollision(t *testing.T) {
+	ing := testIngester(t)
+	// "cpu" + "ux" runs together the same as "cp" + "uux"
+	point := &influxPoint{measurement: "cp", fields: map[string]float64{"uux": 1}}
+	taken := common.ParseUUID(uuid.NewV3(NAMESPACE_UUID, "influx/cp"+"uux").String())
+	md := ing.archiver.MD.(*fakeMetadata)
+	md.docs = append(md.docs, common.MetadataGroup{UUID: taken, URI: "influx/cpu"})
+
+	_, err := ing.initialize("uux", "influx/cp", point)
+	if err == nil {
+		t.Fatal("Expected a series with another stream's UUID to be refused")
+	}
+	if status := queryErrorStatus(err); status != http.StatusBadRequest {
+		t.Errorf("Expected the write to be rejected with 400, got %d", status)
+	}
+	if stream := ing.seen("uux", "influx/cp"); stream != nil {
+		t.Error("Expected the series not to be initialized")
+	}
+}
+
+// returns each of reads in turn, then reports that it has been closed
+type fakePacketConn struct {
+	net.PacketConn
+	reads []error
+	calls int
+}
+
+func (c *fakePacketConn) ReadFrom(buf []byte) (int, net.Addr, error) {
+	c.calls++
+	if len(c.reads) == 0 {
+		return 0, nil, &net.OpError{Op: "read", Net: "udp", Err: net.ErrClosed}
+	}
+	err := c.reads[0]
+	c.reads = c.reads[1:]
+	if err != nil {
+		return 0, nil, err
+	}
+	n := copy(buf, "cpu,host=a usage=1 15")
+	return n, &net.UDPAddr{}, nil
+}
+
+func TestInfluxServeUDP(t *testing.T) {
+	ing := testIngester(t)
+	ing.archiver.TS = &insertTimeseries{fakeTimeseries: &fakeTimeseries{}}
+	// a failed read doesn't stop the listener; an unauthorized datagram is dropped
+	conn := &fakePacketConn{reads: []error{errors.New("connection refused"), nil}}
+	done := make(chan struct{})
+	go func() {
+		ing.serveUDP(conn, "other")
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the listener to stop once the connection was closed")
+	}
+	if conn.calls != 3 {
+		t.Errorf("Expected 3 reads, got %d", conn.calls)
+	}
+}
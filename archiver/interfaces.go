@@ -1,16 +1,29 @@
 package archiver
 
 import (
+	"context"
+	"time"
+
 	"github.com/gtfierro/pundat/common"
+	"github.com/gtfierro/pundat/dots"
 
 	"gopkg.in/mgo.v2/bson"
 )
 
 type MetadataStore interface {
 	GetUnitOfTime(VK string, uuid common.UUID) (common.UnitOfTime, error)
-	GetMetadata(VK string, tags []string, where common.Dict) ([]common.MetadataGroup, error)
-	GetDistinct(VK string, tag string, where common.Dict) ([]string, error)
-	GetUUIDs(VK string, where common.Dict) ([]common.UUID, error)
+	// returns the documents matching the where clause, sorted and paged as
+	// described by page (page.Cursor is ignored; use page.Offset)
+	GetMetadata(VK string, tags []string, where *common.Predicate, page common.Pagination) ([]common.MetadataGroup, error)
+	GetDistinct(VK string, tag string, where *common.Predicate, page common.Pagination) ([]string, error)
+	// returns every stream matching the where clause. Callers must drop the streams the
+	// VK may not read
+	GetUUIDs(where *common.Predicate) ([]common.UUID, error)
+	// returns the documents whose metadata, as it was at the given time, matches the where clause
+	GetDocumentsAsOf(where *common.Predicate, at time.Time) ([]bson.M, error)
+	// returns, for each stream (by UUID), the intervals of [start, end] during which
+	// its metadata matched the where clause
+	GetMatchingIntervals(where *common.Predicate, start, end time.Time) (map[string][]common.Interval, error)
 
 	URIFromUUID(uuid common.UUID) (string, error)
 	UUIDFromURI(uri string) (common.UUID, error)
@@ -18,6 +31,16 @@ type MetadataStore interface {
 	InitializeURI(uri, rewrittenuri, name, unit string, uuid common.UUID) error
 }
 
+// Decides what each VK may see. Every query path checks its results against this.
+// Implemented by dots.DotMaster, which builds DOT chains using the BOSSWAVE registry,
+// and dots.ACL, which reads the permissions from a file
+type Authorizer interface {
+	// returns nil if the VK may read the URI's metadata
+	CanRead(uri, vk string) error
+	// returns the ranges of time in which the VK may read the URI's data
+	GetValidRanges(uri, vk string) (*dots.DisjointRanges, error)
+}
+
 // Interface for timeseries database.
 type TimeseriesStore interface {
 	// returns true if the stream exists
@@ -27,7 +50,7 @@ type TimeseriesStore interface {
 	RegisterStream(uuid common.UUID, uri, name, unit string) error
 
 	// writes a set of readings for a particular stream
-	AddReadings(common.Timeseries) error
+	AddReadings(*common.Timeseries) error
 
 	// list of UUIDs, reference time in nanoseconds
 	// Retrieves data before the reference time for the given streams.
@@ -60,6 +83,9 @@ type TimeseriesStore interface {
 
 	AddAnnotations(uuid common.UUID, annotations map[string]interface{}) error
 
+	// returns a view of the store whose calls are cancelled when ctx is done
+	WithContext(ctx context.Context) TimeseriesStore
+
 	// disconnects from database
 	Disconnect() error
 }
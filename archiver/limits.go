@@ -0,0 +1,264 @@
+package archiver
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gtfierro/pundat/common"
+	"github.com/pkg/errors"
+)
+
+// the limits on a single VK's queries. 0 means no limit
+type queryLimits struct {
+	maxConcurrent int
+	maxStreams    int
+	maxPoints     int64
+	maxTime       time.Duration
+}
+
+// overrides the limits with the non-zero fields of the config
+func (limits queryLimits) update(config *LimitConfig) (queryLimits, error) {
+	if config.MaxConcurrentQueries > 0 {
+		limits.maxConcurrent = config.MaxConcurrentQueries
+	}
+	if config.MaxStreams > 0 {
+		limits.maxStreams = config.MaxStreams
+	}
+	if config.MaxPoints > 0 {
+		limits.maxPoints = int64(config.MaxPoints)
+	}
+	if config.MaxQueryTime != "" {
+		maxTime, err := time.ParseDuration(config.MaxQueryTime)
+		if err != nil {
+			return limits, errors.Wrapf(err, "Could not parse MaxQueryTime %s", config.MaxQueryTime)
+		}
+		limits.maxTime = maxTime
+	}
+	return limits, nil
+}
+
+// Enforces the configured limits on each VK's queries
+type queryLimiter struct {
+	defaults queryLimits
+	perVK    map[string]queryLimits
+	// the number of queries each VK has running
+	running map[string]int
+	sync.Mutex
+}
+
+// The config with the empty name applies to every VK; the others override it for the VK they are named after
+func newQueryLimiter(configs map[string]*LimitConfig) (*queryLimiter, error) {
+	ql := &queryLimiter{
+		perVK:   make(map[string]queryLimits),
+		running: make(map[string]int),
+	}
+	var err error
+	if config, found := configs[""]; found {
+		if ql.defaults, err = ql.defaults.update(config); err != nil {
+			return nil, err
+		}
+	}
+	for vk, config := range configs {
+		if vk == "" {
+			continue
+		}
+		if ql.perVK[vk], err = ql.defaults.update(config); err != nil {
+			return nil, errors.Wrapf(err, "Invalid limits for VK %s", vk)
+		}
+	}
+	return ql, nil
+}
+
+func (ql *queryLimiter) limitsFor(vk string) queryLimits {
+	if limits, found := ql.perVK[vk]; found {
+		return limits
+	}
+	return ql.defaults
+}
+
+// Reserves one of the VK's concurrent queries, returning the limits that apply to the
+// query. done must be called once the query is finished
+func (ql *queryLimiter) start(vk string) (limits queryLimits, done func(), err error) {
+	limits = ql.limitsFor(vk)
+	ql.Lock()
+	defer ql.Unlock()
+	if limits.maxConcurrent > 0 && ql.running[vk] >= limits.maxConcurrent {
+		err = busy(errors.Errorf("Too many concurrent queries: VK %s already has %d running (limit %d)", vk, ql.running[vk], limits.maxConcurrent))
+		return
+	}
+	ql.running[vk]++
+	done = func() {
+		ql.Lock()
+		if ql.running[vk]--; ql.running[vk] <= 0 {
+			delete(ql.running, vk)
+		}
+		ql.Unlock()
+	}
+	return
+}
+
+// A single query being evaluated. Its timeseries store enforces the limits on the query;
+// everything else is the archiver's (see limitedTo)
+type queryContext struct {
+	*Archiver
+	TS     TimeseriesStore
+	limits queryLimits
+	// the streams the query's data parameters resolved to, for the audit log
+	resolved []common.UUID
+}
+
+// Returns the query context for evaluating a single query within the limits: calls to the
+// timeseries store are cancelled when ctx is done and fail once the query has read more
+// than limits.maxPoints readings
+func (a *Archiver) limitedTo(ctx context.Context, limits queryLimits) *queryContext {
+	budget := &readBudget{max: limits.maxPoints}
+	return &queryContext{
+		Archiver: a,
+		TS: &limitedStore{
+			TimeseriesStore: a.TS.WithContext(withReadBudget(ctx, budget)),
+			ctx:             ctx,
+			budget:          budget,
+		},
+		limits: limits,
+	}
+}
+
+// checks the number of streams a data query will read against the limits
+func (q *queryContext) checkStreamLimit(params *common.DataParams) error {
+	if q.limits.maxStreams > 0 && len(params.UUIDs) > q.limits.maxStreams {
+		return badQuery(errors.Errorf("Query matches %d streams, more than the limit of %d for this VK. Narrow the WHERE clause or add a LIMIT", len(params.UUIDs), q.limits.maxStreams))
+	}
+	return nil
+}
+
+// The number of readings a query has read and may read. It is carried in the context given
+// to the timeseries store so that stores which stream readings can stop as soon as a query
+// goes over its limit, rather than after reading everything (see exceededBy)
+type readBudget struct {
+	max  int64
+	read int64
+}
+
+// adds n readings to the total, returning an error if that exceeds the limit
+func (b *readBudget) add(n int) error {
+	if total := atomic.AddInt64(&b.read, int64(n)); b.max > 0 && total > b.max {
+		return b.exceeded()
+	}
+	return nil
+}
+
+// returns an error if reading n more readings would exceed the limit, without counting them.
+// Safe to call on a nil budget (i.e. an unlimited query)
+func (b *readBudget) exceededBy(n int) error {
+	if b != nil && b.max > 0 && atomic.LoadInt64(&b.read)+int64(n) > b.max {
+		return b.exceeded()
+	}
+	return nil
+}
+
+func (b *readBudget) exceeded() error {
+	return badQuery(errors.Errorf("Query read more than %d readings, the limit for this VK. Narrow the time range or use a statistical query", b.max))
+}
+
+type readBudgetKey struct{}
+
+func withReadBudget(ctx context.Context, budget *readBudget) context.Context {
+	return context.WithValue(ctx, readBudgetKey{}, budget)
+}
+
+// the budget of the query ctx belongs to, or nil if it has none
+func readBudgetFrom(ctx context.Context) *readBudget {
+	budget, _ := ctx.Value(readBudgetKey{}).(*readBudget)
+	return budget
+}
+
+// Wraps the timeseries store for a single query, counting the readings it reads
+type limitedStore struct {
+	TimeseriesStore
+	ctx    context.Context
+	budget *readBudget
+}
+
+// adds n readings to the query's total, returning an error if that exceeds the limit
+// or the query has been cancelled
+func (s *limitedStore) read(n int) error {
+	if err := s.ctx.Err(); err != nil {
+		return err
+	}
+	return s.budget.add(n)
+}
+
+func (s *limitedStore) Prev(uuids []common.UUID, ref int64) ([]common.Timeseries, error) {
+	res, err := s.TimeseriesStore.Prev(uuids, ref)
+	for i := 0; err == nil && i < len(res); i++ {
+		err = s.read(len(res[i].Records))
+	}
+	return res, err
+}
+
+func (s *limitedStore) Next(uuids []common.UUID, ref int64) ([]common.Timeseries, error) {
+	res, err := s.TimeseriesStore.Next(uuids, ref)
+	for i := 0; err == nil && i < len(res); i++ {
+		err = s.read(len(res[i].Records))
+	}
+	return res, err
+}
+
+func (s *limitedStore) GetData(uuids []common.UUID, start int64, end int64) ([]common.Timeseries, error) {
+	res, err := s.TimeseriesStore.GetData(uuids, start, end)
+	for i := 0; err == nil && i < len(res); i++ {
+		err = s.read(len(res[i].Records))
+	}
+	return res, err
+}
+
+func (s *limitedStore) GetDataUUID(uuid common.UUID, start int64, end int64, convert common.UnitOfTime) (res common.Timeseries, err error) {
+	res, err = s.TimeseriesStore.GetDataUUID(uuid, start, end, convert)
+	if err == nil {
+		err = s.read(len(res.Records))
+	}
+	return
+}
+
+func (s *limitedStore) StatisticalData(uuids []common.UUID, pointWidth int, start, end int64) ([]common.StatisticTimeseries, error) {
+	res, err := s.TimeseriesStore.StatisticalData(uuids, pointWidth, start, end)
+	for i := 0; err == nil && i < len(res); i++ {
+		err = s.read(len(res[i].Records))
+	}
+	return res, err
+}
+
+func (s *limitedStore) StatisticalDataUUID(uuid common.UUID, pointWidth int, start, end int64, convert common.UnitOfTime) (res common.StatisticTimeseries, err error) {
+	res, err = s.TimeseriesStore.StatisticalDataUUID(uuid, pointWidth, start, end, convert)
+	if err == nil {
+		err = s.read(len(res.Records))
+	}
+	return
+}
+
+func (s *limitedStore) WindowData(uuids []common.UUID, width uint64, start, end int64) ([]common.StatisticTimeseries, error) {
+	res, err := s.TimeseriesStore.WindowData(uuids, width, start, end)
+	for i := 0; err == nil && i < len(res); i++ {
+		err = s.read(len(res[i].Records))
+	}
+	return res, err
+}
+
+func (s *limitedStore) WindowDataUUID(uuid common.UUID, width uint64, start, end int64, convert common.UnitOfTime) (res common.StatisticTimeseries, err error) {
+	res, err = s.TimeseriesStore.WindowDataUUID(uuid, width, start, end, convert)
+	if err == nil {
+		err = s.read(len(res.Records))
+	}
+	return
+}
+
+// each changed range counts as one reading
+func (s *limitedStore) ChangedRanges(uuids []common.UUID, from_gen, to_gen uint64, resolution uint8) ([]common.ChangedRange, error) {
+	res, err := s.TimeseriesStore.ChangedRanges(uuids, from_gen, to_gen, resolution)
+	for i := 0; err == nil && i < len(res); i++ {
+		err = s.read(len(res[i].Ranges))
+	}
+	return res, err
+}
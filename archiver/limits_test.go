@@ -0,0 +1,114 @@
+package archiver
+
+import (
+	"context"
+	"testing"
+
+	"github.com/gtfierro/pundat/common"
+)
+
+func TestQueryLimiterConcurrency(t *testing.T) {
+	ql, err := newQueryLimiter(map[string]*LimitConfig{
+		"":   {MaxConcurrentQueries: 1},
+		"vk": {MaxConcurrentQueries: 2, MaxPoints: 10},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, done, err := ql.start("other")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err = ql.start("other"); err == nil {
+		t.Error("Expected a second concurrent query to go over the default limit")
+	}
+	done()
+	if _, done, err = ql.start("other"); err != nil {
+		t.Errorf("Expected a query to be allowed once the first finished, got %v", err)
+	} else {
+		done()
+	}
+
+	limits, _, err := ql.start("vk")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if limits.maxConcurrent != 2 || limits.maxPoints != 10 {
+		t.Errorf("Expected the VK's own limits, got %+v", limits)
+	}
+	if _, _, err = ql.start("vk"); err != nil {
+		t.Errorf("Expected the VK's own concurrency limit to apply, got %v", err)
+	}
+}
+
+func TestQueryContextLimits(t *testing.T) {
+	a := testArchiver()
+	a.cache = newResultCache()
+	params := func() *common.DataParams {
+		return &common.DataParams{UUIDs: []common.UUID{uuidA, uuidB}, Begin: 0, End: 100}
+	}
+
+	// admin reads 11 readings from each stream
+	q := a.limitedTo(context.Background(), queryLimits{maxPoints: 15})
+	if _, _, err := q.SelectDataRange("admin", params()); err == nil {
+		t.Error("Expected reading 22 readings to go over a limit of 15")
+	}
+	q = a.limitedTo(context.Background(), queryLimits{maxPoints: 22})
+	if _, _, err := q.SelectDataRange("admin", params()); err != nil {
+		t.Errorf("Expected reading 22 readings to be within a limit of 22, got %v", err)
+	}
+	// the archiver itself is not limited
+	if _, found := a.TS.(*limitedStore); found {
+		t.Error("Expected limiting a query to leave the archiver's store alone")
+	}
+
+	q = a.limitedTo(context.Background(), queryLimits{maxStreams: 1})
+	if _, _, err := q.SelectDataRange("admin", params()); err == nil {
+		t.Error("Expected a query of 2 streams to go over a limit of 1")
+	}
+
+	// each changed range counts towards the limit
+	q = a.limitedTo(context.Background(), queryLimits{maxPoints: 1})
+	if _, err := q.GetChangedRanges("admin", params()); err == nil {
+		t.Error("Expected 2 changed ranges to go over a limit of 1")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	q = a.limitedTo(ctx, queryLimits{})
+	if _, _, err := q.SelectDataRange("admin", params()); err != context.Canceled {
+		t.Errorf("Expected a cancelled query to fail, got %v", err)
+	}
+}
+
+func TestReadBudget(t *testing.T) {
+	var unlimited *readBudget
+	if err := unlimited.exceededBy(1e9); err != nil {
+		t.Errorf("Expected a query without a budget to be unlimited, got %v", err)
+	}
+	if budget := readBudgetFrom(context.Background()); budget != nil {
+		t.Errorf("Expected no budget in a plain context, got %+v", budget)
+	}
+
+	budget := &readBudget{max: 10}
+	ctx := withReadBudget(context.Background(), budget)
+	if readBudgetFrom(ctx) != budget {
+		t.Error("Expected the budget to be carried in the context")
+	}
+	if err := budget.add(6); err != nil {
+		t.Fatal(err)
+	}
+	// stores check as they stream without counting; the total is only added once
+	if err := budget.exceededBy(4); err != nil {
+		t.Errorf("Expected 4 more readings to be within the limit, got %v", err)
+	}
+	if err := budget.exceededBy(5); err == nil {
+		t.Error("Expected 5 more readings to go over the limit")
+	}
+	if err := budget.add(4); err != nil {
+		t.Errorf("Expected exceededBy not to count readings, got %v", err)
+	}
+	if err := budget.add(1); err == nil {
+		t.Error("Expected the 11th reading to go over the limit")
+	}
+}
@@ -0,0 +1,182 @@
+package archiver
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/gtfierro/pundat/common"
+	"github.com/pkg/errors"
+)
+
+const (
+	// how often live connections are pinged, and how long the client has to answer
+	livePingPeriod = 30 * time.Second
+	livePongWait   = 60 * time.Second
+	// the longest a single write to a live connection may take
+	liveWriteWait = 10 * time.Second
+	// the number of messages queued for a live connection. A client that falls further
+	// behind than this is dropped
+	liveQueueSize = 256
+	// clients don't send anything but control frames, so anything larger is a mistake
+	liveReadLimit = 4096
+	// the lease on a live connection's subscription, which is renewed with every ping
+	liveLease = 5 * livePingPeriod
+)
+
+var liveUpgrader = websocket.Upgrader{
+	CheckOrigin: func(r *http.Request) bool {
+		// a page on another origin could make the browser present its client certificate,
+		// but can't know a token, so only token-authenticated connections may be cross-origin
+		if r.Header.Get("Authorization") != "" || r.URL.Query().Get("access_token") != "" {
+			return true
+		}
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			return true
+		}
+		u, err := url.Parse(origin)
+		return err == nil && strings.EqualFold(u.Host, r.Host)
+	},
+}
+
+// GET /live?where=<where clause> upgrades to a WebSocket that receives the readings of the
+// matching streams as they arrive. The first message is {"Subscription": ...} and each one
+// after that is {"Timeseries": ...}. Browsers, which can't set headers on WebSockets, can
+// pass their token as ?access_token=<token> instead of an Authorization header
+func (srv *httpServer) handleLive(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		srv.writeError(w, http.StatusMethodNotAllowed, "", errors.New("Use GET"))
+		return
+	}
+	authorization := r.Header.Get("Authorization")
+	if token := r.URL.Query().Get("access_token"); token != "" && authorization == "" {
+		authorization = "Bearer " + token
+	}
+	id, err := srv.creds.identify(authorization, r.TLS)
+	if err != nil {
+		srv.writeError(w, http.StatusUnauthorized, "", err)
+		return
+	}
+	// subscriptions are limited and audited like the SUBSCRIBE query they're equivalent to
+	query := "subscribe data where " + r.URL.Query().Get("where") + ";"
+	parsed := srv.archiver.qp.Parse(query)
+	if parsed.Err != nil {
+		srv.writeError(w, http.StatusBadRequest, query, errors.Errorf("Error (%v) in query \"%v\" (error at %v)", parsed.Err, query, parsed.ErrPos))
+		return
+	}
+	conn, err := liveUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// Upgrade has already replied with the error
+		log.Warning(errors.Wrap(err, "Could not upgrade live connection"))
+		return
+	}
+	srv.archiver.serveLive(conn, id.vk, query, parsed.Where)
+}
+
+// Streams the readings for a live subscription to the connection until the client goes away
+// or can't keep up. Readings are queued by the subscription manager's dispatcher and written
+// here, so a slow client never holds up ingestion or other subscribers
+func (a *Archiver) serveLive(conn *websocket.Conn, vk, query string, predicate *common.Predicate) {
+	defer conn.Close()
+	write := func(msg interface{}) error {
+		conn.SetWriteDeadline(time.Now().Add(liveWriteWait))
+		return conn.WriteJSON(msg)
+	}
+
+	res, err := a.runLimited(vk, query, "subscribe", func(limited *queryContext) (result QueryResult, err error) {
+		var sub QuerySubscriptionResult
+		sub, err = limited.subs.subscribe(vk, &common.SubscribeParams{Where: predicate, Lease: liveLease})
+		result.Subscription = &sub
+		return
+	})
+	if err != nil {
+		write(QueryError{Query: query, Error: err.Error()})
+		return
+	}
+	subID := res.Subscription.ID
+	defer a.subs.unsubscribe(vk, &common.UnsubscribeParams{ID: subID})
+
+	queue := newLiveQueue(vk, liveQueueSize)
+	a.subs.attach(subID, true, queue.deliver)
+
+	// read (and discard) until the client goes away, so that pongs are handled
+	gone := make(chan struct{})
+	go func() {
+		defer close(gone)
+		conn.SetReadLimit(liveReadLimit)
+		conn.SetReadDeadline(time.Now().Add(livePongWait))
+		conn.SetPongHandler(func(string) error {
+			return conn.SetReadDeadline(time.Now().Add(livePongWait))
+		})
+		for {
+			if _, _, err := conn.NextReader(); err != nil {
+				return
+			}
+		}
+	}()
+
+	if err := write(HTTPQueryResult{Subscription: res.Subscription}); err != nil {
+		return
+	}
+	ping := time.NewTicker(livePingPeriod)
+	defer ping.Stop()
+	for {
+		select {
+		case readings := <-queue.readings:
+			if err := write(HTTPQueryResult{Timeseries: &readings}); err != nil {
+				log.Info(errors.Wrapf(err, "Closing live connection for %s", vk))
+				return
+			}
+		case <-ping.C:
+			if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(liveWriteWait)); err != nil {
+				log.Info(errors.Wrapf(err, "Closing live connection for %s", vk))
+				return
+			}
+			// the subscription lasts as long as the connection does
+			if _, err := a.subs.subscribe(vk, &common.SubscribeParams{ID: subID, Lease: liveLease}); err != nil {
+				log.Error(errors.Wrapf(err, "Could not renew live subscription %s", subID))
+				return
+			}
+		case <-queue.tooSlow:
+			conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseTryAgainLater, "too slow"), time.Now().Add(liveWriteWait))
+			return
+		case <-gone:
+			return
+		}
+	}
+}
+
+// The readings waiting to be written to a live connection
+type liveQueue struct {
+	vk       string
+	readings chan QueryTimeseriesResult
+	// closed once the queue overflows
+	tooSlow chan struct{}
+	dropped sync.Once
+}
+
+func newLiveQueue(vk string, size int) *liveQueue {
+	return &liveQueue{
+		vk:       vk,
+		readings: make(chan QueryTimeseriesResult, size),
+		tooSlow:  make(chan struct{}),
+	}
+}
+
+// Queues the readings without blocking. If the queue is full, they are dropped, and so is the
+// connection: the first time this happens, tooSlow is closed and an error returned
+func (q *liveQueue) deliver(readings QueryTimeseriesResult) (err error) {
+	select {
+	case q.readings <- readings:
+	default:
+		q.dropped.Do(func() {
+			close(q.tooSlow)
+			err = errors.Errorf("Live connection for %s can't keep up; dropping it", q.vk)
+		})
+	}
+	return
+}
@@ -0,0 +1,107 @@
+package archiver
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/gtfierro/pundat/common"
+)
+
+func TestLiveRejectsRequests(t *testing.T) {
+	srv := testHTTPServer(t)
+	for _, test := range []struct {
+		method string
+		token  string
+		where  string
+		status int
+	}{
+		{"POST", "secret", "has Room", http.StatusMethodNotAllowed},
+		{"GET", "", "has Room", http.StatusUnauthorized},
+		{"GET", "wrong", "has Room", http.StatusUnauthorized},
+		{"GET", "secret", "", http.StatusBadRequest},
+		{"GET", "secret", "Room =", http.StatusBadRequest},
+	} {
+		var qerr QueryError
+		if status := serve(t, srv, test.method, "/live?where="+url.QueryEscape(test.where), test.token, "", &qerr); status != test.status {
+			t.Errorf("%s with token %q and where %q: expected %d, got %d (%s)", test.method, test.token, test.where, test.status, status, qerr.Error)
+		}
+	}
+}
+
+func TestLive(t *testing.T) {
+	srv := testHTTPServer(t)
+	a := srv.archiver
+	a.subs = newSubscriptionManager(a.MD, a.authority, nil)
+	server := httptest.NewServer(srv.handler())
+	defer server.Close()
+	live := "ws" + strings.TrimPrefix(server.URL, "http") + "/live?where=" + url.QueryEscape("has Room")
+
+	if _, resp, err := websocket.DefaultDialer.Dial(live+"&access_token=wrong", nil); err == nil || resp == nil || resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("Expected a wrong token to be refused before upgrading, got %v", err)
+	}
+
+	conn, _, err := websocket.DefaultDialer.Dial(live+"&access_token=secret", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var first HTTPQueryResult
+	if err := conn.ReadJSON(&first); err != nil {
+		t.Fatal(err)
+	}
+	// vk can only read ns/public/*
+	if first.Subscription == nil || first.Subscription.Streams != 1 {
+		t.Fatalf("Expected the first message to be a subscription to one stream, got %+v", first)
+	}
+
+	// the VK may only read [10, 20]
+	a.subs.publishLive(&common.Timeseries{UUID: uuidA, Records: []*common.TimeseriesReading{
+		{Time: time.Unix(0, 15), Unit: common.UOT_NS, Value: 1.5},
+		{Time: time.Unix(0, 30), Unit: common.UOT_NS, Value: 3},
+	}})
+	var next HTTPQueryResult
+	if err := conn.ReadJSON(&next); err != nil {
+		t.Fatal(err)
+	}
+	if next.Timeseries == nil || len(next.Timeseries.Data) != 1 {
+		t.Fatalf("Expected readings for one stream, got %+v", next)
+	}
+	if data := next.Timeseries.Data[0]; len(data.Times) != 1 || data.Times[0] != 15 || data.Values[0] != 1.5 {
+		t.Errorf("Expected only the reading at 15, got %+v", data)
+	}
+}
+
+func TestLiveQueueDropsSlowClients(t *testing.T) {
+	queue := newLiveQueue("vk", 2)
+	for i := 0; i < 2; i++ {
+		if err := queue.deliver(QueryTimeseriesResult{}); err != nil {
+			t.Fatalf("Expected reading %d to be queued, got %v", i, err)
+		}
+	}
+	select {
+	case <-queue.tooSlow:
+		t.Fatal("Expected the connection to be kept while the queue has room")
+	default:
+	}
+	if err := queue.deliver(QueryTimeseriesResult{}); err == nil {
+		t.Error("Expected an error once the queue is full")
+	}
+	select {
+	case <-queue.tooSlow:
+	default:
+		t.Fatal("Expected the connection to be dropped once the queue is full")
+	}
+	// the connection is only dropped once
+	if err := queue.deliver(QueryTimeseriesResult{}); err != nil {
+		t.Errorf("Expected no further errors, got %v", err)
+	}
+	if len(queue.readings) != 2 {
+		t.Errorf("Expected the queued readings to be kept, got %d", len(queue.readings))
+	}
+}
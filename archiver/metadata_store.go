@@ -2,6 +2,9 @@ package archiver
 
 import (
 	"net"
+	"regexp"
+	"sort"
+	"strings"
 	"time"
 
 	"github.com/coocood/freecache"
@@ -46,6 +49,7 @@ func newMongoStore(c *mongoConfig) *mongo_store {
 	m.uuidtouri = m.db.C("uuidtouri")
 	// add indexes. This will fail Fatal
 	m.addIndexes()
+	go m.backfillText()
 
 	go func() {
 		for _ = range time.Tick(30 * time.Second) {
@@ -66,6 +70,7 @@ func newMongoStore(c *mongoConfig) *mongo_store {
 				delete(doc, "name")
 				delete(doc, "unit")
 				delete(doc, "uri")
+				doc[textField] = textOf(doc)
 				updates = append(updates, bson.M{"originaluri": doc["originaluri"]}, bson.M{"$set": doc})
 			}
 			batch := m.documents.Bulk()
@@ -85,11 +90,10 @@ func (m *mongo_store) GetUnitOfTime(VK string, uuid common.UUID) (common.UnitOfT
 	return common.UOT_NS, nil
 }
 
-func (m *mongo_store) GetMetadata(VK string, tags []string, where common.Dict) ([]common.MetadataGroup, error) {
+func (m *mongo_store) GetMetadata(VK string, tags []string, where *common.Predicate, page common.Pagination) ([]common.MetadataGroup, error) {
 	var (
-		_results    []bson.M
-		whereClause bson.M
-		results     []common.MetadataGroup
+		_results []bson.M
+		results  []common.MetadataGroup
 	)
 
 	// if we have tags, then we make sure to include path/uuid. Otherwise, we need to keep
@@ -102,17 +106,48 @@ func (m *mongo_store) GetMetadata(VK string, tags []string, where common.Dict) (
 		selectTags["uri"] = 1
 		selectTags["originaluri"] = 1
 		selectTags["uuid"] = 1
+	} else {
+		selectTags[textField] = 0
 	}
 
-	if len(where) != 0 {
-		whereClause = where.ToBSON()
+	whereClause, err := mongoWhereClause(where)
+	if err != nil {
+		return nil, err
+	}
+	_, isTextSearch := whereClause["$text"]
+	if isTextSearch {
+		selectTags[scoreField] = bson.M{"$meta": "textScore"}
+	}
+
+	staged := m.documents.Find(whereClause).Select(selectTags)
+	// always break ties on uuid so that pages are stable between queries
+	if page.OrderBy == "" && isTextSearch {
+		// the documents most relevant to the MATCHES search come first
+		staged = staged.Sort("$textScore:"+scoreField, "uuid")
+	} else if !page.IsEmpty() {
+		if page.OrderBy == "" {
+			staged = staged.Sort("uuid")
+		} else if page.Descending {
+			staged = staged.Sort("-"+page.OrderBy, "uuid")
+		} else {
+			staged = staged.Sort(page.OrderBy, "uuid")
+		}
+	}
+	if !page.IsEmpty() {
+		if page.Offset > 0 {
+			staged = staged.Skip(page.Offset)
+		}
+		if page.Limit > 0 {
+			staged = staged.Limit(page.Limit)
+		}
 	}
 
-	if err := m.documents.Find(whereClause).Select(selectTags).All(&_results); err != nil {
+	if err := staged.All(&_results); err != nil {
 		return nil, errors.Wrap(err, "Could not select tags")
 	}
 
 	for _, doc := range _results {
+		delete(doc, scoreField)
 		group := common.GroupFromBson(doc)
 		if !group.IsEmpty() {
 			results = append(results, *group)
@@ -122,14 +157,14 @@ func (m *mongo_store) GetMetadata(VK string, tags []string, where common.Dict) (
 	return results, nil
 }
 
-func (m *mongo_store) GetDistinct(VK string, tag string, where common.Dict) ([]string, error) {
+func (m *mongo_store) GetDistinct(VK string, tag string, where *common.Predicate, page common.Pagination) ([]string, error) {
 	var (
-		whereClause bson.M
-		distincts   []string
-		v           []interface{}
+		distincts []string
+		v         []interface{}
 	)
-	if len(where) != 0 {
-		whereClause = where.ToBSON()
+	whereClause, err := mongoWhereClause(where)
+	if err != nil {
+		return nil, err
 	}
 	if err := m.documents.Find(whereClause).Distinct(tag, &v); err != nil {
 		return nil, errors.Wrap(err, "Could not get the thing")
@@ -139,16 +174,32 @@ func (m *mongo_store) GetDistinct(VK string, tag string, where common.Dict) ([]s
 			distincts = append(distincts, str)
 		}
 	}
+	if page.IsEmpty() {
+		return distincts, nil
+	}
+	// mongo does not sort or page distinct values, so we do it here
+	if page.Descending {
+		sort.Sort(sort.Reverse(sort.StringSlice(distincts)))
+	} else {
+		sort.Strings(distincts)
+	}
+	if page.Offset >= len(distincts) {
+		return []string{}, nil
+	}
+	distincts = distincts[page.Offset:]
+	if page.Limit > 0 && len(distincts) > page.Limit {
+		distincts = distincts[:page.Limit]
+	}
 	return distincts, nil
 }
 
-func (m *mongo_store) GetUUIDs(VK string, where common.Dict) ([]common.UUID, error) {
+func (m *mongo_store) GetUUIDs(where *common.Predicate) ([]common.UUID, error) {
 	var (
-		whereClause bson.M
-		_uuids      []string
+		_uuids []string
 	)
-	if len(where) != 0 {
-		whereClause = where.ToBSON()
+	whereClause, err := mongoWhereClause(where)
+	if err != nil {
+		return nil, err
 	}
 	staged := m.documents.Find(whereClause)
 	if err := staged.Distinct("uuid", &_uuids); err != nil {
@@ -162,9 +213,12 @@ func (m *mongo_store) GetUUIDs(VK string, where common.Dict) ([]common.UUID, err
 }
 
 func (m *mongo_store) URIFromUUID(uuid common.UUID) (string, error) {
-	var uri interface{}
-	err := m.uuidtouri.Find(bson.M{"uuid": uuid.String()}).Select(bson.M{"uri": 1}).One(&uri)
-	return uri.(bson.M)["uri"].(string), err
+	var doc bson.M
+	if err := m.uuidtouri.Find(bson.M{"uuid": uuid.String()}).Select(bson.M{"uri": 1}).One(&doc); err != nil {
+		return "", err
+	}
+	uri, _ := doc["uri"].(string)
+	return uri, nil
 }
 
 func (m *mongo_store) UUIDFromURI(uri string) (common.UUID, error) {
@@ -193,6 +247,7 @@ func (m *mongo_store) InitializeURI(uri, rewrittenURI, name, unit string, uuid c
 	doc["originaluri"] = uri
 	doc["uri"] = rewrittenURI
 	doc["uuid"] = uuid.String()
+	doc[textField] = textOf(doc)
 
 	if _, insertErr := m.documents.Upsert(bson.M{"uuid": doc["uuid"]}, doc); insertErr != nil {
 		return insertErr
@@ -227,6 +282,18 @@ func (m *mongo_store) addIndexes() {
 		log.Fatalf("Could not create index on metadata.{uuid} (%v)", err)
 	}
 
+	// MATCHES searches. The language override names a field that metadata won't
+	// have, so that a "language" tag doesn't change how documents are indexed
+	err = m.documents.EnsureIndex(mgo.Index{
+		Key:              []string{"$text:" + textField},
+		DefaultLanguage:  "none",
+		LanguageOverride: "_language",
+		Background:       true,
+	})
+	if err != nil {
+		log.Fatalf("Could not create text index on metadata.{%s} (%v)", textField, err)
+	}
+
 	index.Key = []string{"uri", "uuid"}
 	index.Unique = true
 	index.DropDups = true
@@ -240,7 +307,7 @@ func (m *mongo_store) GetDocument(uuid common.UUID) bson.M {
 	bytes, err := m.doccache.Get([]byte(uuid))
 	if err == freecache.ErrNotFound {
 		var mydoc bson.M
-		if err := m.documents.Find(bson.M{"uuid": uuid.String()}).Select(bson.M{"_id": 0}).One(&mydoc); err != nil {
+		if err := m.documents.Find(bson.M{"uuid": uuid.String()}).Select(bson.M{"_id": 0, textField: 0}).One(&mydoc); err != nil {
 			log.Error(errors.Wrap(err, "Could not fetch doc from mongo"))
 			return nil
 		}
@@ -264,3 +331,171 @@ func (m *mongo_store) GetDocument(uuid common.UUID) bson.M {
 	}
 	return doc
 }
+
+// the fields of each document that do not come from the PrefixDB
+var streamFields = bson.M{"_id": 0, "uuid": 1, "originaluri": 1, "uri": 1, "name": 1, "unit": 1}
+
+// calls fn with the fields that do not come from the PrefixDB of each stream whose
+// document matches the filter
+func (m *mongo_store) eachStream(filter bson.M, fn func(stream bson.M) error) error {
+	var stream bson.M
+	iter := m.documents.Find(filter).Select(streamFields).Iter()
+	for iter.Next(&stream) {
+		_, hasURI := stream["originaluri"].(string)
+		if _, hasUUID := stream["uuid"].(string); hasURI && hasUUID {
+			if err := fn(stream); err != nil {
+				iter.Close()
+				return err
+			}
+		}
+		stream = nil
+	}
+	return errors.Wrap(iter.Close(), "Could not read metadata documents")
+}
+
+// Returns a filter for the streams whose history could match the where clause. If the
+// where clause requires a metadata key, only the streams that inherit from a URI that has
+// ever had that key can match. Returns false if no stream can match
+func (m *mongo_store) historyCandidates(where *common.Predicate) (bson.M, bool) {
+	key := requiredKey(where)
+	if _, isStreamField := streamFields[key]; key == "" || isStreamField || strings.Contains(key, ".") {
+		return nil, true
+	}
+	var inherit []bson.M
+	for _, uri := range m.pfxdb.URIsWithKey(key) {
+		inherit = append(inherit, bson.M{"originaluri": bson.M{"$regex": "^" + regexp.QuoteMeta(uri) + "(/|$)"}})
+	}
+	if len(inherit) == 0 {
+		return nil, false
+	}
+	return bson.M{"$or": inherit}, true
+}
+
+// rebuilds the stream's document from the history of its metadata
+func documentAt(history *scraper.History, stream bson.M, at time.Time) bson.M {
+	doc := history.LookupAt(stream["originaluri"].(string), at)
+	for key, value := range stream {
+		doc[key] = value
+	}
+	return doc
+}
+
+// The where clause is evaluated in memory against the history of each stream that could
+// match it (see historyCandidates), so unlike GetMetadata this can't use mongo's indexes
+func (m *mongo_store) GetDocumentsAsOf(where *common.Predicate, at time.Time) ([]bson.M, error) {
+	var docs []bson.M
+	filter, possible := m.historyCandidates(where)
+	if !possible {
+		return docs, nil
+	}
+	history := m.pfxdb.History()
+	err := m.eachStream(filter, func(stream bson.M) error {
+		if doc := documentAt(history, stream, at); where.Matches(doc) {
+			docs = append(docs, doc)
+		}
+		return nil
+	})
+	return docs, err
+}
+
+// The where clause is evaluated at start and again at each change to the metadata
+// inherited by each stream, so the intervals line up with the changes
+func (m *mongo_store) GetMatchingIntervals(where *common.Predicate, start, end time.Time) (map[string][]common.Interval, error) {
+	matched := make(map[string][]common.Interval)
+	filter, possible := m.historyCandidates(where)
+	if !possible {
+		return matched, nil
+	}
+	history := m.pfxdb.History()
+	err := m.eachStream(filter, func(stream bson.M) error {
+		uri := stream["originaluri"].(string)
+		times := append([]time.Time{start}, history.ChangesBetween(uri, start, end)...)
+		intervals := matchingIntervals(times, end, func(at time.Time) bool {
+			return where.Matches(documentAt(history, stream, at))
+		})
+		if len(intervals) > 0 {
+			matched[stream["uuid"].(string)] = intervals
+		}
+		return nil
+	})
+	return matched, err
+}
+
+// Given the times at which a stream's metadata changed (starting with the start of the
+// range), returns the intervals up to end during which it matched
+func matchingIntervals(times []time.Time, end time.Time, matches func(at time.Time) bool) []common.Interval {
+	var intervals []common.Interval
+	for i, at := range times {
+		if !matches(at) {
+			continue
+		}
+		until := end.UnixNano()
+		if i+1 < len(times) {
+			until = times[i+1].UnixNano() - 1
+		}
+		if n := len(intervals); n > 0 && intervals[n-1].End+1 == at.UnixNano() {
+			intervals[n-1].End = until
+		} else {
+			intervals = append(intervals, common.Interval{Start: at.UnixNano(), End: until})
+		}
+	}
+	return intervals
+}
+
+// a metadata key that every document matching the where clause has, or "" if there isn't one
+func requiredKey(where *common.Predicate) string {
+	if where == nil {
+		return ""
+	}
+	switch where.Op {
+	case common.OpEq, common.OpLike, common.OpHas, common.OpIn:
+		return where.Key
+	case common.OpAnd:
+		for _, child := range where.Children {
+			if key := requiredKey(child); key != "" {
+				return key
+			}
+		}
+	}
+	return ""
+}
+
+// computes the searchable text of documents stored before MATCHES used the text index
+func (m *mongo_store) backfillText() {
+	var (
+		doc     bson.M
+		updated int
+	)
+	iter := m.documents.Find(bson.M{textField: bson.M{"$exists": false}}).Iter()
+	for iter.Next(&doc) {
+		if err := m.documents.UpdateId(doc["_id"], bson.M{"$set": bson.M{textField: textOf(doc)}}); err != nil {
+			log.Error(errors.Wrapf(err, "Could not index text of %v", doc["uuid"]))
+		} else {
+			updated++
+		}
+		doc = nil
+	}
+	if err := iter.Close(); err != nil {
+		log.Error(errors.Wrap(err, "Could not index text of metadata"))
+	}
+	if updated > 0 {
+		log.Infof("Indexed text of %d metadata documents", updated)
+	}
+}
+
+// Returns the words MATCHES searches for a document: the words in the keys and values of
+// its metadata and in its original URI. The PrefixDB update loop recomputes this
+// whenever the metadata of a document changes
+func textOf(doc bson.M) string {
+	searchable := make(map[string]interface{})
+	for key, value := range doc {
+		switch key {
+		// set by InitializeURI but not by the update loop, so leave them out
+		// to get the same words from both
+		case "_id", "name", "unit", "uri", "uuid", textField:
+			continue
+		}
+		searchable[key] = value
+	}
+	return strings.Join(common.TextTokens(searchable), " ")
+}
@@ -0,0 +1,340 @@
+package archiver
+
+import (
+	"context"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"gopkg.in/mgo.v2/bson"
+
+	"github.com/gtfierro/pundat/common"
+	"github.com/gtfierro/pundat/dots"
+	"github.com/gtfierro/pundat/querylang"
+)
+
+var (
+	ingestedReadings = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "pundat_ingested_readings_total",
+		Help: "Readings received from archived streams",
+	})
+	streamIngestedReadings = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "pundat_stream_ingested_readings_total",
+		Help: "Readings received from each archived stream",
+	}, []string{"uuid"})
+	commitDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "pundat_commit_duration_seconds",
+		Help:    "How long it takes to commit a stream's buffered readings to the timeseries store",
+		Buckets: prometheus.ExponentialBuckets(0.001, 4, 9),
+	})
+	committedReadings = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "pundat_committed_readings_total",
+		Help: "Buffered readings committed to the timeseries store",
+	})
+	addReadingsErrors = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "pundat_add_readings_errors_total",
+		Help: "Writes to the timeseries store that failed",
+	})
+	queryDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "pundat_query_duration_seconds",
+		Help:    "How long queries take to evaluate, by type",
+		Buckets: prometheus.ExponentialBuckets(0.001, 4, 9),
+	}, []string{"type"})
+	queryErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "pundat_query_errors_total",
+		Help: "Queries that failed, by type",
+	}, []string{"type"})
+	mongoCallDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "pundat_mongo_call_duration_seconds",
+		Help:    "How long calls to the metadata store take",
+		Buckets: prometheus.ExponentialBuckets(0.0005, 4, 9),
+	}, []string{"call"})
+	btrdbCallDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "pundat_btrdb_call_duration_seconds",
+		Help:    "How long calls to the timeseries store take",
+		Buckets: prometheus.ExponentialBuckets(0.0005, 4, 9),
+	}, []string{"call"})
+	prefixDBUpdates = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "pundat_prefixdb_updates_total",
+		Help: "Documents marked as updated because metadata they inherit changed",
+	})
+)
+
+func init() {
+	prometheus.MustRegister(ingestedReadings, streamIngestedReadings, commitDuration, committedReadings,
+		addReadingsErrors, queryDuration, queryErrors, mongoCallDuration, btrdbCallDuration, prefixDBUpdates)
+	prometheus.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "pundat_active_streams",
+		Help: "Streams being archived",
+	}, func() float64 { return float64(atomic.LoadInt64(&currentStreams)) }))
+	prometheus.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "pundat_pending_writes",
+		Help: "Writes to the timeseries store that haven't finished",
+	}, func() float64 { return float64(atomic.LoadInt64(&currentWrites)) }))
+	prometheus.MustRegister(prometheus.NewCounterFunc(prometheus.CounterOpts{
+		Name: "pundat_completed_writes_total",
+		Help: "Writes to the timeseries store that have finished",
+	}, func() float64 { return float64(atomic.LoadInt64(&completedWrites)) }))
+}
+
+// Serves /metrics in the background on its own address, so that it can be
+// exposed to a scraper without exposing the profiler or the query APIs
+func startMetrics(c MetricsConfig) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	go func() {
+		log.Fatal(http.ListenAndServe(c.Address, mux))
+	}()
+}
+
+// Exports the permission cache's counters
+func registerDotMasterMetrics(dm *dots.DotMaster) {
+	stat := func(name, help string, get func(dots.CacheStats) int64) prometheus.Collector {
+		return prometheus.NewCounterFunc(prometheus.CounterOpts{Name: name, Help: help}, func() float64 {
+			return float64(get(dm.Stats()))
+		})
+	}
+	prometheus.MustRegister(
+		stat("pundat_permission_cache_hits_total", "Permission lookups answered from the cache",
+			func(s dots.CacheStats) int64 { return s.Hits }),
+		stat("pundat_permission_cache_misses_total", "Permission lookups that had to build DOT chains",
+			func(s dots.CacheStats) int64 { return s.Misses }),
+		stat("pundat_permission_cache_invalidations_total", "Cached permissions dropped because a DOT was revoked or expired",
+			func(s dots.CacheStats) int64 { return s.Invalidations }),
+		stat("pundat_permission_revoked_dots_total", "DOTs found to be revoked or expired",
+			func(s dots.CacheStats) int64 { return s.Revoked }),
+	)
+}
+
+var (
+	muxQueueDepthDesc = prometheus.NewDesc("pundat_mux_queue_depth",
+		"Messages waiting to be handed to the archive requests on a subscription", []string{"uri"}, nil)
+	muxReceiverQueueDepthDesc = prometheus.NewDesc("pundat_mux_receiver_queue_depth",
+		"Messages waiting to be read by the archive requests on a subscription", []string{"uri"}, nil)
+)
+
+// Reports the depth of the queues of each subscription the view manager has made
+type muxCollector struct {
+	vm *viewManager
+}
+
+func (c muxCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- muxQueueDepthDesc
+	ch <- muxReceiverQueueDepthDesc
+}
+
+func (c muxCollector) Collect(ch chan<- prometheus.Metric) {
+	c.vm.subscriptionLock.RLock()
+	defer c.vm.subscriptionLock.RUnlock()
+	for uri, mux := range c.vm.subscriptions {
+		ch <- prometheus.MustNewConstMetric(muxQueueDepthDesc, prometheus.GaugeValue, float64(len(mux.c)), uri)
+		var receivers int
+		mux.RLock()
+		for _, r := range mux.receivers {
+			receivers += len(r)
+		}
+		mux.RUnlock()
+		ch <- prometheus.MustNewConstMetric(muxReceiverQueueDepthDesc, prometheus.GaugeValue, float64(receivers), uri)
+	}
+}
+
+// Records how long the query took and whether it failed under its type (see queryType)
+func observeQuery(kind string, started time.Time, err error) {
+	queryDuration.WithLabelValues(kind).Observe(time.Since(started).Seconds())
+	if err != nil {
+		queryErrors.WithLabelValues(kind).Inc()
+	}
+}
+
+// The kind of query, for labelling metrics
+func queryType(parsed *querylang.ParsedQuery) string {
+	switch {
+	case parsed.Err != nil:
+		return "invalid"
+	case parsed.Explain:
+		return "explain"
+	}
+	switch parsed.QueryType {
+	case querylang.SELECT_TYPE:
+		if parsed.Distinct {
+			return "distinct"
+		}
+		return "metadata"
+	case querylang.DATA_TYPE:
+		if kind := dataQueryType(parsed.GetParams().(*common.DataParams)); kind != "data" {
+			return kind
+		}
+		switch parsed.Data.Dtype {
+		case querylang.BEFORE_TYPE:
+			return "before"
+		case querylang.AFTER_TYPE:
+			return "after"
+		}
+		return "data"
+	case querylang.SUBSCRIBE_TYPE:
+		return "subscribe"
+	case querylang.UNSUBSCRIBE_TYPE:
+		return "unsubscribe"
+	}
+	return "other"
+}
+
+// The kind of data query the parameters are for. Queries for the readings themselves are
+// "data", whether they are in a range or before or after a time
+func dataQueryType(params *common.DataParams) string {
+	switch {
+	case params.IsStatistical:
+		return "statistical"
+	case params.IsWindow:
+		return "window"
+	case params.IsChangedRanges:
+		return "changed"
+	case params.IsDistribution:
+		return "distribution"
+	}
+	return "data"
+}
+
+func observeCall(histogram *prometheus.HistogramVec, call string, started time.Time) {
+	histogram.WithLabelValues(call).Observe(time.Since(started).Seconds())
+}
+
+// Wraps the metadata store to time its calls
+type timedMetadataStore struct {
+	MetadataStore
+}
+
+func (s *timedMetadataStore) GetUnitOfTime(VK string, uuid common.UUID) (common.UnitOfTime, error) {
+	defer observeCall(mongoCallDuration, "GetUnitOfTime", time.Now())
+	return s.MetadataStore.GetUnitOfTime(VK, uuid)
+}
+
+func (s *timedMetadataStore) GetMetadata(VK string, tags []string, where *common.Predicate, page common.Pagination) ([]common.MetadataGroup, error) {
+	defer observeCall(mongoCallDuration, "GetMetadata", time.Now())
+	return s.MetadataStore.GetMetadata(VK, tags, where, page)
+}
+
+func (s *timedMetadataStore) GetDistinct(VK string, tag string, where *common.Predicate, page common.Pagination) ([]string, error) {
+	defer observeCall(mongoCallDuration, "GetDistinct", time.Now())
+	return s.MetadataStore.GetDistinct(VK, tag, where, page)
+}
+
+func (s *timedMetadataStore) GetUUIDs(where *common.Predicate) ([]common.UUID, error) {
+	defer observeCall(mongoCallDuration, "GetUUIDs", time.Now())
+	return s.MetadataStore.GetUUIDs(where)
+}
+
+func (s *timedMetadataStore) GetDocumentsAsOf(where *common.Predicate, at time.Time) ([]bson.M, error) {
+	defer observeCall(mongoCallDuration, "GetDocumentsAsOf", time.Now())
+	return s.MetadataStore.GetDocumentsAsOf(where, at)
+}
+
+func (s *timedMetadataStore) GetMatchingIntervals(where *common.Predicate, start, end time.Time) (map[string][]common.Interval, error) {
+	defer observeCall(mongoCallDuration, "GetMatchingIntervals", time.Now())
+	return s.MetadataStore.GetMatchingIntervals(where, start, end)
+}
+
+func (s *timedMetadataStore) URIFromUUID(uuid common.UUID) (string, error) {
+	defer observeCall(mongoCallDuration, "URIFromUUID", time.Now())
+	return s.MetadataStore.URIFromUUID(uuid)
+}
+
+func (s *timedMetadataStore) UUIDFromURI(uri string) (common.UUID, error) {
+	defer observeCall(mongoCallDuration, "UUIDFromURI", time.Now())
+	return s.MetadataStore.UUIDFromURI(uri)
+}
+
+func (s *timedMetadataStore) GetDocument(uuid common.UUID) bson.M {
+	defer observeCall(mongoCallDuration, "GetDocument", time.Now())
+	return s.MetadataStore.GetDocument(uuid)
+}
+
+func (s *timedMetadataStore) InitializeURI(uri, rewrittenuri, name, unit string, uuid common.UUID) error {
+	defer observeCall(mongoCallDuration, "InitializeURI", time.Now())
+	return s.MetadataStore.InitializeURI(uri, rewrittenuri, name, unit, uuid)
+}
+
+// Wraps the timeseries store to time its calls and count failed writes
+type timedStore struct {
+	TimeseriesStore
+}
+
+func (s *timedStore) StreamExists(uuid common.UUID) (bool, error) {
+	defer observeCall(btrdbCallDuration, "StreamExists", time.Now())
+	return s.TimeseriesStore.StreamExists(uuid)
+}
+
+func (s *timedStore) RegisterStream(uuid common.UUID, uri, name, unit string) error {
+	defer observeCall(btrdbCallDuration, "RegisterStream", time.Now())
+	return s.TimeseriesStore.RegisterStream(uuid, uri, name, unit)
+}
+
+func (s *timedStore) AddReadings(readings *common.Timeseries) error {
+	defer observeCall(btrdbCallDuration, "AddReadings", time.Now())
+	err := s.TimeseriesStore.AddReadings(readings)
+	if err != nil {
+		addReadingsErrors.Inc()
+	}
+	return err
+}
+
+func (s *timedStore) Prev(uuids []common.UUID, before int64) ([]common.Timeseries, error) {
+	defer observeCall(btrdbCallDuration, "Prev", time.Now())
+	return s.TimeseriesStore.Prev(uuids, before)
+}
+
+func (s *timedStore) Next(uuids []common.UUID, after int64) ([]common.Timeseries, error) {
+	defer observeCall(btrdbCallDuration, "Next", time.Now())
+	return s.TimeseriesStore.Next(uuids, after)
+}
+
+func (s *timedStore) GetData(uuids []common.UUID, start int64, end int64) ([]common.Timeseries, error) {
+	defer observeCall(btrdbCallDuration, "GetData", time.Now())
+	return s.TimeseriesStore.GetData(uuids, start, end)
+}
+
+func (s *timedStore) GetDataUUID(uuid common.UUID, start int64, end int64, convert common.UnitOfTime) (common.Timeseries, error) {
+	defer observeCall(btrdbCallDuration, "GetDataUUID", time.Now())
+	return s.TimeseriesStore.GetDataUUID(uuid, start, end, convert)
+}
+
+func (s *timedStore) StatisticalData(uuids []common.UUID, pointWidth int, start, end int64) ([]common.StatisticTimeseries, error) {
+	defer observeCall(btrdbCallDuration, "StatisticalData", time.Now())
+	return s.TimeseriesStore.StatisticalData(uuids, pointWidth, start, end)
+}
+
+func (s *timedStore) StatisticalDataUUID(uuid common.UUID, pointWidth int, start, end int64, convert common.UnitOfTime) (common.StatisticTimeseries, error) {
+	defer observeCall(btrdbCallDuration, "StatisticalDataUUID", time.Now())
+	return s.TimeseriesStore.StatisticalDataUUID(uuid, pointWidth, start, end, convert)
+}
+
+func (s *timedStore) WindowData(uuids []common.UUID, width uint64, start, end int64) ([]common.StatisticTimeseries, error) {
+	defer observeCall(btrdbCallDuration, "WindowData", time.Now())
+	return s.TimeseriesStore.WindowData(uuids, width, start, end)
+}
+
+func (s *timedStore) WindowDataUUID(uuid common.UUID, width uint64, start, end int64, convert common.UnitOfTime) (common.StatisticTimeseries, error) {
+	defer observeCall(btrdbCallDuration, "WindowDataUUID", time.Now())
+	return s.TimeseriesStore.WindowDataUUID(uuid, width, start, end, convert)
+}
+
+func (s *timedStore) ChangedRanges(uuids []common.UUID, from_gen, to_gen uint64, resolution uint8) ([]common.ChangedRange, error) {
+	defer observeCall(btrdbCallDuration, "ChangedRanges", time.Now())
+	return s.TimeseriesStore.ChangedRanges(uuids, from_gen, to_gen, resolution)
+}
+
+func (s *timedStore) DeleteData(uuids []common.UUID, start int64, end int64) error {
+	defer observeCall(btrdbCallDuration, "DeleteData", time.Now())
+	return s.TimeseriesStore.DeleteData(uuids, start, end)
+}
+
+func (s *timedStore) AddAnnotations(uuid common.UUID, annotations map[string]interface{}) error {
+	defer observeCall(btrdbCallDuration, "AddAnnotations", time.Now())
+	return s.TimeseriesStore.AddAnnotations(uuid, annotations)
+}
+
+func (s *timedStore) WithContext(ctx context.Context) TimeseriesStore {
+	return &timedStore{TimeseriesStore: s.TimeseriesStore.WithContext(ctx)}
+}
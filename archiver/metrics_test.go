@@ -0,0 +1,110 @@
+package archiver
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gtfierro/pundat/common"
+	"github.com/gtfierro/pundat/querylang"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestQueryType(t *testing.T) {
+	qp := querylang.NewQueryProcessor()
+	for _, test := range []struct {
+		query string
+		kind  string
+	}{
+		{`select * where has Room;`, "metadata"},
+		{`select distinct Room where has Room;`, "distinct"},
+		{`select data in (now -1h, now) where has Room;`, "data"},
+		{`select data before now where has Room;`, "before"},
+		{`select data after now -1h where has Room;`, "after"},
+		{`select statistical(30) data in (now -1h, now) where has Room;`, "statistical"},
+		{`select window(5min) data in (now -1h, now) where has Room;`, "window"},
+		{`select changed(0, 10, 30) data where has Room;`, "changed"},
+		{`select histogram(10) data in (now -1h, now) where has Room;`, "distribution"},
+		{`explain select data in (now -1h, now) where has Room;`, "explain"},
+		{`subscribe data where has Room;`, "subscribe"},
+		{`unsubscribe "abc";`, "unsubscribe"},
+		{`select where;`, "invalid"},
+	} {
+		if kind := queryType(qp.Parse(test.query)); kind != test.kind {
+			t.Errorf("%s should be a %s query, got %s", test.query, test.kind, kind)
+		}
+	}
+}
+
+// the number of observations, or the count, of the metric
+func metricCount(t *testing.T, metric interface{}) float64 {
+	var m dto.Metric
+	if err := metric.(prometheus.Metric).Write(&m); err != nil {
+		t.Fatal(err)
+	}
+	if m.Histogram != nil {
+		return float64(m.Histogram.GetSampleCount())
+	}
+	return m.Counter.GetValue()
+}
+
+// a timeseries store whose writes fail
+type failingWrites struct {
+	*fakeTimeseries
+}
+
+func (ts failingWrites) AddReadings(readings *common.Timeseries) error {
+	return errors.New("no connection")
+}
+
+func TestObserveQuery(t *testing.T) {
+	durations, failures := metricCount(t, queryDuration.WithLabelValues("data")), metricCount(t, queryErrors.WithLabelValues("data"))
+	a := testArchiver()
+	a.cache = newResultCache()
+	a.qp = querylang.NewQueryProcessor()
+	var err error
+	if a.limiter, err = newQueryLimiter(nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.HandleQuery("vk", `select data in (0, 100) where has Room;`); err != nil {
+		t.Fatal(err)
+	}
+	observeQuery("data", time.Now(), errors.New("failed"))
+	if count := metricCount(t, queryDuration.WithLabelValues("data")); count != durations+2 {
+		t.Errorf("Expected 2 more data queries to be timed, got %v", count-durations)
+	}
+	if count := metricCount(t, queryErrors.WithLabelValues("data")); count != failures+1 {
+		t.Errorf("Expected 1 more failed data query, got %v", count-failures)
+	}
+}
+
+func TestTimedStores(t *testing.T) {
+	a := testArchiver()
+	md := &timedMetadataStore{a.MD}
+	ts := &timedStore{failingWrites{a.TS.(*fakeTimeseries)}}
+	uriCalls := metricCount(t, mongoCallDuration.WithLabelValues("URIFromUUID"))
+	dataCalls := metricCount(t, btrdbCallDuration.WithLabelValues("GetDataUUID"))
+	writeErrors := metricCount(t, addReadingsErrors)
+
+	// the wrappers return what the stores do
+	if uri, err := md.URIFromUUID(uuidA); err != nil || uri != "ns/public/temp" {
+		t.Errorf("Expected ns/public/temp, got %s (%v)", uri, err)
+	}
+	if readings, err := ts.GetDataUUID(uuidA, 0, 100, common.UOT_NS); err != nil || len(readings.Records) != 11 {
+		t.Errorf("Expected 11 readings, got %d (%v)", len(readings.Records), err)
+	}
+	if err := ts.AddReadings(&common.Timeseries{UUID: uuidA}); err == nil {
+		t.Error("Expected the write to fail")
+	}
+
+	if count := metricCount(t, mongoCallDuration.WithLabelValues("URIFromUUID")); count != uriCalls+1 {
+		t.Errorf("Expected 1 more URIFromUUID call to be timed, got %v", count-uriCalls)
+	}
+	if count := metricCount(t, btrdbCallDuration.WithLabelValues("GetDataUUID")); count != dataCalls+1 {
+		t.Errorf("Expected 1 more GetDataUUID call to be timed, got %v", count-dataCalls)
+	}
+	if count := metricCount(t, addReadingsErrors); count != writeErrors+1 {
+		t.Errorf("Expected 1 more failed write, got %v", count-writeErrors)
+	}
+}
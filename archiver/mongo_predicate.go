@@ -0,0 +1,128 @@
+package archiver
+
+import (
+	"strings"
+
+	"github.com/gtfierro/pundat/common"
+	"github.com/pkg/errors"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// the field of each metadata document holding the words MATCHES searches. It is
+// covered by a text index (see common.TextTokens)
+const textField = "_text"
+
+// the field into which the relevance of each document to a MATCHES search is projected
+const scoreField = "_score"
+
+// Translates a predicate tree into a MongoDB query document. A nil predicate
+// becomes a nil document, which mongo treats as "match everything".
+// MATCHES predicates joined to the rest of the where clause with AND are evaluated with
+// the text index, searching the words of all of them together. Mongo allows one text
+// search per query and not under $or or $nor, so MATCHES predicates under OR or NOT are
+// evaluated by matching each word against the text field instead (see wordsClause),
+// which can't use the index and doesn't rank the results by relevance
+func mongoWhereClause(where *common.Predicate) (bson.M, error) {
+	terms, rest := splitTextSearch(where)
+	clause, err := mongoClause(rest)
+	if err != nil || len(terms) == 0 {
+		return clause, err
+	}
+	if clause == nil {
+		clause = bson.M{}
+	}
+	clause["$text"] = bson.M{"$search": textSearch(terms), "$language": "none"}
+	return clause, nil
+}
+
+// Separates the words of the MATCHES predicates that are joined to the where clause by
+// AND from the rest of the clause
+func splitTextSearch(where *common.Predicate) (terms []string, rest *common.Predicate) {
+	if where == nil {
+		return nil, nil
+	}
+	switch where.Op {
+	case common.OpMatches:
+		return common.SearchTerms(where.Values[0]), nil
+	case common.OpAnd:
+		var others []*common.Predicate
+		for _, child := range where.Children {
+			childTerms, childRest := splitTextSearch(child)
+			terms = append(terms, childTerms...)
+			if childRest != nil {
+				others = append(others, childRest)
+			}
+		}
+		switch len(others) {
+		case 0:
+			return terms, nil
+		case 1:
+			return terms, others[0]
+		}
+		return terms, &common.Predicate{Op: common.OpAnd, Children: others}
+	}
+	return nil, where
+}
+
+// Quotes each word so that mongo requires all of them to be present (unquoted words
+// are OR'd). The words only contain letters and digits, so they can't contain quotes
+// or the '-' that mongo treats as negation
+func textSearch(terms []string) string {
+	var quoted []string
+	for _, term := range terms {
+		quoted = append(quoted, `"`+term+`"`)
+	}
+	return strings.Join(quoted, " ")
+}
+
+// Matches documents whose text field contains all of the words. The text field is the
+// document's words separated by spaces, and the words only contain letters and digits,
+// so they need no escaping
+func wordsClause(terms []string) bson.M {
+	var words []bson.M
+	for _, term := range terms {
+		words = append(words, bson.M{textField: bson.M{"$regex": "(^| )" + term + "( |$)"}})
+	}
+	if len(words) == 1 {
+		return words[0]
+	}
+	return bson.M{"$and": words}
+}
+
+func mongoClause(where *common.Predicate) (bson.M, error) {
+	if where == nil {
+		return nil, nil
+	}
+	switch where.Op {
+	case common.OpAnd, common.OpOr, common.OpNot:
+		var children []bson.M
+		for _, child := range where.Children {
+			clause, err := mongoClause(child)
+			if err != nil {
+				return nil, err
+			}
+			children = append(children, clause)
+		}
+		switch where.Op {
+		case common.OpAnd:
+			return bson.M{"$and": children}, nil
+		case common.OpOr:
+			return bson.M{"$or": children}, nil
+		}
+		// mongo's $not only applies to a single field, so negate the whole clause
+		return bson.M{"$nor": children}, nil
+	case common.OpEq:
+		return bson.M{where.Key: where.Values[0]}, nil
+	case common.OpNeq:
+		return bson.M{where.Key: bson.M{"$ne": where.Values[0]}}, nil
+	case common.OpLike:
+		return bson.M{where.Key: bson.M{"$regex": where.Values[0]}}, nil
+	case common.OpHas:
+		return bson.M{where.Key: bson.M{"$exists": true}}, nil
+	case common.OpIn:
+		return bson.M{where.Key: bson.M{"$in": where.Values}}, nil
+	case common.OpMatches:
+		return wordsClause(common.SearchTerms(where.Values[0])), nil
+	}
+	return nil, errors.Errorf("Unknown predicate operator %v", where.Op)
+}
@@ -0,0 +1,97 @@
+package archiver
+
+import (
+	"reflect"
+	"regexp"
+	"testing"
+
+	"github.com/gtfierro/pundat/common"
+	"gopkg.in/mgo.v2/bson"
+)
+
+func matches(search string) *common.Predicate {
+	return &common.Predicate{Op: common.OpMatches, Values: []string{search}}
+}
+
+func TestSplitTextSearch(t *testing.T) {
+	room := common.NewTagPredicate(common.OpEq, "Room", "410")
+	building := common.NewTagPredicate(common.OpEq, "Building", "Soda")
+	for _, test := range []struct {
+		where *common.Predicate
+		terms []string
+		rest  *common.Predicate
+	}{
+		{matches("Air Temp"), []string{"air", "temp"}, nil},
+		{common.NewAnd(matches("temp"), room), []string{"temp"}, room},
+		{common.NewAnd(common.NewAnd(matches("temp"), room), common.NewAnd(matches("air"), building)), []string{"temp", "air"}, common.NewAnd(room, building)},
+		// the text index can't be used under OR or NOT
+		{common.NewOr(matches("temp"), room), nil, common.NewOr(matches("temp"), room)},
+		{common.NewNot(matches("temp")), nil, common.NewNot(matches("temp"))},
+		{room, nil, room},
+	} {
+		terms, rest := splitTextSearch(test.where)
+		if !reflect.DeepEqual(terms, test.terms) || !reflect.DeepEqual(rest, test.rest) {
+			t.Errorf("%s: expected %v and %s, got %v and %s", test.where, test.terms, test.rest, terms, rest)
+		}
+	}
+}
+
+func TestMongoWhereClause(t *testing.T) {
+	room := common.NewTagPredicate(common.OpEq, "Room", "410")
+	clause, err := mongoWhereClause(common.NewAnd(matches("air temp"), room))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := bson.M{"Room": "410", "$text": bson.M{"$search": `"air" "temp"`, "$language": "none"}}
+	if !reflect.DeepEqual(clause, expected) {
+		t.Errorf("Expected a text search, got %v", clause)
+	}
+
+	clause, err = mongoWhereClause(common.NewOr(matches("air temp"), room))
+	if err != nil {
+		t.Fatalf("Expected MATCHES under OR to fall back to matching words, got %v", err)
+	}
+	expected = bson.M{"$or": []bson.M{
+		{"$and": []bson.M{
+			{textField: bson.M{"$regex": "(^| )air( |$)"}},
+			{textField: bson.M{"$regex": "(^| )temp( |$)"}},
+		}},
+		{"Room": "410"},
+	}}
+	if !reflect.DeepEqual(clause, expected) {
+		t.Errorf("Expected $or of the words and the tag, got %v", clause)
+	}
+
+	clause, err = mongoWhereClause(common.NewNot(matches("temp")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, found := clause["$text"]; found {
+		t.Errorf("Expected no text search under NOT, got %v", clause)
+	}
+}
+
+// the words clause must match the same documents as the text search
+func TestWordsClauseMatchesWholeWords(t *testing.T) {
+	text := textOf(bson.M{"Point": "Air_Temp", "Room": "410", "uuid": "ignored"})
+	for _, test := range []struct {
+		search string
+		found  bool
+	}{
+		{"temp", true},
+		{"AIR temp", true},
+		{"410", true},
+		{"tem", false},
+		{"air humidity", false},
+		{"ignored", false},
+	} {
+		found := true
+		for _, term := range common.SearchTerms(test.search) {
+			pattern := wordsClause([]string{term})[textField].(bson.M)["$regex"].(string)
+			found = found && regexp.MustCompile(pattern).MatchString(text)
+		}
+		if found != test.found {
+			t.Errorf("Searching %q for %q: expected %v, got %v", text, test.search, test.found, found)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package archiver
+
+import (
+	"sort"
+	"time"
+
+	"github.com/gtfierro/pundat/common"
+	"github.com/pkg/errors"
+)
+
+// Returns the streams (in the same order) whose data the VK may read for some range of
+// time. Where clauses are evaluated without regard to the VK, so every query that resolves
+// one must pass the result through here before revealing anything about the streams
+func readableStreams(auth Authorizer, md MetadataStore, vk string, uuids []common.UUID) ([]common.UUID, error) {
+	var readable []common.UUID
+	for _, uuid := range uuids {
+		uri, err := md.URIFromUUID(uuid)
+		if err != nil {
+			return readable, err
+		}
+		// an error here means there is no chain from the VK to the URI
+		if ranges, err := auth.GetValidRanges(uri, vk); err == nil && len(ranges.Ranges) > 0 {
+			readable = append(readable, uuid)
+		}
+	}
+	return readable, nil
+}
+
+// Returns an error unless the authorizer lets the VK write to the URI at each of the times
+// (in nanoseconds). Writes are granted like reads, so a grant limited to a range of time
+// only covers readings taken within it
+func canWrite(auth Authorizer, uri, vk string, times []int64) error {
+	ranges, err := auth.GetValidRanges(uri, vk)
+	if err != nil {
+		return errors.Wrapf(err, "%s may not write to %s", vk, uri)
+	}
+	for _, t := range times {
+		allowed := false
+		for _, rng := range ranges.Ranges {
+			if rng.Contains(time.Unix(0, t)) {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return errors.Errorf("%s may not write to %s at %d", vk, uri, t)
+		}
+	}
+	return nil
+}
+
+// Returns the distinct values of the tag among the documents the VK may read, sorted and
+// paged. Values are collected from the masked documents rather than asking the metadata
+// store for distinct values, because those would include values from documents the VK can't
+// see, and values of the tag on documents where it is hidden from the VK
+func (a *Archiver) readableDistinct(vk, tag string, where *common.Predicate, groups []common.MetadataGroup, page common.Pagination) ([]string, error) {
+	var (
+		distincts []string
+		seen      = make(map[string]bool)
+	)
+	groups, err := a.maskMetadataGroupsByPermission(vk, where, "", groups)
+	if err != nil {
+		return nil, err
+	}
+	for i := range groups {
+		rec := groups[i].GetKey(tag)
+		if rec == nil {
+			continue
+		}
+		if value, ok := rec.Value.(string); ok && !seen[value] {
+			seen[value] = true
+			distincts = append(distincts, value)
+		}
+	}
+	if page.Descending {
+		sort.Sort(sort.Reverse(sort.StringSlice(distincts)))
+	} else {
+		sort.Strings(distincts)
+	}
+	if page.Offset >= len(distincts) {
+		return []string{}, nil
+	}
+	distincts = distincts[page.Offset:]
+	if page.Limit > 0 && len(distincts) > page.Limit {
+		distincts = distincts[:page.Limit]
+	}
+	return distincts, nil
+}
+
+// clips the changed ranges of each stream to the ranges of time the VK may read
+func (a *Archiver) maskChangedRangesByPermission(vk string, changed []common.ChangedRange) ([]common.ChangedRange, error) {
+	var ret []common.ChangedRange
+	for _, cr := range changed {
+		uri, err := a.MD.URIFromUUID(cr.UUID)
+		if err != nil {
+			return ret, err
+		}
+		validRanges, err := a.authority.GetValidRanges(uri, vk)
+		if err != nil {
+			continue
+		}
+		masked := common.ChangedRange{UUID: cr.UUID}
+		for _, rng := range cr.Ranges {
+			for _, valid := range validRanges.Ranges {
+				start, end := rng.StartTime, rng.EndTime
+				if s := valid.Start.UnixNano(); s > start {
+					start = s
+				}
+				if e := valid.End.UnixNano(); e < end {
+					end = e
+				}
+				if start <= end {
+					masked.Ranges = append(masked.Ranges, &common.TimeRange{StartTime: start, EndTime: end, Generation: rng.Generation})
+				}
+			}
+		}
+		if len(masked.Ranges) > 0 {
+			ret = append(ret, masked)
+		}
+	}
+	return ret, nil
+}
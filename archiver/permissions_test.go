@@ -0,0 +1,198 @@
+package archiver
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/gtfierro/pundat/common"
+	"github.com/gtfierro/pundat/dots"
+	"github.com/pkg/errors"
+)
+
+// holds one document per stream. Methods the tests don't need are left unimplemented
+type fakeMetadata struct {
+	MetadataStore
+	docs []common.MetadataGroup
+}
+
+func (md *fakeMetadata) GetMetadata(VK string, tags []string, where *common.Predicate, page common.Pagination) ([]common.MetadataGroup, error) {
+	return md.docs, nil
+}
+
+func (md *fakeMetadata) URIFromUUID(uuid common.UUID) (string, error) {
+	for i := range md.docs {
+		if md.docs[i].UUID.String() == uuid.String() {
+			return md.docs[i].URI, nil
+		}
+	}
+	return "", errors.New("No such stream")
+}
+
+// reports that every stream changed over [0, 100]
+type fakeTimeseries struct {
+	TimeseriesStore
+}
+
+func (ts *fakeTimeseries) ChangedRanges(uuids []common.UUID, from_gen, to_gen uint64, resolution uint8) ([]common.ChangedRange, error) {
+	var changed []common.ChangedRange
+	for _, uuid := range uuids {
+		changed = append(changed, common.ChangedRange{UUID: uuid, Ranges: []*common.TimeRange{{StartTime: 0, EndTime: 100}}})
+	}
+	return changed, nil
+}
+
+// every stream has a reading every 10ns in [0, 100]
+func (ts *fakeTimeseries) GetDataUUID(uuid common.UUID, start int64, end int64, convert common.UnitOfTime) (common.Timeseries, error) {
+	var records []*common.TimeseriesReading
+	for t := int64(0); t <= 100; t += 10 {
+		if t >= start && t <= end {
+			records = append(records, &common.TimeseriesReading{Time: time.Unix(0, t), Value: float64(t)})
+		}
+	}
+	return common.Timeseries{UUID: uuid, Records: records}, nil
+}
+
+func (ts *fakeTimeseries) WithContext(ctx context.Context) TimeseriesStore {
+	return ts
+}
+
+var (
+	uuidA = common.ParseUUID("0e7f5c36-9d2d-11e7-a1a5-0cc47a0f7eea")
+	uuidB = common.ParseUUID("1a4e6a1c-9d2d-11e7-a1a5-0cc47a0f7eea")
+)
+
+func testArchiver() *Archiver {
+	room := func(uuid common.UUID, uri, name string) common.MetadataGroup {
+		group := common.NewMetadataGroup(&common.MetadataRecord{Key: "Room", Value: name})
+		return common.MetadataGroup{Records: group.Records, UUID: uuid, URI: uri}
+	}
+	// the archiver's permissions come from a static ACL, so no registry is needed
+	acl, err := dots.NewACL(dots.ACLConfig{Grants: []dots.ACLGrant{
+		{VK: "vk", URI: "ns/public/*", From: "10", To: "20"},
+		{VK: "admin", URI: "ns/*"},
+	}})
+	if err != nil {
+		panic(err)
+	}
+	return &Archiver{
+		MD: &fakeMetadata{docs: []common.MetadataGroup{
+			room(uuidA, "ns/public/temp", "410"),
+			room(uuidB, "ns/private/temp", "secret"),
+		}},
+		TS:        &fakeTimeseries{},
+		authority: acl,
+	}
+}
+
+// evaluates queries on the archiver without any limits
+func unlimited(a *Archiver) *queryContext {
+	return &queryContext{Archiver: a, TS: a.TS}
+}
+
+func TestDistinctTagMasksByPermission(t *testing.T) {
+	a := testArchiver()
+	values, _, err := a.DistinctTag("vk", &common.DistinctParams{Tag: "Room"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(values, []string{"410"}) {
+		t.Errorf("Expected only the readable stream's value, got %v", values)
+	}
+	values, _, err = a.DistinctTag("other", &common.DistinctParams{Tag: "Room"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(values) != 0 {
+		t.Errorf("Expected no values for a VK without access, got %v", values)
+	}
+}
+
+func TestChangedRangesMasksByPermission(t *testing.T) {
+	a := testArchiver()
+	changed, err := unlimited(a).GetChangedRanges("vk", &common.DataParams{UUIDs: []common.UUID{uuidA, uuidB}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changed) != 1 || changed[0].UUID.String() != uuidA.String() {
+		t.Fatalf("Expected only the readable stream, got %v", changed)
+	}
+	if rng := changed[0].Ranges; len(rng) != 1 || rng[0].StartTime != 10 || rng[0].EndTime != 20 {
+		t.Errorf("Expected the change to be clipped to [10, 20], got %+v", rng[0])
+	}
+}
+
+func TestRedactionHidesTags(t *testing.T) {
+	a := testArchiver()
+	var err error
+	a.redaction, err = newRedactor(RedactionConfig{Policies: []RedactionPolicy{
+		{URI: "ns/public/*", Tags: []string{"Ro*"}, Reveal: "ns/reveal"},
+	}}, a.authority)
+	if err != nil {
+		t.Fatal(err)
+	}
+	groups, _, err := a.SelectTags("vk", &common.TagParams{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(groups) != 1 || groups[0].HasKey("Room") {
+		t.Errorf("Expected the readable stream without its Room tag, got %+v", groups)
+	}
+	values, _, err := a.DistinctTag("vk", &common.DistinctParams{Tag: "Room"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(values) != 0 {
+		t.Errorf("Expected no values of a hidden tag, got %v", values)
+	}
+	// filtering on the hidden tag must not reveal which streams match
+	where := common.NewTagPredicate(common.OpEq, "Room", "410")
+	if groups, _, err = a.SelectTags("vk", &common.TagParams{Where: where}); err != nil {
+		t.Fatal(err)
+	}
+	if len(groups) != 0 {
+		t.Errorf("Expected a where clause on a hidden tag to match nothing, got %+v", groups)
+	}
+	// nor which streams matched it in the past
+	before, err := unlimited(a).SelectDataBefore("vk", &common.DataParams{Where: where, AsOfData: true, Begin: 15, End: 15})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(before) != 0 {
+		t.Errorf("Expected an AS OF DATA where clause on a hidden tag to match nothing, got %+v", before)
+	}
+	a.cache = newResultCache()
+	data, _, err := unlimited(a).SelectDataRange("vk", &common.DataParams{Where: where, AsOfData: true, Begin: 0, End: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data) != 0 {
+		t.Errorf("Expected an AS OF DATA where clause on a hidden tag to match nothing, got %+v", data)
+	}
+	// admin can read the Reveal URI, so sees everything
+	values, _, err = a.DistinctTag("admin", &common.DistinctParams{Tag: "Room", Where: where})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(values, []string{"410", "secret"}) {
+		t.Errorf("Expected the exempt VK to see every value, got %v", values)
+	}
+}
+
+func (md *fakeMetadata) GetUUIDs(where *common.Predicate) ([]common.UUID, error) {
+	var uuids []common.UUID
+	for i := range md.docs {
+		uuids = append(uuids, md.docs[i].UUID)
+	}
+	return uuids, nil
+}
+
+// every stream matches throughout [start, end]
+func (md *fakeMetadata) GetMatchingIntervals(where *common.Predicate, start, end time.Time) (map[string][]common.Interval, error) {
+	matched := make(map[string][]common.Interval)
+	for i := range md.docs {
+		matched[md.docs[i].UUID.String()] = []common.Interval{{Start: start.UnixNano(), End: end.UnixNano()}}
+	}
+	return matched, nil
+}
@@ -0,0 +1,193 @@
+package archiver
+
+import (
+	"io/ioutil"
+	"path"
+	"strings"
+
+	"github.com/gtfierro/pundat/common"
+	"github.com/gtfierro/pundat/dots"
+	"github.com/pkg/errors"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// Struct representation of the tag redaction policies. Each policy hides the tags
+// matching its Tags patterns (shell-style, e.g. "room*") on the streams whose URIs match
+// its URI pattern (BOSSWAVE syntax) from every VK except those listed in VKs and those
+// that can read the Reveal URI (checked with DOTs, or with the ACL if one is configured).
+// Follows the basic structure:
+//
+//    Policies:
+//      - URI: ucberkeley/eecs/soda/*
+//        Tags: [Occupant, Room*]
+//        VKs: [Tj1RiNjKD8ZfYWvMvLVLaIaqDTV9LuNE7pPJoFTwoy8=]
+//        Reveal: ucberkeley/eecs/soda/private
+//
+// A tag pattern also hides the nested fields of the tags it matches
+type RedactionConfig struct {
+	Policies []RedactionPolicy `yaml:"Policies"`
+}
+
+type RedactionPolicy struct {
+	URI    string   `yaml:"URI"`
+	Tags   []string `yaml:"Tags"`
+	VKs    []string `yaml:"VKs"`
+	Reveal string   `yaml:"Reveal"`
+}
+
+// Hides tags from the VKs the redaction policies don't allow to see them. A hidden tag
+// is treated as if the stream did not have it: it is removed from SELECT results and its
+// values are not counted by SELECT DISTINCT. The metadata store evaluates where clauses
+// without regard to the VK, so queries that test hidden tags (or search the text of the
+// whole document with MATCHES, or order by a hidden tag) don't match the streams the
+// tags are hidden on; otherwise the results would reveal what the hidden values are.
+// All methods are safe to call on a nil redactor, which hides nothing
+type redactor struct {
+	policies []RedactionPolicy
+	auth     Authorizer
+}
+
+func readRedactionPolicies(filename string, auth Authorizer) (*redactor, error) {
+	var config RedactionConfig
+	bytes, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Could not read redaction policies %s", filename)
+	}
+	if err := yaml.Unmarshal(bytes, &config); err != nil {
+		return nil, errors.Wrap(err, "Could not unmarshal redaction policies")
+	}
+	return newRedactor(config, auth)
+}
+
+func newRedactor(config RedactionConfig, auth Authorizer) (*redactor, error) {
+	for i, policy := range config.Policies {
+		if policy.URI == "" || len(policy.Tags) == 0 {
+			return nil, errors.Errorf("Redaction policy %d needs both a URI and Tags", i)
+		}
+		for _, pattern := range policy.Tags {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return nil, errors.Wrapf(err, "Redaction policy %d has an invalid tag pattern %s", i, pattern)
+			}
+		}
+	}
+	return &redactor{policies: config.Policies, auth: auth}, nil
+}
+
+// the patterns of the tags hidden from a VK on a stream
+type tagPatterns []string
+
+// Returns the patterns of the tags on the stream with the given URI that the VK may not see
+func (r *redactor) hidden(uri, vk string) tagPatterns {
+	if r == nil {
+		return nil
+	}
+	var hidden tagPatterns
+	for _, policy := range r.policies {
+		if !dots.MatchURI(policy.URI, uri) || r.exempt(policy, vk) {
+			continue
+		}
+		hidden = append(hidden, policy.Tags...)
+	}
+	return hidden
+}
+
+// returns true if the policy lets the VK see the tags
+func (r *redactor) exempt(policy RedactionPolicy, vk string) bool {
+	for _, allowed := range policy.VKs {
+		if allowed == vk {
+			return true
+		}
+	}
+	return policy.Reveal != "" && r.auth.CanRead(policy.Reveal, vk) == nil
+}
+
+// returns true if the tag, or a tag it is nested under, matches one of the patterns
+func (hidden tagPatterns) hides(tag string) bool {
+	for _, pattern := range hidden {
+		for prefix := tag; ; {
+			if matched, _ := path.Match(pattern, prefix); matched {
+				return true
+			}
+			idx := strings.LastIndex(prefix, ".")
+			if idx < 0 {
+				break
+			}
+			prefix = prefix[:idx]
+		}
+	}
+	return false
+}
+
+// returns true if evaluating the where clause, or ordering by the tag, would depend on
+// the value of a hidden tag
+func (hidden tagPatterns) probedBy(where *common.Predicate, orderBy string) bool {
+	if len(hidden) == 0 {
+		return false
+	}
+	if orderBy != "" && hidden.hides(orderBy) {
+		return true
+	}
+	if where == nil {
+		return false
+	}
+	switch where.Op {
+	case common.OpMatches:
+		return true
+	case common.OpAnd, common.OpOr, common.OpNot:
+		for _, child := range where.Children {
+			if hidden.probedBy(child, "") {
+				return true
+			}
+		}
+		return false
+	}
+	return hidden.hides(where.Key)
+}
+
+// Returns the streams (in the same order) whose match of the where clause does not
+// depend on tags hidden from the VK
+func (r *redactor) unprobed(md MetadataStore, vk string, where *common.Predicate, uuids []common.UUID) ([]common.UUID, error) {
+	if r == nil || where == nil {
+		return uuids, nil
+	}
+	var ret []common.UUID
+	for _, uuid := range uuids {
+		uri, err := md.URIFromUUID(uuid)
+		if err != nil {
+			return ret, err
+		}
+		if !r.hidden(uri, vk).probedBy(where, "") {
+			ret = append(ret, uuid)
+		}
+	}
+	return ret, nil
+}
+
+// Removes the tags hidden from the VK from the groups, and drops the groups that only
+// matched the where clause (or were ordered) because of the values of hidden tags. The
+// groups must have their URIs resolved
+func (r *redactor) redactGroups(vk string, where *common.Predicate, orderBy string, groups []common.MetadataGroup) []common.MetadataGroup {
+	if r == nil {
+		return groups
+	}
+	var ret []common.MetadataGroup
+	for i := range groups {
+		group := &groups[i]
+		hidden := r.hidden(group.URI, vk)
+		if len(hidden) == 0 {
+			ret = append(ret, common.MetadataGroup{Records: group.Records, UUID: group.UUID, URI: group.URI})
+			continue
+		}
+		if hidden.probedBy(where, orderBy) {
+			continue
+		}
+		records := make(map[string]*common.MetadataRecord, len(group.Records))
+		for key, record := range group.Records {
+			if !hidden.hides(key) {
+				records[key] = record
+			}
+		}
+		ret = append(ret, common.MetadataGroup{Records: records, UUID: group.UUID, URI: group.URI})
+	}
+	return ret
+}
@@ -0,0 +1,115 @@
+package archiver
+
+import (
+	"context"
+	"encoding/json"
+	"runtime"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"github.com/gtfierro/pundat/version"
+	"github.com/pkg/errors"
+)
+
+// how often the archiver publishes !meta/lastalive and !meta/status. Tools treat an archiver
+// that hasn't been alive for 5 minutes as gone, so this is well inside that
+const statusInterval = 30 * time.Second
+
+// how long a backend has to answer the health check
+const healthCheckTimeout = 10 * time.Second
+
+// The document the archiver publishes, JSON-encoded, on <DeployNS>/s.giles/!meta/status
+type Status struct {
+	Version string
+	Started time.Time
+	// seconds since the archiver started
+	Uptime int64
+	// the namespaces the archiver looks for archive requests on
+	Namespaces []string
+	// the archive requests the archiver is following
+	ArchiveRequests int
+	// the streams being archived
+	Streams    int64
+	Goroutines int
+	// "ok" or the reason each backend ("Metadata", "Timeseries") is unhealthy
+	Backends map[string]string
+}
+
+// A backend whose health can be checked
+type pinger interface {
+	ping(ctx context.Context) error
+}
+
+func (m *mongo_store) ping(ctx context.Context) error {
+	// mgo can't be cancelled, but does have its own timeouts
+	return errors.Wrap(m.session.Ping(), "Could not ping Mongo")
+}
+
+func (bdb *btrdbv4Iface) ping(ctx context.Context) error {
+	_, err := bdb.conn.Info(ctx)
+	return errors.Wrap(err, "Could not get BtrDB info")
+}
+
+// Builds the archiver's current status, checking each backend
+func (a *Archiver) status() Status {
+	status := Status{
+		Version:         version.Release,
+		Started:         a.started,
+		Uptime:          int64(time.Since(a.started) / time.Second),
+		Namespaces:      append([]string{}, a.config.BOSSWAVE.ListenNS...),
+		ArchiveRequests: a.vm.requestHosts.Count(),
+		Streams:         atomic.LoadInt64(&currentStreams),
+		Goroutines:      runtime.NumGoroutine(),
+		Backends:        make(map[string]string),
+	}
+	sort.Strings(status.Namespaces)
+	for name, backend := range a.backends {
+		ctx, cancel := context.WithTimeout(context.Background(), healthCheckTimeout)
+		if err := backend.ping(ctx); err != nil {
+			status.Backends[name] = err.Error()
+		} else {
+			status.Backends[name] = "ok"
+		}
+		cancel()
+	}
+	return status
+}
+
+// Publishes !meta/lastalive and !meta/status on the archiver's service (and lastalive on its
+// interface) every statusInterval until ctx is done. If PeriodicReport is set, the status is
+// logged too
+func (a *Archiver) publishStatus(ctx context.Context) {
+	publish := func() {
+		now := time.Now().Format(time.RFC3339)
+		if err := a.svc.SetMetadata("lastalive", now); err != nil {
+			log.Error(errors.Wrap(err, "Could not publish lastalive"))
+		}
+		if err := a.iface.SetMetadata("lastalive", now); err != nil {
+			log.Error(errors.Wrap(err, "Could not publish lastalive"))
+		}
+		status := a.status()
+		doc, err := json.Marshal(status)
+		if err != nil {
+			log.Error(errors.Wrap(err, "Could not serialize status"))
+			return
+		}
+		if err := a.svc.SetMetadata("status", string(doc)); err != nil {
+			log.Error(errors.Wrap(err, "Could not publish status"))
+		}
+		if a.config.Archiver.PeriodicReport {
+			log.Infof("status %s", doc)
+		}
+	}
+	publish()
+	tick := time.NewTicker(statusInterval)
+	defer tick.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-tick.C:
+			publish()
+		}
+	}
+}
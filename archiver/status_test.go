@@ -0,0 +1,69 @@
+package archiver
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+type fakeBackend struct {
+	err error
+}
+
+func (b fakeBackend) ping(ctx context.Context) error {
+	return b.err
+}
+
+func TestStatus(t *testing.T) {
+	a := &Archiver{
+		config:  &Config{BOSSWAVE: BWConfig{ListenNS: []string{"ns2", "ns1"}}},
+		started: time.Now().Add(-time.Minute),
+		vm:      &viewManager{requestHosts: NewSynchronizedArchiveRequestMap()},
+		backends: map[string]pinger{
+			"Metadata":   fakeBackend{},
+			"Timeseries": fakeBackend{errors.New("Could not get BtrDB info: connection refused")},
+		},
+	}
+	a.vm.requestHosts.Set("ns1/host", &ArchiveRequest{URI: "ns1/host/*", Name: "temp"})
+	a.vm.requestHosts.Set("ns2/host", &ArchiveRequest{URI: "ns2/host/*", Name: "temp"})
+
+	status := a.status()
+	if status.ArchiveRequests != 2 {
+		t.Errorf("Expected 2 archive requests, got %d", status.ArchiveRequests)
+	}
+	if !reflect.DeepEqual(status.Namespaces, []string{"ns1", "ns2"}) {
+		t.Errorf("Expected the namespaces sorted, got %v", status.Namespaces)
+	}
+	if status.Uptime < 60 {
+		t.Errorf("Expected an uptime of at least 60s, got %d", status.Uptime)
+	}
+	expected := map[string]string{"Metadata": "ok", "Timeseries": "Could not get BtrDB info: connection refused"}
+	if !reflect.DeepEqual(status.Backends, expected) {
+		t.Errorf("Expected backends %v, got %v", expected, status.Backends)
+	}
+	// the namespaces are copied, so the config isn't reordered
+	if a.config.BOSSWAVE.ListenNS[0] != "ns2" {
+		t.Errorf("Expected the configured namespaces to be left alone, got %v", a.config.BOSSWAVE.ListenNS)
+	}
+
+	// published as JSON
+	doc, err := json.Marshal(status)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded Status
+	if err := json.Unmarshal(doc, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if !decoded.Started.Equal(status.Started) {
+		t.Errorf("Expected the start time %s, got %s", status.Started, decoded.Started)
+	}
+	decoded.Started = status.Started
+	if !reflect.DeepEqual(decoded, status) {
+		t.Errorf("Expected %+v after decoding, got %+v", status, decoded)
+	}
+}
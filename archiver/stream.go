@@ -36,32 +36,48 @@ type Stream struct {
 	urireplace string
 	// incoming data
 	buffer chan *bw2.SimpleMessage
+	// receives readings as soon as they arrive, before they are committed
+	live *subscriptionManager
+	// where the UUIDs of the streams are recorded, if set
+	archived *streamSet
 	// maps URI -> UUID (under the other parameters of this archive request)
 	seenURIs   map[string]common.UUID
-	timeseries map[string]common.Timeseries
+	timeseries map[string]*common.Timeseries
 	sync.RWMutex
 }
 
 func (s *Stream) initialize(timeseriesStore TimeseriesStore, metadataStore MetadataStore, msg *bw2.SimpleMessage) error {
+	return s.initializeURI(timeseriesStore, metadataStore, msg.URI)
+}
+
+// Creates the stream for readings from the URI and starts committing the readings buffered
+// for it. The URI is rewritten by urimatch and urireplace, if they are set
+func (s *Stream) initializeURI(timeseriesStore TimeseriesStore, metadataStore MetadataStore, uri string) error {
 	atomic.AddInt64(&currentStreams, 1)
-	// don't need to worry about escaping $ in the URI because bosswave doesn't allow it
-	rewrittenURI := s.urimatch.ReplaceAllString(msg.URI, s.urireplace)
+	rewrittenURI := uri
+	if s.urimatch != nil {
+		// don't need to worry about escaping $ in the URI because bosswave doesn't allow it
+		rewrittenURI = s.urimatch.ReplaceAllString(uri, s.urireplace)
+	}
 
 	currentUUID := common.ParseUUID(uuid.NewV3(NAMESPACE_UUID, rewrittenURI+s.name).String())
+	if s.archived != nil {
+		s.archived.add(currentUUID)
+	}
 
 	// update stream structures
 	s.Lock()
-	s.seenURIs[msg.URI] = currentUUID
-	s.timeseries[msg.URI] = common.Timeseries{
+	s.seenURIs[uri] = currentUUID
+	s.timeseries[uri] = &common.Timeseries{
 		UUID:   currentUUID,
-		SrcURI: msg.URI,
+		SrcURI: uri,
 	}
 
 	s.Unlock()
 
 	// do initialization with the metadata store
-	if metadataErr := metadataStore.InitializeURI(msg.URI, rewrittenURI, s.name, s.unit, currentUUID); metadataErr != nil {
-		log.Error(errors.Wrapf(metadataErr, "Error initializing metadata store with URI %s", msg.URI))
+	if metadataErr := metadataStore.InitializeURI(uri, rewrittenURI, s.name, s.unit, currentUUID); metadataErr != nil {
+		log.Error(errors.Wrapf(metadataErr, "Error initializing metadata store with URI %s", uri))
 		return metadataErr
 	}
 
@@ -70,7 +86,7 @@ func (s *Stream) initialize(timeseriesStore TimeseriesStore, metadataStore Metad
 		return err
 	} else if !exists {
 		if err := timeseriesStore.RegisterStream(currentUUID, rewrittenURI, s.name, s.unit); err != nil {
-			log.Error(errors.Wrapf(err, "Could not create stream (%s %s %s %s)", currentUUID.String(), msg.URI, s.name, s.unit))
+			log.Error(errors.Wrapf(err, "Could not create stream (%s %s %s %s)", currentUUID.String(), uri, s.name, s.unit))
 			return err
 		}
 	}
@@ -80,26 +96,36 @@ func (s *Stream) initialize(timeseriesStore TimeseriesStore, metadataStore Metad
 		for {
 			time.Sleep(commitTick + time.Duration(rand.Intn(jitter))*time.Second)
 			s.RLock()
-			ts := s.timeseries[msg.URI]
+			ts, found := s.timeseries[uri]
 			s.RUnlock()
+			if !found {
+				// forgotten, so there is nothing more to commit
+				return
+			}
 
-			commitme := ts.Copy()
+			ts.RLock()
+			commitme := &common.Timeseries{
+				UUID:    ts.UUID,
+				SrcURI:  ts.SrcURI,
+				Records: append([]*common.TimeseriesReading(nil), ts.Records...),
+			}
+			ts.RUnlock()
 			// if no readings, then we give up
 			if len(commitme.Records) == 0 {
 				continue
 			}
 			// now we can assume the stream exists and can write to it
+			started := time.Now()
 			if err := timeseriesStore.AddReadings(commitme); err != nil {
 				log.Error(errors.Wrap(err, "Could not write timeseries reading (probably deadline exceeded)"), len(commitme.Records))
 				continue
 			}
+			commitDuration.Observe(time.Since(started).Seconds())
+			committedReadings.Add(float64(len(commitme.Records)))
+			// readings buffered while these were written are left for the next commit
 			ts.Lock()
 			ts.Records = ts.Records[len(commitme.Records):]
 			ts.Unlock()
-
-			s.Lock()
-			s.timeseries[msg.URI] = ts
-			s.Unlock()
 		}
 	}()
 
@@ -107,24 +133,7 @@ func (s *Stream) initialize(timeseriesStore TimeseriesStore, metadataStore Metad
 }
 
 func (s *Stream) start(timeseriesStore TimeseriesStore, metadataStore MetadataStore) {
-	// start goroutine to push stream metadata into timeseries store
-	go func() {
-		for _ = range time.Tick(annotationTick) {
-			var uuids []common.UUID
-			s.RLock()
-			for _, ts := range s.timeseries {
-				uuids = append(uuids, ts.UUID)
-			}
-			s.RUnlock()
-			for _, uuid := range uuids {
-				if doc := metadataStore.GetDocument(uuid); doc == nil {
-					continue
-				} else if err := timeseriesStore.AddAnnotations(uuid, doc); err != nil {
-					log.Error(errors.Wrapf(err, "Could not write annotations for %s (%p)", uuid, s))
-				}
-			}
-		}
-	}()
+	s.startAnnotations(timeseriesStore, metadataStore)
 
 	var readPoints func()
 	// loop through the buffer
@@ -153,11 +162,11 @@ func (s *Stream) start(timeseriesStore TimeseriesStore, metadataStore MetadataSt
 
 			// grab the timeseries object
 			s.RLock()
-			ts := s.timeseries[msg.URI]
+			ts, found := s.timeseries[msg.URI]
 			s.RUnlock()
 			po := msg.GetOnePODF(s.po)
 
-			if po == nil {
+			if po == nil || !found {
 				continue
 			}
 
@@ -182,6 +191,10 @@ func (s *Stream) start(timeseriesStore TimeseriesStore, metadataStore MetadataSt
 
 			// extract the time
 			timestamps := s.getTimes(thing)
+			// the readings from this message, for the live subscriptions. They are published as
+			// they are, so they carry the unit (nanoseconds) their times are rendered in; without
+			// it, every time would be rendered as 0. The timeseries stores ignore the unit
+			var fresh []*common.TimeseriesReading
 
 			// generate the timeseries values from our extracted value, and then save it
 			// test if the value is a list
@@ -208,9 +221,10 @@ func (s *Stream) start(timeseriesStore TimeseriesStore, metadataStore MetadataSt
 						continue
 					}
 					ts.Lock()
-					newrec := &common.TimeseriesReading{Time: timestamps[len(ts.Records)], Value: value_f64}
+					newrec := &common.TimeseriesReading{Time: timestamps[len(ts.Records)], Unit: common.UOT_NS, Value: value_f64}
 					ts.Records = append(ts.Records, newrec)
 					ts.Unlock()
+					fresh = append(fresh, newrec)
 				}
 			} else {
 				value_f64, ok := value.(float64)
@@ -233,9 +247,18 @@ func (s *Stream) start(timeseriesStore TimeseriesStore, metadataStore MetadataSt
 				if math.IsInf(value_f64, 0) || math.IsNaN(value_f64) {
 					continue
 				}
+				newrec := &common.TimeseriesReading{Time: timestamps[0], Unit: common.UOT_NS, Value: value_f64}
 				ts.Lock()
-				ts.Records = append(ts.Records, &common.TimeseriesReading{Time: timestamps[0], Value: value_f64})
+				ts.Records = append(ts.Records, newrec)
 				ts.Unlock()
+				fresh = append(fresh, newrec)
+			}
+			if len(fresh) > 0 {
+				ingestedReadings.Add(float64(len(fresh)))
+				streamIngestedReadings.WithLabelValues(ts.UUID.String()).Add(float64(len(fresh)))
+				if s.live != nil {
+					s.live.publishLive(&common.Timeseries{UUID: ts.UUID, SrcURI: msg.URI, Records: fresh})
+				}
 			}
 			//ts.Lock()
 			//if len(ts.Records) > commitCount {
@@ -248,9 +271,6 @@ func (s *Stream) start(timeseriesStore TimeseriesStore, metadataStore MetadataSt
 			//	}
 			//}
 			//ts.Unlock()
-			s.Lock()
-			s.timeseries[msg.URI] = ts
-			s.Unlock()
 
 		}
 	}
@@ -316,3 +336,49 @@ func (s *Stream) getTime(timeThing interface{}) time.Time {
 	}
 	return time.Now()
 }
+
+// starts the goroutine that pushes stream metadata into the timeseries store
+func (s *Stream) startAnnotations(timeseriesStore TimeseriesStore, metadataStore MetadataStore) {
+	go func() {
+		for _ = range time.Tick(annotationTick) {
+			var uuids []common.UUID
+			s.RLock()
+			for _, ts := range s.timeseries {
+				uuids = append(uuids, ts.UUID)
+			}
+			s.RUnlock()
+			for _, uuid := range uuids {
+				if doc := metadataStore.GetDocument(uuid); doc == nil {
+					continue
+				} else if err := timeseriesStore.AddAnnotations(uuid, doc); err != nil {
+					log.Error(errors.Wrapf(err, "Could not write annotations for %s (%p)", uuid, s))
+				}
+			}
+		}
+	}()
+}
+
+// undoes initializeURI for a URI that could not be initialized, so that it is tried again
+func (s *Stream) forget(uri string) {
+	atomic.AddInt64(&currentStreams, -1)
+	s.Lock()
+	delete(s.seenURIs, uri)
+	delete(s.timeseries, uri)
+	s.Unlock()
+}
+
+// Buffers readings from the URI, which must have been initialized, to be committed with the
+// stream's other readings
+func (s *Stream) addReadings(uri string, records []*common.TimeseriesReading) {
+	s.RLock()
+	ts := s.timeseries[uri]
+	s.RUnlock()
+	ts.Lock()
+	ts.Records = append(ts.Records, records...)
+	ts.Unlock()
+	ingestedReadings.Add(float64(len(records)))
+	streamIngestedReadings.WithLabelValues(ts.UUID.String()).Add(float64(len(records)))
+	if s.live != nil {
+		s.live.publishLive(&common.Timeseries{UUID: ts.UUID, SrcURI: uri, Records: records})
+	}
+}
@@ -0,0 +1,282 @@
+package archiver
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/gtfierro/pundat/common"
+	"github.com/pkg/errors"
+	"github.com/satori/go.uuid"
+)
+
+// how long a subscription lasts if the SUBSCRIBE or RENEW query doesn't give a lease
+const defaultSubscriptionLease = 10 * time.Minute
+
+// the longest lease we grant. Subscribers must renew before their lease runs out
+const maxSubscriptionLease = 24 * time.Hour
+
+// how often we drop expired subscriptions and re-evaluate WHERE clauses after streams
+// have been created or their metadata has changed
+const subscriptionRefresh = 5 * time.Second
+
+// the number of batches of incoming readings waiting to be delivered to live
+// subscriptions. Beyond this, batches are dropped rather than holding up ingestion
+const liveBacklog = 10000
+
+// A continuous query (SUBSCRIBE DATA WHERE ...). Readings for the matching streams are
+// pushed to the subscriber as they are committed to the timeseries store
+type subscription struct {
+	id      string
+	vk      string
+	where   *common.Predicate
+	expires time.Time
+	// UUIDs of the streams currently matching the where clause
+	streams map[string]struct{}
+	// sends newly committed readings to the subscriber. Nil until attached
+	deliver func(QueryTimeseriesResult) error
+	// if true, readings are delivered as they arrive from BOSSWAVE rather than once
+	// they are committed to the timeseries store
+	live bool
+}
+
+func (sub *subscription) result() QuerySubscriptionResult {
+	return QuerySubscriptionResult{
+		ID:      sub.id,
+		Expires: sub.expires.UnixNano(),
+		Streams: len(sub.streams),
+	}
+}
+
+type subscriptionManager struct {
+	md   MetadataStore
+	auth Authorizer
+	hide *redactor
+	subs map[string]*subscription
+	// readings waiting to be delivered to live subscriptions
+	live chan *common.Timeseries
+	// set when streams are created or metadata changes, so the where
+	// clauses need to be evaluated again
+	stale bool
+	sync.RWMutex
+}
+
+func newSubscriptionManager(md MetadataStore, auth Authorizer, hide *redactor) *subscriptionManager {
+	sm := &subscriptionManager{
+		md:   md,
+		auth: auth,
+		hide: hide,
+		subs: make(map[string]*subscription),
+		live: make(chan *common.Timeseries, liveBacklog),
+	}
+	go sm.run()
+	go func() {
+		for readings := range sm.live {
+			sm.publish(readings, true)
+		}
+	}()
+	return sm
+}
+
+func (sm *subscriptionManager) run() {
+	for _ = range time.Tick(subscriptionRefresh) {
+		now := time.Now()
+		var refresh []*subscription
+		sm.Lock()
+		for id, sub := range sm.subs {
+			if now.After(sub.expires) {
+				log.Infof("Subscription %s expired", id)
+				delete(sm.subs, id)
+			} else if sm.stale {
+				refresh = append(refresh, sub)
+			}
+		}
+		sm.stale = false
+		sm.Unlock()
+
+		for _, sub := range refresh {
+			if err := sm.resolve(sub); err != nil {
+				log.Error(errors.Wrapf(err, "Could not re-evaluate subscription %s", sub.id))
+			}
+		}
+	}
+}
+
+// evaluates the subscription's where clause, keeping the streams its VK may read
+func (sm *subscriptionManager) resolve(sub *subscription) error {
+	uuids, err := sm.md.GetUUIDs(sub.where)
+	if err != nil {
+		return err
+	}
+	if uuids, err = readableStreams(sm.auth, sm.md, sub.vk, uuids); err != nil {
+		return err
+	}
+	if uuids, err = sm.hide.unprobed(sm.md, sub.vk, sub.where, uuids); err != nil {
+		return err
+	}
+	streams := make(map[string]struct{}, len(uuids))
+	for _, uuid := range uuids {
+		streams[uuid.String()] = struct{}{}
+	}
+	sm.Lock()
+	sub.streams = streams
+	sm.Unlock()
+	return nil
+}
+
+// Creates a subscription for the VK, or renews one of its existing subscriptions if params.ID is set
+func (sm *subscriptionManager) subscribe(vk string, params *common.SubscribeParams) (QuerySubscriptionResult, error) {
+	lease := params.Lease
+	if lease <= 0 {
+		lease = defaultSubscriptionLease
+	} else if lease > maxSubscriptionLease {
+		lease = maxSubscriptionLease
+	}
+
+	if params.ID != "" {
+		sm.Lock()
+		defer sm.Unlock()
+		sub, found := sm.subs[params.ID]
+		if !found || sub.vk != vk {
+			return QuerySubscriptionResult{}, badQuery(errors.Errorf("No subscription %s (it may have expired)", params.ID))
+		}
+		sub.expires = time.Now().Add(lease)
+		return sub.result(), nil
+	}
+
+	sub := &subscription{
+		id:      uuid.NewV4().String(),
+		vk:      vk,
+		where:   params.Where,
+		expires: time.Now().Add(lease),
+	}
+	if err := sm.resolve(sub); err != nil {
+		return QuerySubscriptionResult{}, err
+	}
+	sm.Lock()
+	sm.subs[sub.id] = sub
+	sm.Unlock()
+	log.Infof("New subscription %s (VK=%s) to %d streams", sub.id, vk, len(sub.streams))
+	return sub.result(), nil
+}
+
+// Ends one of the VK's subscriptions
+func (sm *subscriptionManager) unsubscribe(vk string, params *common.UnsubscribeParams) (QuerySubscriptionResult, error) {
+	sm.Lock()
+	defer sm.Unlock()
+	sub, found := sm.subs[params.ID]
+	if !found || sub.vk != vk {
+		return QuerySubscriptionResult{}, badQuery(errors.Errorf("No subscription %s (it may have expired)", params.ID))
+	}
+	delete(sm.subs, params.ID)
+	return QuerySubscriptionResult{ID: params.ID}, nil
+}
+
+// Sets how readings are delivered to a new subscription, and whether it receives them as they
+// arrive (live) or once they are committed. Does nothing if the subscription already has a
+// deliverer (i.e. it was renewed) or no longer exists
+func (sm *subscriptionManager) attach(id string, live bool, deliver func(QueryTimeseriesResult) error) {
+	sm.Lock()
+	defer sm.Unlock()
+	if sub, found := sm.subs[id]; found && sub.deliver == nil {
+		sub.deliver = deliver
+		sub.live = live
+	}
+}
+
+// called when streams are created or metadata changes
+func (sm *subscriptionManager) markStale() {
+	sm.Lock()
+	sm.stale = true
+	sm.Unlock()
+}
+
+// Queues readings that have just arrived for the live subscriptions. Never blocks: if the
+// live subscriptions have fallen too far behind, the readings are dropped
+func (sm *subscriptionManager) publishLive(readings *common.Timeseries) {
+	select {
+	case sm.live <- readings:
+	default:
+		log.Warningf("Live subscriptions are behind; dropped %d readings for %s", len(readings.Records), readings.UUID)
+	}
+}
+
+// Pushes readings to the live or committed-reading subscriptions that include the stream. Each
+// subscriber only receives the readings that fall within the ranges its DOTs grant access to
+func (sm *subscriptionManager) publish(readings *common.Timeseries, live bool) {
+	type subscriber struct {
+		id      string
+		vk      string
+		deliver func(QueryTimeseriesResult) error
+	}
+	var matched []subscriber
+	sm.RLock()
+	for _, sub := range sm.subs {
+		if _, found := sub.streams[readings.UUID.String()]; found && sub.deliver != nil && sub.live == live {
+			matched = append(matched, subscriber{sub.id, sub.vk, sub.deliver})
+		}
+	}
+	sm.RUnlock()
+	if len(matched) == 0 {
+		return
+	}
+
+	uri, err := sm.md.URIFromUUID(readings.UUID)
+	if err != nil {
+		log.Error(errors.Wrapf(err, "Could not resolve URI for subscribed stream %s", readings.UUID))
+		return
+	}
+	for _, sub := range matched {
+		validRanges, err := sm.auth.GetValidRanges(uri, sub.vk)
+		if err != nil {
+			log.Error(errors.Wrapf(err, "Could not get valid ranges for subscription %s", sub.id))
+			continue
+		}
+		visible := []common.Timeseries{{
+			UUID:       readings.UUID,
+			SrcURI:     uri,
+			Generation: readings.Generation,
+		}}
+		for _, rdg := range readings.Records {
+			for _, rng := range validRanges.Ranges {
+				if rng.Contains(rdg.Time) {
+					visible[0].Records = append(visible[0].Records, rdg)
+					break
+				}
+			}
+		}
+		if len(visible[0].Records) == 0 {
+			continue
+		}
+		if err := sub.deliver(timeseriesResult(0, visible, nil, "", common.TimeFormat{})); err != nil {
+			log.Error(errors.Wrapf(err, "Could not deliver readings for subscription %s", sub.id))
+		}
+	}
+}
+
+// Wraps a TimeseriesStore so that the subscription manager hears about new streams
+// and committed readings
+type notifyingStore struct {
+	TimeseriesStore
+	subs *subscriptionManager
+}
+
+func (s *notifyingStore) RegisterStream(uuid common.UUID, uri, name, unit string) error {
+	err := s.TimeseriesStore.RegisterStream(uuid, uri, name, unit)
+	if err == nil {
+		s.subs.markStale()
+	}
+	return err
+}
+
+func (s *notifyingStore) AddReadings(readings *common.Timeseries) error {
+	err := s.TimeseriesStore.AddReadings(readings)
+	if err == nil {
+		go s.subs.publish(readings, false)
+	}
+	return err
+}
+
+func (s *notifyingStore) WithContext(ctx context.Context) TimeseriesStore {
+	return &notifyingStore{TimeseriesStore: s.TimeseriesStore.WithContext(ctx), subs: s.subs}
+}
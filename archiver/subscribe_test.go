@@ -0,0 +1,93 @@
+package archiver
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gtfierro/pundat/common"
+)
+
+func TestSubscribeRenewUnsubscribe(t *testing.T) {
+	a := testArchiver()
+	sm := newSubscriptionManager(a.MD, a.authority, nil)
+
+	res, err := sm.subscribe("vk", &common.SubscribeParams{Lease: time.Minute})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// vk can only read ns/public/*
+	if res.Streams != 1 {
+		t.Errorf("Expected the subscription to include only the readable stream, got %d", res.Streams)
+	}
+	if expires := time.Unix(0, res.Expires); time.Until(expires) > time.Minute {
+		t.Errorf("Expected a lease of at most a minute, got one expiring %s", expires)
+	}
+
+	renewed, err := sm.subscribe("vk", &common.SubscribeParams{ID: res.ID, Lease: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if renewed.ID != res.ID || time.Until(time.Unix(0, renewed.Expires)) < 59*time.Minute {
+		t.Errorf("Expected the renewal to extend the lease to an hour, got %+v", renewed)
+	}
+	if _, err = sm.subscribe("vk", &common.SubscribeParams{ID: res.ID, Lease: 48 * time.Hour}); err != nil {
+		t.Fatal(err)
+	}
+	if lease := time.Until(sm.subs[res.ID].expires); lease > maxSubscriptionLease {
+		t.Errorf("Expected the lease to be capped at %s, got %s", maxSubscriptionLease, lease)
+	}
+
+	// only the VK that made the subscription can renew or end it
+	if _, err = sm.subscribe("other", &common.SubscribeParams{ID: res.ID}); err == nil {
+		t.Error("Expected another VK's renewal to fail")
+	}
+	if _, err = sm.unsubscribe("other", &common.UnsubscribeParams{ID: res.ID}); err == nil {
+		t.Error("Expected another VK's unsubscribe to fail")
+	}
+
+	if _, err = sm.unsubscribe("vk", &common.UnsubscribeParams{ID: res.ID}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = sm.subscribe("vk", &common.SubscribeParams{ID: res.ID}); err == nil {
+		t.Error("Expected renewing an ended subscription to fail")
+	}
+	if _, err = sm.unsubscribe("vk", &common.UnsubscribeParams{ID: res.ID}); err == nil {
+		t.Error("Expected ending a subscription twice to fail")
+	}
+}
+
+func TestPublishMasksReadings(t *testing.T) {
+	a := testArchiver()
+	sm := newSubscriptionManager(a.MD, a.authority, nil)
+	res, err := sm.subscribe("vk", &common.SubscribeParams{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	delivered := make(chan QueryTimeseriesResult, 1)
+	sm.attach(res.ID, false, func(readings QueryTimeseriesResult) error {
+		delivered <- readings
+		return nil
+	})
+
+	readings, _ := a.TS.GetDataUUID(uuidA, 0, 100, common.UOT_NS)
+	sm.publish(&readings, false)
+	select {
+	case result := <-delivered:
+		if len(result.Data) != 1 || len(result.Data[0].Values) != 2 {
+			t.Fatalf("Expected only the readings in [10, 20], got %+v", result.Data)
+		}
+	default:
+		t.Fatal("Expected readings for the subscribed stream to be delivered")
+	}
+
+	// readings the subscription's VK can't read, or live readings, aren't delivered
+	readings, _ = a.TS.GetDataUUID(uuidB, 0, 100, common.UOT_NS)
+	sm.publish(&readings, false)
+	readings, _ = a.TS.GetDataUUID(uuidA, 0, 100, common.UOT_NS)
+	sm.publish(&readings, true)
+	select {
+	case result := <-delivered:
+		t.Errorf("Expected nothing else to be delivered, got %+v", result)
+	default:
+	}
+}
@@ -1,14 +1,21 @@
 package archiver
 
 import (
+	"time"
+
 	"github.com/gtfierro/pundat/common"
 	bw2 "github.com/immesys/bw2bind"
 )
 
-func POsFromMetadataGroup(nonce uint32, groups []common.MetadataGroup) bw2.PayloadObject {
+func POsFromMetadataGroup(nonce uint32, groups []common.MetadataGroup, cursor string) bw2.PayloadObject {
+	return metadataResult(nonce, groups, cursor).ToMsgPackBW()
+}
+
+func metadataResult(nonce uint32, groups []common.MetadataGroup, cursor string) QueryMetadataResult {
 	mdRes := QueryMetadataResult{
-		Nonce: nonce,
-		Data:  []KeyValueMetadata{},
+		Nonce:  nonce,
+		Data:   []KeyValueMetadata{},
+		Cursor: cursor,
 	}
 	for _, group := range groups {
 		group.RLock()
@@ -23,15 +30,28 @@ func POsFromMetadataGroup(nonce uint32, groups []common.MetadataGroup) bw2.Paylo
 		mdRes.Data = append(mdRes.Data, md)
 		group.RUnlock()
 	}
-	return mdRes.ToMsgPackBW()
+	return mdRes
+}
+
+// Returns the timeseries and statistics results as a sequence of payload objects, each
+// holding at most maxReadings readings, to be sent as separate messages in order
+func POsFromTimeseriesGroup(nonce uint32, tsGroups []common.Timeseries, statsGroups []common.StatisticTimeseries, cursor string, format common.TimeFormat, maxReadings int) []bw2.PayloadObject {
+	var pos []bw2.PayloadObject
+	for _, chunk := range timeseriesResult(nonce, tsGroups, statsGroups, cursor, format).Split(maxReadings) {
+		pos = append(pos, chunk.ToMsgPackBW())
+	}
+	return pos
 }
 
-func POsFromTimeseriesGroup(nonce uint32, tsGroups []common.Timeseries, statsGroups []common.StatisticTimeseries) bw2.PayloadObject {
+func timeseriesResult(nonce uint32, tsGroups []common.Timeseries, statsGroups []common.StatisticTimeseries, cursor string, format common.TimeFormat) QueryTimeseriesResult {
 	tsRes := QueryTimeseriesResult{
-		Nonce: nonce,
-		Data:  []Timeseries{},
-		Stats: []Statistics{},
+		Nonce:    nonce,
+		Data:     []Timeseries{},
+		Stats:    []Statistics{},
+		Cursor:   cursor,
+		Timezone: format.Timezone,
 	}
+	loc := format.Location()
 	for _, group := range tsGroups {
 		ts := Timeseries{
 			UUID:       group.UUID.String(),
@@ -43,6 +63,9 @@ func POsFromTimeseriesGroup(nonce uint32, tsGroups []common.Timeseries, statsGro
 		for _, rdg := range group.Records {
 			ts.Times = append(ts.Times, common.TimeAsUnit(rdg.Time, rdg.Unit))
 			ts.Values = append(ts.Values, rdg.Value)
+			if format.RFC3339 {
+				ts.Timestamps = append(ts.Timestamps, rdg.Time.In(loc).Format(time.RFC3339Nano))
+			}
 		}
 		tsRes.Data = append(tsRes.Data, ts)
 	}
@@ -62,13 +85,46 @@ func POsFromTimeseriesGroup(nonce uint32, tsGroups []common.Timeseries, statsGro
 			ts.Min = append(ts.Min, rdg.Min)
 			ts.Mean = append(ts.Mean, rdg.Mean)
 			ts.Max = append(ts.Max, rdg.Max)
+			if format.RFC3339 {
+				ts.Timestamps = append(ts.Timestamps, rdg.Time.In(loc).Format(time.RFC3339Nano))
+			}
 		}
 		tsRes.Stats = append(tsRes.Stats, ts)
 	}
-	return tsRes.ToMsgPackBW()
+	tsRes.Final = true
+	return tsRes
+}
+
+func POsFromDistributions(nonce uint32, dists []common.Distribution) bw2.PayloadObject {
+	return distributionResult(nonce, dists).ToMsgPackBW()
+}
+
+func distributionResult(nonce uint32, dists []common.Distribution) QueryDistributionResult {
+	distRes := QueryDistributionResult{
+		Nonce: nonce,
+		Data:  []Distribution{},
+	}
+	for _, dist := range dists {
+		distRes.Data = append(distRes.Data, Distribution{
+			UUID:        dist.UUID.String(),
+			Generation:  dist.Generation,
+			Count:       dist.Count,
+			Min:         dist.Min,
+			Max:         dist.Max,
+			Percentiles: dist.Percentiles,
+			Values:      dist.Values,
+			Edges:       dist.Edges,
+			Counts:      dist.Counts,
+		})
+	}
+	return distRes
 }
 
 func POsFromChangedGroup(nonce uint32, groups []common.ChangedRange) bw2.PayloadObject {
+	return changedResult(nonce, groups).ToMsgPackBW()
+}
+
+func changedResult(nonce uint32, groups []common.ChangedRange) QueryChangedResult {
 	crRes := QueryChangedResult{
 		Nonce:   nonce,
 		Changed: []ChangedRange{},
@@ -84,5 +140,5 @@ func POsFromChangedGroup(nonce uint32, groups []common.ChangedRange) bw2.Payload
 			crRes.Changed = append(crRes.Changed, cr)
 		}
 	}
-	return crRes.ToMsgPackBW()
+	return crRes
 }
@@ -1,6 +1,7 @@
 package archiver
 
 import (
+	"github.com/gtfierro/pundat/common"
 	"github.com/pkg/errors"
 	bw2 "github.com/immesys/bw2bind"
 	"strings"
@@ -60,21 +61,17 @@ func (m *SynchronizedArchiveRequestMap) Set(uri string, req *ArchiveRequest) {
 	if list, found := m.values[uri]; !found {
 		list = new(ArchiveRequestList)
 		list.AddRequest(req)
+		m.values[uri] = list
 	} else {
 		list.AddRequest(req)
 	}
 }
 
+// replaces the archive requests for the URI
 func (m *SynchronizedArchiveRequestMap) SetList(uri string, req *ArchiveRequestList) {
 	m.Lock()
 	defer m.Unlock()
-	if list, found := m.values[uri]; !found {
-		m.values[uri] = req
-	} else {
-		for _, r := range *req {
-			list.AddRequest(r)
-		}
-	}
+	m.values[uri] = req
 }
 
 func (m *SynchronizedArchiveRequestMap) Del(uri string) {
@@ -91,6 +88,39 @@ func (m *SynchronizedArchiveRequestMap) RemoveEntry(uri string, req *ArchiveRequ
 	}
 }
 
+// the number of archive requests in the map
+func (m *SynchronizedArchiveRequestMap) Count() (count int) {
+	m.RLock()
+	defer m.RUnlock()
+	for _, list := range m.values {
+		count += len(*list)
+	}
+	return
+}
+
+// The streams that archive requests (and line protocol ingestion) write to, so that other
+// writers can keep out of them. Streams are added as their first readings arrive
+type streamSet struct {
+	uuids map[string]bool
+	sync.RWMutex
+}
+
+func newStreamSet() *streamSet {
+	return &streamSet{uuids: make(map[string]bool)}
+}
+
+func (set *streamSet) add(uuid common.UUID) {
+	set.Lock()
+	defer set.Unlock()
+	set.uuids[uuid.String()] = true
+}
+
+func (set *streamSet) has(uuid common.UUID) bool {
+	set.RLock()
+	defer set.RUnlock()
+	return set.uuids[uuid.String()]
+}
+
 func compareStringSliceAsSet(s1, s2 []string) bool {
 	var (
 		found bool
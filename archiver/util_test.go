@@ -80,3 +80,30 @@ func TestMatchIgnoreLastN(t *testing.T) {
 		}
 	}
 }
+
+func TestArchiveRequestMapCount(t *testing.T) {
+	m := NewSynchronizedArchiveRequestMap()
+	temp := &ArchiveRequest{URI: "ns/a/*", Name: "temp"}
+	hum := &ArchiveRequest{URI: "ns/a/*", Name: "hum"}
+	m.Set("ns/a", temp)
+	m.Set("ns/a", temp)
+	m.Set("ns/a", hum)
+	m.Set("ns/b", &ArchiveRequest{URI: "ns/b/*", Name: "temp"})
+	if count := m.Count(); count != 3 {
+		t.Errorf("Expected 3 archive requests, got %d", count)
+	}
+
+	m.RemoveEntry("ns/a", hum)
+	if count := m.Count(); count != 2 {
+		t.Errorf("Expected 2 archive requests after removing one, got %d", count)
+	}
+	// the list replaces the requests for ns/b
+	m.SetList("ns/b", &ArchiveRequestList{hum, temp})
+	if count := m.Count(); count != 3 {
+		t.Errorf("Expected 3 archive requests after replacing a list, got %d", count)
+	}
+	m.Del("ns/b")
+	if count := m.Count(); count != 1 {
+		t.Errorf("Expected 1 archive request after deleting a URI, got %d", count)
+	}
+}
@@ -20,6 +20,8 @@ type viewManager struct {
 	bwcfg      BWConfig
 	store      MetadataStore
 	ts         TimeseriesStore
+	live       *subscriptionManager
+	archived   *streamSet
 	vk         string
 	bw2address string
 	bw2entity  string
@@ -34,12 +36,14 @@ type viewManager struct {
 	requestURIs      *SynchronizedArchiveRequestMap
 }
 
-func newViewManager(client *bw2.BW2Client, vk string, cfg BWConfig, store MetadataStore, ts TimeseriesStore, bw2address, bw2entity string) *viewManager {
+func newViewManager(client *bw2.BW2Client, vk string, cfg BWConfig, store MetadataStore, ts TimeseriesStore, live *subscriptionManager, archived *streamSet, bw2address, bw2entity string) *viewManager {
 	vm := &viewManager{
 		client:           client,
 		bwcfg:            cfg,
 		store:            store,
 		ts:               ts,
+		live:             live,
+		archived:         archived,
 		vk:               vk,
 		bw2address:       bw2address,
 		bw2entity:        bw2entity,
@@ -155,7 +159,7 @@ func (vm *viewManager) HandleArchiveRequest(ctx context.Context, request *Archiv
 	s2 := &Stream{}
 	s2.buffer = make(chan *bw2.SimpleMessage, 10000)
 	s2.seenURIs = make(map[string]common.UUID)
-	s2.timeseries = make(map[string]common.Timeseries)
+	s2.timeseries = make(map[string]*common.Timeseries)
 	s2.subscribeURI = request.URI
 	s2.name = request.Name
 	s2.unit = request.Unit
@@ -172,6 +176,8 @@ func (vm *viewManager) HandleArchiveRequest(ctx context.Context, request *Archiv
 	}
 	s2.urimatch = re
 	s2.urireplace = request.URIReplace
+	s2.live = vm.live
+	s2.archived = vm.archived
 	ns := strings.Split(s2.subscribeURI, "/")[0]
 
 	// create a client for this archive request
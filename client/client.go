@@ -58,10 +58,66 @@ type PundatClient struct {
 	vk      string
 	uri     string
 	c       chan *bw.SimpleMessage
-	waiting map[uint32]chan *bw.SimpleMessage
+	waiting map[uint32]*waiter
 	l       sync.RWMutex
 }
 
+// a query waiting for replies. A query can receive several replies when the
+// archiver splits a large result into chunks
+type waiter struct {
+	replies chan *bw.SimpleMessage
+	// closed when the query stops listening for replies
+	done chan struct{}
+	// replies the query hasn't taken yet. These are queued per query so that a
+	// query that is slow to take its replies doesn't hold up the others
+	queue []*bw.SimpleMessage
+	ready chan struct{}
+	sync.Mutex
+}
+
+func newWaiter(replies chan *bw.SimpleMessage) *waiter {
+	w := &waiter{replies: replies, done: make(chan struct{}), ready: make(chan struct{}, 1)}
+	go w.forward()
+	return w
+}
+
+// queues a reply for the query. Never blocks
+func (w *waiter) deliver(msg *bw.SimpleMessage) {
+	w.Lock()
+	w.queue = append(w.queue, msg)
+	w.Unlock()
+	select {
+	case w.ready <- struct{}{}:
+	default:
+	}
+}
+
+// hands the queued replies to the query, in order, until it stops listening
+func (w *waiter) forward() {
+	for {
+		select {
+		case <-w.done:
+			return
+		case <-w.ready:
+		}
+		for {
+			w.Lock()
+			if len(w.queue) == 0 {
+				w.Unlock()
+				break
+			}
+			msg := w.queue[0]
+			w.queue = w.queue[1:]
+			w.Unlock()
+			select {
+			case w.replies <- msg:
+			case <-w.done:
+				return
+			}
+		}
+	}
+}
+
 // Create a new API isntance w/ the given client and VerifyingKey.
 // The verifying key is returned by any of the BW2Client.SetEntity* calls
 // URI should be the base of the giles service
@@ -70,7 +126,7 @@ func NewPundatClient(client *bw.BW2Client, vk string, uri string) (*PundatClient
 		client:  client,
 		vk:      vk,
 		uri:     strings.TrimSuffix(uri, "/") + "/s.giles/_/i.archiver",
-		waiting: make(map[uint32]chan *bw.SimpleMessage),
+		waiting: make(map[uint32]*waiter),
 	}
 	c, err := pc.client.Subscribe(&bw.SubscribeParams{
 		URI: pc.uri + fmt.Sprintf("/signal/%s,queries", pc.vk[:len(pc.vk)-1]),
@@ -88,16 +144,13 @@ func NewPundatClient(client *bw.BW2Client, vk string, uri string) (*PundatClient
 				log.Println(fmt.Sprintf("Error fetching nonce (%v)", err))
 				continue
 			}
-			pc.l.Lock()
-			if replyChan, found := pc.waiting[nonce]; found {
-				// throw it in if channel is listening, else drop it
-				select {
-				case replyChan <- msg:
-					delete(pc.waiting, nonce)
-				default:
-				}
+			pc.l.RLock()
+			w, found := pc.waiting[nonce]
+			pc.l.RUnlock()
+			if !found {
+				continue
 			}
-			pc.l.Unlock()
+			w.deliver(msg)
 		}
 	}()
 
@@ -115,7 +168,18 @@ func NewPundatClientFromConfig(entity, agent, uri string) (*PundatClient, error)
 
 func (pc *PundatClient) markWaitFor(nonce uint32, replyChan chan *bw.SimpleMessage) {
 	pc.l.Lock()
-	pc.waiting[nonce] = replyChan
+	pc.waiting[nonce] = newWaiter(replyChan)
+	pc.l.Unlock()
+}
+
+// stops delivering replies for the nonce. Must be called once the query
+// started by publishQuery has received all the replies it needs
+func (pc *PundatClient) stopWaiting(nonce uint32) {
+	pc.l.Lock()
+	if w, found := pc.waiting[nonce]; found {
+		close(w.done)
+		delete(pc.waiting, nonce)
+	}
 	pc.l.Unlock()
 }
 
@@ -127,30 +191,15 @@ func (pc *PundatClient) Query(query string, timeout int) (mdRes messages.QueryMe
 		mdfound  bool
 		chfound  bool
 		errfound bool
+		chunk    messages.QueryTimeseriesResult
+		// large timeseries results arrive in several chunks
+		chunks = newChunkAssembler()
 	)
-	nonce := rand.Uint32()
-	msg := messages.KeyValueQuery{
-		Query: query,
-		Nonce: nonce,
-	}
-
-	var timeoutChan <-chan time.Time
-
-	if timeout > 0 {
-		timeoutChan = time.After(time.Duration(timeout) * time.Second)
-	}
-
-	replyChan := make(chan *bw.SimpleMessage, 1)
-	pc.markWaitFor(nonce, replyChan)
-
-	err = pc.client.Publish(&bw.PublishParams{
-		URI:            pc.uri + "/slot/query",
-		PayloadObjects: []bw.PayloadObject{msg.ToMsgPackBW()},
-	})
+	nonce, replyChan, timeoutChan, err := pc.publishQuery(query, timeout)
 	if err != nil {
-		err = fmt.Errorf("Could not publish (%v)", err)
 		return
 	}
+	defer pc.stopWaiting(nonce)
 	for {
 		select {
 		case <-timeoutChan:
@@ -161,29 +210,116 @@ func (pc *PundatClient) Query(query string, timeout int) (mdRes messages.QueryMe
 			if errfound {
 				return
 			}
-			tsfound, tsRes, err = getTimeseries(nonce, msg)
+			tsfound, chunk, err = getTimeseries(nonce, msg)
 			if err != nil {
 				return
 			}
-			mdfound, mdRes, err = getMetadata(nonce, msg)
-			if err != nil {
+			if mdfound, mdRes, err = getMetadata(nonce, msg); err != nil || mdfound {
 				return
 			}
-			chfound, chRes, err = getChanged(nonce, msg)
-			if err != nil {
+			if chfound, chRes, err = getChanged(nonce, msg); err != nil || chfound {
 				return
 			}
-			if tsfound || mdfound || chfound {
-				if err != nil {
+			if tsfound {
+				for _, ready := range chunks.add(chunk) {
+					tsRes.Append(ready)
+				}
+				if chunks.done {
 					return
 				}
-				return
+				// the timeout applies to each chunk
+				if timeout > 0 {
+					timeoutChan = time.After(time.Duration(timeout) * time.Second)
+				}
 			}
 		}
 	}
 	return
 }
 
+// Synchronously asks the archiver for the plan it would use to evaluate the query
+// (see EXPLAIN). Timeout behaves as in Query
+func (pc *PundatClient) Explain(query string, timeout int) (plan messages.QueryPlan, err error) {
+	if !strings.HasPrefix(strings.ToLower(strings.TrimSpace(query)), "explain") {
+		query = "explain " + query
+	}
+	nonce, replyChan, timeoutChan, err := pc.publishQuery(query, timeout)
+	if err != nil {
+		return
+	}
+	defer pc.stopWaiting(nonce)
+	for {
+		select {
+		case <-timeoutChan:
+			err = ErrNoResponse
+			return
+		case msg := <-replyChan:
+			if errfound, err := getError(nonce, msg); errfound {
+				return plan, err
+			}
+			found, plan, err := getPlan(nonce, msg)
+			if found || err != nil {
+				return plan, err
+			}
+		}
+	}
+}
+
+// Synchronously evaluates a PERCENTILE or HISTOGRAM query. Timeout behaves as in Query
+func (pc *PundatClient) Distribution(query string, timeout int) (distRes messages.QueryDistributionResult, err error) {
+	nonce, replyChan, timeoutChan, err := pc.publishQuery(query, timeout)
+	if err != nil {
+		return
+	}
+	defer pc.stopWaiting(nonce)
+	for {
+		select {
+		case <-timeoutChan:
+			err = ErrNoResponse
+			return
+		case msg := <-replyChan:
+			if errfound, err := getError(nonce, msg); errfound {
+				return distRes, err
+			}
+			found, distRes, err := getDistribution(nonce, msg)
+			if found || err != nil {
+				return distRes, err
+			}
+			// the archiver replies with an empty metadata result if no streams matched
+			if found, _, err := getMetadata(nonce, msg); found || err != nil {
+				return distRes, err
+			}
+		}
+	}
+}
+
+// sends the query to the archiver, returning the nonce and the channels on which to wait
+// for the reply and for the timeout (which is nil if timeout <= 0)
+func (pc *PundatClient) publishQuery(query string, timeout int) (nonce uint32, replyChan chan *bw.SimpleMessage, timeoutChan <-chan time.Time, err error) {
+	nonce = rand.Uint32()
+	msg := messages.KeyValueQuery{
+		Query: query,
+		Nonce: nonce,
+	}
+
+	if timeout > 0 {
+		timeoutChan = time.After(time.Duration(timeout) * time.Second)
+	}
+
+	replyChan = make(chan *bw.SimpleMessage, 1)
+	pc.markWaitFor(nonce, replyChan)
+
+	err = pc.client.Publish(&bw.PublishParams{
+		URI:            pc.uri + "/slot/query",
+		PayloadObjects: []bw.PayloadObject{msg.ToMsgPackBW()},
+	})
+	if err != nil {
+		pc.stopWaiting(nonce)
+		err = fmt.Errorf("Could not publish (%v)", err)
+	}
+	return
+}
+
 // Extracts QueryError from Giles response. Returns false if no related message was found
 func getError(nonce uint32, msg *bw.SimpleMessage) (bool, error) {
 	var (
@@ -256,6 +392,42 @@ func getChanged(nonce uint32, msg *bw.SimpleMessage) (bool, messages.QueryChange
 	return false, changedResults, nil
 }
 
+// Extracts the QueryPlan from Giles response. Returns false if no related message was found
+func getPlan(nonce uint32, msg *bw.SimpleMessage) (bool, messages.QueryPlan, error) {
+	var (
+		po   bw.PayloadObject
+		plan messages.QueryPlan
+	)
+	if po = msg.GetOnePODF(messages.GilesQueryExplainPIDString); po != nil {
+		if err := po.(bw.MsgPackPayloadObject).ValueInto(&plan); err != nil {
+			return false, plan, err
+		}
+		if plan.Nonce != nonce {
+			return false, plan, nil
+		}
+		return true, plan, nil
+	}
+	return false, plan, nil
+}
+
+// Extracts the percentiles and histograms from Giles response. Returns false if no related message was found
+func getDistribution(nonce uint32, msg *bw.SimpleMessage) (bool, messages.QueryDistributionResult, error) {
+	var (
+		po          bw.PayloadObject
+		distResults messages.QueryDistributionResult
+	)
+	if po = msg.GetOnePODF(messages.GilesQueryDistributionPIDString); po != nil {
+		if err := po.(bw.MsgPackPayloadObject).ValueInto(&distResults); err != nil {
+			return false, distResults, err
+		}
+		if distResults.Nonce != nonce {
+			return false, distResults, nil
+		}
+		return true, distResults, nil
+	}
+	return false, distResults, nil
+}
+
 func getNonce(msg *bw.SimpleMessage) (uint32, error) {
 	var (
 		po                bw.PayloadObject
@@ -263,6 +435,9 @@ func getNonce(msg *bw.SimpleMessage) (uint32, error) {
 		timeseriesResults messages.QueryTimeseriesResult
 		metadataResults   messages.QueryMetadataResult
 		queryError        messages.QueryError
+		plan              messages.QueryPlan
+		distResults       messages.QueryDistributionResult
+		subResults        messages.QuerySubscriptionResult
 	)
 	if po = msg.GetOnePODF(bw.PODFGilesQueryError); po != nil {
 		err := po.(bw.MsgPackPayloadObject).ValueInto(&queryError)
@@ -280,5 +455,17 @@ func getNonce(msg *bw.SimpleMessage) (uint32, error) {
 		err := po.(bw.MsgPackPayloadObject).ValueInto(&changedResults)
 		return changedResults.Nonce, err
 	}
+	if po = msg.GetOnePODF(messages.GilesQueryExplainPIDString); po != nil {
+		err := po.(bw.MsgPackPayloadObject).ValueInto(&plan)
+		return plan.Nonce, err
+	}
+	if po = msg.GetOnePODF(messages.GilesQueryDistributionPIDString); po != nil {
+		err := po.(bw.MsgPackPayloadObject).ValueInto(&distResults)
+		return distResults.Nonce, err
+	}
+	if po = msg.GetOnePODF(messages.GilesQuerySubscriptionPIDString); po != nil {
+		err := po.(bw.MsgPackPayloadObject).ValueInto(&subResults)
+		return subResults.Nonce, err
+	}
 	return 0, fmt.Errorf("no nonce found?!")
 }
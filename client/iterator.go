@@ -0,0 +1,98 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	messages "github.com/gtfierro/pundat/archiver"
+	"github.com/gtfierro/pundat/querylang"
+)
+
+// Fetches the results of a query one page at a time. The archiver returns a
+// cursor token with each page that is truncated by a LIMIT clause; the iterator
+// sends that token back in a CURSOR clause to fetch the next page. The query
+// can't have an OFFSET clause, because the cursor already records where each
+// page starts.
+//
+//    it := pc.Iterate("select * where has uuid limit 100;", 10)
+//    for it.Next() {
+//    	fmt.Println(it.Metadata())
+//    }
+//    if err := it.Err(); err != nil {
+//    	log.Fatal(err)
+//    }
+type QueryIterator struct {
+	pc      *PundatClient
+	query   string
+	timeout int
+	cursor  string
+	done    bool
+	err     error
+	md      messages.QueryMetadataResult
+	ts      messages.QueryTimeseriesResult
+	ch      messages.QueryChangedResult
+}
+
+// Returns an iterator over the pages of results for the given query. No query is
+// sent until the first call to Next(). Timeout (in seconds) applies to each page
+// as it does in Query()
+func (pc *PundatClient) Iterate(query string, timeout int) *QueryIterator {
+	return &QueryIterator{
+		pc:      pc,
+		query:   strings.TrimSuffix(strings.TrimSpace(query), ";"),
+		timeout: timeout,
+	}
+}
+
+// Fetches the next page of results. Returns false when there are no more pages
+// or when an error occurs; check Err() to tell the difference
+func (it *QueryIterator) Next() bool {
+	if it.done {
+		return false
+	}
+	query := it.query
+	if it.cursor == "" {
+		// the grammar has no OFFSET ... CURSOR, so the second page couldn't be fetched
+		if parsed := querylang.Parse(query); parsed.Err == nil && parsed.Page.Offset > 0 {
+			it.err = errors.New("Cannot iterate over a query with an OFFSET clause")
+			it.done = true
+			return false
+		}
+	} else {
+		query += fmt.Sprintf(" cursor \"%s\"", it.cursor)
+	}
+	it.md, it.ts, it.ch, it.err = it.pc.Query(query+";", it.timeout)
+	if it.err != nil {
+		it.done = true
+		return false
+	}
+	// the archiver only places the cursor on one of the results
+	if it.md.Cursor != "" {
+		it.cursor = it.md.Cursor
+	} else {
+		it.cursor = it.ts.Cursor
+	}
+	it.done = it.cursor == ""
+	return true
+}
+
+// The metadata results of the current page
+func (it *QueryIterator) Metadata() messages.QueryMetadataResult {
+	return it.md
+}
+
+// The timeseries and statistics results of the current page
+func (it *QueryIterator) Timeseries() messages.QueryTimeseriesResult {
+	return it.ts
+}
+
+// The changed ranges results of the current page
+func (it *QueryIterator) Changed() messages.QueryChangedResult {
+	return it.ch
+}
+
+// Returns the error (if any) that ended the iteration
+func (it *QueryIterator) Err() error {
+	return it.err
+}
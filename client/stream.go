@@ -0,0 +1,105 @@
+package client
+
+import (
+	"time"
+
+	messages "github.com/gtfierro/pundat/archiver"
+)
+
+// Puts the chunks of a timeseries result back in order. The archiver numbers the
+// chunks of a result from 0 and marks the last one as final, but BOSSWAVE does not
+// guarantee they arrive in order. A result from an archiver that doesn't send chunks
+// is a single message, so it is final
+type chunkAssembler struct {
+	next    uint32
+	pending map[uint32]messages.QueryTimeseriesResult
+	// true once the final chunk has been returned by add
+	done bool
+}
+
+func newChunkAssembler() *chunkAssembler {
+	return &chunkAssembler{pending: make(map[uint32]messages.QueryTimeseriesResult)}
+}
+
+// Adds a received chunk, returning the chunks (in order) that are now ready
+func (ca *chunkAssembler) add(chunk messages.QueryTimeseriesResult) (ready []messages.QueryTimeseriesResult) {
+	if !chunk.Chunked {
+		chunk.Seqno, chunk.Final = ca.next, true
+	}
+	ca.pending[chunk.Seqno] = chunk
+	for !ca.done {
+		next, found := ca.pending[ca.next]
+		if !found {
+			break
+		}
+		delete(ca.pending, ca.next)
+		ready = append(ready, next)
+		ca.next++
+		ca.done = next.Final
+	}
+	return
+}
+
+// Sends a data query and delivers the timeseries results as they arrive, one chunk at a
+// time and in order, so that large results can be processed incrementally. Consecutive
+// chunks may contain readings from the same stream. Both channels are closed when the
+// result is complete; any error (including ErrNoResponse if no chunk arrives within
+// the timeout, in seconds) is sent on the error channel first.
+//
+//    chunks, errc := pc.StreamQuery("select data in (now -1y, now) where uuid = 'abc';", 10)
+//    for chunk := range chunks {
+//    	process(chunk)
+//    }
+//    if err := <-errc; err != nil {
+//    	log.Fatal(err)
+//    }
+func (pc *PundatClient) StreamQuery(query string, timeout int) (<-chan messages.QueryTimeseriesResult, <-chan error) {
+	var (
+		results = make(chan messages.QueryTimeseriesResult)
+		errc    = make(chan error, 1)
+	)
+	go func() {
+		defer close(errc)
+		defer close(results)
+		nonce, replyChan, timeoutChan, err := pc.publishQuery(query, timeout)
+		if err != nil {
+			errc <- err
+			return
+		}
+		defer pc.stopWaiting(nonce)
+
+		chunks := newChunkAssembler()
+		for !chunks.done {
+			select {
+			case <-timeoutChan:
+				errc <- ErrNoResponse
+				return
+			case msg := <-replyChan:
+				if errfound, err := getError(nonce, msg); errfound {
+					errc <- err
+					return
+				}
+				found, chunk, err := getTimeseries(nonce, msg)
+				if err != nil {
+					errc <- err
+					return
+				}
+				if !found {
+					// the archiver replies with an empty metadata result if there is no data
+					if found, _, err := getMetadata(nonce, msg); found || err != nil {
+						errc <- err
+						return
+					}
+					continue
+				}
+				for _, ready := range chunks.add(chunk) {
+					results <- ready
+				}
+				if timeout > 0 {
+					timeoutChan = time.After(time.Duration(timeout) * time.Second)
+				}
+			}
+		}
+	}()
+	return results, errc
+}
@@ -0,0 +1,58 @@
+package client
+
+import (
+	"testing"
+	"time"
+
+	messages "github.com/gtfierro/pundat/archiver"
+	bw "gopkg.in/immesys/bw2bind.v5"
+)
+
+func TestChunkAssemblerOrdersChunks(t *testing.T) {
+	ca := newChunkAssembler()
+	chunk := func(seqno uint32, final bool) messages.QueryTimeseriesResult {
+		return messages.QueryTimeseriesResult{Seqno: seqno, Final: final, Chunked: true}
+	}
+	if ready := ca.add(chunk(1, false)); len(ready) != 0 {
+		t.Errorf("Expected chunk 1 to wait for chunk 0, got %v", ready)
+	}
+	if ready := ca.add(chunk(2, true)); len(ready) != 0 || ca.done {
+		t.Errorf("Expected the final chunk to wait for chunk 0, got %v", ready)
+	}
+	ready := ca.add(chunk(0, false))
+	if len(ready) != 3 || ready[0].Seqno != 0 || ready[1].Seqno != 1 || ready[2].Seqno != 2 || !ca.done {
+		t.Errorf("Expected chunks 0, 1 and 2 once chunk 0 arrived, got %v", ready)
+	}
+}
+
+// older archivers send the whole result in one message without the chunk fields
+func TestChunkAssemblerAcceptsUnchunkedResults(t *testing.T) {
+	ca := newChunkAssembler()
+	if ready := ca.add(messages.QueryTimeseriesResult{Nonce: 1}); len(ready) != 1 || !ca.done {
+		t.Errorf("Expected a result without chunk fields to be complete, got %v (done %v)", ready, ca.done)
+	}
+}
+
+// a query that doesn't take its replies must not hold up the delivery of replies to others
+func TestWaiterDoesNotBlockDelivery(t *testing.T) {
+	slow := newWaiter(make(chan *bw.SimpleMessage))
+	defer close(slow.done)
+	delivered := make(chan struct{})
+	go func() {
+		for i := 0; i < 100; i++ {
+			slow.deliver(&bw.SimpleMessage{From: string(rune('a' + i%26))})
+		}
+		close(delivered)
+	}()
+	select {
+	case <-delivered:
+	case <-time.After(time.Second):
+		t.Fatal("Delivering replies to a query that isn't reading them blocked")
+	}
+	// the replies are still handed over, in order
+	for i := 0; i < 100; i++ {
+		if msg := <-slow.replies; msg.From != string(rune('a'+i%26)) {
+			t.Fatalf("Expected reply %d to be %s, got %s", i, string(rune('a'+i%26)), msg.From)
+		}
+	}
+}
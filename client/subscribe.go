@@ -0,0 +1,230 @@
+package client
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	messages "github.com/gtfierro/pundat/archiver"
+	bw "gopkg.in/immesys/bw2bind.v5"
+)
+
+// A continuous query registered with SUBSCRIBE DATA WHERE ... New readings for the
+// matching streams are delivered on C as the archiver commits them. The subscription
+// lasts until Expires unless it is renewed.
+//
+//    sub, err := pc.Subscribe("subscribe data where Location/Building = 'Soda' lease 30 min;", 10)
+//    if err != nil {
+//    	log.Fatal(err)
+//    }
+//    defer sub.Unsubscribe(10)
+//    for readings := range sub.C {
+//    	process(readings)
+//    }
+type Subscription struct {
+	ID string
+	// when the lease runs out
+	Expires time.Time
+	// the number of streams matching the where clause when the subscription was created
+	Streams int
+	// receives the new readings. Closed by Unsubscribe or Close
+	C <-chan messages.QueryTimeseriesResult
+
+	pc    *PundatClient
+	nonce uint32
+	// the lease KeepAlive asks for when renewing
+	lease time.Duration
+	stop  chan struct{}
+	once  sync.Once
+}
+
+// Sends a SUBSCRIBE query and waits for the archiver to register it. If no reply is
+// received within the timeout (in seconds), returns ErrNoResponse
+func (pc *PundatClient) Subscribe(query string, timeout int) (*Subscription, error) {
+	nonce, replyChan, timeoutChan, err := pc.publishQuery(query, timeout)
+	if err != nil {
+		return nil, err
+	}
+	// readings can be published before the reply to the SUBSCRIBE query arrives
+	var early []messages.QueryTimeseriesResult
+	for {
+		select {
+		case <-timeoutChan:
+			pc.stopWaiting(nonce)
+			return nil, ErrNoResponse
+		case msg := <-replyChan:
+			if errfound, err := getError(nonce, msg); errfound {
+				pc.stopWaiting(nonce)
+				return nil, err
+			}
+			if found, readings, err := getTimeseries(nonce, msg); found && err == nil {
+				early = append(early, readings)
+				continue
+			}
+			found, res, err := getSubscription(nonce, msg)
+			if err != nil {
+				pc.stopWaiting(nonce)
+				return nil, err
+			}
+			if !found {
+				continue
+			}
+			results := make(chan messages.QueryTimeseriesResult)
+			sub := &Subscription{
+				ID:      res.ID,
+				Expires: time.Unix(0, res.Expires),
+				Streams: res.Streams,
+				C:       results,
+				pc:      pc,
+				nonce:   nonce,
+				lease:   time.Until(time.Unix(0, res.Expires)),
+				stop:    make(chan struct{}),
+			}
+			go sub.forward(replyChan, results, early)
+			return sub, nil
+		}
+	}
+}
+
+// delivers the readings published for the subscription until it is closed
+func (sub *Subscription) forward(replyChan chan *bw.SimpleMessage, results chan messages.QueryTimeseriesResult, early []messages.QueryTimeseriesResult) {
+	defer close(results)
+	defer sub.pc.stopWaiting(sub.nonce)
+	for _, readings := range early {
+		select {
+		case results <- readings:
+		case <-sub.stop:
+			return
+		}
+	}
+	for {
+		select {
+		case <-sub.stop:
+			return
+		case msg := <-replyChan:
+			found, readings, err := getTimeseries(sub.nonce, msg)
+			if err != nil {
+				log.Println(fmt.Sprintf("Error decoding readings for subscription %s (%v)", sub.ID, err))
+				continue
+			}
+			if !found {
+				continue
+			}
+			select {
+			case results <- readings:
+			case <-sub.stop:
+				return
+			}
+		}
+	}
+}
+
+// Extends the subscription's lease by the given duration (the archiver's default if 0)
+func (sub *Subscription) Renew(lease time.Duration, timeout int) error {
+	query := fmt.Sprintf("renew \"%s\";", sub.ID)
+	if lease > 0 {
+		query = fmt.Sprintf("renew \"%s\" lease %d ms;", sub.ID, lease/time.Millisecond)
+	}
+	res, err := sub.pc.SubscriptionQuery(query, timeout)
+	if err != nil {
+		return err
+	}
+	sub.Expires = time.Unix(0, res.Expires)
+	if lease > 0 {
+		sub.lease = lease
+	}
+	return nil
+}
+
+// Renews the subscription before each lease runs out, with the lease it was created with,
+// until it is closed. Blocks, so it is usually run in its own goroutine. A failed renewal
+// is retried until the lease has run out, at which point the subscription is closed and
+// the error returned
+func (sub *Subscription) KeepAlive(timeout int) error {
+	for {
+		select {
+		case <-sub.stop:
+			return nil
+		case <-time.After(renewalDelay(sub.Expires, time.Now())):
+		}
+		err := sub.Renew(sub.lease, timeout)
+		if err == nil {
+			continue
+		}
+		if time.Now().After(sub.Expires) {
+			sub.Close()
+			return err
+		}
+		log.Println(fmt.Sprintf("Could not renew subscription %s; retrying (%v)", sub.ID, err))
+	}
+}
+
+// the shortest time KeepAlive waits between renewals
+const minRenewalDelay = time.Second
+
+// how long to wait before renewing a lease that runs out at expires: halfway through what
+// is left, so there is time to retry if the renewal fails
+func renewalDelay(expires, now time.Time) time.Duration {
+	if delay := expires.Sub(now) / 2; delay > minRenewalDelay {
+		return delay
+	}
+	return minRenewalDelay
+}
+
+// Ends the subscription on the archiver and closes C
+func (sub *Subscription) Unsubscribe(timeout int) error {
+	defer sub.Close()
+	_, err := sub.pc.SubscriptionQuery(fmt.Sprintf("unsubscribe \"%s\";", sub.ID), timeout)
+	return err
+}
+
+// Stops delivering readings and closes C. The subscription stays registered with
+// the archiver until its lease runs out
+func (sub *Subscription) Close() {
+	sub.once.Do(func() {
+		close(sub.stop)
+	})
+}
+
+// Synchronously evaluates a RENEW or UNSUBSCRIBE query. Timeout behaves as in Query
+func (pc *PundatClient) SubscriptionQuery(query string, timeout int) (subRes messages.QuerySubscriptionResult, err error) {
+	nonce, replyChan, timeoutChan, err := pc.publishQuery(query, timeout)
+	if err != nil {
+		return
+	}
+	defer pc.stopWaiting(nonce)
+	for {
+		select {
+		case <-timeoutChan:
+			err = ErrNoResponse
+			return
+		case msg := <-replyChan:
+			if errfound, err := getError(nonce, msg); errfound {
+				return subRes, err
+			}
+			found, subRes, err := getSubscription(nonce, msg)
+			if found || err != nil {
+				return subRes, err
+			}
+		}
+	}
+}
+
+// Extracts the result of a SUBSCRIBE, RENEW or UNSUBSCRIBE query from Giles response. Returns false if no related message was found
+func getSubscription(nonce uint32, msg *bw.SimpleMessage) (bool, messages.QuerySubscriptionResult, error) {
+	var (
+		po         bw.PayloadObject
+		subResults messages.QuerySubscriptionResult
+	)
+	if po = msg.GetOnePODF(messages.GilesQuerySubscriptionPIDString); po != nil {
+		if err := po.(bw.MsgPackPayloadObject).ValueInto(&subResults); err != nil {
+			return false, subResults, err
+		}
+		if subResults.Nonce != nonce {
+			return false, subResults, nil
+		}
+		return true, subResults, nil
+	}
+	return false, subResults, nil
+}
@@ -0,0 +1,41 @@
+package client
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRenewalDelay(t *testing.T) {
+	now := time.Unix(1000, 0)
+	for _, test := range []struct {
+		expires time.Time
+		delay   time.Duration
+	}{
+		{now.Add(10 * time.Minute), 5 * time.Minute},
+		{now.Add(3 * time.Second), 1500 * time.Millisecond},
+		// don't renew in a tight loop as the lease runs out, or once it has
+		{now.Add(time.Second), minRenewalDelay},
+		{now.Add(-time.Minute), minRenewalDelay},
+	} {
+		if delay := renewalDelay(test.expires, now); delay != test.delay {
+			t.Errorf("Lease expiring in %s: expected to renew after %s, got %s", test.expires.Sub(now), test.delay, delay)
+		}
+	}
+}
+
+func TestKeepAliveStopsWhenClosed(t *testing.T) {
+	sub := &Subscription{Expires: time.Now().Add(time.Hour), stop: make(chan struct{})}
+	done := make(chan error)
+	go func() {
+		done <- sub.KeepAlive(1)
+	}()
+	sub.Close()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Expected no error from a closed subscription, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Error("KeepAlive did not return after the subscription was closed")
+	}
+}
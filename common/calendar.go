@@ -0,0 +1,125 @@
+package common
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// unit of a calendar-aligned window
+type CalendarUnit uint
+
+const (
+	CalendarDay CalendarUnit = iota
+	CalendarWeek
+	CalendarMonth
+	CalendarQuarter
+	CalendarYear
+)
+
+func (unit CalendarUnit) String() string {
+	switch unit {
+	case CalendarDay:
+		return "day"
+	case CalendarWeek:
+		return "week"
+	case CalendarMonth:
+		return "month"
+	case CalendarQuarter:
+		return "quarter"
+	case CalendarYear:
+		return "year"
+	}
+	return "unknown"
+}
+
+// Returns the calendar unit named by units, and whether or not it is one
+func ParseCalendarUnit(units string) (CalendarUnit, bool) {
+	switch units {
+	case "d", "day", "days":
+		return CalendarDay, true
+	case "w", "wk", "week", "weeks":
+		return CalendarWeek, true
+	case "mo", "month", "months":
+		return CalendarMonth, true
+	case "q", "quarter", "quarters":
+		return CalendarQuarter, true
+	case "y", "yr", "year", "years":
+		return CalendarYear, true
+	}
+	return CalendarDay, false
+}
+
+// Returns the day of the week named by day (e.g. "monday" or "mon")
+func ParseWeekday(day string) (time.Weekday, error) {
+	day = strings.ToLower(day)
+	for wd := time.Sunday; wd <= time.Saturday; wd++ {
+		name := strings.ToLower(wd.String())
+		if day == name || day == name[:3] {
+			return wd, nil
+		}
+	}
+	return time.Sunday, fmt.Errorf("Invalid day of the week %v", day)
+}
+
+// A window whose buckets start at local midnight on calendar boundaries instead of
+// having a fixed width. Buckets are computed in the location of the times given to
+// Floor and Next, so days are 23 or 25 hours long across daylight savings transitions.
+type CalendarWindow struct {
+	// number of units in each bucket
+	Count int
+	Unit  CalendarUnit
+	// the day weekly buckets start on
+	WeekStart time.Weekday
+}
+
+// Returns the start of the bucket containing t. Months, quarters and years are aligned
+// so that buckets of Count units start at the beginning of the year (e.g. 2-month buckets
+// start in January, March, ...); day and week buckets start on the day or week containing t
+func (cw CalendarWindow) Floor(t time.Time) time.Time {
+	year, month, day := t.Date()
+	count := cw.Count
+	if count < 1 {
+		count = 1
+	}
+	switch cw.Unit {
+	case CalendarWeek:
+		back := (int(t.Weekday()) - int(cw.WeekStart) + 7) % 7
+		return time.Date(year, month, day-back, 0, 0, 0, 0, t.Location())
+	case CalendarMonth:
+		month = time.Month((int(month)-1)/count*count + 1)
+		return time.Date(year, month, 1, 0, 0, 0, 0, t.Location())
+	case CalendarQuarter:
+		month = time.Month((int(month)-1)/(3*count)*(3*count) + 1)
+		return time.Date(year, month, 1, 0, 0, 0, 0, t.Location())
+	case CalendarYear:
+		return time.Date(year-year%count, time.January, 1, 0, 0, 0, 0, t.Location())
+	}
+	return time.Date(year, month, day, 0, 0, 0, 0, t.Location())
+}
+
+// Returns the start of the bucket after the one starting at t
+func (cw CalendarWindow) Next(t time.Time) time.Time {
+	count := cw.Count
+	if count < 1 {
+		count = 1
+	}
+	switch cw.Unit {
+	case CalendarWeek:
+		return t.AddDate(0, 0, 7*count)
+	case CalendarMonth:
+		return t.AddDate(0, count, 0)
+	case CalendarQuarter:
+		return t.AddDate(0, 3*count, 0)
+	case CalendarYear:
+		return t.AddDate(count, 0, 0)
+	}
+	return t.AddDate(0, 0, count)
+}
+
+func (cw CalendarWindow) String() string {
+	if cw.Unit == CalendarWeek {
+		return fmt.Sprintf("%d %s (starting %s)", cw.Count, cw.Unit, cw.WeekStart)
+	}
+	return fmt.Sprintf("%d %s", cw.Count, cw.Unit)
+}
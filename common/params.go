@@ -2,15 +2,65 @@ package common
 
 import (
 	"fmt"
+	"time"
 )
 
 type QueryParams interface {
 	Dump() string
 }
 
+// ordering and paging of a query's results
+type Pagination struct {
+	// tag to sort the results by. If empty, results are returned in an
+	// arbitrary (but stable) order
+	OrderBy string
+	// if true, sort by OrderBy from largest to smallest
+	Descending bool
+	// maximum number of results to return. 0 means no limit
+	Limit int
+	// number of results to skip before returning any
+	Offset int
+	// opaque token returned by a previous page of results. If provided,
+	// supercedes Offset
+	Cursor string
+}
+
+func (page Pagination) IsEmpty() bool {
+	return page == Pagination{}
+}
+
+func (page Pagination) Dump() string {
+	if page.IsEmpty() {
+		return ""
+	}
+	ret := fmt.Sprintf("ORDER BY: %s (descending=%v)\n", page.OrderBy, page.Descending)
+	ret += fmt.Sprintf("LIMIT: %d OFFSET: %d CURSOR: %s\n", page.Limit, page.Offset, page.Cursor)
+	return ret
+}
+
+// how timestamps in the results of a data query are rendered
+type TimeFormat struct {
+	// IANA name of the timezone the query was resolved in (e.g. America/Los_Angeles)
+	Timezone string
+	// if true, timestamps are also returned as RFC3339 strings in Timezone
+	RFC3339 bool
+}
+
+// Returns the location named by Timezone, or UTC if it can't be loaded
+func (format TimeFormat) Location() *time.Location {
+	loc, err := time.LoadLocation(format.Timezone)
+	if err != nil {
+		return time.UTC
+	}
+	return loc
+}
+
 type TagParams struct {
 	Tags  []string
-	Where Dict
+	Where *Predicate
+	Page  Pagination
+	// if non-zero, evaluate Where against the metadata as it was at this time
+	AsOf time.Time
 }
 
 func (params TagParams) Dump() string {
@@ -18,21 +68,61 @@ func (params TagParams) Dump() string {
 	for _, tag := range params.Tags {
 		ret += fmt.Sprintf("-> %s\n", tag)
 	}
-	ret += fmt.Sprintf("WHERE\n%+v", params.Where)
+	ret += fmt.Sprintf("WHERE\n%s\n", params.Where)
+	ret += dumpAsOf(params.AsOf)
+	ret += params.Page.Dump()
 	return ret
 }
 
 type DistinctParams struct {
 	Tag   string
-	Where Dict
+	Where *Predicate
+	Page  Pagination
+	// if non-zero, evaluate Where against the metadata as it was at this time
+	AsOf time.Time
 }
 
 func (params DistinctParams) Dump() string {
 	ret := fmt.Sprintf("SELECT DISTINCT\nTag: %s\n", params.Tag)
-	ret += fmt.Sprintf("WHERE\n%+v", params.Where)
+	ret += fmt.Sprintf("WHERE\n%s\n", params.Where)
+	ret += dumpAsOf(params.AsOf)
+	ret += params.Page.Dump()
 	return ret
 }
 
+func dumpAsOf(at time.Time) string {
+	if at.IsZero() {
+		return ""
+	}
+	return fmt.Sprintf("AS OF %s\n", at.Format(time.RFC3339Nano))
+}
+
+// Registers a continuous query that pushes new readings for the streams matching Where
+// (SUBSCRIBE DATA WHERE ...), or renews the lease on an existing one (RENEW "<id>")
+type SubscribeParams struct {
+	Where *Predicate
+	// if non-empty, renew the subscription with this ID instead of creating one
+	ID string
+	// how long the subscription lasts unless it is renewed. 0 means the default
+	Lease time.Duration
+}
+
+func (params SubscribeParams) Dump() string {
+	if params.ID != "" {
+		return fmt.Sprintf("RENEW %s\nLease: %s\n", params.ID, params.Lease)
+	}
+	return fmt.Sprintf("SUBSCRIBE\nWHERE\n%s\nLease: %s\n", params.Where, params.Lease)
+}
+
+// Ends the subscription with the given ID (UNSUBSCRIBE "<id>")
+type UnsubscribeParams struct {
+	ID string
+}
+
+func (params UnsubscribeParams) Dump() string {
+	return fmt.Sprintf("UNSUBSCRIBE %s\n", params.ID)
+}
+
 // 3 valid states:
 // - !IsStatistical && !IsWindow: normal range query
 // - !IsStatistical && IsWindow: window query
@@ -40,8 +130,8 @@ func (params DistinctParams) Dump() string {
 // - IsStatistical && IsWindow: INVALID
 type DataParams struct {
 	// clause to evaluate for which streams to fetch.
-	// If this is empty, uses the UUIDs
-	Where Dict
+	// If this is nil, uses the UUIDs
+	Where *Predicate
 	// UUIDs from which to fetch data. Superceded by Where
 	UUIDs []UUID
 	// restrict the number of streams returned
@@ -55,6 +145,8 @@ type DataParams struct {
 	End int64
 	// converts all readings to this unit of time when finished
 	ConvertToUnit UnitOfTime
+	// the timezone and format in which to render timestamps
+	Format TimeFormat
 	// if true, then we interpret pointwidth
 	IsStatistical bool
 	// PointWidth of X means the window size is (1 << X) nanoseconds
@@ -62,16 +154,48 @@ type DataParams struct {
 	// if true, then we interpret windowwidth.
 	IsWindow bool
 	// we interpret this as nanoseconds
-	Width           uint64
+	Width uint64
+	// if non-nil, the window follows the calendar in Format's timezone and Width is ignored
+	Calendar *CalendarWindow
+	// if true, summarize the distribution of the raw values with the
+	// given percentiles (0-100) and/or a histogram with HistogramBins bins
+	IsDistribution  bool
+	Percentiles     []float64
+	HistogramBins   int
 	IsChangedRanges bool
 	FromGen         uint64
 	ToGen           uint64
 	Resolution      uint8
+	// opaque token returned by a previous data query whose results were
+	// truncated by DataLimit. Resumes each stream from where it left off
+	Cursor string
+	// if non-zero, evaluate Where against the metadata as it was at this time
+	AsOf time.Time
+	// if true, evaluate Where against the metadata valid at the time of each reading
+	AsOfData bool
+	// for AsOfData: the intervals in which each stream's metadata matched Where,
+	// keyed by UUID. Filled in when Where is evaluated
+	Matched map[string][]Interval
+}
+
+// A span of time [Start, End] in nanoseconds
+type Interval struct {
+	Start int64
+	End   int64
 }
 
 func (params DataParams) Dump() string {
-	ret := fmt.Sprintf("DATA\n%d UUIDs\nWHERE:\n%+v", len(params.UUIDs), params.Where)
-	ret += fmt.Sprintf("Begin: %d\nEnd: %d\n", params.Begin, params.End)
-	ret += fmt.Sprintf("Convert to : %s", params.ConvertToUnit.String())
+	ret := fmt.Sprintf("DATA\n%d UUIDs\nWHERE:\n%s\n", len(params.UUIDs), params.Where)
+	loc := params.Format.Location()
+	ret += fmt.Sprintf("Begin: %d (%s)\nEnd: %d (%s)\n", params.Begin, time.Unix(0, params.Begin).In(loc).Format(time.RFC3339Nano), params.End, time.Unix(0, params.End).In(loc).Format(time.RFC3339Nano))
+	if params.Calendar != nil {
+		ret += fmt.Sprintf("Window: %s in %s\n", params.Calendar, loc)
+	}
+	if params.AsOfData {
+		ret += "AS OF DATA\n"
+	} else {
+		ret += dumpAsOf(params.AsOf)
+	}
+	ret += fmt.Sprintf("Convert to : %s (rfc3339=%v)", params.ConvertToUnit.String(), params.Format.RFC3339)
 	return ret
 }
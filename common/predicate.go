@@ -0,0 +1,193 @@
+package common
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+type PredicateOp uint8
+
+const (
+	// all children must match
+	OpAnd PredicateOp = iota + 1
+	// any child must match
+	OpOr
+	// the single child must not match
+	OpNot
+	// Key's value equals Values[0]
+	OpEq
+	// Key's value does not equal Values[0] (or Key does not exist)
+	OpNeq
+	// Key's value matches the regular expression Values[0]
+	OpLike
+	// Key exists
+	OpHas
+	// Key's value is one of Values
+	OpIn
+	// the keys and values of the document contain all the words in Values[0] (see SearchTerms)
+	OpMatches
+)
+
+func (op PredicateOp) String() string {
+	switch op {
+	case OpAnd:
+		return "and"
+	case OpOr:
+		return "or"
+	case OpNot:
+		return "not"
+	case OpEq:
+		return "="
+	case OpNeq:
+		return "!="
+	case OpLike:
+		return "like"
+	case OpHas:
+		return "has"
+	case OpIn:
+		return "in"
+	case OpMatches:
+		return "matches"
+	}
+	return "unknown"
+}
+
+// A node in the tree representing the WHERE clause of a query. This is independent of
+// any particular metadata store: MetadataStore implementations translate it into their
+// own query language, or use Matches to evaluate it against documents in memory.
+// A nil Predicate matches every document.
+type Predicate struct {
+	Op PredicateOp
+	// the tag this predicate tests. Empty for And, Or, Not and Matches
+	Key string
+	// the values, pattern or search this predicate tests against
+	Values []string
+	// the operands of And, Or and Not
+	Children []*Predicate
+}
+
+func NewAnd(left, right *Predicate) *Predicate {
+	return &Predicate{Op: OpAnd, Children: []*Predicate{left, right}}
+}
+
+func NewOr(left, right *Predicate) *Predicate {
+	return &Predicate{Op: OpOr, Children: []*Predicate{left, right}}
+}
+
+func NewNot(inner *Predicate) *Predicate {
+	return &Predicate{Op: OpNot, Children: []*Predicate{inner}}
+}
+
+func NewTagPredicate(op PredicateOp, key string, values ...string) *Predicate {
+	return &Predicate{Op: op, Key: key, Values: values}
+}
+
+// Returns true if the document satisfies the predicate. Keys are looked up as-is, and
+// then as a '.'-separated path through nested documents
+func (p *Predicate) Matches(doc map[string]interface{}) bool {
+	if p == nil {
+		return true
+	}
+	switch p.Op {
+	case OpAnd:
+		for _, child := range p.Children {
+			if !child.Matches(doc) {
+				return false
+			}
+		}
+		return true
+	case OpOr:
+		for _, child := range p.Children {
+			if child.Matches(doc) {
+				return true
+			}
+		}
+		return false
+	case OpNot:
+		return !p.Children[0].Matches(doc)
+	case OpMatches:
+		tokens := TextTokens(doc)
+		for _, term := range SearchTerms(p.Values[0]) {
+			if i := sort.SearchStrings(tokens, term); i == len(tokens) || tokens[i] != term {
+				return false
+			}
+		}
+		return true
+	}
+
+	value, found := lookupKey(doc, p.Key)
+	switch p.Op {
+	case OpHas:
+		return found
+	case OpEq:
+		return found && value == p.Values[0]
+	case OpNeq:
+		return !found || value != p.Values[0]
+	case OpLike:
+		return found && matchRegex(p.Values[0], value)
+	case OpIn:
+		if !found {
+			return false
+		}
+		for _, v := range p.Values {
+			if value == v {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Returns the predicate in (roughly) the syntax of the query language
+func (p *Predicate) String() string {
+	if p == nil {
+		return ""
+	}
+	switch p.Op {
+	case OpAnd, OpOr:
+		var parts []string
+		for _, child := range p.Children {
+			parts = append(parts, child.String())
+		}
+		return "(" + strings.Join(parts, " "+p.Op.String()+" ") + ")"
+	case OpNot:
+		return "not " + p.Children[0].String()
+	case OpHas:
+		return "has " + p.Key
+	case OpMatches:
+		return fmt.Sprintf("matches %q", p.Values[0])
+	case OpIn:
+		var values []string
+		for _, v := range p.Values {
+			values = append(values, fmt.Sprintf("%q", v))
+		}
+		return fmt.Sprintf("[%s] in %s", strings.Join(values, ", "), p.Key)
+	}
+	return fmt.Sprintf("%s %s %q", p.Key, p.Op, p.Values[0])
+}
+
+func lookupKey(doc map[string]interface{}, key string) (string, bool) {
+	if value, found := doc[key]; found {
+		return fmt.Sprintf("%v", value), true
+	}
+	parts := strings.SplitN(key, ".", 2)
+	if len(parts) < 2 {
+		return "", false
+	}
+	switch inner := doc[parts[0]].(type) {
+	case map[string]interface{}:
+		return lookupKey(inner, parts[1])
+	case bson.M:
+		return lookupKey(inner, parts[1])
+	}
+	return "", false
+}
+
+func matchRegex(pattern, value string) bool {
+	matched, err := regexp.MatchString(pattern, value)
+	return err == nil && matched
+}
@@ -0,0 +1,208 @@
+package common
+
+import (
+	"math"
+	"sort"
+)
+
+// A streaming quantile sketch (a merging t-digest). Values are summarized by a bounded
+// number of weighted centroids, which are smallest near the tails of the distribution,
+// so that extreme quantiles (p5, p95) stay accurate. Memory use depends only on the
+// compression, not on the number of values added.
+type QuantileSketch struct {
+	compression float64
+	// sorted by mean
+	centroids []centroid
+	// values added since the last merge
+	buffer []centroid
+	count  float64
+	min    float64
+	max    float64
+}
+
+type centroid struct {
+	mean   float64
+	weight float64
+}
+
+// Larger compression gives more accurate quantiles at the cost of more memory;
+// the sketch holds at most about 2*compression centroids
+func NewQuantileSketch(compression float64) *QuantileSketch {
+	return &QuantileSketch{
+		compression: compression,
+		min:         math.Inf(1),
+		max:         math.Inf(-1),
+	}
+}
+
+func (s *QuantileSketch) Add(value float64) {
+	if math.IsNaN(value) {
+		return
+	}
+	s.buffer = append(s.buffer, centroid{mean: value, weight: 1})
+	s.count++
+	if value < s.min {
+		s.min = value
+	}
+	if value > s.max {
+		s.max = value
+	}
+	if len(s.buffer) >= int(5*s.compression) {
+		s.merge()
+	}
+}
+
+// the scale function: centroids may span at most 1 unit of k
+func (s *QuantileSketch) k(q float64) float64 {
+	return s.compression / (2 * math.Pi) * math.Asin(2*q-1)
+}
+
+// merges the buffered values into the centroids
+func (s *QuantileSketch) merge() {
+	if len(s.buffer) == 0 {
+		return
+	}
+	all := append(s.centroids, s.buffer...)
+	sort.Slice(all, func(i, j int) bool {
+		return all[i].mean < all[j].mean
+	})
+	merged := []centroid{all[0]}
+	var before float64
+	for _, c := range all[1:] {
+		cur := &merged[len(merged)-1]
+		proposed := cur.weight + c.weight
+		if s.k((before+proposed)/s.count)-s.k(before/s.count) <= 1 {
+			cur.mean += (c.mean - cur.mean) * c.weight / proposed
+			cur.weight = proposed
+			continue
+		}
+		before += cur.weight
+		merged = append(merged, c)
+	}
+	s.centroids = merged
+	s.buffer = s.buffer[:0]
+}
+
+// the number of values added to the sketch
+func (s *QuantileSketch) Count() uint64 {
+	return uint64(s.count)
+}
+
+func (s *QuantileSketch) Min() float64 {
+	return s.min
+}
+
+func (s *QuantileSketch) Max() float64 {
+	return s.max
+}
+
+// Returns the estimated value at quantile q (0 <= q <= 1). Returns NaN if the sketch is empty
+func (s *QuantileSketch) Quantile(q float64) float64 {
+	s.merge()
+	if len(s.centroids) == 0 {
+		return math.NaN()
+	}
+	if q <= 0 {
+		return s.min
+	}
+	if q >= 1 {
+		return s.max
+	}
+	// interpolate between the centers of adjacent centroids, treating min and
+	// max as centroids of weight 0 at either end
+	target := q * s.count
+	prevMean, prevRank := s.min, 0.0
+	var cumulative float64
+	for _, c := range s.centroids {
+		rank := cumulative + c.weight/2
+		if target < rank {
+			return interpolate(prevRank, prevMean, rank, c.mean, target)
+		}
+		prevMean, prevRank = c.mean, rank
+		cumulative += c.weight
+	}
+	return interpolate(prevRank, prevMean, s.count, s.max, target)
+}
+
+// Returns the estimated fraction of values that are <= value
+func (s *QuantileSketch) CDF(value float64) float64 {
+	s.merge()
+	if len(s.centroids) == 0 || value < s.min {
+		return 0
+	}
+	if value >= s.max {
+		return 1
+	}
+	prevMean, prevRank := s.min, 0.0
+	var cumulative float64
+	for _, c := range s.centroids {
+		rank := cumulative + c.weight/2
+		if value < c.mean {
+			return interpolate(prevMean, prevRank, c.mean, rank, value) / s.count
+		}
+		prevMean, prevRank = c.mean, rank
+		cumulative += c.weight
+	}
+	return interpolate(prevMean, prevRank, s.max, s.count, value) / s.count
+}
+
+// returns the y value at x on the line through (x0, y0) and (x1, y1)
+func interpolate(x0, y0, x1, y1, x float64) float64 {
+	if x1 == x0 {
+		return y0
+	}
+	return y0 + (y1-y0)*(x-x0)/(x1-x0)
+}
+
+// Summarizes the distribution of values in a stream over a range of time
+type Distribution struct {
+	UUID       UUID
+	Generation uint64
+	Count      uint64
+	Min        float64
+	Max        float64
+	// the requested percentiles (0-100) and their estimated values
+	Percentiles []float64
+	Values      []float64
+	// histogram of the values: Counts[i] is the estimated number of values in
+	// [Edges[i], Edges[i+1]) (the last bin also includes Max)
+	Edges  []float64
+	Counts []uint64
+}
+
+// Builds a Distribution from the sketch. The histogram has bins of equal width between
+// the smallest and largest values; if bins is 0, no histogram is computed
+func NewDistribution(uuid UUID, sketch *QuantileSketch, percentiles []float64, bins int) Distribution {
+	dist := Distribution{
+		UUID:        uuid,
+		Count:       sketch.Count(),
+		Percentiles: percentiles,
+	}
+	if dist.Count == 0 {
+		return dist
+	}
+	dist.Min, dist.Max = sketch.Min(), sketch.Max()
+	for _, p := range percentiles {
+		dist.Values = append(dist.Values, sketch.Quantile(p/100))
+	}
+	if bins <= 0 {
+		return dist
+	}
+	width := (dist.Max - dist.Min) / float64(bins)
+	var below uint64
+	for i := 0; i <= bins; i++ {
+		dist.Edges = append(dist.Edges, dist.Min+float64(i)*width)
+	}
+	for i := 1; i <= bins; i++ {
+		upto := uint64(math.Floor(sketch.CDF(dist.Edges[i])*float64(dist.Count) + 0.5))
+		if i == bins {
+			upto = dist.Count
+		}
+		if upto < below {
+			upto = below
+		}
+		dist.Counts = append(dist.Counts, upto-below)
+		below = upto
+	}
+	return dist
+}
@@ -0,0 +1,99 @@
+package common
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+	"testing"
+)
+
+// Compares the sketch's estimates with the exact quantiles of the data. The error is
+// measured in rank (the fraction of the data below the estimate), which t-digests keep
+// small everywhere and smallest at the tails
+func TestQuantileSketchAccuracy(t *testing.T) {
+	const n = 100000
+	rng := rand.New(rand.NewSource(1))
+	for _, test := range []struct {
+		name     string
+		generate func(i int) float64
+	}{
+		{"uniform", func(i int) float64 { return rng.Float64() }},
+		{"normal", func(i int) float64 { return rng.NormFloat64()*10 + 50 }},
+		{"exponential", func(i int) float64 { return rng.ExpFloat64() }},
+		{"ascending", func(i int) float64 { return float64(i) }},
+		{"descending", func(i int) float64 { return float64(n - i) }},
+		{"few distinct values", func(i int) float64 { return float64(rng.Intn(5)) }},
+	} {
+		sketch := NewQuantileSketch(200)
+		data := make([]float64, n)
+		for i := range data {
+			data[i] = test.generate(i)
+			sketch.Add(data[i])
+		}
+		sort.Float64s(data)
+
+		if sketch.Count() != n || sketch.Min() != data[0] || sketch.Max() != data[n-1] {
+			t.Errorf("%s: expected count %d, min %v and max %v but got %d, %v and %v", test.name, n, data[0], data[n-1], sketch.Count(), sketch.Min(), sketch.Max())
+		}
+		for _, q := range []float64{0.001, 0.01, 0.1, 0.25, 0.5, 0.75, 0.9, 0.99, 0.999} {
+			estimate := sketch.Quantile(q)
+			// the range of ranks the estimate could have, allowing for repeated values
+			lower := float64(sort.SearchFloat64s(data, estimate)) / n
+			upper := float64(sort.Search(n, func(i int) bool { return data[i] > estimate })) / n
+			tolerance := 0.005
+			if q < 0.01 || q > 0.99 {
+				tolerance = 0.001
+			}
+			if q < lower-tolerance || q > upper+tolerance {
+				t.Errorf("%s: the %v quantile is estimated as %v, which has rank [%v, %v]", test.name, q, estimate, lower, upper)
+			}
+		}
+		for _, value := range []float64{data[n/10], data[n/2], data[9*n/10]} {
+			exact := float64(sort.Search(n, func(i int) bool { return data[i] > value })) / n
+			lower := float64(sort.SearchFloat64s(data, value)) / n
+			if cdf := sketch.CDF(value); cdf < lower-0.005 || cdf > exact+0.005 {
+				t.Errorf("%s: the CDF at %v is estimated as %v but is in [%v, %v]", test.name, value, cdf, lower, exact)
+			}
+		}
+	}
+}
+
+// with fewer values than the compression, every value is kept exactly
+func TestQuantileSketchSmall(t *testing.T) {
+	sketch := NewQuantileSketch(200)
+	for _, value := range []float64{5, 1, 4, 2, 3} {
+		sketch.Add(value)
+	}
+	for q, expected := range map[float64]float64{0: 1, 0.5: 3, 1: 5} {
+		if estimate := sketch.Quantile(q); math.Abs(estimate-expected) > 1e-9 {
+			t.Errorf("The %v quantile of 1..5 should be %v but got %v", q, expected, estimate)
+		}
+	}
+	if empty := NewQuantileSketch(200); empty.Count() != 0 {
+		t.Errorf("An empty sketch should have no values, got %d", empty.Count())
+	}
+}
+
+func TestDistributionHistogram(t *testing.T) {
+	sketch := NewQuantileSketch(200)
+	for i := 0; i < 1000; i++ {
+		sketch.Add(float64(i % 100))
+	}
+	dist := NewDistribution(UUID{}, sketch, []float64{50}, 4)
+	if len(dist.Edges) != 5 || dist.Edges[0] != 0 || dist.Edges[4] != 99 {
+		t.Fatalf("Expected 4 bins over [0, 99], got edges %v", dist.Edges)
+	}
+	var total uint64
+	for _, count := range dist.Counts {
+		total += count
+		if math.Abs(float64(count)-250) > 15 {
+			t.Errorf("Expected about 250 values in each bin of a uniform distribution, got %v", dist.Counts)
+		}
+	}
+	if total != 1000 {
+		t.Errorf("Expected the bins to hold all 1000 values, got %d", total)
+	}
+	if len(dist.Values) != 1 || math.Abs(dist.Values[0]-49.5) > 1.5 {
+		t.Errorf("Expected the median to be about 49.5, got %v", dist.Values)
+	}
+}
@@ -0,0 +1,67 @@
+package common
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+// Splits text into lowercase words of letters and digits. Everything else (spaces,
+// punctuation, the '/' in tag names) separates words
+func tokenize(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// Returns the words of a MATCHES search. A document matches if it contains all of them
+func SearchTerms(search string) []string {
+	return dedupe(tokenize(search))
+}
+
+// Returns the sorted, distinct words in the keys and values of the document (including
+// nested documents and lists). This is what MATCHES searches
+func TextTokens(doc map[string]interface{}) []string {
+	var tokens []string
+	var add func(value interface{})
+	add = func(value interface{}) {
+		switch v := value.(type) {
+		case map[string]interface{}:
+			for key, inner := range v {
+				tokens = append(tokens, tokenize(key)...)
+				add(inner)
+			}
+		case bson.M:
+			add(map[string]interface{}(v))
+		case []interface{}:
+			for _, inner := range v {
+				add(inner)
+			}
+		case []string:
+			for _, inner := range v {
+				tokens = append(tokens, tokenize(inner)...)
+			}
+		case string:
+			tokens = append(tokens, tokenize(v)...)
+		case nil:
+		default:
+			tokens = append(tokens, tokenize(fmt.Sprintf("%v", v))...)
+		}
+	}
+	add(doc)
+	return dedupe(tokens)
+}
+
+func dedupe(words []string) []string {
+	sort.Strings(words)
+	var unique []string
+	for i, word := range words {
+		if i == 0 || word != words[i-1] {
+			unique = append(unique, word)
+		}
+	}
+	return unique
+}
@@ -41,7 +41,7 @@ func TestGuessTimeUnit(t *testing.T) {
 	}
 
 	for _, testcase := range testcases {
-		timeunit := GuessTimeUnit(uint64(testcase.timestamp))
+		timeunit := GuessTimeUnit(testcase.timestamp)
 		if timeunit.String() != testcase.unit {
 			t.Errorf("Parsing timestamp %d (%s) gave unit %s but expected %s", testcase.timestamp, testcase.time, timeunit, testcase.unit)
 		}
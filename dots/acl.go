@@ -0,0 +1,172 @@
+package dots
+
+import (
+	"io/ioutil"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// Struct representation of a static access control list. Grants the VKs access to the
+// data of URIs matching the patterns (BOSSWAVE syntax: '+' matches one segment and '*'
+// any number of them) within the given ranges of time. Follows the basic structure:
+//
+//    Grants:
+//      - VK: Tj1RiNjKD8ZfYWvMvLVLaIaqDTV9LuNE7pPJoFTwoy8=
+//        URI: ucberkeley/eecs/soda/*
+//        From: 2017-01-01
+//        To: 2018-01-01
+//      - VK: "*"
+//        URI: ucberkeley/eecs/soda/+/public/*
+//
+// From and To are optional and default to the beginning and end of time. A VK of "*"
+// applies to every VK
+type ACLConfig struct {
+	Grants []ACLGrant `yaml:"Grants"`
+}
+
+type ACLGrant struct {
+	VK   string `yaml:"VK"`
+	URI  string `yaml:"URI"`
+	From string `yaml:"From"`
+	To   string `yaml:"To"`
+}
+
+type aclGrant struct {
+	vk      string
+	pattern []string
+	rng     TimeRange
+}
+
+// An implementation of the archiver's permission checks that reads the permissions from
+// a file instead of building DOT chains, so that it does not need a BOSSWAVE registry
+type ACL struct {
+	grants []aclGrant
+}
+
+func ReadACL(filename string) (*ACL, error) {
+	var config ACLConfig
+	bytes, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Could not read ACL file %s", filename)
+	}
+	if err := yaml.Unmarshal(bytes, &config); err != nil {
+		return nil, errors.Wrap(err, "Could not unmarshal ACL file")
+	}
+	return NewACL(config)
+}
+
+func NewACL(config ACLConfig) (*ACL, error) {
+	acl := new(ACL)
+	for i, grant := range config.Grants {
+		if grant.VK == "" || grant.URI == "" {
+			return nil, errors.Errorf("Grant %d needs both a VK and a URI", i)
+		}
+		parsed := aclGrant{
+			vk:      grant.VK,
+			pattern: strings.Split(strings.Trim(grant.URI, "/"), "/"),
+			rng:     TimeRange{Start: time.Unix(0, Beginning), End: time.Unix(0, EndOfTime)},
+		}
+		var err error
+		if grant.From != "" {
+			if parsed.rng.Start, err = parseACLTime(grant.From); err != nil {
+				return nil, errors.Wrapf(err, "Grant %d has an invalid From", i)
+			}
+		}
+		if grant.To != "" {
+			if parsed.rng.End, err = parseACLTime(grant.To); err != nil {
+				return nil, errors.Wrapf(err, "Grant %d has an invalid To", i)
+			}
+		}
+		if parsed.rng.End.Before(parsed.rng.Start) {
+			return nil, errors.Errorf("Grant %d ends before it starts", i)
+		}
+		acl.grants = append(acl.grants, parsed)
+	}
+	return acl, nil
+}
+
+var aclTimeFormats = []string{time.RFC3339Nano, "2006-01-02 15:04:05", "2006-01-02"}
+
+// accepts RFC3339 times, dates with or without a time of day (in UTC), and unix nanoseconds
+func parseACLTime(value string) (time.Time, error) {
+	if nanos, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Unix(0, nanos), nil
+	}
+	for _, format := range aclTimeFormats {
+		if t, err := time.Parse(format, value); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, errors.Errorf("Could not parse time %s", value)
+}
+
+// Returns true if the URI matches the BOSSWAVE URI pattern ('+' matches one segment
+// and '*' any number of them)
+func MatchURI(pattern, uri string) bool {
+	return matchURI(strings.Split(strings.Trim(pattern, "/"), "/"), strings.Split(strings.Trim(uri, "/"), "/"))
+}
+
+// returns true if the URI matches the pattern
+func matchURI(pattern, uri []string) bool {
+	if len(pattern) == 0 {
+		return len(uri) == 0
+	}
+	switch pattern[0] {
+	case "*":
+		for i := 0; i <= len(uri); i++ {
+			if matchURI(pattern[1:], uri[i:]) {
+				return true
+			}
+		}
+		return false
+	case "+":
+		return len(uri) > 0 && matchURI(pattern[1:], uri[1:])
+	}
+	return len(uri) > 0 && pattern[0] == uri[0] && matchURI(pattern[1:], uri[1:])
+}
+
+// the grants to the VK on the URI
+func (acl *ACL) grantsFor(uri, vk string) []aclGrant {
+	var grants []aclGrant
+	segments := strings.Split(strings.Trim(uri, "/"), "/")
+	for _, grant := range acl.grants {
+		if (grant.vk == vk || grant.vk == "*") && matchURI(grant.pattern, segments) {
+			grants = append(grants, grant)
+		}
+	}
+	return grants
+}
+
+// Returns nil if some grant gives the VK access to the URI
+func (acl *ACL) CanRead(uri, vk string) error {
+	if len(acl.grantsFor(uri, vk)) == 0 {
+		return errors.Errorf("ACL does not grant %s access to %s", vk, uri)
+	}
+	return nil
+}
+
+// Returns the union of the ranges of the grants to the VK on the URI, in time order
+func (acl *ACL) GetValidRanges(uri, vk string) (*DisjointRanges, error) {
+	grants := acl.grantsFor(uri, vk)
+	sort.Slice(grants, func(i, j int) bool {
+		return grants[i].rng.Start.Before(grants[j].rng.Start)
+	})
+	// DisjointRanges.Merge only notices overlaps with ranges that start later, so
+	// coalesce the sorted ranges here
+	ranges := new(DisjointRanges)
+	for _, grant := range grants {
+		if n := len(ranges.Ranges); n > 0 && !ranges.Ranges[n-1].End.Before(grant.rng.Start) {
+			if grant.rng.End.After(ranges.Ranges[n-1].End) {
+				ranges.Ranges[n-1].End = grant.rng.End
+			}
+			continue
+		}
+		ranges.Ranges = append(ranges.Ranges, NewTimeRange(grant.rng.Start, grant.rng.End))
+	}
+	return ranges, nil
+}
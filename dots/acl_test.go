@@ -0,0 +1,52 @@
+package dots
+
+import (
+	"testing"
+	"time"
+)
+
+func TestACL(t *testing.T) {
+	acl, err := NewACL(ACLConfig{Grants: []ACLGrant{
+		{VK: "alice", URI: "ns/soda/*", From: "2017-01-01", To: "2017-02-01"},
+		{VK: "alice", URI: "ns/soda/+/temp", From: "2017-01-15", To: "2017-03-01"},
+		{VK: "*", URI: "ns/public/*"},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		uri     string
+		vk      string
+		canRead bool
+		ranges  int
+	}{
+		{"ns/soda/410/temp", "alice", true, 1},
+		{"ns/soda/410/hum", "alice", true, 1},
+		{"ns/soda", "alice", true, 1},
+		{"ns/cory/410/temp", "alice", false, 0},
+		{"ns/soda/410/temp", "bob", false, 0},
+		{"ns/public/weather", "bob", true, 1},
+	} {
+		if err := acl.CanRead(test.uri, test.vk); (err == nil) != test.canRead {
+			t.Errorf("CanRead(%s, %s) should be %v but got %v", test.uri, test.vk, test.canRead, err)
+		}
+		ranges, err := acl.GetValidRanges(test.uri, test.vk)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(ranges.Ranges) != test.ranges {
+			t.Errorf("GetValidRanges(%s, %s) should have %d ranges but got %s", test.uri, test.vk, test.ranges, ranges)
+		}
+	}
+
+	// the two overlapping grants on temp are merged
+	ranges, _ := acl.GetValidRanges("ns/soda/410/temp", "alice")
+	expected := NewTimeRange(time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC), time.Date(2017, 3, 1, 0, 0, 0, 0, time.UTC))
+	if rng := ranges.Ranges[0]; !rng.Start.Equal(expected.Start) || !rng.End.Equal(expected.End) {
+		t.Errorf("Expected %s but got %s", expected, rng)
+	}
+
+	if _, err := NewACL(ACLConfig{Grants: []ACLGrant{{VK: "alice", URI: "ns/*", From: "2018-01-01", To: "2017-01-01"}}}); err == nil {
+		t.Error("Expected an error for a grant that ends before it starts")
+	}
+}
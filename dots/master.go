@@ -10,6 +10,7 @@ import (
 	"github.com/pkg/errors"
 	"log"
 	"strings"
+	"sync/atomic"
 	"time"
 )
 
@@ -32,32 +33,53 @@ type DotMaster struct {
 	cache   *ccache.LayeredCache
 	canread *ccache.Cache
 	expiry  time.Duration
+	// the longest a cached permission is used for (see revocation.go)
+	maxStaleness time.Duration
+	deps         *dependencies
+	counters     cacheCounters
 }
 
-func NewDotMaster(client *bw2.BW2Client, expiry time.Duration) *DotMaster {
-	return &DotMaster{
-		client:  client,
-		cache:   ccache.Layered(ccache.Configure().MaxSize(1000000)),
-		canread: ccache.New(ccache.Configure().MaxSize(1000000)),
-		expiry:  expiry,
+// Cached permissions expire after [expiry] (CanRead after 10 minutes), or [maxStaleness]
+// if that is shorter, and are dropped early if the DOTs they rely on are revoked
+func NewDotMaster(client *bw2.BW2Client, expiry, maxStaleness time.Duration) *DotMaster {
+	dm := &DotMaster{
+		client:       client,
+		cache:        ccache.Layered(ccache.Configure().MaxSize(1000000)),
+		canread:      ccache.New(ccache.Configure().MaxSize(1000000)),
+		expiry:       expiry,
+		maxStaleness: maxStaleness,
+		deps:         newDependencies(),
 	}
+	go dm.revalidate()
+	return dm
 }
 
 // Returns nil if VK can read URI; else returns an error
 // really just a simple wrapper around buildanychain
 func (dm *DotMaster) CanRead(uri, vk string) error {
 	key := uri + vk
-	if item := dm.canread.Get(key); item == nil {
+	if item := dm.canread.Get(key); item == nil || item.Expired() {
+		atomic.AddInt64(&dm.counters.misses, 1)
 		chain, err := dm.client.BuildAnyChain(uri, "C", vk)
-		dm.canread.Set(key, err == nil, 10*time.Minute)
+		dm.canread.Set(key, err == nil && chain != nil, dm.ttl(10*time.Minute))
 		if chain != nil && err == nil {
+			if obj, err := objects.NewDChain(objects.ROAccessDChain, chain.Content); err == nil {
+				if dchain, ok := obj.(*objects.DChain); ok {
+					dm.deps.add(cacheKey{uri, vk}, chainDOTs([]*objects.DChain{dchain}))
+				}
+			}
 			return nil
 		}
 		//cannot read
+		if err == nil {
+			return errors.New("Could not build chain")
+		}
 		return errors.Wrap(err, "Could not build chain")
 	} else if item.Value().(bool) {
+		atomic.AddInt64(&dm.counters.hits, 1)
 		return nil // can read!
 	}
+	atomic.AddInt64(&dm.counters.hits, 1)
 	return errors.New("Could not build chain")
 }
 
@@ -68,8 +90,10 @@ func (dm *DotMaster) GetValidRanges(uri, vk string) (*DisjointRanges, error) {
 	if found := dm.cache.Get(uri, vk); found != nil && !found.Expired() {
 		// check item.expired
 		log.Printf("returning from cache for %s %s", uri, vk)
+		atomic.AddInt64(&dm.counters.hits, 1)
 		return found.Value().(*DisjointRanges), nil
 	}
+	atomic.AddInt64(&dm.counters.misses, 1)
 	accessChains, err := dm.GetAccessDOTChains(uri, vk)
 	if err != nil {
 		return ranges, err
@@ -86,8 +110,9 @@ func (dm *DotMaster) GetValidRanges(uri, vk string) (*DisjointRanges, error) {
 		rng := intersectDChainArchivalTimes(dchain)
 		ranges.Merge(rng)
 	}
-	// store the result in the cache
-	dm.cache.Set(uri, vk, ranges, dm.expiry)
+	// store the result in the cache, remembering which DOTs it came from
+	dm.deps.add(cacheKey{uri, vk}, append(chainDOTs(accessChains), chainDOTs(archivalChains)...))
+	dm.cache.Set(uri, vk, ranges, dm.ttl(dm.expiry))
 	return ranges, nil
 }
 
@@ -152,6 +177,22 @@ func (dm *DotMaster) GetArchivalDOTChains(uri, vk string) ([]*objects.DChain, er
 	return dchainlist, err
 }
 
+// Returns the windows of time granted to the VK on the URI by chains of DOTs on the archive
+// namespace (archive/start/<t1>/end/<t2>/<uri>), one per chain
+func (dm *DotMaster) GetArchivalRanges(uri, vk string) ([]*TimeRange, error) {
+	var ranges []*TimeRange
+	chains, err := dm.GetArchivalDOTChains(uri, vk)
+	if err != nil {
+		return ranges, err
+	}
+	for _, dchain := range chains {
+		if rng := intersectDChainArchivalTimes(dchain); rng != nil {
+			ranges = append(ranges, rng)
+		}
+	}
+	return ranges, nil
+}
+
 // Searches for DOTs from VK [fromvk] to VK [findvk] on the archive version of the URI [uri]
 // (which will be archive/start/+/end/+/<uri>)
 // This method recurseively searches the graph and returns a list of the found DOTs
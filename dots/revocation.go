@@ -0,0 +1,246 @@
+package dots
+
+import (
+	"log"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/immesys/bw2/objects"
+	bw2 "github.com/immesys/bw2bind"
+	"github.com/pkg/errors"
+)
+
+// how often the DOTs behind cached permissions are checked against the registry
+const revalidateInterval = 30 * time.Second
+
+// the most DOTs checked against the registry each interval. When more DOTs than this are
+// cached, the ones checked longest ago go first
+const revalidateBatch = 100
+
+// cached permissions are never used for longer than this, even if the registry can't
+// be reached to check for revocations
+const DefaultMaxStaleness = 10 * time.Minute
+
+// a cached CanRead or GetValidRanges result
+type cacheKey struct {
+	uri string
+	vk  string
+}
+
+// a DOT in the chain behind a cached permission
+type dotRef struct {
+	hash string
+	// zero if the DOT doesn't expire
+	expires time.Time
+}
+
+// what is known about a DOT that cached permissions rely on
+type dotState struct {
+	// the cached permissions that relied on it
+	keys    map[cacheKey]struct{}
+	expires time.Time
+	// when the registry last said it was valid; zero if it hasn't been checked
+	checked time.Time
+}
+
+// Remembers which DOTs each cached permission was derived from, so that the cached
+// permissions can be dropped when one of the DOTs is revoked or expires
+type dependencies struct {
+	// DOT hash -> its state
+	byDOT map[string]*dotState
+	sync.Mutex
+}
+
+func newDependencies() *dependencies {
+	return &dependencies{byDOT: make(map[string]*dotState)}
+}
+
+func (deps *dependencies) add(key cacheKey, dots []dotRef) {
+	deps.Lock()
+	defer deps.Unlock()
+	for _, dot := range dots {
+		state := deps.byDOT[dot.hash]
+		if state == nil {
+			state = &dotState{keys: make(map[cacheKey]struct{}), expires: dot.expires}
+			deps.byDOT[dot.hash] = state
+		}
+		state.keys[key] = struct{}{}
+	}
+}
+
+// removes and returns the cached permissions that relied on the DOT
+func (deps *dependencies) remove(hash string) []cacheKey {
+	deps.Lock()
+	defer deps.Unlock()
+	var keys []cacheKey
+	if state := deps.byDOT[hash]; state != nil {
+		for key := range state.keys {
+			keys = append(keys, key)
+		}
+	}
+	delete(deps.byDOT, hash)
+	return keys
+}
+
+// forgets the cached permissions for which cached returns false
+func (deps *dependencies) prune(cached func(key cacheKey) bool) {
+	deps.Lock()
+	defer deps.Unlock()
+	for hash, state := range deps.byDOT {
+		for key := range state.keys {
+			if !cached(key) {
+				delete(state.keys, key)
+			}
+		}
+		if len(state.keys) == 0 {
+			delete(deps.byDOT, hash)
+		}
+	}
+}
+
+// Returns the DOTs that have expired by now, which don't need to be looked up, and at
+// most limit of the others that haven't been checked against the registry since
+// revalidateInterval ago, those checked longest ago (or never) first
+func (deps *dependencies) due(now time.Time, limit int) (expired, stale []string) {
+	deps.Lock()
+	defer deps.Unlock()
+	for hash, state := range deps.byDOT {
+		if !state.expires.IsZero() && !state.expires.After(now) {
+			expired = append(expired, hash)
+		} else if now.Sub(state.checked) >= revalidateInterval {
+			stale = append(stale, hash)
+		}
+	}
+	sort.Slice(stale, func(i, j int) bool {
+		return deps.byDOT[stale[i]].checked.Before(deps.byDOT[stale[j]].checked)
+	})
+	if len(stale) > limit {
+		stale = stale[:limit]
+	}
+	return expired, stale
+}
+
+// records that the registry said the DOT was valid at the given time
+func (deps *dependencies) checked(hash string, at time.Time) {
+	deps.Lock()
+	defer deps.Unlock()
+	if state := deps.byDOT[hash]; state != nil {
+		state.checked = at
+	}
+}
+
+// Counters describing how well the permission caches are working
+type CacheStats struct {
+	// lookups answered from the cache
+	Hits int64
+	// lookups that had to build DOT chains
+	Misses int64
+	// cached permissions dropped because a DOT they relied on was revoked or expired
+	Invalidations int64
+	// DOTs found to be revoked or expired by the revalidation loop
+	Revoked int64
+}
+
+// the fraction of lookups answered from the cache
+func (stats CacheStats) HitRate() float64 {
+	if stats.Hits+stats.Misses == 0 {
+		return 0
+	}
+	return float64(stats.Hits) / float64(stats.Hits+stats.Misses)
+}
+
+type cacheCounters struct {
+	hits          int64
+	misses        int64
+	invalidations int64
+	revoked       int64
+}
+
+// Returns the current values of the cache counters
+func (dm *DotMaster) Stats() CacheStats {
+	return CacheStats{
+		Hits:          atomic.LoadInt64(&dm.counters.hits),
+		Misses:        atomic.LoadInt64(&dm.counters.misses),
+		Invalidations: atomic.LoadInt64(&dm.counters.invalidations),
+		Revoked:       atomic.LoadInt64(&dm.counters.revoked),
+	}
+}
+
+// Drops every cached permission that was derived from the DOT with the given hash. Call
+// this when a DOT is known to have been revoked
+func (dm *DotMaster) Invalidate(dothash string) {
+	for _, key := range dm.deps.remove(dothash) {
+		dm.cache.Delete(key.uri, key.vk)
+		dm.canread.Delete(key.uri + key.vk)
+		atomic.AddInt64(&dm.counters.invalidations, 1)
+	}
+}
+
+// returns true if either of the caches still holds a permission for the key
+func (dm *DotMaster) cached(key cacheKey) bool {
+	if item := dm.cache.Get(key.uri, key.vk); item != nil && !item.Expired() {
+		return true
+	}
+	item := dm.canread.Get(key.uri + key.vk)
+	return item != nil && !item.Expired()
+}
+
+// the DOTs in the chains, with their expiry times if the chains are elaborated
+func chainDOTs(chains []*objects.DChain) []dotRef {
+	var dots []dotRef
+	for _, chain := range chains {
+		for i := 0; i < chain.NumHashes(); i++ {
+			hash := chain.GetDotHash(i)
+			if hash == nil {
+				continue
+			}
+			ref := dotRef{hash: fmtHash(hash)}
+			if dot := chain.GetDOT(i); dot != nil && dot.GetExpiry() != nil {
+				ref.expires = *dot.GetExpiry()
+			}
+			dots = append(dots, ref)
+		}
+	}
+	return dots
+}
+
+// the cache lifetime of a permission, bounded by the maximum staleness
+func (dm *DotMaster) ttl(lifetime time.Duration) time.Duration {
+	if dm.maxStaleness > 0 && lifetime > dm.maxStaleness {
+		return dm.maxStaleness
+	}
+	return lifetime
+}
+
+// Periodically drops the cached permissions relying on DOTs that have expired or been
+// revoked. Expiry is known from the DOTs themselves; revocations are found by checking a
+// batch of the DOTs against the registry each interval
+func (dm *DotMaster) revalidate() {
+	for now := range time.Tick(revalidateInterval) {
+		// expired permissions don't need checking
+		dm.deps.prune(dm.cached)
+		expired, stale := dm.deps.due(now, revalidateBatch)
+		for _, hash := range expired {
+			log.Printf("DOT %s has expired; dropping the permissions that relied on it", hash)
+			atomic.AddInt64(&dm.counters.revoked, 1)
+			dm.Invalidate(hash)
+		}
+		for _, hash := range stale {
+			_, valid, err := dm.client.ResolveRegistry(hash)
+			if err != nil {
+				// try again next time; the maximum staleness still applies
+				log.Println(errors.Wrapf(err, "Could not check validity of DOT %s", hash))
+				continue
+			}
+			if valid == bw2.StateRevoked || valid == bw2.StateExpired {
+				log.Printf("DOT %s is %s; dropping the permissions that relied on it", hash, dm.client.ValidityToString(valid, nil))
+				atomic.AddInt64(&dm.counters.revoked, 1)
+				dm.Invalidate(hash)
+			} else {
+				dm.deps.checked(hash, now)
+			}
+		}
+	}
+}
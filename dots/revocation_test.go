@@ -0,0 +1,127 @@
+package dots
+
+import (
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/karlseguin/ccache"
+)
+
+func testDotMaster(expiry, maxStaleness time.Duration) *DotMaster {
+	return &DotMaster{
+		cache:        ccache.Layered(ccache.Configure()),
+		canread:      ccache.New(ccache.Configure()),
+		expiry:       expiry,
+		maxStaleness: maxStaleness,
+		deps:         newDependencies(),
+	}
+}
+
+func TestDependencies(t *testing.T) {
+	var (
+		deps  = newDependencies()
+		alice = cacheKey{uri: "ns/soda/*", vk: "alice"}
+		bob   = cacheKey{uri: "ns/cory/*", vk: "bob"}
+	)
+	deps.add(alice, []dotRef{{hash: "d1"}, {hash: "d2"}})
+	deps.add(bob, []dotRef{{hash: "d2"}})
+
+	keys := deps.remove("d2")
+	sort.Slice(keys, func(i, j int) bool { return keys[i].vk < keys[j].vk })
+	if len(keys) != 2 || keys[0] != alice || keys[1] != bob {
+		t.Errorf("Removing d2 should return both permissions but got %v", keys)
+	}
+	if keys := deps.remove("d2"); len(keys) != 0 {
+		t.Errorf("d2 should have been forgotten but got %v", keys)
+	}
+
+	// only alice's permission is still cached, so d3 is forgotten
+	deps.add(bob, []dotRef{{hash: "d3"}})
+	deps.prune(func(key cacheKey) bool { return key == alice })
+	if _, found := deps.byDOT["d3"]; found {
+		t.Error("d3 should have been pruned")
+	}
+	if keys := deps.remove("d1"); len(keys) != 1 || keys[0] != alice {
+		t.Errorf("Removing d1 should return alice's permission but got %v", keys)
+	}
+}
+
+func TestDependenciesDue(t *testing.T) {
+	var (
+		deps = newDependencies()
+		key  = cacheKey{uri: "ns/soda/*", vk: "alice"}
+		now  = time.Unix(1000000, 0)
+	)
+	deps.add(key, []dotRef{
+		{hash: "expired", expires: now.Add(-time.Second)},
+		{hash: "later", expires: now.Add(time.Hour)},
+		{hash: "forever"},
+		{hash: "recent"},
+		{hash: "old"},
+	})
+	deps.checked("recent", now.Add(-time.Second))
+	deps.checked("old", now.Add(-time.Hour))
+	deps.checked("forever", now.Add(-2*time.Hour))
+
+	expired, stale := deps.due(now, 2)
+	if len(expired) != 1 || expired[0] != "expired" {
+		t.Errorf("Only the expired DOT should have expired but got %v", expired)
+	}
+	// "later" has never been checked, so it goes first
+	if len(stale) != 2 || stale[0] != "later" || stale[1] != "forever" {
+		t.Errorf("The batch should be [later forever] but got %v", stale)
+	}
+
+	expired, stale = deps.due(now, revalidateBatch)
+	if len(stale) != 3 {
+		t.Errorf("The recently checked DOT shouldn't be due but got %v", stale)
+	}
+	if _, stale = deps.due(now.Add(revalidateInterval), revalidateBatch); len(stale) != 4 {
+		t.Errorf("Every unexpired DOT should be due an interval later but got %v", stale)
+	}
+}
+
+func TestInvalidate(t *testing.T) {
+	var (
+		dm    = testDotMaster(time.Hour, DefaultMaxStaleness)
+		alice = cacheKey{uri: "ns/soda/*", vk: "alice"}
+		bob   = cacheKey{uri: "ns/cory/*", vk: "bob"}
+	)
+	for _, key := range []cacheKey{alice, bob} {
+		dm.cache.Set(key.uri, key.vk, new(DisjointRanges), time.Hour)
+		dm.canread.Set(key.uri+key.vk, true, time.Hour)
+	}
+	dm.deps.add(alice, []dotRef{{hash: "d1"}})
+	dm.deps.add(bob, []dotRef{{hash: "d2"}})
+
+	dm.Invalidate("d1")
+	if dm.cached(alice) {
+		t.Error("alice's permissions should have been dropped")
+	}
+	if !dm.cached(bob) {
+		t.Error("bob's permissions shouldn't have been dropped")
+	}
+	if stats := dm.Stats(); stats.Invalidations != 1 {
+		t.Errorf("Should have counted 1 invalidation but got %d", stats.Invalidations)
+	}
+	// nothing relies on an unknown DOT
+	dm.Invalidate("d3")
+	if stats := dm.Stats(); stats.Invalidations != 1 {
+		t.Errorf("Should still have counted 1 invalidation but got %d", stats.Invalidations)
+	}
+}
+
+func TestTTL(t *testing.T) {
+	dm := testDotMaster(time.Hour, 10*time.Minute)
+	if ttl := dm.ttl(time.Hour); ttl != 10*time.Minute {
+		t.Errorf("TTL should be bounded by the maximum staleness but got %s", ttl)
+	}
+	if ttl := dm.ttl(time.Minute); ttl != time.Minute {
+		t.Errorf("TTL shorter than the maximum staleness should be kept but got %s", ttl)
+	}
+	dm.maxStaleness = 0
+	if ttl := dm.ttl(time.Hour); ttl != time.Hour {
+		t.Errorf("TTL should be unbounded without a maximum staleness but got %s", ttl)
+	}
+}
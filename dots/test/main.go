@@ -13,7 +13,7 @@ func main() {
 	client := bw2.ConnectOrExit("")
 	client.SetEntityFromEnvironOrExit()
 
-	dm := dots.NewDotMaster(client, 10*time.Second)
+	dm := dots.NewDotMaster(client, 10*time.Second, dots.DefaultMaxStaleness)
 
 	vk := os.Args[1]
 	uri := os.Args[2]
@@ -43,6 +43,19 @@ func parseArchiveURI(archiveuri string) (start, end time.Time, uri string, err e
 	return
 }
 
+// Returns the URI on the archive namespace for an archival DOT granting access to the data
+// of uri in the range [start, end]; the inverse of parseArchiveURI. A zero start or end
+// leaves that side of the range open ("+")
+func ArchiveURI(start, end time.Time, uri string) string {
+	bound := func(t time.Time) string {
+		if t.IsZero() {
+			return "+"
+		}
+		return strconv.FormatInt(t.UnixNano(), 10)
+	}
+	return fmt.Sprintf("%s/start/%s/end/%s/%s", ArchiveVK, bound(start), bound(end), strings.Trim(uri, "/"))
+}
+
 // returns the <uri> component of "archive/start/+/end+/<uri>"
 func getURIFromArchiveURI(archiveuri string) string {
 	return strings.Join(strings.Split(archiveuri, "/")[5:], "/")
@@ -0,0 +1,45 @@
+package dots
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestArchiveURI(t *testing.T) {
+	for _, test := range []struct {
+		start, end time.Time
+		uri        string
+		archiveuri string
+	}{
+		{time.Unix(0, 10), time.Unix(0, 20), "ns/a/*", ArchiveVK + "/start/10/end/20/ns/a/*"},
+		{time.Time{}, time.Unix(0, 20), "/ns/a/", ArchiveVK + "/start/+/end/20/ns/a"},
+		{time.Unix(0, 10), time.Time{}, "ns/a", ArchiveVK + "/start/10/end/+/ns/a"},
+	} {
+		archiveuri := ArchiveURI(test.start, test.end, test.uri)
+		if archiveuri != test.archiveuri {
+			t.Errorf("Expected %s, got %s", test.archiveuri, archiveuri)
+			continue
+		}
+		start, end, uri, err := parseArchiveURI(archiveuri)
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		if !test.start.IsZero() && !start.Equal(test.start) {
+			t.Errorf("Start of %s should be %s, got %s", archiveuri, test.start, start)
+		}
+		if !test.end.IsZero() && !end.Equal(test.end) {
+			t.Errorf("End of %s should be %s, got %s", archiveuri, test.end, end)
+		}
+		if test.start.IsZero() && start.UnixNano() != Beginning {
+			t.Errorf("Start of %s should be open, got %s", archiveuri, start)
+		}
+		if test.end.IsZero() && end.UnixNano() != EndOfTime {
+			t.Errorf("End of %s should be open, got %s", archiveuri, end)
+		}
+		if want := strings.Trim(test.uri, "/"); uri != want {
+			t.Errorf("URI of %s should be %s, got %s", archiveuri, want, uri)
+		}
+	}
+}
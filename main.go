@@ -192,6 +192,112 @@ func main() {
 				},
 			},
 		},
+		{
+			Name:   "revoke",
+			Usage:  "Revoke the DOTs granted to a key by 'pundat grant' for access to an archiver",
+			Action: doRevoke,
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:   "agent,a",
+					Value:  "127.0.0.1:28589",
+					Usage:  "Local BOSSWAVE Agent",
+					EnvVar: "BW2_AGENT",
+				},
+				cli.StringFlag{
+					Name:   "entity,e",
+					Value:  "",
+					Usage:  "The entity that granted the DOTs",
+					EnvVar: "BW2_DEFAULT_ENTITY",
+				},
+				cli.StringFlag{
+					Name:   "bankroll, b",
+					Value:  "",
+					Usage:  "The entity to use for bankrolling",
+					EnvVar: "BW2_DEFAULT_BANKROLL",
+				},
+				cli.StringFlag{
+					Name:  "key, k",
+					Usage: "The key or alias to revoke access from",
+				},
+				cli.StringFlag{
+					Name:  "uri, u",
+					Usage: "The base URI of the archiver",
+				},
+			},
+		},
+		{
+			Name:   "access",
+			Usage:  "List the keys with access to an archiver and when their access expires",
+			Action: doAccess,
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:   "agent,a",
+					Value:  "127.0.0.1:28589",
+					Usage:  "Local BOSSWAVE Agent",
+					EnvVar: "BW2_AGENT",
+				},
+				cli.StringFlag{
+					Name:   "entity,e",
+					Value:  "",
+					Usage:  "The entity to use; keys are found by following the DOTs it granted",
+					EnvVar: "BW2_DEFAULT_ENTITY",
+				},
+				cli.StringFlag{
+					Name:  "uri, u",
+					Usage: "The base URI of the archiver",
+				},
+			},
+		},
+		{
+			Name:   "grant-range",
+			Usage:  "Grant a key access to the data archived on a URI pattern within a range of time",
+			Action: doGrantRange,
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:   "agent,a",
+					Value:  "127.0.0.1:28589",
+					Usage:  "Local BOSSWAVE Agent",
+					EnvVar: "BW2_AGENT",
+				},
+				cli.StringFlag{
+					Name:   "entity,e",
+					Value:  "",
+					Usage:  "The entity to grant from (the namespace authority, or a key with archival access)",
+					EnvVar: "BW2_DEFAULT_ENTITY",
+				},
+				cli.StringFlag{
+					Name:   "bankroll, b",
+					Value:  "",
+					Usage:  "The entity to use for bankrolling",
+					EnvVar: "BW2_DEFAULT_BANKROLL",
+				},
+				cli.StringFlag{
+					Name:  "key, k",
+					Usage: "The key or alias to grant access to",
+				},
+				cli.StringFlag{
+					Name:  "uri, u",
+					Usage: "The URI pattern of the data",
+				},
+				cli.StringFlag{
+					Name:  "from",
+					Usage: "The start of the range (a date, a Unix nanosecond timestamp, or relative to now e.g. -30d). Defaults to the beginning of time",
+				},
+				cli.StringFlag{
+					Name:  "to",
+					Usage: "The end of the range (a date, a Unix nanosecond timestamp, or relative to now e.g. +1d). Defaults to the end of time",
+				},
+				cli.StringFlag{
+					Name:  "expiry",
+					Usage: "Set the expiry on the DOT measured from now e.g. 3d7h20m. This is when the grant stops being valid, not the end of the range",
+				},
+				cli.IntFlag{
+					Name:  "ttl",
+					Value: 0,
+					Usage: "How many times the key may pass the access on",
+				},
+			},
+		},
 		// archive request commands
 		{
 			Name:   "listreq",
@@ -279,9 +385,41 @@ func main() {
 		},
 		{
 			Name:   "gettime",
-			Usage:  "Convert a time expression into a Unix nano timestamp. No arguments => returns current time",
+			Usage:  "Convert time expressions (dates, or relative to now e.g. +2h, or -- -2d) into Unix nano timestamps. No arguments => returns current time",
 			Action: doTime,
 		},
+		{
+			Name:   "audit",
+			Usage:  "Show the queries recorded in an archiver's audit log",
+			Action: doAudit,
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "log, l",
+					Value: "audit",
+					Usage: "The audit log directory (AuditLog in the archiver config)",
+				},
+				cli.StringFlag{
+					Name:  "vk, k",
+					Usage: "Only show queries made by this VK",
+				},
+				cli.StringFlag{
+					Name:  "uri, u",
+					Usage: "Only show queries that returned streams under this URI prefix",
+				},
+				cli.StringFlag{
+					Name:  "from",
+					Usage: "Only show queries made after this time (a date, a Unix nanosecond timestamp, or relative to now e.g. -2d)",
+				},
+				cli.StringFlag{
+					Name:  "to",
+					Usage: "Only show queries made before this time",
+				},
+				cli.BoolFlag{
+					Name:  "json",
+					Usage: "Print the entries as JSON, one per line",
+				},
+			},
+		},
 	}
 	app.Run(os.Args)
 }
@@ -0,0 +1,37 @@
+package querylang
+
+import "testing"
+
+func TestWherePredicate(t *testing.T) {
+	doc := map[string]interface{}{
+		"uuid":     "abc",
+		"Building": "Soda",
+		"Floor":    "4",
+	}
+	for _, test := range []struct {
+		query   string
+		matches bool
+	}{
+		{`select * where uuid = "abc";`, true},
+		{`select * where uuid != "abc";`, false},
+		{`select * where Room != "410";`, true},
+		{`select * where has Building and Floor = 4;`, true},
+		{`select * where has Room or Building like "^So";`, true},
+		{`select * where not has Building;`, false},
+		{`select * where ["3","4"] in Floor;`, true},
+		{`select * where ["3","4"] not in Floor;`, false},
+		{`select * where matches "soda";`, true},
+		{`select * where matches "Soda 4";`, true},
+		{`select * where matches "Sod";`, false},
+		{`select * where matches "Evans";`, false},
+	} {
+		parsed := NewQueryProcessor().Parse(test.query)
+		if parsed.Err != nil {
+			t.Errorf("Could not parse %s (%v)", test.query, parsed.Err)
+			continue
+		}
+		if parsed.Where.Matches(doc) != test.matches {
+			t.Errorf("Query %s (%s) should match=%v", test.query, parsed.Where, test.matches)
+		}
+	}
+}
@@ -3,21 +3,52 @@ package querylang
 
 import (
 	"github.com/gtfierro/pundat/common"
+	"github.com/karlseguin/ccache"
 	"strings"
+	"time"
 )
 
+// how long a parsed query stays in the cache after it was last used
+const parsedQueryTTL = 30 * time.Minute
+
 type QueryProcessor struct {
+	// caches ParsedQuery by QueryHash
+	cache *ccache.Cache
 }
 
-// TODO: add caching?
 func NewQueryProcessor() *QueryProcessor {
-	return &QueryProcessor{}
+	return &QueryProcessor{
+		cache: ccache.New(ccache.Configure().MaxSize(10000)),
+	}
 }
 
+// Parses the query string. Successfully parsed queries are cached, so dashboards that
+// send the same query every few seconds only pay for parsing once. Times given relative
+// to 'now' are shifted forward to the current time when a cached query is reused
 func (qp *QueryProcessor) Parse(querystring string) *ParsedQuery {
 	if !strings.HasSuffix(querystring, ";") {
 		querystring = querystring + ";"
 	}
+	if item := qp.cache.Get(querystring); item != nil && !item.Expired() {
+		item.Extend(parsedQueryTTL)
+		return item.Value().(*ParsedQuery).rebase(time.Now())
+	}
+	pq := parse(querystring)
+	if pq.Err == nil {
+		qp.cache.Set(string(pq.Hash), pq, parsedQueryTTL)
+	}
+	return pq
+}
+
+// Parses the query string without a QueryProcessor. The result is not cached
+func Parse(querystring string) *ParsedQuery {
+	if !strings.HasSuffix(querystring, ";") {
+		querystring = querystring + ";"
+	}
+	return parse(querystring)
+}
+
+func parse(querystring string) *ParsedQuery {
 	l := NewSQLex(querystring)
 	sqParse(l)
 	pq := ParsedQuery{
@@ -26,12 +57,21 @@ func (qp *QueryProcessor) Parse(querystring string) *ParsedQuery {
 		Target:    l.query.Contents,
 		Where:     l.query.where,
 		Distinct:  l.query.distinct,
+		Explain:   l.query.explain,
 		Data:      l.query.data,
+		Page:      l.query.page,
+		Lease:     l.query.lease,
+		AsOfData:  l.query.asOf.data,
 		Err:       l.error,
 		ErrPos:    l.lasttoken,
 		//TODO: have a more robust hash function
 		Hash:        QueryHash(querystring),
 		Querystring: querystring,
+		now:         l.now,
+		asOf:        l.query.asOf.ref,
+	}
+	if err := pq.resolveAsOf(l.now); err != nil && pq.Err == nil {
+		pq.Err = err
 	}
 	i := 0
 	for key, _ := range l._keys {
@@ -48,11 +88,21 @@ type ParsedQuery struct {
 	Keys []string
 	// list of tags to target for deletion or selection
 	Target []string
-	//TODO: replace these with with something that's not bson.M
-	// where clause for query
-	Where common.Dict
+	// where clause for query. nil if there is none
+	Where *common.Predicate
 	// are we querying distinct values?
 	Distinct bool
+	// if true, return the plan for evaluating the query instead of its results
+	Explain bool
+	// ordering, limit, offset and cursor of the query results
+	Page common.Pagination
+	// the lease requested by SUBSCRIBE and RENEW queries
+	Lease time.Duration
+	// if non-zero, evaluate the where clause against the metadata as it was at this time
+	AsOf time.Time
+	// if true, evaluate the where clause of a data query against the metadata that
+	// was valid at the time of each reading
+	AsOfData bool
 	// a unique representation of this query used to compare two different query objects
 	Hash QueryHash
 	Data *DataQuery
@@ -61,6 +111,42 @@ type ParsedQuery struct {
 	// token where the error in parsing took place
 	ErrPos      string
 	Querystring string
+	// the time that 'now' referred to when the query was parsed
+	now time.Time
+	// the unresolved AS OF time
+	asOf timeRef
+}
+
+// resolves the AS OF time in the timezone of the query (UTC for metadata queries)
+func (parsed *ParsedQuery) resolveAsOf(now time.Time) (err error) {
+	loc := time.UTC
+	if parsed.Data != nil && parsed.Data.loc != nil {
+		loc = parsed.Data.loc
+	}
+	parsed.AsOf, err = parsed.asOf.in(now, loc)
+	return
+}
+
+// Returns a copy of the parsed query with all times that were relative to 'now'
+// resolved again as though the query had been parsed at the given time. We resolve
+// rather than shift the times so that calendar offsets (e.g. "now -1d") stay correct
+// across daylight savings transitions in the query's timezone
+func (parsed *ParsedQuery) rebase(now time.Time) *ParsedQuery {
+	ret := *parsed
+	// the query resolved without error when it was parsed, and only the
+	// relative times can change, so these cannot fail
+	if parsed.asOf.Relative {
+		ret.resolveAsOf(now)
+		ret.now = now
+	}
+	if parsed.Data == nil || !(parsed.Data.StartRelative || parsed.Data.EndRelative) {
+		return &ret
+	}
+	data := *parsed.Data
+	data.resolve(now)
+	ret.Data = &data
+	ret.now = now
+	return &ret
 }
 
 func (parsed *ParsedQuery) GetParams() common.QueryParams {
@@ -70,11 +156,15 @@ func (parsed *ParsedQuery) GetParams() common.QueryParams {
 			return &common.DistinctParams{
 				Tag:   parsed.Target[0],
 				Where: parsed.Where,
+				Page:  parsed.Page,
+				AsOf:  parsed.AsOf,
 			}
 		}
 		return &common.TagParams{
 			Tags:  parsed.Target,
 			Where: parsed.Where,
+			Page:  parsed.Page,
+			AsOf:  parsed.AsOf,
 		}
 	case DELETE_TYPE:
 		if parsed.Data == nil {
@@ -99,15 +189,34 @@ func (parsed *ParsedQuery) GetParams() common.QueryParams {
 			Begin:           parsed.Data.Start.UnixNano(),
 			End:             parsed.Data.End.UnixNano(),
 			ConvertToUnit:   parsed.Data.Timeconv,
+			Format:          parsed.Data.Format,
 			IsStatistical:   parsed.Data.IsStatistical,
 			IsWindow:        parsed.Data.IsWindow,
 			IsChangedRanges: parsed.Data.IsChangedRanges,
+			Calendar:        parsed.Data.Calendar,
+			IsDistribution:  parsed.Data.IsDistribution,
+			Percentiles:     parsed.Data.Percentiles,
+			HistogramBins:   parsed.Data.HistogramBins,
 			Width:           parsed.Data.Width,
 			PointWidth:      int(parsed.Data.PointWidth),
 			FromGen:         parsed.Data.FromGen,
 			ToGen:           parsed.Data.ToGen,
 			Resolution:      parsed.Data.Resolution,
+			Cursor:          parsed.Page.Cursor,
+			AsOf:            parsed.AsOf,
+			AsOfData:        parsed.AsOfData,
 		}
+	case SUBSCRIBE_TYPE:
+		params := &common.SubscribeParams{
+			Where: parsed.Where,
+			Lease: parsed.Lease,
+		}
+		if len(parsed.Target) > 0 {
+			params.ID = parsed.Target[0]
+		}
+		return params
+	case UNSUBSCRIBE_TYPE:
+		return &common.UnsubscribeParams{ID: parsed.Target[0]}
 	default:
 		return nil
 	}
@@ -121,18 +230,8 @@ const (
 	DELETE_TYPE
 	DATA_TYPE
 	APPLY_TYPE
+	SUBSCRIBE_TYPE
+	UNSUBSCRIBE_TYPE
 )
 
 type QueryHash string
-
-func fixMongoKey(key string) string {
-	switch {
-	case strings.HasPrefix(key, "Metadata"):
-		return "Metadata." + strings.Replace(key[9:], ".", "|", -1)
-	case strings.HasPrefix(key, "Properties"):
-		return "Properties." + strings.Replace(key[11:], ".", "|", -1)
-	case strings.HasPrefix(key, "Actuator"):
-		return "Actuator." + strings.Replace(key[9:], ".", "|", -1)
-	}
-	return key
-}
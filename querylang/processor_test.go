@@ -0,0 +1,30 @@
+package querylang
+
+import (
+	"testing"
+	"time"
+)
+
+// a cached query with times relative to now must be resolved against the time it is reused
+func TestParsedQueryRebase(t *testing.T) {
+	parsed := parse(`select data in (now -1h, now) where uuid = "abc";`)
+	if parsed.Err != nil {
+		t.Fatal(parsed.Err)
+	}
+	later := parsed.now.Add(10 * time.Minute)
+	rebased := parsed.rebase(later)
+	if !rebased.Data.End.Equal(later) || !rebased.Data.Start.Equal(later.Add(-time.Hour)) {
+		t.Errorf("Expected the rebased query to cover (%v, %v) but got (%v, %v)", later.Add(-time.Hour), later, rebased.Data.Start, rebased.Data.End)
+	}
+	if parsed.Data.End.Equal(later) {
+		t.Error("Rebasing should not change the cached query")
+	}
+
+	absolute := parse(`select data in (1500000000s, 1500003600s) where uuid = "abc";`)
+	if absolute.Err != nil {
+		t.Fatal(absolute.Err)
+	}
+	if rebased := absolute.rebase(later); !rebased.Data.Start.Equal(absolute.Data.Start) || !rebased.Data.End.Equal(absolute.Data.End) {
+		t.Errorf("Rebasing should not move absolute times, got (%v, %v)", rebased.Data.Start, rebased.Data.End)
+	}
+}
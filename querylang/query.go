@@ -1,14 +1,19 @@
+// Code generated by goyacc -o query.go -p sq query.y. DO NOT EDIT.
+
 //line query.y:2
+
 package querylang
 
 import __yyfmt__ "fmt"
 
 //line query.y:3
+
 import (
 	"bufio"
 	"fmt"
 	"github.com/gtfierro/pundat/common"
 	"github.com/taylorchu/toki"
+	"regexp"
 	"strconv"
 	"strings"
 	_time "time"
@@ -18,17 +23,22 @@ import (
 Notes here
 **/
 
-//line query.y:21
+//line query.y:22
 type sqSymType struct {
 	yys      int
 	str      string
-	dict     common.Dict
+	pred     *common.Predicate
 	data     *DataQuery
 	limit    Limit
-	timeconv common.UnitOfTime
+	timeconv outputFormat
 	list     List
-	time     _time.Time
-	timediff _time.Duration
+	time     timeRef
+	timediff relTime
+	page     common.Pagination
+	align    windowAlign
+	floats   []float64
+	duration _time.Duration
+	asof     asOf
 }
 
 const SELECT = 57346
@@ -66,10 +76,28 @@ const LPAREN = 57377
 const RPAREN = 57378
 const LBRACK = 57379
 const RBRACK = 57380
-const NUMBER = 57381
-const SEMICOLON = 57382
-const NEWLINE = 57383
-const TIMEUNIT = 57384
+const ORDER = 57381
+const BY = 57382
+const ASC = 57383
+const DESC = 57384
+const OFFSET = 57385
+const CURSOR = 57386
+const EXPLAIN = 57387
+const TZ = 57388
+const ALIGN = 57389
+const LOCAL = 57390
+const WEEKSTART = 57391
+const PERCENTILE = 57392
+const HISTOGRAM = 57393
+const SUBSCRIBE = 57394
+const UNSUBSCRIBE = 57395
+const RENEW = 57396
+const LEASE = 57397
+const OF = 57398
+const NUMBER = 57399
+const SEMICOLON = 57400
+const NEWLINE = 57401
+const TIMEUNIT = 57402
 
 var sqToknames = [...]string{
 	"$end",
@@ -110,22 +138,46 @@ var sqToknames = [...]string{
 	"RPAREN",
 	"LBRACK",
 	"RBRACK",
+	"ORDER",
+	"BY",
+	"ASC",
+	"DESC",
+	"OFFSET",
+	"CURSOR",
+	"EXPLAIN",
+	"TZ",
+	"ALIGN",
+	"LOCAL",
+	"WEEKSTART",
+	"PERCENTILE",
+	"HISTOGRAM",
+	"SUBSCRIBE",
+	"UNSUBSCRIBE",
+	"RENEW",
+	"LEASE",
+	"OF",
 	"NUMBER",
 	"SEMICOLON",
 	"NEWLINE",
 	"TIMEUNIT",
 }
+
 var sqStatenames = [...]string{}
 
 const sqEofCode = 1
 const sqErrCode = 2
 const sqInitialStackSize = 16
 
-//line query.y:391
+//line query.y:595
 
 const eof = 0
 
-var supported_formats = []string{"1/2/2006",
+var supported_formats = []string{_time.RFC3339Nano,
+	_time.RFC3339,
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"2006-01-02",
+	"1/2/2006",
 	"1-2-2006",
 	"1/2/2006 03:04:05 PM MST",
 	"1-2-2006 03:04:05 PM MST",
@@ -144,6 +196,10 @@ func (qt QueryType) String() string {
 		ret = "delete"
 	case DATA_TYPE:
 		ret = "data"
+	case SUBSCRIBE_TYPE:
+		ret = "subscribe"
+	case UNSUBSCRIBE_TYPE:
+		ret = "unsubscribe"
 	}
 	return ret
 }
@@ -154,11 +210,19 @@ type query struct {
 	// information about a data query if we are one
 	data *DataQuery
 	// where clause for query
-	where common.Dict
+	where *common.Predicate
 	// are we querying distinct values?
 	distinct bool
+	// return the plan for the query instead of evaluating it
+	explain bool
 	// list of tags to target for deletion, selection
 	Contents []string
+	// ordering and paging of the results
+	page common.Pagination
+	// requested lease of a subscription
+	lease _time.Duration
+	// the time at which to evaluate the where clause (AS OF)
+	asOf asOf
 }
 
 func (q *query) Print() {
@@ -185,6 +249,8 @@ type sqLex struct {
 	// all keys that we encounter. Used for republish concerns
 	_keys map[string]struct{}
 	keys  []string
+	// the time that 'now' refers to in this query
+	now _time.Time
 }
 
 func NewSQLex(s string) *sqLex {
@@ -192,6 +258,18 @@ func NewSQLex(s string) *sqLex {
 		[]toki.Def{
 			{Token: WHERE, Pattern: "\\bwhere\\b"},
 			{Token: SELECT, Pattern: "\\bselect\\b"},
+			{Token: EXPLAIN, Pattern: "\\bexplain\\b"},
+			{Token: TZ, Pattern: "\\btz\\b"},
+			{Token: ALIGN, Pattern: "\\balign\\b"},
+			{Token: PERCENTILE, Pattern: "\\bpercentile\\b"},
+			{Token: UNSUBSCRIBE, Pattern: "\\bunsubscribe\\b"},
+			{Token: SUBSCRIBE, Pattern: "\\bsubscribe\\b"},
+			{Token: RENEW, Pattern: "\\brenew\\b"},
+			{Token: LEASE, Pattern: "\\blease\\b"},
+			{Token: OF, Pattern: "\\bof\\b"},
+			{Token: HISTOGRAM, Pattern: "\\bhistogram\\b"},
+			{Token: LOCAL, Pattern: "\\blocal\\b"},
+			{Token: WEEKSTART, Pattern: "\\bweekstart\\b"},
 			{Token: APPLY, Pattern: "\\bapply\\b"},
 			{Token: DELETE, Pattern: "\\bdelete\\b"},
 			{Token: DISTINCT, Pattern: "\\bdistinct\\b"},
@@ -200,6 +278,12 @@ func NewSQLex(s string) *sqLex {
 			{Token: WINDOW, Pattern: "\\bwindow\\b"},
 			{Token: CHANGED, Pattern: "\\bchanged\\b"},
 			{Token: LIMIT, Pattern: "\\blimit\\b"},
+			{Token: ORDER, Pattern: "\\border\\b"},
+			{Token: BY, Pattern: "\\bby\\b"},
+			{Token: ASC, Pattern: "\\basc\\b"},
+			{Token: DESC, Pattern: "\\bdesc\\b"},
+			{Token: OFFSET, Pattern: "\\boffset\\b"},
+			{Token: CURSOR, Pattern: "\\bcursor\\b"},
 			{Token: STREAMLIMIT, Pattern: "\\bstreamlimit\\b"},
 			{Token: ALL, Pattern: "\\*"},
 			{Token: NOW, Pattern: "\\bnow\\b"},
@@ -231,7 +315,7 @@ func NewSQLex(s string) *sqLex {
 		})
 	scanner.SetInput(s)
 	q := &query{Contents: []string{}, distinct: false}
-	return &sqLex{query: q, querystring: s, scanner: scanner, error: nil, lasttoken: "", _keys: map[string]struct{}{}, tokens: []string{}}
+	return &sqLex{query: q, querystring: s, scanner: scanner, error: nil, lasttoken: "", _keys: map[string]struct{}{}, tokens: []string{}, now: _time.Now()}
 }
 
 func (sq *sqLex) Lex(lval *sqSymType) int {
@@ -249,6 +333,124 @@ func (sq *sqLex) Error(s string) {
 	sq.error = fmt.Errorf(s)
 }
 
+// reports an error if the pattern given to LIKE is not a valid regular expression
+func (sq *sqLex) checkRegex(pattern string) {
+	if _, err := regexp.Compile(pattern); err != nil {
+		sq.Error(fmt.Sprintf("Invalid regular expression \"%v\" (%v)", pattern, err.Error()))
+	}
+}
+
+// reports an error if a metadata query is AS OF DATA, which only makes sense for data queries
+func (sq *sqLex) metadataAsOf(at asOf) asOf {
+	if at.data {
+		sq.Error("AS OF DATA can only be used in data queries")
+	}
+	return at
+}
+
+// reports an error if the search given to MATCHES has no words to search for
+func (sq *sqLex) checkSearch(search string) {
+	if len(common.SearchTerms(search)) == 0 {
+		sq.Error(fmt.Sprintf("MATCHES \"%v\" has no words to search for", search))
+	}
+}
+
+// Builds a data query over [start, end]. The times are resolved in the timezone
+// named by tz, which defaults to UTC
+func (sq *sqLex) dataQuery(dtype DataQueryType, start, end timeRef, limit Limit, format outputFormat, tz string) *DataQuery {
+	loc, err := _time.LoadLocation(tz)
+	if err != nil {
+		sq.Error(fmt.Sprintf("Unknown timezone \"%v\" (%v)", tz, err.Error()))
+		loc = _time.UTC
+	}
+	dq := &DataQuery{
+		Dtype:         dtype,
+		StartRelative: start.Relative,
+		EndRelative:   end.Relative,
+		Limit:         limit,
+		Timeconv:      format.unit,
+		Format:        common.TimeFormat{Timezone: loc.String(), RFC3339: format.rfc3339},
+		start:         start,
+		end:           end,
+		loc:           loc,
+	}
+	if err := dq.resolve(sq.now); err != nil {
+		sq.Error(err.Error())
+	}
+	return dq
+}
+
+// Parses one term of a relative time. Days are kept separate from the rest of
+// the duration so that they can be added as calendar days
+func (sq *sqLex) parseReltime(num, units string) relTime {
+	switch units {
+	case "d", "day", "days":
+		days, err := strconv.ParseInt(num, 10, 64)
+		if err != nil {
+			sq.Error(fmt.Sprintf("Error parsing relative time \"%v %v\" (%v)", num, units, err.Error()))
+		}
+		return relTime{days: int(days)}
+	}
+	dur, err := common.ParseReltime(num, units)
+	if err != nil {
+		sq.Error(fmt.Sprintf("Error parsing relative time \"%v %v\" (%v)", num, units, err.Error()))
+	}
+	return relTime{duration: dur}
+}
+
+// Parses the width of a window that isn't aligned to the calendar. Weeks are 7 days of
+// 24 hours; months, quarters and years vary in length, so they must be aligned
+func (sq *sqLex) parseWindowWidth(num, units string) uint64 {
+	unit, isCalendar := common.ParseCalendarUnit(units)
+	switch {
+	case isCalendar && unit == common.CalendarWeek:
+		weeks, err := strconv.ParseInt(num, 10, 64)
+		if err != nil {
+			sq.Error(fmt.Sprintf("Error parsing relative time \"%v %v\" (%v)", num, units, err.Error()))
+		}
+		num, units = strconv.FormatInt(7*weeks, 10), "days"
+	case isCalendar && unit != common.CalendarDay:
+		sq.Error(fmt.Sprintf("Windows of %vs vary in length; use ALIGN LOCAL to align them to the calendar", unit))
+		return 0
+	}
+	dur, err := common.ParseReltime(num, units)
+	if err != nil {
+		sq.Error(fmt.Sprintf("Error parsing relative time \"%v %v\" (%v)", num, units, err.Error()))
+	}
+	return uint64(dur.Nanoseconds())
+}
+
+// parses an argument to PERCENTILE, which must be between 0 and 100
+func (sq *sqLex) parsePercentile(num string) float64 {
+	p, err := strconv.ParseFloat(num, 64)
+	if err != nil || p < 0 || p > 100 {
+		sq.Error(fmt.Sprintf("Percentiles must be between 0 and 100, not \"%v\"", num))
+	}
+	return p
+}
+
+func (sq *sqLex) parseWeekday(day string) _time.Weekday {
+	wd, err := common.ParseWeekday(day)
+	if err != nil {
+		sq.Error(err.Error())
+	}
+	return wd
+}
+
+// parses the non-negative integer arguments to LIMIT and OFFSET in a metadata query
+func (sq *sqLex) parseCount(num string) int {
+	i, err := strconv.ParseInt(num, 10, 64)
+	if err != nil {
+		sq.Error(fmt.Sprintf("Could not parse integer \"%v\" (%v)", num, err.Error()))
+		return 0
+	}
+	if i < 0 {
+		sq.Error(fmt.Sprintf("Expected a non-negative integer but got %v", num))
+		return 0
+	}
+	return int(i)
+}
+
 func readline(fi *bufio.Reader) (string, bool) {
 	fmt.Printf("smap> ")
 	s, err := fi.ReadString('\n')
@@ -261,130 +463,172 @@ func readline(fi *bufio.Reader) (string, bool) {
 // Parse has been moved to query_processor.go
 
 //line yacctab:1
-var sqExca = [...]int{
+var sqExca = [...]int8{
 	-1, 1,
 	1, -1,
 	-2, 0,
 }
 
-const sqNprod = 53
 const sqPrivate = 57344
 
-var sqTokenNames []string
-var sqStates []string
-
-const sqLast = 169
-
-var sqAct = [...]int{
-
-	99, 77, 48, 74, 50, 70, 45, 36, 35, 49,
-	14, 50, 58, 49, 43, 50, 22, 42, 32, 18,
-	125, 121, 75, 89, 15, 107, 44, 102, 101, 56,
-	47, 51, 52, 38, 47, 55, 37, 34, 14, 54,
-	40, 66, 41, 61, 71, 65, 15, 20, 53, 69,
-	94, 72, 144, 140, 80, 38, 139, 130, 37, 119,
-	106, 93, 40, 83, 41, 87, 88, 90, 85, 86,
-	82, 81, 129, 123, 122, 91, 29, 28, 27, 26,
-	97, 103, 124, 98, 116, 24, 25, 68, 67, 115,
-	92, 59, 60, 100, 6, 138, 133, 132, 71, 111,
-	108, 109, 112, 110, 23, 8, 118, 96, 10, 12,
-	11, 13, 120, 9, 95, 63, 64, 50, 84, 15,
-	62, 126, 73, 113, 7, 57, 30, 131, 15, 127,
-	128, 76, 17, 78, 79, 114, 134, 19, 21, 136,
-	137, 142, 143, 145, 146, 141, 147, 135, 148, 31,
-	10, 12, 11, 13, 18, 9, 117, 105, 104, 18,
-	2, 5, 3, 1, 46, 16, 39, 4, 33,
+const sqLast = 271
+
+var sqAct = [...]uint8{
+	170, 51, 68, 71, 95, 114, 22, 111, 78, 62,
+	147, 28, 30, 50, 106, 142, 35, 138, 136, 72,
+	72, 29, 29, 72, 4, 29, 5, 29, 101, 98,
+	14, 83, 60, 16, 18, 17, 21, 67, 15, 47,
+	73, 74, 58, 195, 23, 188, 183, 22, 112, 13,
+	160, 79, 150, 149, 90, 139, 99, 91, 70, 70,
+	96, 81, 70, 86, 129, 3, 80, 77, 105, 94,
+	109, 100, 6, 8, 7, 19, 20, 59, 76, 120,
+	117, 16, 18, 17, 21, 26, 15, 4, 75, 5,
+	65, 127, 128, 130, 131, 155, 192, 156, 125, 126,
+	97, 137, 178, 171, 12, 102, 141, 140, 163, 164,
+	108, 104, 143, 145, 146, 165, 166, 64, 134, 29,
+	230, 222, 221, 19, 20, 200, 219, 218, 151, 204,
+	185, 158, 177, 103, 161, 6, 8, 7, 133, 96,
+	162, 123, 121, 119, 23, 118, 32, 167, 208, 194,
+	82, 168, 174, 53, 169, 23, 52, 49, 179, 193,
+	55, 190, 56, 189, 53, 44, 43, 52, 184, 42,
+	41, 55, 40, 56, 39, 187, 37, 38, 61, 186,
+	181, 66, 93, 92, 180, 176, 175, 197, 132, 84,
+	85, 196, 198, 199, 201, 36, 202, 203, 88, 89,
+	26, 148, 33, 87, 220, 210, 212, 205, 209, 213,
+	214, 215, 216, 217, 207, 33, 206, 182, 144, 135,
+	124, 122, 110, 225, 223, 224, 45, 226, 227, 233,
+	234, 25, 236, 237, 228, 229, 235, 231, 232, 239,
+	23, 172, 31, 34, 113, 173, 238, 115, 116, 211,
+	191, 159, 157, 153, 152, 27, 46, 26, 11, 57,
+	1, 2, 107, 154, 24, 9, 63, 69, 54, 10,
+	48,
 }
-var sqPact = [...]int{
-
-	156, -1000, 100, 142, 7, 147, -1000, -1000, 109, 71,
-	44, 43, 42, 41, 103, -1000, 147, -22, 5, -23,
-	-1000, -26, -1000, -9, -5, -5, 9, 0, -4, -10,
-	109, -28, -1000, 62, 27, -1000, 94, 109, 97, 55,
-	27, 97, -1000, -1000, -5, 99, -17, 112, -1000, -1000,
-	-1000, 117, 117, 35, 34, 109, 95, -1000, -1000, 27,
-	27, -1000, 97, -16, 97, -1000, -1000, 109, 57, 25,
-	12, 91, 84, -5, -1000, 109, -1000, 66, -11, -12,
-	66, 145, 144, 24, -14, -1000, -1000, -1000, -1000, -1000,
-	-1000, -1000, 109, -1000, -1000, 97, -5, 117, -17, -1000,
-	104, 118, -1000, -1000, 56, 51, 143, 83, -1000, -1000,
-	23, 66, -1000, -1000, -18, 39, 38, 49, -19, 117,
-	-1000, -1000, -5, -5, 37, 21, 66, 74, 73, -5,
-	134, -1000, -5, -5, 72, -1000, 20, 17, -5, 117,
-	117, 16, 66, 66, 117, -1000, -1000, 66, -1000,
+
+var sqPact = [...]int16{
+	20, -1000, -1000, 83, 25, 73, 242, 99, 99, -1000,
+	188, 245, -1000, -1000, 221, 162, 139, 137, 135, 134,
+	131, 130, 203, -1000, 245, -19, 125, 245, 22, -1000,
+	-26, 175, 78, 34, 175, -1000, 2, 5, 5, 31,
+	21, 10, -6, 9, 4, 221, -27, -1000, 160, 136,
+	-1000, 177, 221, 99, 150, 136, 99, 22, -29, -1,
+	-1000, 78, -30, 89, 71, 1, 66, 5, 199, -9,
+	225, -1000, -1000, 231, 231, 109, 107, 221, 106, 198,
+	105, 197, -1000, -1000, 136, 136, -1000, 99, 7, 99,
+	-1000, -1000, 221, 155, 102, 80, 196, -40, -1000, 221,
+	-41, -1000, -2, 99, 221, -1000, -1000, -43, 99, 195,
+	5, -1000, 221, -1000, 174, -4, -5, 174, 241, 240,
+	48, 239, -6, 238, -7, -1000, -1000, -1000, -1000, -1000,
+	-1000, -1000, 221, -1000, -1000, 99, -1000, -1000, -1000, 65,
+	-1000, 74, -1000, -1000, 5, 231, -9, 57, 222, 228,
+	-1000, 57, 153, 152, 96, 54, 221, 151, -1000, 147,
+	194, -1000, -1000, -11, 99, -1000, -1000, 94, 174, -1000,
+	-1000, 99, -1000, -12, -1000, 128, 126, 237, 47, -1000,
+	124, 114, -14, -1000, -1000, 231, 57, -1000, -1000, 5,
+	5, 92, 221, 5, 5, 93, 174, -1000, 193, 191,
+	113, -1000, 185, 182, 236, 57, 5, 5, 5, 5,
+	5, -1000, -1000, 91, 90, 181, 86, 85, 231, 231,
+	5, 231, 231, 174, 174, 84, 174, 174, 57, 57,
+	231, 57, 57, -1000, -1000, 174, -1000, -1000, 57, -1000,
 }
-var sqPgo = [...]int{
 
-	0, 168, 8, 132, 167, 94, 5, 166, 161, 6,
-	164, 3, 1, 0, 2, 7, 163,
+var sqPgo = [...]int16{
+	0, 270, 13, 231, 269, 104, 4, 268, 258, 2,
+	267, 7, 5, 10, 9, 266, 263, 8, 42, 146,
+	262, 0, 3, 1, 260, 261,
 }
-var sqR1 = [...]int{
 
-	0, 16, 16, 16, 16, 16, 5, 5, 7, 6,
+var sqR1 = [...]int8{
+	0, 24, 24, 25, 25, 25, 25, 25, 25, 25,
+	25, 19, 19, 19, 18, 18, 5, 5, 7, 6,
 	6, 4, 4, 4, 4, 8, 8, 8, 8, 8,
-	8, 8, 8, 9, 9, 10, 10, 10, 10, 11,
-	11, 12, 12, 12, 12, 13, 13, 3, 2, 2,
-	2, 2, 2, 2, 2, 2, 2, 14, 15, 1,
-	1, 1, 1,
+	8, 8, 8, 8, 8, 9, 9, 10, 10, 10,
+	10, 11, 11, 12, 12, 12, 12, 13, 13, 17,
+	17, 16, 16, 16, 16, 21, 21, 14, 14, 14,
+	14, 14, 15, 15, 15, 15, 20, 20, 3, 2,
+	2, 2, 2, 2, 2, 2, 2, 2, 22, 23,
+	1, 1, 1, 1,
 }
-var sqR2 = [...]int{
-
-	0, 4, 3, 4, 4, 3, 1, 3, 3, 1,
-	3, 1, 1, 2, 1, 9, 7, 13, 13, 14,
-	9, 5, 5, 1, 2, 2, 1, 1, 1, 2,
-	3, 0, 2, 2, 4, 0, 2, 2, 3, 3,
-	3, 3, 2, 2, 3, 4, 3, 1, 1, 3,
-	3, 2, 1,
+
+var sqR2 = [...]int8{
+	0, 1, 2, 6, 5, 6, 4, 3, 5, 4,
+	3, 0, 3, 3, 0, 3, 1, 3, 3, 1,
+	3, 1, 1, 2, 1, 10, 8, 14, 14, 16,
+	14, 14, 9, 6, 6, 1, 2, 2, 1, 1,
+	1, 2, 3, 0, 2, 2, 4, 0, 2, 1,
+	3, 0, 2, 2, 4, 0, 2, 1, 3, 5,
+	3, 5, 0, 3, 4, 4, 0, 2, 2, 3,
+	3, 3, 3, 2, 2, 3, 4, 3, 1, 1,
+	3, 3, 2, 1,
 }
-var sqChk = [...]int{
-
-	-1000, -16, 4, 6, -4, -8, -5, 24, 5, 13,
-	8, 10, 9, 11, -15, 19, -8, -3, 12, -3,
-	40, -3, -15, 33, 14, 15, 35, 35, 35, 35,
-	23, -3, 40, -1, 32, -2, -15, 31, 28, -7,
-	35, 37, 40, 40, 35, -9, -10, 39, -14, 18,
-	20, -9, -9, 39, 39, 39, 39, -5, 40, 29,
-	30, -2, 26, 21, 22, -15, -14, 33, 32, -2,
-	-6, -14, -9, 23, -11, 39, 19, -12, 16, 17,
-	-12, 36, 36, -15, 23, -2, -2, -14, -14, 39,
-	-14, -15, 33, 36, 38, 23, 23, -9, -15, -13,
-	27, 39, 39, -13, 13, 13, 36, 39, -15, -6,
-	-9, -12, -11, 19, 17, 33, 33, 13, 23, 36,
-	-13, 39, 35, 35, 33, 39, -12, -9, -9, 35,
-	36, -13, 23, 23, -9, 13, -9, -9, 23, 36,
-	36, -9, -12, -12, 36, -13, -13, -12, -13,
+
+var sqChk = [...]int16{
+	-1000, -24, -25, 45, 4, 6, 52, 54, 53, -25,
+	-4, -8, -5, 24, 5, 13, 8, 10, 9, 50,
+	51, 11, -23, 19, -8, -3, 12, 13, -22, 20,
+	-22, -3, -19, 27, -3, -23, 33, 14, 15, 35,
+	35, 35, 35, 35, 35, 23, -3, 58, -1, 32,
+	-2, -23, 31, 28, -7, 35, 37, -3, -18, 55,
+	58, -19, -14, -15, 39, 56, -19, 35, -9, -10,
+	57, -22, 18, -9, -9, 57, 57, 57, -17, 57,
+	57, 57, -5, 58, 29, 30, -2, 26, 21, 22,
+	-23, -22, 33, 32, -2, -6, -22, -18, 58, 57,
+	-14, 58, 16, 44, 40, -9, 13, -20, 44, -9,
+	23, -11, 57, 19, -12, 16, 17, -12, 36, 36,
+	-23, 36, 23, 36, 23, -2, -2, -22, -22, 57,
+	-22, -23, 33, 36, 38, 23, 58, -23, 58, 57,
+	-22, -23, 58, -22, 23, -9, -23, -13, 27, 57,
+	57, -13, 13, 13, -16, 47, 49, 13, -17, 13,
+	57, -23, -6, 43, 44, 41, 42, -9, -12, -11,
+	-21, 46, 19, 17, -21, 33, 33, 36, 48, -23,
+	33, 33, 23, 57, -22, 36, -13, -22, 57, 35,
+	35, 13, 49, 35, 35, 57, -12, -21, -9, -9,
+	33, -23, -9, -9, 36, -13, 23, 23, 35, 23,
+	23, 13, -21, -9, -9, -9, -9, -9, 36, 36,
+	23, 36, 36, -12, -12, -9, -12, -12, -13, -13,
+	36, -13, -13, -21, -21, -12, -21, -21, -13, -21,
 }
-var sqDef = [...]int{
-
-	0, -2, 0, 0, 0, 0, 11, 12, 14, 0,
-	0, 0, 0, 0, 6, 48, 0, 0, 0, 0,
-	2, 0, 13, 0, 0, 0, 0, 0, 0, 0,
-	0, 0, 5, 37, 0, 52, 0, 0, 0, 0,
-	0, 0, 1, 3, 0, 0, 23, 26, 27, 28,
-	47, 31, 31, 0, 0, 0, 0, 7, 4, 0,
-	0, 51, 0, 0, 0, 42, 43, 0, 0, 0,
-	0, 9, 0, 0, 24, 0, 25, 35, 0, 0,
-	35, 0, 0, 0, 0, 49, 50, 38, 39, 40,
-	41, 44, 0, 46, 8, 0, 0, 31, 29, 21,
-	0, 32, 33, 22, 0, 0, 0, 0, 45, 10,
-	0, 35, 30, 36, 0, 0, 0, 0, 0, 31,
-	16, 34, 0, 0, 0, 0, 35, 0, 0, 0,
-	0, 15, 0, 0, 0, 20, 0, 0, 0, 31,
-	31, 0, 35, 35, 31, 17, 18, 35, 19,
+
+var sqDef = [...]int8{
+	0, -2, 1, 0, 0, 0, 0, 0, 0, 2,
+	11, 0, 21, 22, 24, 0, 0, 0, 0, 0,
+	0, 0, 16, 79, 0, 0, 0, 0, 14, 78,
+	0, 11, 62, 0, 11, 23, 0, 0, 0, 0,
+	0, 0, 0, 0, 0, 0, 0, 7, 68, 0,
+	83, 0, 0, 0, 0, 0, 0, 14, 0, 0,
+	10, 62, 0, 57, 0, 0, 66, 0, 0, 35,
+	38, 39, 40, 43, 43, 0, 0, 0, 0, 49,
+	0, 0, 17, 6, 0, 0, 82, 0, 0, 0,
+	73, 74, 0, 0, 0, 0, 19, 0, 9, 0,
+	0, 4, 0, 0, 0, 12, 13, 0, 0, 0,
+	0, 36, 0, 37, 47, 0, 0, 47, 0, 0,
+	51, 0, 0, 0, 0, 80, 81, 69, 70, 71,
+	72, 75, 0, 77, 18, 0, 8, 15, 3, 58,
+	60, 63, 5, 67, 0, 43, 41, 55, 0, 44,
+	45, 55, 0, 0, 0, 0, 0, 0, 50, 0,
+	0, 76, 20, 0, 0, 64, 65, 0, 47, 42,
+	33, 0, 48, 0, 34, 0, 0, 0, 52, 53,
+	0, 0, 0, 59, 61, 43, 55, 56, 46, 0,
+	0, 0, 0, 0, 0, 0, 47, 26, 0, 0,
+	0, 54, 0, 0, 0, 55, 0, 0, 0, 0,
+	0, 32, 25, 0, 0, 0, 0, 0, 43, 43,
+	0, 43, 43, 47, 47, 0, 47, 47, 55, 55,
+	43, 55, 55, 27, 28, 47, 30, 31, 55, 29,
 }
-var sqTok1 = [...]int{
 
+var sqTok1 = [...]int8{
 	1,
 }
-var sqTok2 = [...]int{
 
+var sqTok2 = [...]int8{
 	2, 3, 4, 5, 6, 7, 8, 9, 10, 11,
 	12, 13, 14, 15, 16, 17, 18, 19, 20, 21,
 	22, 23, 24, 25, 26, 27, 28, 29, 30, 31,
 	32, 33, 34, 35, 36, 37, 38, 39, 40, 41,
-	42,
+	42, 43, 44, 45, 46, 47, 48, 49, 50, 51,
+	52, 53, 54, 55, 56, 57, 58, 59, 60,
 }
-var sqTok3 = [...]int{
+
+var sqTok3 = [...]int8{
 	0,
 }
 
@@ -466,9 +710,9 @@ func sqErrorMessage(state, lookAhead int) string {
 	expected := make([]int, 0, 4)
 
 	// Look for shiftable tokens.
-	base := sqPact[state]
+	base := int(sqPact[state])
 	for tok := TOKSTART; tok-1 < len(sqToknames); tok++ {
-		if n := base + tok; n >= 0 && n < sqLast && sqChk[sqAct[n]] == tok {
+		if n := base + tok; n >= 0 && n < sqLast && int(sqChk[int(sqAct[n])]) == tok {
 			if len(expected) == cap(expected) {
 				return res
 			}
@@ -478,13 +722,13 @@ func sqErrorMessage(state, lookAhead int) string {
 
 	if sqDef[state] == -2 {
 		i := 0
-		for sqExca[i] != -1 || sqExca[i+1] != state {
+		for sqExca[i] != -1 || int(sqExca[i+1]) != state {
 			i += 2
 		}
 
 		// Look for tokens that we accept or reduce.
 		for i += 2; sqExca[i] >= 0; i += 2 {
-			tok := sqExca[i]
+			tok := int(sqExca[i])
 			if tok < TOKSTART || sqExca[i+1] == 0 {
 				continue
 			}
@@ -515,30 +759,30 @@ func sqlex1(lex sqLexer, lval *sqSymType) (char, token int) {
 	token = 0
 	char = lex.Lex(lval)
 	if char <= 0 {
-		token = sqTok1[0]
+		token = int(sqTok1[0])
 		goto out
 	}
 	if char < len(sqTok1) {
-		token = sqTok1[char]
+		token = int(sqTok1[char])
 		goto out
 	}
 	if char >= sqPrivate {
 		if char < sqPrivate+len(sqTok2) {
-			token = sqTok2[char-sqPrivate]
+			token = int(sqTok2[char-sqPrivate])
 			goto out
 		}
 	}
 	for i := 0; i < len(sqTok3); i += 2 {
-		token = sqTok3[i+0]
+		token = int(sqTok3[i+0])
 		if token == char {
-			token = sqTok3[i+1]
+			token = int(sqTok3[i+1])
 			goto out
 		}
 	}
 
 out:
 	if token == 0 {
-		token = sqTok2[1] /* unknown char */
+		token = int(sqTok2[1]) /* unknown char */
 	}
 	if sqDebug >= 3 {
 		__yyfmt__.Printf("lex %s(%d)\n", sqTokname(token), uint(char))
@@ -593,7 +837,7 @@ sqstack:
 	sqS[sqp].yys = sqstate
 
 sqnewstate:
-	sqn = sqPact[sqstate]
+	sqn = int(sqPact[sqstate])
 	if sqn <= sqFlag {
 		goto sqdefault /* simple state */
 	}
@@ -604,8 +848,8 @@ sqnewstate:
 	if sqn < 0 || sqn >= sqLast {
 		goto sqdefault
 	}
-	sqn = sqAct[sqn]
-	if sqChk[sqn] == sqtoken { /* valid shift */
+	sqn = int(sqAct[sqn])
+	if int(sqChk[sqn]) == sqtoken { /* valid shift */
 		sqrcvr.char = -1
 		sqtoken = -1
 		sqVAL = sqrcvr.lval
@@ -618,7 +862,7 @@ sqnewstate:
 
 sqdefault:
 	/* default state action */
-	sqn = sqDef[sqstate]
+	sqn = int(sqDef[sqstate])
 	if sqn == -2 {
 		if sqrcvr.char < 0 {
 			sqrcvr.char, sqtoken = sqlex1(sqlex, &sqrcvr.lval)
@@ -627,18 +871,18 @@ sqdefault:
 		/* look through exception table */
 		xi := 0
 		for {
-			if sqExca[xi+0] == -1 && sqExca[xi+1] == sqstate {
+			if sqExca[xi+0] == -1 && int(sqExca[xi+1]) == sqstate {
 				break
 			}
 			xi += 2
 		}
 		for xi += 2; ; xi += 2 {
-			sqn = sqExca[xi+0]
+			sqn = int(sqExca[xi+0])
 			if sqn < 0 || sqn == sqtoken {
 				break
 			}
 		}
-		sqn = sqExca[xi+1]
+		sqn = int(sqExca[xi+1])
 		if sqn < 0 {
 			goto ret0
 		}
@@ -660,10 +904,10 @@ sqdefault:
 
 			/* find a state where "error" is a legal shift action */
 			for sqp >= 0 {
-				sqn = sqPact[sqS[sqp].yys] + sqErrCode
+				sqn = int(sqPact[sqS[sqp].yys]) + sqErrCode
 				if sqn >= 0 && sqn < sqLast {
-					sqstate = sqAct[sqn] /* simulate a shift of "error" */
-					if sqChk[sqstate] == sqErrCode {
+					sqstate = int(sqAct[sqn]) /* simulate a shift of "error" */
+					if int(sqChk[sqstate]) == sqErrCode {
 						goto sqstack
 					}
 				}
@@ -699,7 +943,7 @@ sqdefault:
 	sqpt := sqp
 	_ = sqpt // guard against "declared and not used"
 
-	sqp -= sqR2[sqn]
+	sqp -= int(sqR2[sqn])
 	// sqp is now the index of $0. Perform the default action. Iff the
 	// reduced production is ε, $1 is possibly out of range.
 	if sqp+1 >= len(sqS) {
@@ -710,162 +954,265 @@ sqdefault:
 	sqVAL = sqS[sqp+1]
 
 	/* consult goto table to find next state */
-	sqn = sqR1[sqn]
-	sqg := sqPgo[sqn]
+	sqn = int(sqR1[sqn])
+	sqg := int(sqPgo[sqn])
 	sqj := sqg + sqS[sqp].yys + 1
 
 	if sqj >= sqLast {
-		sqstate = sqAct[sqg]
+		sqstate = int(sqAct[sqg])
 	} else {
-		sqstate = sqAct[sqj]
-		if sqChk[sqstate] != -sqn {
-			sqstate = sqAct[sqg]
+		sqstate = int(sqAct[sqj])
+		if int(sqChk[sqstate]) != -sqn {
+			sqstate = int(sqAct[sqg])
 		}
 	}
 	// dummy call; replaced with literal code
 	switch sqnt {
 
-	case 1:
-		sqDollar = sqS[sqpt-4 : sqpt+1]
-		//line query.y:60
+	case 2:
+		sqDollar = sqS[sqpt-2 : sqpt+1]
+//line query.y:79
+		{
+			sqlex.(*sqLex).query.explain = true
+		}
+	case 3:
+		sqDollar = sqS[sqpt-6 : sqpt+1]
+//line query.y:85
 		{
 			sqlex.(*sqLex).query.Contents = sqDollar[2].list
-			sqlex.(*sqLex).query.where = sqDollar[3].dict
+			sqlex.(*sqLex).query.where = sqDollar[3].pred
+			sqlex.(*sqLex).query.asOf = sqlex.(*sqLex).metadataAsOf(sqDollar[4].asof)
+			sqlex.(*sqLex).query.page = sqDollar[5].page
 			sqlex.(*sqLex).query.qtype = SELECT_TYPE
 		}
-	case 2:
-		sqDollar = sqS[sqpt-3 : sqpt+1]
-		//line query.y:66
+	case 4:
+		sqDollar = sqS[sqpt-5 : sqpt+1]
+//line query.y:93
 		{
 			sqlex.(*sqLex).query.Contents = sqDollar[2].list
+			sqlex.(*sqLex).query.asOf = sqlex.(*sqLex).metadataAsOf(sqDollar[3].asof)
+			sqlex.(*sqLex).query.page = sqDollar[4].page
 			sqlex.(*sqLex).query.qtype = SELECT_TYPE
 		}
-	case 3:
-		sqDollar = sqS[sqpt-4 : sqpt+1]
-		//line query.y:71
+	case 5:
+		sqDollar = sqS[sqpt-6 : sqpt+1]
+//line query.y:100
 		{
-			sqlex.(*sqLex).query.where = sqDollar[3].dict
+			sqlex.(*sqLex).query.where = sqDollar[3].pred
 			sqlex.(*sqLex).query.data = sqDollar[2].data
+			sqlex.(*sqLex).query.asOf = sqDollar[4].asof
+			sqlex.(*sqLex).query.page = common.Pagination{Cursor: sqDollar[5].str}
 			sqlex.(*sqLex).query.qtype = DATA_TYPE
 		}
-	case 4:
+	case 6:
 		sqDollar = sqS[sqpt-4 : sqpt+1]
-		//line query.y:77
+//line query.y:108
 		{
 			sqlex.(*sqLex).query.data = sqDollar[2].data
-			sqlex.(*sqLex).query.where = sqDollar[3].dict
+			sqlex.(*sqLex).query.where = sqDollar[3].pred
 			sqlex.(*sqLex).query.qtype = DELETE_TYPE
 		}
-	case 5:
+	case 7:
 		sqDollar = sqS[sqpt-3 : sqpt+1]
-		//line query.y:83
+//line query.y:114
 		{
 			sqlex.(*sqLex).query.Contents = []string{}
-			sqlex.(*sqLex).query.where = sqDollar[2].dict
+			sqlex.(*sqLex).query.where = sqDollar[2].pred
 			sqlex.(*sqLex).query.qtype = DELETE_TYPE
 		}
-	case 6:
+	case 8:
+		sqDollar = sqS[sqpt-5 : sqpt+1]
+//line query.y:120
+		{
+			sqlex.(*sqLex).query.where = sqDollar[3].pred
+			sqlex.(*sqLex).query.lease = sqDollar[4].duration
+			sqlex.(*sqLex).query.qtype = SUBSCRIBE_TYPE
+		}
+	case 9:
+		sqDollar = sqS[sqpt-4 : sqpt+1]
+//line query.y:126
+		{
+			sqlex.(*sqLex).query.Contents = []string{sqDollar[2].str}
+			sqlex.(*sqLex).query.lease = sqDollar[3].duration
+			sqlex.(*sqLex).query.qtype = SUBSCRIBE_TYPE
+		}
+	case 10:
+		sqDollar = sqS[sqpt-3 : sqpt+1]
+//line query.y:132
+		{
+			sqlex.(*sqLex).query.Contents = []string{sqDollar[2].str}
+			sqlex.(*sqLex).query.qtype = UNSUBSCRIBE_TYPE
+		}
+	case 11:
+		sqDollar = sqS[sqpt-0 : sqpt+1]
+//line query.y:139
+		{
+			sqVAL.asof = asOf{}
+		}
+	case 12:
+		sqDollar = sqS[sqpt-3 : sqpt+1]
+//line query.y:143
+		{
+			sqVAL.asof = asOf{ref: sqDollar[3].time}
+		}
+	case 13:
+		sqDollar = sqS[sqpt-3 : sqpt+1]
+//line query.y:147
+		{
+			sqVAL.asof = asOf{data: true}
+		}
+	case 14:
+		sqDollar = sqS[sqpt-0 : sqpt+1]
+//line query.y:153
+		{
+			sqVAL.duration = 0
+		}
+	case 15:
+		sqDollar = sqS[sqpt-3 : sqpt+1]
+//line query.y:157
+		{
+			dur, err := common.ParseReltime(sqDollar[2].str, sqDollar[3].str)
+			if err != nil || dur <= 0 {
+				sqlex.(*sqLex).Error(fmt.Sprintf("Invalid lease \"%v %v\"", sqDollar[2].str, sqDollar[3].str))
+			}
+			sqVAL.duration = dur
+		}
+	case 16:
 		sqDollar = sqS[sqpt-1 : sqpt+1]
-		//line query.y:91
+//line query.y:167
 		{
 			sqVAL.list = List{sqDollar[1].str}
 		}
-	case 7:
+	case 17:
 		sqDollar = sqS[sqpt-3 : sqpt+1]
-		//line query.y:95
+//line query.y:171
 		{
 			sqVAL.list = append(List{sqDollar[1].str}, sqDollar[3].list...)
 		}
-	case 8:
+	case 18:
 		sqDollar = sqS[sqpt-3 : sqpt+1]
-		//line query.y:101
+//line query.y:177
 		{
 			sqVAL.list = sqDollar[2].list
 		}
-	case 9:
+	case 19:
 		sqDollar = sqS[sqpt-1 : sqpt+1]
-		//line query.y:106
+//line query.y:182
 		{
 			sqVAL.list = List{sqDollar[1].str}
 		}
-	case 10:
+	case 20:
 		sqDollar = sqS[sqpt-3 : sqpt+1]
-		//line query.y:110
+//line query.y:186
 		{
 			sqVAL.list = append(List{sqDollar[1].str}, sqDollar[3].list...)
 		}
-	case 11:
+	case 21:
 		sqDollar = sqS[sqpt-1 : sqpt+1]
-		//line query.y:116
+//line query.y:192
 		{
 			sqlex.(*sqLex).query.Contents = sqDollar[1].list
 			sqVAL.list = sqDollar[1].list
 		}
-	case 12:
+	case 22:
 		sqDollar = sqS[sqpt-1 : sqpt+1]
-		//line query.y:121
+//line query.y:197
 		{
 			sqVAL.list = List{}
 		}
-	case 13:
+	case 23:
 		sqDollar = sqS[sqpt-2 : sqpt+1]
-		//line query.y:125
+//line query.y:201
 		{
 			sqlex.(*sqLex).query.distinct = true
 			sqVAL.list = List{sqDollar[2].str}
 		}
-	case 14:
+	case 24:
 		sqDollar = sqS[sqpt-1 : sqpt+1]
-		//line query.y:130
+//line query.y:206
 		{
 			sqlex.(*sqLex).query.distinct = true
 			sqVAL.list = List{}
 		}
-	case 15:
-		sqDollar = sqS[sqpt-9 : sqpt+1]
-		//line query.y:137
+	case 25:
+		sqDollar = sqS[sqpt-10 : sqpt+1]
+//line query.y:213
 		{
-			sqVAL.data = &DataQuery{Dtype: IN_TYPE, Start: sqDollar[4].time, End: sqDollar[6].time, Limit: sqDollar[8].limit, Timeconv: sqDollar[9].timeconv, IsStatistical: false, IsWindow: false, IsChangedRanges: false}
+			sqVAL.data = sqlex.(*sqLex).dataQuery(IN_TYPE, sqDollar[4].time, sqDollar[6].time, sqDollar[8].limit, sqDollar[9].timeconv, sqDollar[10].str)
 		}
-	case 16:
-		sqDollar = sqS[sqpt-7 : sqpt+1]
-		//line query.y:141
+	case 26:
+		sqDollar = sqS[sqpt-8 : sqpt+1]
+//line query.y:217
 		{
-			sqVAL.data = &DataQuery{Dtype: IN_TYPE, Start: sqDollar[3].time, End: sqDollar[5].time, Limit: sqDollar[6].limit, Timeconv: sqDollar[7].timeconv, IsStatistical: false, IsWindow: false, IsChangedRanges: false}
+			sqVAL.data = sqlex.(*sqLex).dataQuery(IN_TYPE, sqDollar[3].time, sqDollar[5].time, sqDollar[6].limit, sqDollar[7].timeconv, sqDollar[8].str)
 		}
-	case 17:
-		sqDollar = sqS[sqpt-13 : sqpt+1]
-		//line query.y:145
+	case 27:
+		sqDollar = sqS[sqpt-14 : sqpt+1]
+//line query.y:221
 		{
 			num, err := strconv.ParseInt(sqDollar[3].str, 10, 64)
 			if err != nil {
 				sqlex.(*sqLex).Error(fmt.Sprintf("Could not parse integer \"%v\" (%v)", sqDollar[3].str, err.Error()))
 			}
-			sqVAL.data = &DataQuery{Dtype: IN_TYPE, Start: sqDollar[8].time, End: sqDollar[10].time, Limit: sqDollar[12].limit, Timeconv: sqDollar[13].timeconv, IsStatistical: true, IsWindow: false, IsChangedRanges: false, PointWidth: num}
+			sqVAL.data = sqlex.(*sqLex).dataQuery(IN_TYPE, sqDollar[8].time, sqDollar[10].time, sqDollar[12].limit, sqDollar[13].timeconv, sqDollar[14].str)
+			sqVAL.data.IsStatistical = true
+			sqVAL.data.PointWidth = num
 		}
-	case 18:
-		sqDollar = sqS[sqpt-13 : sqpt+1]
-		//line query.y:153
+	case 28:
+		sqDollar = sqS[sqpt-14 : sqpt+1]
+//line query.y:231
 		{
 			num, err := strconv.ParseInt(sqDollar[3].str, 10, 64)
 			if err != nil {
 				sqlex.(*sqLex).Error(fmt.Sprintf("Could not parse integer \"%v\" (%v)", sqDollar[3].str, err.Error()))
 			}
-			sqVAL.data = &DataQuery{Dtype: IN_TYPE, Start: sqDollar[8].time, End: sqDollar[10].time, Limit: sqDollar[12].limit, Timeconv: sqDollar[13].timeconv, IsStatistical: true, IsWindow: false, IsChangedRanges: false, PointWidth: num}
+			sqVAL.data = sqlex.(*sqLex).dataQuery(IN_TYPE, sqDollar[8].time, sqDollar[10].time, sqDollar[12].limit, sqDollar[13].timeconv, sqDollar[14].str)
+			sqVAL.data.IsStatistical = true
+			sqVAL.data.PointWidth = num
 		}
-	case 19:
+	case 29:
+		sqDollar = sqS[sqpt-16 : sqpt+1]
+//line query.y:241
+		{
+			sqVAL.data = sqlex.(*sqLex).dataQuery(IN_TYPE, sqDollar[10].time, sqDollar[12].time, sqDollar[14].limit, sqDollar[15].timeconv, sqDollar[16].str)
+			sqVAL.data.IsWindow = true
+			unit, isCalendar := common.ParseCalendarUnit(sqDollar[4].str)
+			// windows have a fixed width unless they are aligned to the calendar
+			if isCalendar && sqDollar[5].align.local {
+				count, err := strconv.ParseInt(sqDollar[3].str, 10, 64)
+				if err != nil || count < 1 {
+					sqlex.(*sqLex).Error(fmt.Sprintf("Calendar windows must be a positive whole number of %v, not \"%v\"", unit, sqDollar[3].str))
+				}
+				sqVAL.data.Calendar = &common.CalendarWindow{Count: int(count), Unit: unit, WeekStart: sqDollar[5].align.weekstart}
+			} else if sqDollar[5].align.local {
+				sqlex.(*sqLex).Error(fmt.Sprintf("Cannot ALIGN LOCAL a window of \"%v %v\"; use days, weeks, months, quarters or years", sqDollar[3].str, sqDollar[4].str))
+			} else {
+				sqVAL.data.Width = sqlex.(*sqLex).parseWindowWidth(sqDollar[3].str, sqDollar[4].str)
+			}
+		}
+	case 30:
 		sqDollar = sqS[sqpt-14 : sqpt+1]
-		//line query.y:161
+//line query.y:259
 		{
-			dur, err := common.ParseReltime(sqDollar[3].str, sqDollar[4].str)
-			if err != nil {
-				sqlex.(*sqLex).Error(fmt.Sprintf("Error parsing relative time \"%v %v\" (%v)", sqDollar[3].str, sqDollar[4].str, err.Error()))
+			sqVAL.data = sqlex.(*sqLex).dataQuery(IN_TYPE, sqDollar[8].time, sqDollar[10].time, sqDollar[12].limit, sqDollar[13].timeconv, sqDollar[14].str)
+			sqVAL.data.IsDistribution = true
+			sqVAL.data.Percentiles = sqDollar[3].floats
+		}
+	case 31:
+		sqDollar = sqS[sqpt-14 : sqpt+1]
+//line query.y:265
+		{
+			bins, err := strconv.ParseInt(sqDollar[3].str, 10, 64)
+			if err != nil || bins < 1 {
+				sqlex.(*sqLex).Error(fmt.Sprintf("The number of histogram bins must be a positive integer, not \"%v\"", sqDollar[3].str))
 			}
-			sqVAL.data = &DataQuery{Dtype: IN_TYPE, Start: sqDollar[9].time, End: sqDollar[11].time, Limit: sqDollar[13].limit, Timeconv: sqDollar[14].timeconv, IsStatistical: false, IsWindow: true, IsChangedRanges: false, Width: uint64(dur.Nanoseconds())}
+			sqVAL.data = sqlex.(*sqLex).dataQuery(IN_TYPE, sqDollar[8].time, sqDollar[10].time, sqDollar[12].limit, sqDollar[13].timeconv, sqDollar[14].str)
+			sqVAL.data.IsDistribution = true
+			sqVAL.data.HistogramBins = int(bins)
 		}
-	case 20:
+	case 32:
 		sqDollar = sqS[sqpt-9 : sqpt+1]
-		//line query.y:169
+//line query.y:275
 		{
 			fromgen, err := strconv.ParseInt(sqDollar[3].str, 10, 64)
 			if err != nil {
@@ -881,103 +1228,105 @@ sqdefault:
 			}
 			sqVAL.data = &DataQuery{Dtype: CHANGED_TYPE, IsStatistical: false, IsWindow: false, IsChangedRanges: true, FromGen: uint64(fromgen), ToGen: uint64(togen), Resolution: uint8(resolution)}
 		}
-	case 21:
-		sqDollar = sqS[sqpt-5 : sqpt+1]
-		//line query.y:185
+	case 33:
+		sqDollar = sqS[sqpt-6 : sqpt+1]
+//line query.y:291
 		{
-			sqVAL.data = &DataQuery{Dtype: BEFORE_TYPE, Start: sqDollar[3].time, Limit: sqDollar[4].limit, Timeconv: sqDollar[5].timeconv, IsStatistical: false, IsWindow: false, IsChangedRanges: false}
+			sqVAL.data = sqlex.(*sqLex).dataQuery(BEFORE_TYPE, sqDollar[3].time, timeRef{}, sqDollar[4].limit, sqDollar[5].timeconv, sqDollar[6].str)
 		}
-	case 22:
-		sqDollar = sqS[sqpt-5 : sqpt+1]
-		//line query.y:189
+	case 34:
+		sqDollar = sqS[sqpt-6 : sqpt+1]
+//line query.y:295
 		{
-			sqVAL.data = &DataQuery{Dtype: AFTER_TYPE, Start: sqDollar[3].time, Limit: sqDollar[4].limit, Timeconv: sqDollar[5].timeconv, IsStatistical: false, IsWindow: false, IsChangedRanges: false}
+			sqVAL.data = sqlex.(*sqLex).dataQuery(AFTER_TYPE, sqDollar[3].time, timeRef{}, sqDollar[4].limit, sqDollar[5].timeconv, sqDollar[6].str)
 		}
-	case 23:
+	case 35:
 		sqDollar = sqS[sqpt-1 : sqpt+1]
-		//line query.y:195
+//line query.y:301
 		{
 			sqVAL.time = sqDollar[1].time
 		}
-	case 24:
+	case 36:
 		sqDollar = sqS[sqpt-2 : sqpt+1]
-		//line query.y:199
+//line query.y:305
 		{
-			sqVAL.time = sqDollar[1].time.Add(sqDollar[2].timediff)
+			abs, rel := sqDollar[1].time, sqDollar[2].timediff
+			sqVAL.time = timeRef{
+				resolve: func(now _time.Time, loc *_time.Location) (_time.Time, error) {
+					t, err := abs.resolve(now, loc)
+					return rel.addTo(t), err
+				},
+				Relative: abs.Relative,
+			}
 		}
-	case 25:
+	case 37:
 		sqDollar = sqS[sqpt-2 : sqpt+1]
-		//line query.y:205
+//line query.y:318
 		{
 			foundtime, err := common.ParseAbsTime(sqDollar[1].str, sqDollar[2].str)
 			if err != nil {
 				sqlex.(*sqLex).Error(fmt.Sprintf("Could not parse time \"%v %v\" (%v)", sqDollar[1].str, sqDollar[2].str, err.Error()))
 			}
-			sqVAL.time = foundtime
+			sqVAL.time = fixedTime(foundtime)
 		}
-	case 26:
+	case 38:
 		sqDollar = sqS[sqpt-1 : sqpt+1]
-		//line query.y:213
+//line query.y:326
 		{
 			num, err := strconv.ParseInt(sqDollar[1].str, 10, 64)
 			if err != nil {
 				sqlex.(*sqLex).Error(fmt.Sprintf("Could not parse integer \"%v\" (%v)", sqDollar[1].str, err.Error()))
 			}
-			sqVAL.time = _time.Unix(num, 0)
+			sqVAL.time = fixedTime(_time.Unix(num, 0))
 		}
-	case 27:
+	case 39:
 		sqDollar = sqS[sqpt-1 : sqpt+1]
-		//line query.y:221
+//line query.y:334
 		{
-			found := false
-			for _, format := range supported_formats {
-				t, err := _time.Parse(format, sqDollar[1].str)
-				if err != nil {
-					continue
+			// times without an explicit offset are in the timezone of the query
+			literal := sqDollar[1].str
+			sqVAL.time = timeRef{resolve: func(now _time.Time, loc *_time.Location) (_time.Time, error) {
+				for _, format := range supported_formats {
+					if t, err := _time.ParseInLocation(format, literal, loc); err == nil {
+						return t, nil
+					}
 				}
-				sqVAL.time = t
-				found = true
-				break
-			}
-			if !found {
-				sqlex.(*sqLex).Error(fmt.Sprintf("No time format matching \"%v\" found", sqDollar[1].str))
-			}
+				return _time.Time{}, fmt.Errorf("No time format matching \"%v\" found", literal)
+			}}
 		}
-	case 28:
+	case 40:
 		sqDollar = sqS[sqpt-1 : sqpt+1]
-		//line query.y:237
+//line query.y:347
 		{
-			sqVAL.time = _time.Now()
+			sqVAL.time = timeRef{
+				resolve: func(now _time.Time, loc *_time.Location) (_time.Time, error) {
+					return now, nil
+				},
+				Relative: true,
+			}
 		}
-	case 29:
+	case 41:
 		sqDollar = sqS[sqpt-2 : sqpt+1]
-		//line query.y:243
+//line query.y:358
 		{
-			var err error
-			sqVAL.timediff, err = common.ParseReltime(sqDollar[1].str, sqDollar[2].str)
-			if err != nil {
-				sqlex.(*sqLex).Error(fmt.Sprintf("Error parsing relative time \"%v %v\" (%v)", sqDollar[1].str, sqDollar[2].str, err.Error()))
-			}
+			sqVAL.timediff = sqlex.(*sqLex).parseReltime(sqDollar[1].str, sqDollar[2].str)
 		}
-	case 30:
+	case 42:
 		sqDollar = sqS[sqpt-3 : sqpt+1]
-		//line query.y:251
+//line query.y:362
 		{
-			newDuration, err := common.ParseReltime(sqDollar[1].str, sqDollar[2].str)
-			if err != nil {
-				sqlex.(*sqLex).Error(fmt.Sprintf("Error parsing relative time \"%v %v\" (%v)", sqDollar[1].str, sqDollar[2].str, err.Error()))
-			}
-			sqVAL.timediff = common.AddDurations(newDuration, sqDollar[3].timediff)
+			rel := sqlex.(*sqLex).parseReltime(sqDollar[1].str, sqDollar[2].str)
+			sqVAL.timediff = relTime{days: rel.days + sqDollar[3].timediff.days, duration: common.AddDurations(rel.duration, sqDollar[3].timediff.duration)}
 		}
-	case 31:
+	case 43:
 		sqDollar = sqS[sqpt-0 : sqpt+1]
-		//line query.y:261
+//line query.y:369
 		{
 			sqVAL.limit = Limit{Limit: -1, Streamlimit: -1}
 		}
-	case 32:
+	case 44:
 		sqDollar = sqS[sqpt-2 : sqpt+1]
-		//line query.y:265
+//line query.y:373
 		{
 			num, err := strconv.ParseInt(sqDollar[2].str, 10, 64)
 			if err != nil {
@@ -985,9 +1334,9 @@ sqdefault:
 			}
 			sqVAL.limit = Limit{Limit: num, Streamlimit: -1}
 		}
-	case 33:
+	case 45:
 		sqDollar = sqS[sqpt-2 : sqpt+1]
-		//line query.y:273
+//line query.y:381
 		{
 			num, err := strconv.ParseInt(sqDollar[2].str, 10, 64)
 			if err != nil {
@@ -995,9 +1344,9 @@ sqdefault:
 			}
 			sqVAL.limit = Limit{Limit: -1, Streamlimit: num}
 		}
-	case 34:
+	case 46:
 		sqDollar = sqS[sqpt-4 : sqpt+1]
-		//line query.y:281
+//line query.y:389
 		{
 			limit_num, err := strconv.ParseInt(sqDollar[2].str, 10, 64)
 			if err != nil {
@@ -1009,124 +1358,246 @@ sqdefault:
 			}
 			sqVAL.limit = Limit{Limit: limit_num, Streamlimit: slimit_num}
 		}
-	case 35:
+	case 47:
 		sqDollar = sqS[sqpt-0 : sqpt+1]
-		//line query.y:295
+//line query.y:403
 		{
-			sqVAL.timeconv = common.UOT_NS
+			sqVAL.timeconv = outputFormat{unit: common.UOT_NS}
 		}
-	case 36:
+	case 48:
 		sqDollar = sqS[sqpt-2 : sqpt+1]
-		//line query.y:299
+//line query.y:407
 		{
-			uot, err := common.ParseUOT(sqDollar[2].str)
-			if err != nil {
-				sqlex.(*sqLex).Error(fmt.Sprintf("Could not parse unit of time %v (%v)", sqDollar[2].str, err))
+			if strings.ToLower(sqDollar[2].str) == "rfc3339" {
+				sqVAL.timeconv = outputFormat{unit: common.UOT_NS, rfc3339: true}
+			} else {
+				uot, err := common.ParseUOT(sqDollar[2].str)
+				if err != nil {
+					sqlex.(*sqLex).Error(fmt.Sprintf("Could not parse unit of time %v (%v)", sqDollar[2].str, err))
+				}
+				sqVAL.timeconv = outputFormat{unit: uot}
 			}
-			sqVAL.timeconv = uot
 		}
-	case 37:
+	case 49:
+		sqDollar = sqS[sqpt-1 : sqpt+1]
+//line query.y:421
+		{
+			sqVAL.floats = []float64{sqlex.(*sqLex).parsePercentile(sqDollar[1].str)}
+		}
+	case 50:
+		sqDollar = sqS[sqpt-3 : sqpt+1]
+//line query.y:425
+		{
+			sqVAL.floats = append([]float64{sqlex.(*sqLex).parsePercentile(sqDollar[1].str)}, sqDollar[3].floats...)
+		}
+	case 51:
+		sqDollar = sqS[sqpt-0 : sqpt+1]
+//line query.y:431
+		{
+			sqVAL.align = windowAlign{weekstart: _time.Monday}
+		}
+	case 52:
 		sqDollar = sqS[sqpt-2 : sqpt+1]
-		//line query.y:311
+//line query.y:435
 		{
-			sqVAL.dict = sqDollar[2].dict
+			sqVAL.align = windowAlign{local: true, weekstart: _time.Monday}
 		}
-	case 38:
+	case 53:
+		sqDollar = sqS[sqpt-2 : sqpt+1]
+//line query.y:439
+		{
+			sqlex.(*sqLex).Error("WEEKSTART can only be used with ALIGN LOCAL")
+			sqVAL.align = windowAlign{weekstart: sqlex.(*sqLex).parseWeekday(sqDollar[2].str)}
+		}
+	case 54:
+		sqDollar = sqS[sqpt-4 : sqpt+1]
+//line query.y:444
+		{
+			sqVAL.align = windowAlign{local: true, weekstart: sqlex.(*sqLex).parseWeekday(sqDollar[4].str)}
+		}
+	case 55:
+		sqDollar = sqS[sqpt-0 : sqpt+1]
+//line query.y:450
+		{
+			sqVAL.str = ""
+		}
+	case 56:
+		sqDollar = sqS[sqpt-2 : sqpt+1]
+//line query.y:454
+		{
+			sqVAL.str = sqDollar[2].str
+		}
+	case 57:
+		sqDollar = sqS[sqpt-1 : sqpt+1]
+//line query.y:462
+		{
+			sqVAL.page = sqDollar[1].page
+		}
+	case 58:
 		sqDollar = sqS[sqpt-3 : sqpt+1]
-		//line query.y:318
+//line query.y:466
 		{
-			sqVAL.dict = common.Dict{sqDollar[1].str: common.Dict{"$regex": sqDollar[3].str}}
+			sqVAL.page = sqDollar[1].page
+			sqVAL.page.Limit = sqlex.(*sqLex).parseCount(sqDollar[3].str)
 		}
-	case 39:
+	case 59:
+		sqDollar = sqS[sqpt-5 : sqpt+1]
+//line query.y:471
+		{
+			sqVAL.page = sqDollar[1].page
+			sqVAL.page.Limit = sqlex.(*sqLex).parseCount(sqDollar[3].str)
+			sqVAL.page.Offset = sqlex.(*sqLex).parseCount(sqDollar[5].str)
+		}
+	case 60:
 		sqDollar = sqS[sqpt-3 : sqpt+1]
-		//line query.y:322
+//line query.y:477
 		{
-			sqVAL.dict = common.Dict{sqDollar[1].str: sqDollar[3].str}
+			sqVAL.page = sqDollar[1].page
+			sqVAL.page.Cursor = sqDollar[3].str
 		}
-	case 40:
+	case 61:
+		sqDollar = sqS[sqpt-5 : sqpt+1]
+//line query.y:482
+		{
+			sqVAL.page = sqDollar[1].page
+			sqVAL.page.Limit = sqlex.(*sqLex).parseCount(sqDollar[3].str)
+			sqVAL.page.Cursor = sqDollar[5].str
+		}
+	case 62:
+		sqDollar = sqS[sqpt-0 : sqpt+1]
+//line query.y:490
+		{
+			sqVAL.page = common.Pagination{}
+		}
+	case 63:
 		sqDollar = sqS[sqpt-3 : sqpt+1]
-		//line query.y:326
+//line query.y:494
 		{
-			sqVAL.dict = common.Dict{sqDollar[1].str: sqDollar[3].str}
+			sqVAL.page = common.Pagination{OrderBy: sqDollar[3].str}
 		}
-	case 41:
+	case 64:
+		sqDollar = sqS[sqpt-4 : sqpt+1]
+//line query.y:498
+		{
+			sqVAL.page = common.Pagination{OrderBy: sqDollar[3].str}
+		}
+	case 65:
+		sqDollar = sqS[sqpt-4 : sqpt+1]
+//line query.y:502
+		{
+			sqVAL.page = common.Pagination{OrderBy: sqDollar[3].str, Descending: true}
+		}
+	case 66:
+		sqDollar = sqS[sqpt-0 : sqpt+1]
+//line query.y:508
+		{
+			sqVAL.str = ""
+		}
+	case 67:
+		sqDollar = sqS[sqpt-2 : sqpt+1]
+//line query.y:512
+		{
+			sqVAL.str = sqDollar[2].str
+		}
+	case 68:
+		sqDollar = sqS[sqpt-2 : sqpt+1]
+//line query.y:518
+		{
+			sqVAL.pred = sqDollar[2].pred
+		}
+	case 69:
 		sqDollar = sqS[sqpt-3 : sqpt+1]
-		//line query.y:330
+//line query.y:525
 		{
-			sqVAL.dict = common.Dict{sqDollar[1].str: common.Dict{"$neq": sqDollar[3].str}}
+			sqlex.(*sqLex).checkRegex(sqDollar[3].str)
+			sqVAL.pred = common.NewTagPredicate(common.OpLike, sqDollar[1].str, sqDollar[3].str)
 		}
-	case 42:
+	case 70:
+		sqDollar = sqS[sqpt-3 : sqpt+1]
+//line query.y:530
+		{
+			sqVAL.pred = common.NewTagPredicate(common.OpEq, sqDollar[1].str, sqDollar[3].str)
+		}
+	case 71:
+		sqDollar = sqS[sqpt-3 : sqpt+1]
+//line query.y:534
+		{
+			sqVAL.pred = common.NewTagPredicate(common.OpEq, sqDollar[1].str, sqDollar[3].str)
+		}
+	case 72:
+		sqDollar = sqS[sqpt-3 : sqpt+1]
+//line query.y:538
+		{
+			sqVAL.pred = common.NewTagPredicate(common.OpNeq, sqDollar[1].str, sqDollar[3].str)
+		}
+	case 73:
 		sqDollar = sqS[sqpt-2 : sqpt+1]
-		//line query.y:334
+//line query.y:542
 		{
-			sqVAL.dict = common.Dict{sqDollar[2].str: common.Dict{"$exists": true}}
+			sqVAL.pred = common.NewTagPredicate(common.OpHas, sqDollar[2].str)
 		}
-	case 43:
+	case 74:
 		sqDollar = sqS[sqpt-2 : sqpt+1]
-		//line query.y:338
+//line query.y:546
 		{
-			//$$ = common.Dict{"$text": common.Dict{"$search": $2}}
-			sqVAL.dict = common.Dict{"$where": fmt.Sprintf("JSON.stringify(this).match(new RegExp('%s'))", sqDollar[2].str)}
+			sqlex.(*sqLex).checkSearch(sqDollar[2].str)
+			sqVAL.pred = &common.Predicate{Op: common.OpMatches, Values: []string{sqDollar[2].str}}
 		}
-	case 44:
+	case 75:
 		sqDollar = sqS[sqpt-3 : sqpt+1]
-		//line query.y:343
+//line query.y:551
 		{
-			sqVAL.dict = common.Dict{sqDollar[3].str: common.Dict{"$in": sqDollar[1].list}}
+			sqVAL.pred = common.NewTagPredicate(common.OpIn, sqDollar[3].str, sqDollar[1].list...)
 		}
-	case 45:
+	case 76:
 		sqDollar = sqS[sqpt-4 : sqpt+1]
-		//line query.y:347
+//line query.y:555
 		{
-			sqVAL.dict = common.Dict{sqDollar[3].str: common.Dict{"$not": common.Dict{"$in": sqDollar[1].list}}}
+			sqVAL.pred = common.NewNot(common.NewTagPredicate(common.OpIn, sqDollar[4].str, sqDollar[1].list...))
 		}
-	case 46:
+	case 77:
 		sqDollar = sqS[sqpt-3 : sqpt+1]
-		//line query.y:351
+//line query.y:559
 		{
-			sqVAL.dict = sqDollar[2].dict
+			sqVAL.pred = sqDollar[2].pred
 		}
-	case 47:
+	case 78:
 		sqDollar = sqS[sqpt-1 : sqpt+1]
-		//line query.y:357
+//line query.y:565
 		{
 			sqVAL.str = strings.Trim(sqDollar[1].str, "\"'")
 		}
-	case 48:
+	case 79:
 		sqDollar = sqS[sqpt-1 : sqpt+1]
-		//line query.y:363
+//line query.y:571
 		{
 
 			sqlex.(*sqLex)._keys[sqDollar[1].str] = struct{}{}
 			sqVAL.str = cleantagstring(sqDollar[1].str)
 		}
-	case 49:
+	case 80:
 		sqDollar = sqS[sqpt-3 : sqpt+1]
-		//line query.y:371
+//line query.y:579
 		{
-			sqVAL.dict = common.Dict{"$and": []common.Dict{sqDollar[1].dict, sqDollar[3].dict}}
+			sqVAL.pred = common.NewAnd(sqDollar[1].pred, sqDollar[3].pred)
 		}
-	case 50:
+	case 81:
 		sqDollar = sqS[sqpt-3 : sqpt+1]
-		//line query.y:375
+//line query.y:583
 		{
-			sqVAL.dict = common.Dict{"$or": []common.Dict{sqDollar[1].dict, sqDollar[3].dict}}
+			sqVAL.pred = common.NewOr(sqDollar[1].pred, sqDollar[3].pred)
 		}
-	case 51:
+	case 82:
 		sqDollar = sqS[sqpt-2 : sqpt+1]
-		//line query.y:379
+//line query.y:587
 		{
-			tmp := make(common.Dict)
-			for k, v := range sqDollar[2].dict {
-				tmp[k] = common.Dict{"$ne": v}
-			}
-			sqVAL.dict = tmp
+			sqVAL.pred = common.NewNot(sqDollar[2].pred)
 		}
-	case 52:
+	case 83:
 		sqDollar = sqS[sqpt-1 : sqpt+1]
-		//line query.y:387
+//line query.y:591
 		{
-			sqVAL.dict = sqDollar[1].dict
+			sqVAL.pred = sqDollar[1].pred
 		}
 	}
 	goto sqstack /* stack new state and value */
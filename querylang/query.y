@@ -8,6 +8,7 @@ import (
 	"fmt"
 	"github.com/taylorchu/toki"
     "github.com/gtfierro/pundat/common"
+	"regexp"
 	"strconv"
     _time "time"
 )
@@ -20,13 +21,18 @@ Notes here
 
 %union{
 	str string
-	dict common.Dict
+	pred *common.Predicate
 	data *DataQuery
 	limit Limit
-    timeconv common.UnitOfTime
+    timeconv outputFormat
 	list List
-	time _time.Time
-    timediff _time.Duration
+	time timeRef
+    timediff relTime
+    page common.Pagination
+    align windowAlign
+    floats []float64
+    duration _time.Duration
+    asof asOf
 }
 
 %token <str> SELECT DISTINCT DELETE APPLY STATISTICAL WINDOW STATISTICS CHANGED
@@ -37,18 +43,30 @@ Notes here
 %token <str> LIKE AS MATCHES
 %token <str> AND OR HAS NOT IN TO
 %token <str> LPAREN RPAREN LBRACK RBRACK
+%token <str> ORDER BY ASC DESC OFFSET CURSOR
+%token <str> EXPLAIN TZ
+%token <str> ALIGN LOCAL WEEKSTART
+%token <str> PERCENTILE HISTOGRAM
+%token <str> SUBSCRIBE UNSUBSCRIBE RENEW LEASE
+%token <str> OF
 %token NUMBER
 %token SEMICOLON
 %token NEWLINE
 %token TIMEUNIT
 
-%type <dict> whereList whereTerm whereClause
+%type <pred> whereList whereTerm whereClause
 %type <list> selector tagList valueList valueListBrack
 %type <data> dataClause
 %type <time> timeref abstime
 %type <timediff> reltime
 %type <limit> limit
 %type <timeconv> timeconv
+%type <page> pagination orderClause
+%type <align> windowAlign
+%type <floats> percentileList
+%type <duration> lease
+%type <asof> asOf
+%type <str> cursorClause timezone
 %type <str> NUMBER qstring lvalue TIMEUNIT
 %type <str> SEMICOLON NEWLINE
 
@@ -56,21 +74,34 @@ Notes here
 
 %%
 
-query		: SELECT selector whereClause SEMICOLON
+statement	: query
+			| EXPLAIN query
+			{
+				sqlex.(*sqLex).query.explain = true
+			}
+			;
+
+query		: SELECT selector whereClause asOf pagination SEMICOLON
 			{
 				sqlex.(*sqLex).query.Contents = $2
 				sqlex.(*sqLex).query.where = $3
+				sqlex.(*sqLex).query.asOf = sqlex.(*sqLex).metadataAsOf($4)
+				sqlex.(*sqLex).query.page = $5
 				sqlex.(*sqLex).query.qtype = SELECT_TYPE
 			}
-			| SELECT selector SEMICOLON
+			| SELECT selector asOf pagination SEMICOLON
 			{
 				sqlex.(*sqLex).query.Contents = $2
+				sqlex.(*sqLex).query.asOf = sqlex.(*sqLex).metadataAsOf($3)
+				sqlex.(*sqLex).query.page = $4
 				sqlex.(*sqLex).query.qtype = SELECT_TYPE
 			}
-			| SELECT dataClause whereClause SEMICOLON
+			| SELECT dataClause whereClause asOf cursorClause SEMICOLON
 			{
 				sqlex.(*sqLex).query.where = $3
 				sqlex.(*sqLex).query.data = $2
+				sqlex.(*sqLex).query.asOf = $4
+				sqlex.(*sqLex).query.page = common.Pagination{Cursor: $5}
 				sqlex.(*sqLex).query.qtype = DATA_TYPE
 			}
             | DELETE dataClause whereClause SEMICOLON
@@ -85,6 +116,51 @@ query		: SELECT selector whereClause SEMICOLON
 				sqlex.(*sqLex).query.where = $2
 				sqlex.(*sqLex).query.qtype = DELETE_TYPE
 			}
+			| SUBSCRIBE DATA whereClause lease SEMICOLON
+			{
+				sqlex.(*sqLex).query.where = $3
+				sqlex.(*sqLex).query.lease = $4
+				sqlex.(*sqLex).query.qtype = SUBSCRIBE_TYPE
+			}
+			| RENEW qstring lease SEMICOLON
+			{
+				sqlex.(*sqLex).query.Contents = []string{$2}
+				sqlex.(*sqLex).query.lease = $3
+				sqlex.(*sqLex).query.qtype = SUBSCRIBE_TYPE
+			}
+			| UNSUBSCRIBE qstring SEMICOLON
+			{
+				sqlex.(*sqLex).query.Contents = []string{$2}
+				sqlex.(*sqLex).query.qtype = UNSUBSCRIBE_TYPE
+			}
+			;
+
+asOf		: /* empty */
+			{
+				$$ = asOf{}
+			}
+			| AS OF timeref
+			{
+				$$ = asOf{ref: $3}
+			}
+			| AS OF DATA
+			{
+				$$ = asOf{data: true}
+			}
+			;
+
+lease		: /* empty */
+			{
+				$$ = 0
+			}
+			| LEASE NUMBER lvalue
+			{
+                dur, err := common.ParseReltime($2, $3)
+                if err != nil || dur <= 0 {
+				    sqlex.(*sqLex).Error(fmt.Sprintf("Invalid lease \"%v %v\"", $2, $3))
+                }
+                $$ = dur
+			}
 			;
 
 tagList		: lvalue
@@ -133,37 +209,67 @@ selector	: tagList
 			}
 			;
 
-dataClause : DATA IN LPAREN timeref COMMA timeref RPAREN limit timeconv
+dataClause : DATA IN LPAREN timeref COMMA timeref RPAREN limit timeconv timezone
 			{
-				$$ = &DataQuery{Dtype: IN_TYPE, Start: $4, End: $6, Limit: $8, Timeconv: $9, IsStatistical: false, IsWindow: false, IsChangedRanges: false}
+				$$ = sqlex.(*sqLex).dataQuery(IN_TYPE, $4, $6, $8, $9, $10)
 			}
-		   | DATA IN timeref COMMA timeref limit timeconv
+		   | DATA IN timeref COMMA timeref limit timeconv timezone
 			{
-				$$ = &DataQuery{Dtype: IN_TYPE, Start: $3, End: $5, Limit: $6, Timeconv: $7, IsStatistical: false, IsWindow: false, IsChangedRanges: false}
+				$$ = sqlex.(*sqLex).dataQuery(IN_TYPE, $3, $5, $6, $7, $8)
 			}
-		   | STATISTICAL LPAREN NUMBER RPAREN DATA IN LPAREN timeref COMMA timeref RPAREN limit timeconv
+		   | STATISTICAL LPAREN NUMBER RPAREN DATA IN LPAREN timeref COMMA timeref RPAREN limit timeconv timezone
 			{
                 num, err := strconv.ParseInt($3, 10, 64)
                 if err != nil {
 				    sqlex.(*sqLex).Error(fmt.Sprintf("Could not parse integer \"%v\" (%v)", $3, err.Error()))
                 }
-				$$ = &DataQuery{Dtype: IN_TYPE, Start: $8, End: $10, Limit: $12, Timeconv: $13, IsStatistical: true, IsWindow: false, IsChangedRanges: false, PointWidth: num}
+				$$ = sqlex.(*sqLex).dataQuery(IN_TYPE, $8, $10, $12, $13, $14)
+				$$.IsStatistical = true
+				$$.PointWidth = num
 			}
-		   | STATISTICS LPAREN NUMBER RPAREN DATA IN LPAREN timeref COMMA timeref RPAREN limit timeconv
+		   | STATISTICS LPAREN NUMBER RPAREN DATA IN LPAREN timeref COMMA timeref RPAREN limit timeconv timezone
 			{
                 num, err := strconv.ParseInt($3, 10, 64)
                 if err != nil {
 				    sqlex.(*sqLex).Error(fmt.Sprintf("Could not parse integer \"%v\" (%v)", $3, err.Error()))
                 }
-				$$ = &DataQuery{Dtype: IN_TYPE, Start: $8, End: $10, Limit: $12, Timeconv: $13, IsStatistical: true, IsWindow: false, IsChangedRanges: false, PointWidth: num}
+				$$ = sqlex.(*sqLex).dataQuery(IN_TYPE, $8, $10, $12, $13, $14)
+				$$.IsStatistical = true
+				$$.PointWidth = num
+			}
+		   | WINDOW LPAREN NUMBER lvalue windowAlign RPAREN DATA IN LPAREN timeref COMMA timeref RPAREN limit timeconv timezone
+			{
+				$$ = sqlex.(*sqLex).dataQuery(IN_TYPE, $10, $12, $14, $15, $16)
+				$$.IsWindow = true
+                unit, isCalendar := common.ParseCalendarUnit($4)
+                // windows have a fixed width unless they are aligned to the calendar
+                if isCalendar && $5.local {
+                    count, err := strconv.ParseInt($3, 10, 64)
+                    if err != nil || count < 1 {
+				        sqlex.(*sqLex).Error(fmt.Sprintf("Calendar windows must be a positive whole number of %v, not \"%v\"", unit, $3))
+                    }
+                    $$.Calendar = &common.CalendarWindow{Count: int(count), Unit: unit, WeekStart: $5.weekstart}
+                } else if $5.local {
+				    sqlex.(*sqLex).Error(fmt.Sprintf("Cannot ALIGN LOCAL a window of \"%v %v\"; use days, weeks, months, quarters or years", $3, $4))
+                } else {
+				    $$.Width = sqlex.(*sqLex).parseWindowWidth($3, $4)
+                }
 			}
-		   | WINDOW LPAREN NUMBER lvalue RPAREN DATA IN LPAREN timeref COMMA timeref RPAREN limit timeconv
+		   | PERCENTILE LPAREN percentileList RPAREN DATA IN LPAREN timeref COMMA timeref RPAREN limit timeconv timezone
 			{
-                dur, err := common.ParseReltime($3, $4)
-                if err != nil {
-				    sqlex.(*sqLex).Error(fmt.Sprintf("Error parsing relative time \"%v %v\" (%v)", $3, $4, err.Error()))
+				$$ = sqlex.(*sqLex).dataQuery(IN_TYPE, $8, $10, $12, $13, $14)
+				$$.IsDistribution = true
+				$$.Percentiles = $3
+			}
+		   | HISTOGRAM LPAREN NUMBER RPAREN DATA IN LPAREN timeref COMMA timeref RPAREN limit timeconv timezone
+			{
+                bins, err := strconv.ParseInt($3, 10, 64)
+                if err != nil || bins < 1 {
+				    sqlex.(*sqLex).Error(fmt.Sprintf("The number of histogram bins must be a positive integer, not \"%v\"", $3))
                 }
-				$$ = &DataQuery{Dtype: IN_TYPE, Start: $9, End: $11, Limit: $13, Timeconv: $14, IsStatistical: false, IsWindow: true, IsChangedRanges: false, Width: uint64(dur.Nanoseconds())}
+				$$ = sqlex.(*sqLex).dataQuery(IN_TYPE, $8, $10, $12, $13, $14)
+				$$.IsDistribution = true
+				$$.HistogramBins = int(bins)
 			}
            | CHANGED LPAREN NUMBER COMMA NUMBER COMMA NUMBER RPAREN DATA
            {
@@ -181,13 +287,13 @@ dataClause : DATA IN LPAREN timeref COMMA timeref RPAREN limit timeconv
                 }
                 $$ = &DataQuery{Dtype: CHANGED_TYPE, IsStatistical: false, IsWindow: false, IsChangedRanges: true, FromGen: uint64(fromgen), ToGen: uint64(togen), Resolution: uint8(resolution)}
            }
-		   | DATA BEFORE timeref limit timeconv
+		   | DATA BEFORE timeref limit timeconv timezone
 			{
-				$$ = &DataQuery{Dtype: BEFORE_TYPE, Start: $3, Limit: $4, Timeconv: $5, IsStatistical: false, IsWindow: false, IsChangedRanges: false}
+				$$ = sqlex.(*sqLex).dataQuery(BEFORE_TYPE, $3, timeRef{}, $4, $5, $6)
 			}
-		   | DATA AFTER timeref limit timeconv
+		   | DATA AFTER timeref limit timeconv timezone
 			{
-				$$ = &DataQuery{Dtype: AFTER_TYPE, Start: $3, Limit: $4, Timeconv: $5, IsStatistical: false, IsWindow: false, IsChangedRanges: false}
+				$$ = sqlex.(*sqLex).dataQuery(AFTER_TYPE, $3, timeRef{}, $4, $5, $6)
 			}
 		   ;
 
@@ -197,7 +303,14 @@ timeref		: abstime
 			}
 			| abstime reltime
 			{
-                $$ = $1.Add($2)
+                abs, rel := $1, $2
+                $$ = timeRef{
+                    resolve: func(now _time.Time, loc *_time.Location) (_time.Time, error) {
+                        t, err := abs.resolve(now, loc)
+                        return rel.addTo(t), err
+                    },
+                    Relative: abs.Relative,
+                }
 			}
 			;
 
@@ -207,7 +320,7 @@ abstime		: NUMBER LVALUE
                 if err != nil {
 				    sqlex.(*sqLex).Error(fmt.Sprintf("Could not parse time \"%v %v\" (%v)", $1, $2, err.Error()))
                 }
-                $$ = foundtime
+                $$ = fixedTime(foundtime)
             }
             | NUMBER
             {
@@ -215,45 +328,40 @@ abstime		: NUMBER LVALUE
                 if err != nil {
 				    sqlex.(*sqLex).Error(fmt.Sprintf("Could not parse integer \"%v\" (%v)", $1, err.Error()))
                 }
-                $$ = _time.Unix(num, 0)
+                $$ = fixedTime(_time.Unix(num, 0))
             }
 			| qstring
             {
-                found := false
-                for _, format := range supported_formats {
-                    t, err := _time.Parse(format, $1)
-                    if err != nil {
-                        continue
+                // times without an explicit offset are in the timezone of the query
+                literal := $1
+                $$ = timeRef{resolve: func(now _time.Time, loc *_time.Location) (_time.Time, error) {
+                    for _, format := range supported_formats {
+                        if t, err := _time.ParseInLocation(format, literal, loc); err == nil {
+                            return t, nil
+                        }
                     }
-                    $$ = t
-                    found = true
-                    break
-                }
-                if !found {
-				    sqlex.(*sqLex).Error(fmt.Sprintf("No time format matching \"%v\" found", $1))
-                }
+                    return _time.Time{}, fmt.Errorf("No time format matching \"%v\" found", literal)
+                }}
             }
 			| NOW
             {
-                $$ = _time.Now()
+                $$ = timeRef{
+                    resolve: func(now _time.Time, loc *_time.Location) (_time.Time, error) {
+                        return now, nil
+                    },
+                    Relative: true,
+                }
             }
 			;
 
 reltime		: NUMBER lvalue
             {
-                var err error
-                $$, err = common.ParseReltime($1, $2)
-                if err != nil {
-				    sqlex.(*sqLex).Error(fmt.Sprintf("Error parsing relative time \"%v %v\" (%v)", $1, $2, err.Error()))
-                }
+                $$ = sqlex.(*sqLex).parseReltime($1, $2)
             }
 			| NUMBER lvalue reltime
             {
-                newDuration, err := common.ParseReltime($1, $2)
-                if err != nil {
-				    sqlex.(*sqLex).Error(fmt.Sprintf("Error parsing relative time \"%v %v\" (%v)", $1, $2, err.Error()))
-                }
-                $$ = common.AddDurations(newDuration, $3)
+                rel := sqlex.(*sqLex).parseReltime($1, $2)
+                $$ = relTime{days: rel.days + $3.days, duration: common.AddDurations(rel.duration, $3.duration)}
             }
 			;
 
@@ -293,19 +401,118 @@ limit		: /* empty */
 
 timeconv    : /* empty */
             {
-                $$ = common.UOT_NS
+                $$ = outputFormat{unit: common.UOT_NS}
             }
             | AS LVALUE
             {
-                uot, err := common.ParseUOT($2)
-                if err != nil {
-                    sqlex.(*sqLex).Error(fmt.Sprintf("Could not parse unit of time %v (%v)", $2, err))
+                if strings.ToLower($2) == "rfc3339" {
+                    $$ = outputFormat{unit: common.UOT_NS, rfc3339: true}
+                } else {
+                    uot, err := common.ParseUOT($2)
+                    if err != nil {
+                        sqlex.(*sqLex).Error(fmt.Sprintf("Could not parse unit of time %v (%v)", $2, err))
+                    }
+                    $$ = outputFormat{unit: uot}
                 }
-                $$ = uot
             }
             ;
 
+percentileList : NUMBER
+            {
+                $$ = []float64{sqlex.(*sqLex).parsePercentile($1)}
+            }
+            | NUMBER COMMA percentileList
+            {
+                $$ = append([]float64{sqlex.(*sqLex).parsePercentile($1)}, $3...)
+            }
+            ;
+
+windowAlign : /* empty */
+            {
+                $$ = windowAlign{weekstart: _time.Monday}
+            }
+            | ALIGN LOCAL
+            {
+                $$ = windowAlign{local: true, weekstart: _time.Monday}
+            }
+            | WEEKSTART lvalue
+            {
+                sqlex.(*sqLex).Error("WEEKSTART can only be used with ALIGN LOCAL")
+                $$ = windowAlign{weekstart: sqlex.(*sqLex).parseWeekday($2)}
+            }
+            | ALIGN LOCAL WEEKSTART lvalue
+            {
+                $$ = windowAlign{local: true, weekstart: sqlex.(*sqLex).parseWeekday($4)}
+            }
+            ;
+
+timezone    : /* empty */
+            {
+                $$ = ""
+            }
+            | TZ qstring
+            {
+                $$ = $2
+            }
+            ;
+
+
+
+pagination  : orderClause
+            {
+                $$ = $1
+            }
+            | orderClause LIMIT NUMBER
+            {
+                $$ = $1
+                $$.Limit = sqlex.(*sqLex).parseCount($3)
+            }
+            | orderClause LIMIT NUMBER OFFSET NUMBER
+            {
+                $$ = $1
+                $$.Limit = sqlex.(*sqLex).parseCount($3)
+                $$.Offset = sqlex.(*sqLex).parseCount($5)
+            }
+            | orderClause CURSOR qstring
+            {
+                $$ = $1
+                $$.Cursor = $3
+            }
+            | orderClause LIMIT NUMBER CURSOR qstring
+            {
+                $$ = $1
+                $$.Limit = sqlex.(*sqLex).parseCount($3)
+                $$.Cursor = $5
+            }
+            ;
+
+orderClause : /* empty */
+            {
+                $$ = common.Pagination{}
+            }
+            | ORDER BY lvalue
+            {
+                $$ = common.Pagination{OrderBy: $3}
+            }
+            | ORDER BY lvalue ASC
+            {
+                $$ = common.Pagination{OrderBy: $3}
+            }
+            | ORDER BY lvalue DESC
+            {
+                $$ = common.Pagination{OrderBy: $3, Descending: true}
+            }
+            ;
 
+cursorClause : /* empty */
+             {
+                $$ = ""
+             }
+             | CURSOR qstring
+             {
+                $$ = $2
+             }
+             ;
 
 whereClause : WHERE whereList
 			{
@@ -316,36 +523,37 @@ whereClause : WHERE whereList
 
 whereTerm : lvalue LIKE qstring
 			{
-				$$ = common.Dict{$1: common.Dict{"$regex": $3}}
+				sqlex.(*sqLex).checkRegex($3)
+				$$ = common.NewTagPredicate(common.OpLike, $1, $3)
 			}
 		  | lvalue EQ qstring
 			{
-				$$ = common.Dict{$1: $3}
+				$$ = common.NewTagPredicate(common.OpEq, $1, $3)
 			}
           | lvalue EQ NUMBER
             {
-				$$ = common.Dict{$1: $3}
+				$$ = common.NewTagPredicate(common.OpEq, $1, $3)
             }
 		  | lvalue NEQ qstring
 			{
-				$$ = common.Dict{$1: common.Dict{"$neq": $3}}
+				$$ = common.NewTagPredicate(common.OpNeq, $1, $3)
 			}
 		  | HAS lvalue
 			{
-				$$ = common.Dict{$2: common.Dict{"$exists": true}}
+				$$ = common.NewTagPredicate(common.OpHas, $2)
 			}
           | MATCHES qstring
             {
-                //$$ = common.Dict{"$text": common.Dict{"$search": $2}}
-                $$ = common.Dict{"$where": fmt.Sprintf("JSON.stringify(this).match(new RegExp('%s'))", $2)}
+				sqlex.(*sqLex).checkSearch($2)
+                $$ = &common.Predicate{Op: common.OpMatches, Values: []string{$2}}
             }
           | valueListBrack IN lvalue
             {
-                $$ = common.Dict{$3: common.Dict{"$in": $1}}
+                $$ = common.NewTagPredicate(common.OpIn, $3, $1...)
             }
           | valueListBrack NOT IN lvalue
             {
-                $$ = common.Dict{$3: common.Dict{"$not": common.Dict{"$in": $1}}}
+                $$ = common.NewNot(common.NewTagPredicate(common.OpIn, $4, $1...))
             }
           | LPAREN whereTerm RPAREN
             {
@@ -369,19 +577,15 @@ lvalue    : LVALUE
 
 whereList : whereList AND whereTerm
 			{
-				$$ = common.Dict{"$and": []common.Dict{$1, $3}}
+				$$ = common.NewAnd($1, $3)
 			}
 		  | whereList OR whereTerm
 			{
-				$$ = common.Dict{"$or": []common.Dict{$1, $3}}
+				$$ = common.NewOr($1, $3)
 			}
 		  | NOT whereTerm
 			{
-                tmp := make(common.Dict)
-                for k,v := range $2 {
-                    tmp[k] = common.Dict{"$ne": v}
-                }
-				$$ = tmp
+				$$ = common.NewNot($2)
 			}
 		  | whereTerm
 			{
@@ -391,7 +595,12 @@ whereList : whereList AND whereTerm
 %%
 
 const eof = 0
-var supported_formats = []string{"1/2/2006",
+var supported_formats = []string{_time.RFC3339Nano,
+                                 _time.RFC3339,
+                                 "2006-01-02T15:04:05",
+                                 "2006-01-02 15:04:05",
+                                 "2006-01-02",
+                                 "1/2/2006",
                                  "1-2-2006",
                                  "1/2/2006 03:04:05 PM MST",
                                  "1-2-2006 03:04:05 PM MST",
@@ -409,6 +618,10 @@ func (qt QueryType) String() string {
 		ret = "delete"
 	case DATA_TYPE:
 		ret = "data"
+	case SUBSCRIBE_TYPE:
+		ret = "subscribe"
+	case UNSUBSCRIBE_TYPE:
+		ret = "unsubscribe"
 	}
 	return ret
 }
@@ -419,11 +632,19 @@ type query struct {
 	// information about a data query if we are one
 	data	   *DataQuery
 	// where clause for query
-	where	  common.Dict
+	where	  *common.Predicate
 	// are we querying distinct values?
 	distinct  bool
+	// return the plan for the query instead of evaluating it
+	explain   bool
 	// list of tags to target for deletion, selection
 	Contents  []string
+	// ordering and paging of the results
+	page      common.Pagination
+	// requested lease of a subscription
+	lease     _time.Duration
+	// the time at which to evaluate the where clause (AS OF)
+	asOf      asOf
 }
 
 func (q *query) Print() {
@@ -450,6 +671,8 @@ type sqLex struct {
     // all keys that we encounter. Used for republish concerns
     _keys    map[string]struct{}
     keys    []string
+    // the time that 'now' refers to in this query
+    now     _time.Time
 }
 
 func NewSQLex(s string) *sqLex {
@@ -457,6 +680,18 @@ func NewSQLex(s string) *sqLex {
 		[]toki.Def{
 			{Token: WHERE, Pattern: "\\bwhere\\b"},
 			{Token: SELECT, Pattern: "\\bselect\\b"},
+			{Token: EXPLAIN, Pattern: "\\bexplain\\b"},
+			{Token: TZ, Pattern: "\\btz\\b"},
+			{Token: ALIGN, Pattern: "\\balign\\b"},
+			{Token: PERCENTILE, Pattern: "\\bpercentile\\b"},
+			{Token: UNSUBSCRIBE, Pattern: "\\bunsubscribe\\b"},
+			{Token: SUBSCRIBE, Pattern: "\\bsubscribe\\b"},
+			{Token: RENEW, Pattern: "\\brenew\\b"},
+			{Token: LEASE, Pattern: "\\blease\\b"},
+			{Token: OF, Pattern: "\\bof\\b"},
+			{Token: HISTOGRAM, Pattern: "\\bhistogram\\b"},
+			{Token: LOCAL, Pattern: "\\blocal\\b"},
+			{Token: WEEKSTART, Pattern: "\\bweekstart\\b"},
             {Token: APPLY, Pattern: "\\bapply\\b"},
 			{Token: DELETE, Pattern: "\\bdelete\\b"},
 			{Token: DISTINCT, Pattern: "\\bdistinct\\b"},
@@ -465,6 +700,12 @@ func NewSQLex(s string) *sqLex {
 			{Token: WINDOW, Pattern: "\\bwindow\\b"},
 			{Token: CHANGED, Pattern: "\\bchanged\\b"},
 			{Token: LIMIT, Pattern: "\\blimit\\b"},
+			{Token: ORDER, Pattern: "\\border\\b"},
+			{Token: BY, Pattern: "\\bby\\b"},
+			{Token: ASC, Pattern: "\\basc\\b"},
+			{Token: DESC, Pattern: "\\bdesc\\b"},
+			{Token: OFFSET, Pattern: "\\boffset\\b"},
+			{Token: CURSOR, Pattern: "\\bcursor\\b"},
 			{Token: STREAMLIMIT, Pattern: "\\bstreamlimit\\b"},
 			{Token: ALL, Pattern: "\\*"},
 			{Token: NOW, Pattern: "\\bnow\\b"},
@@ -496,7 +737,7 @@ func NewSQLex(s string) *sqLex {
 		})
 	scanner.SetInput(s)
 	q := &query{Contents: []string{}, distinct: false}
-	return &sqLex{query: q, querystring: s, scanner: scanner, error: nil, lasttoken: "", _keys: map[string]struct{}{}, tokens: []string{}}
+	return &sqLex{query: q, querystring: s, scanner: scanner, error: nil, lasttoken: "", _keys: map[string]struct{}{}, tokens: []string{}, now: _time.Now()}
 }
 
 func (sq *sqLex) Lex(lval *sqSymType) int {
@@ -514,6 +755,124 @@ func (sq *sqLex) Error(s string) {
     sq.error = fmt.Errorf(s)
 }
 
+// reports an error if the pattern given to LIKE is not a valid regular expression
+func (sq *sqLex) checkRegex(pattern string) {
+	if _, err := regexp.Compile(pattern); err != nil {
+		sq.Error(fmt.Sprintf("Invalid regular expression \"%v\" (%v)", pattern, err.Error()))
+	}
+}
+
+// reports an error if a metadata query is AS OF DATA, which only makes sense for data queries
+func (sq *sqLex) metadataAsOf(at asOf) asOf {
+	if at.data {
+		sq.Error("AS OF DATA can only be used in data queries")
+	}
+	return at
+}
+
+// reports an error if the search given to MATCHES has no words to search for
+func (sq *sqLex) checkSearch(search string) {
+	if len(common.SearchTerms(search)) == 0 {
+		sq.Error(fmt.Sprintf("MATCHES \"%v\" has no words to search for", search))
+	}
+}
+
+// Builds a data query over [start, end]. The times are resolved in the timezone
+// named by tz, which defaults to UTC
+func (sq *sqLex) dataQuery(dtype DataQueryType, start, end timeRef, limit Limit, format outputFormat, tz string) *DataQuery {
+    loc, err := _time.LoadLocation(tz)
+    if err != nil {
+        sq.Error(fmt.Sprintf("Unknown timezone \"%v\" (%v)", tz, err.Error()))
+        loc = _time.UTC
+    }
+    dq := &DataQuery{
+        Dtype:         dtype,
+        StartRelative: start.Relative,
+        EndRelative:   end.Relative,
+        Limit:         limit,
+        Timeconv:      format.unit,
+        Format:        common.TimeFormat{Timezone: loc.String(), RFC3339: format.rfc3339},
+        start:         start,
+        end:           end,
+        loc:           loc,
+    }
+    if err := dq.resolve(sq.now); err != nil {
+        sq.Error(err.Error())
+    }
+    return dq
+}
+
+// Parses one term of a relative time. Days are kept separate from the rest of
+// the duration so that they can be added as calendar days
+func (sq *sqLex) parseReltime(num, units string) relTime {
+    switch units {
+    case "d", "day", "days":
+        days, err := strconv.ParseInt(num, 10, 64)
+        if err != nil {
+            sq.Error(fmt.Sprintf("Error parsing relative time \"%v %v\" (%v)", num, units, err.Error()))
+        }
+        return relTime{days: int(days)}
+    }
+    dur, err := common.ParseReltime(num, units)
+    if err != nil {
+        sq.Error(fmt.Sprintf("Error parsing relative time \"%v %v\" (%v)", num, units, err.Error()))
+    }
+    return relTime{duration: dur}
+}
+
+// Parses the width of a window that isn't aligned to the calendar. Weeks are 7 days of
+// 24 hours; months, quarters and years vary in length, so they must be aligned
+func (sq *sqLex) parseWindowWidth(num, units string) uint64 {
+    unit, isCalendar := common.ParseCalendarUnit(units)
+    switch {
+    case isCalendar && unit == common.CalendarWeek:
+        weeks, err := strconv.ParseInt(num, 10, 64)
+        if err != nil {
+            sq.Error(fmt.Sprintf("Error parsing relative time \"%v %v\" (%v)", num, units, err.Error()))
+        }
+        num, units = strconv.FormatInt(7*weeks, 10), "days"
+    case isCalendar && unit != common.CalendarDay:
+        sq.Error(fmt.Sprintf("Windows of %vs vary in length; use ALIGN LOCAL to align them to the calendar", unit))
+        return 0
+    }
+    dur, err := common.ParseReltime(num, units)
+    if err != nil {
+        sq.Error(fmt.Sprintf("Error parsing relative time \"%v %v\" (%v)", num, units, err.Error()))
+    }
+    return uint64(dur.Nanoseconds())
+}
+
+// parses an argument to PERCENTILE, which must be between 0 and 100
+func (sq *sqLex) parsePercentile(num string) float64 {
+    p, err := strconv.ParseFloat(num, 64)
+    if err != nil || p < 0 || p > 100 {
+        sq.Error(fmt.Sprintf("Percentiles must be between 0 and 100, not \"%v\"", num))
+    }
+    return p
+}
+
+func (sq *sqLex) parseWeekday(day string) _time.Weekday {
+    wd, err := common.ParseWeekday(day)
+    if err != nil {
+        sq.Error(err.Error())
+    }
+    return wd
+}
+
+// parses the non-negative integer arguments to LIMIT and OFFSET in a metadata query
+func (sq *sqLex) parseCount(num string) int {
+    i, err := strconv.ParseInt(num, 10, 64)
+    if err != nil {
+        sq.Error(fmt.Sprintf("Could not parse integer \"%v\" (%v)", num, err.Error()))
+        return 0
+    }
+    if i < 0 {
+        sq.Error(fmt.Sprintf("Expected a non-negative integer but got %v", num))
+        return 0
+    }
+    return int(i)
+}
+
 func readline(fi *bufio.Reader) (string, bool) {
 	fmt.Printf("smap> ")
 	s, err := fi.ReadString('\n')
@@ -6,11 +6,16 @@ import (
 )
 
 type DataQuery struct {
-	Dtype           DataQueryType
-	Start           time.Time
-	End             time.Time
-	Limit           Limit
-	Timeconv        common.UnitOfTime
+	Dtype DataQueryType
+	Start time.Time
+	End   time.Time
+	// true if Start/End were given relative to 'now'
+	StartRelative bool
+	EndRelative   bool
+	Limit         Limit
+	Timeconv      common.UnitOfTime
+	// the timezone the times were resolved in, and how to render them
+	Format          common.TimeFormat
 	IsStatistical   bool
 	IsWindow        bool
 	IsChangedRanges bool
@@ -19,6 +24,79 @@ type DataQuery struct {
 	Resolution      uint8
 	Width           uint64
 	PointWidth      int64
+	// set for windows aligned to the calendar in the query's timezone
+	Calendar *common.CalendarWindow
+	// set for PERCENTILE and HISTOGRAM queries
+	IsDistribution bool
+	Percentiles    []float64
+	HistogramBins  int
+	// unresolved start and end, kept so that Start and End can be resolved
+	// again against a different 'now'
+	start, end timeRef
+	loc        *time.Location
+}
+
+// resolves Start and End against the given time
+func (dq *DataQuery) resolve(now time.Time) (err error) {
+	if dq.Start, err = dq.start.in(now, dq.loc); err != nil {
+		return
+	}
+	dq.End, err = dq.end.in(now, dq.loc)
+	return
+}
+
+// The AS OF clause of a query: the time at which the WHERE clause is evaluated
+// against the history of the metadata
+type asOf struct {
+	// AS OF <time>. The zero timeRef means the current metadata
+	ref timeRef
+	// AS OF DATA: against the metadata that was valid at the time of each reading
+	data bool
+}
+
+// A time from the query. We cannot resolve literal or relative times until we
+// know the timezone of the query (from the TZ clause), so this holds a function
+// that does so, and whether or not the time is relative to 'now'
+type timeRef struct {
+	resolve  func(now time.Time, loc *time.Location) (time.Time, error)
+	Relative bool
+}
+
+// a timeRef for a time that doesn't depend on 'now' or the timezone
+func fixedTime(t time.Time) timeRef {
+	return timeRef{resolve: func(time.Time, *time.Location) (time.Time, error) { return t, nil }}
+}
+
+// Returns the time in the given location. The zero timeRef resolves to the zero time
+func (ref timeRef) in(now time.Time, loc *time.Location) (time.Time, error) {
+	if ref.resolve == nil {
+		return time.Time{}, nil
+	}
+	return ref.resolve(now.In(loc), loc)
+}
+
+// A relative time, e.g. "-1d 6h". Days are calendar days in the timezone of the query,
+// so across a daylight savings transition they are not 24 hours long
+type relTime struct {
+	days     int
+	duration time.Duration
+}
+
+func (rel relTime) addTo(t time.Time) time.Time {
+	return t.AddDate(0, 0, rel.days).Add(rel.duration)
+}
+
+// the options of a WINDOW clause
+type windowAlign struct {
+	// if true, align the window to the calendar in the query's timezone
+	local     bool
+	weekstart time.Weekday
+}
+
+// the AS clause of a data query
+type outputFormat struct {
+	unit    common.UnitOfTime
+	rfc3339 bool
 }
 
 type Limit struct {
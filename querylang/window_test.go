@@ -0,0 +1,47 @@
+package querylang
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gtfierro/pundat/common"
+)
+
+func TestWindowAlignment(t *testing.T) {
+	for _, test := range []struct {
+		query    string
+		width    time.Duration
+		calendar *common.CalendarWindow
+	}{
+		{`select window(1d) data in (now -7d, now) where uuid = "a";`, 24 * time.Hour, nil},
+		// without ALIGN LOCAL, windows keep their fixed width
+		{`select window(2w) data in (now -7d, now) where uuid = "a";`, 14 * 24 * time.Hour, nil},
+		{`select window(1d align local) data in (now -7d, now) where uuid = "a";`, 0, &common.CalendarWindow{Count: 1, Unit: common.CalendarDay, WeekStart: time.Monday}},
+		{`select window(1w align local weekstart sunday) data in (now -7d, now) where uuid = "a";`, 0, &common.CalendarWindow{Count: 1, Unit: common.CalendarWeek, WeekStart: time.Sunday}},
+		{`select window(3mo align local) data in (now -7d, now) where uuid = "a";`, 0, &common.CalendarWindow{Count: 3, Unit: common.CalendarMonth, WeekStart: time.Monday}},
+	} {
+		parsed := parse(test.query)
+		if parsed.Err != nil {
+			t.Errorf("Could not parse %s (%v)", test.query, parsed.Err)
+			continue
+		}
+		if time.Duration(parsed.Data.Width) != test.width {
+			t.Errorf("%s should have width %v but got %v", test.query, test.width, time.Duration(parsed.Data.Width))
+		}
+		if (test.calendar == nil) != (parsed.Data.Calendar == nil) || (test.calendar != nil && *test.calendar != *parsed.Data.Calendar) {
+			t.Errorf("%s should have calendar window %v but got %v", test.query, test.calendar, parsed.Data.Calendar)
+		}
+	}
+
+	for _, query := range []string{
+		// months, quarters and years have no fixed width
+		`select window(1mo) data in (now -7d, now) where uuid = "a";`,
+		`select window(1y) data in (now -7d, now) where uuid = "a";`,
+		`select window(1w weekstart sunday) data in (now -7d, now) where uuid = "a";`,
+		`select window(1h align local) data in (now -7d, now) where uuid = "a";`,
+	} {
+		if parsed := parse(query); parsed.Err == nil {
+			t.Errorf("%s should not parse", query)
+		}
+	}
+}
@@ -0,0 +1,224 @@
+package scraper
+
+import (
+	"encoding/binary"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	ldbutil "github.com/syndtr/goleveldb/leveldb/util"
+	"gopkg.in/mgo.v2/bson"
+)
+
+/*
+The prefix db also keeps every version of every metadata key, so that the metadata of a
+URI can be reconstructed as it was at any point in time. Versions are written in the same
+transaction as the key itself. Their keys are
+
+	0x00 <SrcURI> 0x00 <TimeValid as 8 byte big-endian nanoseconds>
+
+so the versions of a key sort in time order directly after each other, and the values
+are the same msgp-encoded KVPairs as the current value. An index of the URIs that have
+ever had each key is kept alongside, with keys
+
+	0x01 <Key> 0x00 <URI>
+
+URIs never start with either byte, so neither shows up in scans of the current metadata.
+*/
+
+const (
+	historyPrefix  = 0x00
+	keyIndexPrefix = 0x01
+	historySep     = 0x00
+)
+
+func historyKey(srcuri string, valid time.Time) []byte {
+	nanos := valid.UnixNano()
+	if nanos < 0 {
+		nanos = 0
+	}
+	key := make([]byte, len(srcuri)+10)
+	key[0] = historyPrefix
+	copy(key[1:], srcuri)
+	key[len(srcuri)+1] = historySep
+	binary.BigEndian.PutUint64(key[len(srcuri)+2:], uint64(nanos))
+	return key
+}
+
+// splits a history key into the SrcURI and the time the version became valid
+func parseHistoryKey(key []byte) (string, int64) {
+	if len(key) < 10 {
+		return "", 0
+	}
+	split := len(key) - 9
+	return string(key[1:split]), int64(binary.BigEndian.Uint64(key[split+1:]))
+}
+
+// the range of history keys for the metadata set directly on uri
+func historyRange(uri string) []byte {
+	return append([]byte{historyPrefix}, uri...)
+}
+
+func keyIndexKey(key, uri string) []byte {
+	return append(keyIndexRange(key), uri...)
+}
+
+func keyIndexRange(key string) []byte {
+	return append(append([]byte{keyIndexPrefix}, key...), historySep)
+}
+
+func isHistoryKey(key []byte) bool {
+	return len(key) > 0 && (key[0] == historyPrefix || key[0] == keyIndexPrefix)
+}
+
+// returns the URIs whose metadata is inherited by uri (the prefixes of uri ending before
+// each '/', and the uri itself), from least to most specific
+func prefixesOf(uri string) []string {
+	var prefixes []string
+	for i := 0; i < len(uri); i++ {
+		if uri[i] == '/' {
+			prefixes = append(prefixes, uri[:i])
+		}
+	}
+	return append(prefixes, uri)
+}
+
+// Returns the URIs on which the metadata key has ever been set
+func (pfxdb *PrefixDB) URIsWithKey(key string) []string {
+	var uris []string
+	prefix := keyIndexRange(key)
+	iter := pfxdb.NewIterator(ldbutil.BytesPrefix(prefix), nil)
+	for iter.Next() {
+		uris = append(uris, string(iter.Key()[len(prefix):]))
+	}
+	iter.Release()
+	if err := iter.Error(); err != nil {
+		log.Error(err)
+	}
+	return uris
+}
+
+// one version of a metadata key
+type version struct {
+	valid int64
+	key   string
+	value string
+}
+
+// A reader of the metadata history for a single query. The versions set on each URI are
+// read from the db the first time they are needed, so reconstructing the metadata of many
+// streams, or of one stream at many times, reads each URI only once. Not safe for
+// concurrent use
+type History struct {
+	pfxdb    *PrefixDB
+	versions map[string][]version
+}
+
+func (pfxdb *PrefixDB) History() *History {
+	return &History{pfxdb: pfxdb, versions: make(map[string][]version)}
+}
+
+// the versions of the metadata set directly on uri, ordered by key and then time
+func (h *History) of(uri string) []version {
+	if versions, found := h.versions[uri]; found {
+		return versions
+	}
+	var versions []version
+	iter := h.pfxdb.NewIterator(BytesPrefix(historyRange(uri)), nil)
+	for iter.Next() {
+		_, valid := parseHistoryKey(iter.Key())
+		kv := newKVPair()
+		if _, err := kv.UnmarshalMsg(iter.Value()); err != nil {
+			log.Error(err)
+		} else {
+			versions = append(versions, version{valid: valid, key: kv.Key, value: kv.Value})
+		}
+		kv.release()
+	}
+	iter.Release()
+	if err := iter.Error(); err != nil {
+		log.Error(err)
+	}
+	h.versions[uri] = versions
+	return versions
+}
+
+// The same as Lookup, but returns the metadata of uri as it was at the given time: the
+// latest version of each key that was valid at or before then
+func (h *History) LookupAt(uri string, at time.Time) bson.M {
+	doc := make(bson.M)
+	limit := at.UnixNano()
+	for _, prefix := range prefixesOf(uri) {
+		// versions are in time order, so later ones replace earlier ones
+		for _, v := range h.of(prefix) {
+			if v.valid <= limit {
+				doc[v.key] = v.value
+			}
+		}
+	}
+	return doc
+}
+
+// Returns the times after start and up to end at which the metadata inherited by uri
+// changed, in order
+func (h *History) ChangesBetween(uri string, start, end time.Time) []time.Time {
+	var changes []int64
+	for _, prefix := range prefixesOf(uri) {
+		for _, v := range h.of(prefix) {
+			if v.valid > start.UnixNano() && v.valid <= end.UnixNano() {
+				changes = append(changes, v.valid)
+			}
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i] < changes[j] })
+	var times []time.Time
+	for i, nanos := range changes {
+		if i == 0 || nanos != changes[i-1] {
+			times = append(times, time.Unix(0, nanos))
+		}
+	}
+	return times
+}
+
+// Metadata stored before the history was kept has no versions. Records the current
+// value of each such key as valid since the beginning of time, so that queries about the
+// past see it
+func (pfxdb *PrefixDB) backfillHistory() {
+	batch := new(leveldb.Batch)
+	hist := pfxdb.NewIterator(nil, nil)
+	defer hist.Release()
+	iter := pfxdb.NewIterator(nil, nil)
+	for iter.Next() {
+		if isHistoryKey(iter.Key()) || !strings.Contains(string(iter.Key()), metasuffixstr) {
+			continue
+		}
+		srcuri := string(iter.Key())
+		if hist.Seek(historyKey(srcuri, time.Unix(0, 0))) {
+			if last, _ := parseHistoryKey(hist.Key()); hist.Key()[0] == historyPrefix && last == srcuri {
+				continue
+			}
+		}
+		kv := newKVPair()
+		if _, err := kv.UnmarshalMsg(iter.Value()); err != nil {
+			log.Error(err)
+		} else {
+			batch.Put(historyKey(srcuri, time.Unix(0, 0)), append([]byte(nil), iter.Value()...))
+			batch.Put(keyIndexKey(kv.Key, getStrippedURI(srcuri)), nil)
+		}
+		kv.release()
+	}
+	iter.Release()
+	if err := iter.Error(); err != nil {
+		log.Error(err)
+		return
+	}
+	if batch.Len() == 0 {
+		return
+	}
+	if err := pfxdb.Write(batch, nil); err != nil {
+		log.Error(err)
+		return
+	}
+	log.Noticef("Recorded history of %d existing metadata keys", batch.Len()/2)
+}
@@ -0,0 +1,180 @@
+package scraper
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/google/btree"
+	"github.com/gtfierro/pundat/common"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/storage"
+	"github.com/willf/bloom"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// a PrefixDB held in memory, without the background goroutines
+func testPrefixDB(t *testing.T) *PrefixDB {
+	db, err := leveldb.Open(storage.NewMemStorage(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	deps, err := leveldb.Open(storage.NewMemStorage(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &PrefixDB{
+		DB:           db,
+		dependencies: deps,
+		usagefilter:  bloom.NewWithEstimates(1000, .01),
+		updated:      btree.New(3),
+	}
+}
+
+func record(uri, key, value string, valid int64) common.MetadataRecord {
+	return common.MetadataRecord{Key: key, Value: value, SrcURI: uri + "/!meta/" + key, TimeValid: time.Unix(0, valid)}
+}
+
+func TestHistoryKeyRoundTrip(t *testing.T) {
+	for _, test := range []struct {
+		srcuri string
+		valid  time.Time
+		nanos  int64
+	}{
+		{"ns/a/!meta/Room", time.Unix(0, 1234), 1234},
+		{"ns/!meta/Building", time.Unix(1500000000, 5), 1500000000000000005},
+		// times before 1970 are recorded as the beginning of time
+		{"ns/!meta/Building", time.Unix(-10, 0), 0},
+	} {
+		srcuri, nanos := parseHistoryKey(historyKey(test.srcuri, test.valid))
+		if srcuri != test.srcuri || nanos != test.nanos {
+			t.Errorf("Expected %s at %d, got %s at %d", test.srcuri, test.nanos, srcuri, nanos)
+		}
+	}
+	// versions of a key sort in time order, before any other key with the same prefix
+	earlier := historyKey("ns/!meta/Room", time.Unix(0, 255))
+	later := historyKey("ns/!meta/Room", time.Unix(0, 256))
+	longer := historyKey("ns/!meta/Room2", time.Unix(0, 0))
+	if bytes.Compare(earlier, later) >= 0 || bytes.Compare(later, longer) >= 0 {
+		t.Error("Expected the versions of a key to sort in time order before longer keys")
+	}
+	if !isHistoryKey(earlier) || !isHistoryKey(keyIndexKey("Room", "ns")) || isHistoryKey([]byte("ns/!meta/Room")) {
+		t.Error("Expected only history and index keys to be reserved")
+	}
+}
+
+func TestHistoryLookupAt(t *testing.T) {
+	pfxdb := testPrefixDB(t)
+	if err := pfxdb.InsertRecords(
+		record("ns", "Building", "Soda", 0),
+		record("ns/a", "Room", "410", 10),
+		record("ns/a", "Room", "420", 20),
+		record("ns/b", "Room", "500", 15),
+	); err != nil {
+		t.Fatal(err)
+	}
+	// written after the rest, but valid earlier
+	if err := pfxdb.InsertRecords(record("ns/a/temp", "Room", "400", 5)); err != nil {
+		t.Fatal(err)
+	}
+
+	history := pfxdb.History()
+	for _, test := range []struct {
+		at  int64
+		doc bson.M
+	}{
+		{0, bson.M{"Building": "Soda"}},
+		{9, bson.M{"Building": "Soda", "Room": "400"}},
+		// the stream's own Room overrides the one it inherits
+		{15, bson.M{"Building": "Soda", "Room": "400"}},
+	} {
+		if doc := history.LookupAt("ns/a/temp", time.Unix(0, test.at)); !reflect.DeepEqual(doc, test.doc) {
+			t.Errorf("At %d: expected %v, got %v", test.at, test.doc, doc)
+		}
+	}
+	for _, test := range []struct {
+		at  int64
+		doc bson.M
+	}{
+		{9, bson.M{"Building": "Soda"}},
+		{10, bson.M{"Building": "Soda", "Room": "410"}},
+		{19, bson.M{"Building": "Soda", "Room": "410"}},
+		{20, bson.M{"Building": "Soda", "Room": "420"}},
+	} {
+		if doc := history.LookupAt("ns/a/humidity", time.Unix(0, test.at)); !reflect.DeepEqual(doc, test.doc) {
+			t.Errorf("At %d: expected %v, got %v", test.at, test.doc, doc)
+		}
+	}
+	// the current metadata is unaffected by the history kept alongside it
+	if doc := pfxdb.Lookup("ns/a/humidity"); !reflect.DeepEqual(doc, bson.M{"Building": "Soda", "Room": "420"}) {
+		t.Errorf("Expected the latest metadata, got %v", doc)
+	}
+
+	if uris := pfxdb.URIsWithKey("Room"); !reflect.DeepEqual(uris, []string{"ns/a", "ns/a/temp", "ns/b"}) {
+		t.Errorf("Expected the URIs that have had a Room, got %v", uris)
+	}
+	if uris := pfxdb.URIsWithKey("Floor"); len(uris) != 0 {
+		t.Errorf("Expected no URIs to have had a Floor, got %v", uris)
+	}
+}
+
+func TestHistoryChangesBetween(t *testing.T) {
+	pfxdb := testPrefixDB(t)
+	if err := pfxdb.InsertRecords(
+		record("ns", "Building", "Soda", 0),
+		record("ns", "Building", "Cory", 30),
+		record("ns/a", "Room", "410", 10),
+		record("ns/a", "Floor", "4", 10),
+		record("ns/a", "Room", "420", 20),
+		record("ns/b", "Room", "500", 15),
+	); err != nil {
+		t.Fatal(err)
+	}
+	nanos := func(times []time.Time) []int64 {
+		var ret []int64
+		for _, t := range times {
+			ret = append(ret, t.UnixNano())
+		}
+		return ret
+	}
+	history := pfxdb.History()
+	// changes on the same key or at the same time are reported once; changes to
+	// URIs that aren't inherited are left out
+	if changes := nanos(history.ChangesBetween("ns/a/temp", time.Unix(0, 0), time.Unix(0, 100))); !reflect.DeepEqual(changes, []int64{10, 20, 30}) {
+		t.Errorf("Expected changes at 10, 20 and 30, got %v", changes)
+	}
+	// start is exclusive and end is inclusive
+	if changes := nanos(history.ChangesBetween("ns/a/temp", time.Unix(0, 10), time.Unix(0, 20))); !reflect.DeepEqual(changes, []int64{20}) {
+		t.Errorf("Expected a change at 20, got %v", changes)
+	}
+}
+
+func TestBackfillHistory(t *testing.T) {
+	pfxdb := testPrefixDB(t)
+	// metadata written before history was kept
+	kv := newKVPair()
+	kv.Key, kv.Value = "Room", "410"
+	value, err := kv.MarshalMsg(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := pfxdb.Put([]byte("ns/a/!meta/Room"), value, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := pfxdb.InsertRecords(record("ns", "Building", "Soda", 10)); err != nil {
+		t.Fatal(err)
+	}
+	pfxdb.backfillHistory()
+	pfxdb.backfillHistory()
+
+	if doc := pfxdb.History().LookupAt("ns/a/temp", time.Unix(0, 0)); !reflect.DeepEqual(doc, bson.M{"Room": "410"}) {
+		t.Errorf("Expected the existing metadata to have been valid since the beginning of time, got %v", doc)
+	}
+	if doc := pfxdb.History().LookupAt("ns/a/temp", time.Unix(0, 10)); !reflect.DeepEqual(doc, bson.M{"Room": "410", "Building": "Soda"}) {
+		t.Errorf("Expected the existing history to be kept, got %v", doc)
+	}
+	if uris := pfxdb.URIsWithKey("Room"); !reflect.DeepEqual(uris, []string{"ns/a"}) {
+		t.Errorf("Expected the backfilled key to be indexed, got %v", uris)
+	}
+}
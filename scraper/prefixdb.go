@@ -39,6 +39,8 @@ type PrefixDB struct {
 	usagefilter  *bloom.BloomFilter
 	updated      *btree.BTree
 	mu           sync.Mutex
+	// called with the URI of each document marked as updated
+	listeners []func(uri string)
 	*leveldb.DB
 }
 
@@ -58,6 +60,7 @@ func NewPrefixDB(cfg *Config) *PrefixDB {
 		log.Fatal(err)
 	}
 	pfxdb.dependencies = db2
+	go pfxdb.backfillHistory()
 
 	// background compaction
 	go func() {
@@ -94,15 +97,31 @@ func (pfxdb *PrefixDB) InsertRecords(records ...common.MetadataRecord) error {
 			tx.Discard()
 			return err
 		}
+		// keep this version in the history (see history.go)
+		if err := tx.Put(historyKey(rec.SrcURI, rec.TimeValid), bytes, nil); err != nil {
+			tx.Discard()
+			return err
+		}
+		if err := tx.Put(keyIndexKey(rec.Key, getStrippedURI(rec.SrcURI)), nil, nil); err != nil {
+			tx.Discard()
+			return err
+		}
 		if pfxdb.usagefilter.Test([]byte(getStrippedURI(rec.SrcURI))) {
 			// if the updated URI has been used, we need to mark it as 'dirty'
 			// so the downstream documents can be updated
 			stripped := getStrippedURI(rec.SrcURI)
+			deps := pfxdb.GetDependencies(stripped)
 			pfxdb.mu.Lock()
-			for _, dep := range pfxdb.GetDependencies(stripped) {
+			for _, dep := range deps {
 				pfxdb.updated.ReplaceOrInsert(URI(dep))
 			}
+			listeners := pfxdb.listeners
 			pfxdb.mu.Unlock()
+			for _, dep := range deps {
+				for _, cb := range listeners {
+					cb(dep)
+				}
+			}
 		}
 		kv.release()
 	}
@@ -110,6 +129,14 @@ func (pfxdb *PrefixDB) InsertRecords(records ...common.MetadataRecord) error {
 	return tx.Commit()
 }
 
+// Registers a callback that is invoked with the URI of each document that is
+// marked as updated because some metadata it inherits from has changed
+func (pfxdb *PrefixDB) OnUpdate(cb func(uri string)) {
+	pfxdb.mu.Lock()
+	pfxdb.listeners = append(pfxdb.listeners, cb)
+	pfxdb.mu.Unlock()
+}
+
 // fetches all of the documents whose URIs are in the 'updated' tree
 func (pfxdb *PrefixDB) GetUpdatedDocuments() []bson.M {
 	var records []bson.M